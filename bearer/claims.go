@@ -0,0 +1,131 @@
+package bearer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Claims describe the access granted by a valid access token.
+type Claims struct {
+	Issuer   string
+	Subject  string
+	ClientId string
+	// Id of the token (jti), empty if it has none
+	TokenId  string
+	Scope    []string
+	Audience []string
+	// Zero if the token does not expire
+	ExpiresAt time.Time
+	// Authentication of the user, zero or empty if it is not known
+	AuthTime time.Time
+	ACR      string
+	// Thumbprint of the DPoP key the token is bound to, empty for bearer tokens
+	JKT string
+	// All claims of the token, including the ones above
+	Raw map[string]interface{}
+}
+
+// HasScope returns true if the token was granted the scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scope {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAudience returns true if the token is intended for the audience.
+func (c *Claims) HasAudience(audience string) bool {
+	for _, a := range c.Audience {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenClaims is the JSON form of the claims shared by JWT access tokens (RFC
+// 9068) and introspection responses (RFC 7662).
+type tokenClaims struct {
+	Active    bool            `json:"active"`
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	ClientId  string          `json:"client_id"`
+	TokenId   string          `json:"jti"`
+	Scope     string          `json:"scope"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+	AuthTime  int64           `json:"auth_time"`
+	ACR       string          `json:"acr"`
+	Cnf       struct {
+		JKT string `json:"jkt"`
+	} `json:"cnf"`
+}
+
+func parseClaims(data []byte) (*tokenClaims, *Claims, error) {
+	parsed := &tokenClaims{}
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(data, parsed); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, err
+	}
+	audience, err := parseAudience(parsed.Audience)
+	if err != nil {
+		return nil, nil, err
+	}
+	claims := &Claims{
+		Issuer:   parsed.Issuer,
+		Subject:  parsed.Subject,
+		ClientId: parsed.ClientId,
+		TokenId:  parsed.TokenId,
+		Scope:    strings.Fields(parsed.Scope),
+		Audience: audience,
+		ACR:      parsed.ACR,
+		JKT:      parsed.Cnf.JKT,
+		Raw:      raw,
+	}
+	if parsed.ExpiresAt != 0 {
+		claims.ExpiresAt = time.Unix(parsed.ExpiresAt, 0)
+	}
+	if parsed.AuthTime != 0 {
+		claims.AuthTime = time.Unix(parsed.AuthTime, 0)
+	}
+	return parsed, claims, nil
+}
+
+// The audience is a single string or an array of strings.
+func parseAudience(data json.RawMessage) ([]string, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		return []string{single}, nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return nil, errors.New("bearer: invalid audience")
+	}
+	return multiple, nil
+}
+
+type contextKey struct{}
+
+// ClaimsFromContext returns the claims of the access token the request was
+// authorized with, nil if the request did not pass the middleware.
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(contextKey{}).(*Claims)
+	return claims
+}
+
+// ContextWithClaims returns a copy of the context that carries the claims.
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
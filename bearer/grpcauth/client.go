@@ -0,0 +1,132 @@
+package grpcauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+)
+
+// Tokens are refreshed when they expire in less than this time
+const refreshBefore = 30 * time.Second
+
+// Lifetime of tokens whose response does not tell when they expire
+const defaultTokenLifetime = 5 * time.Minute
+
+// Maximum size of a token response
+const maxTokenResponse = 1 << 20
+
+// ClientCredentials are gRPC per-RPC credentials that attach an access token
+// obtained with the client credentials grant. The token is reused until it is
+// about to expire and is then obtained again.
+type ClientCredentials struct {
+	// Allows sending tokens without transport security, only for local
+	// connections and tests
+	AllowInsecure bool
+	tokenEndpoint string
+	credentials   *service.ClientCredentials
+	scope         string
+	client        *http.Client
+	mutex         sync.Mutex
+	token         string
+	expiresAt     time.Time
+}
+
+// NewClientCredentials returns credentials that request tokens with the scope
+// from the token endpoint. Nil client uses http.DefaultClient.
+func NewClientCredentials(
+	tokenEndpoint string,
+	credentials *service.ClientCredentials,
+	scope string,
+	client *http.Client) *ClientCredentials {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ClientCredentials{
+		tokenEndpoint: tokenEndpoint,
+		credentials:   credentials,
+		scope:         scope,
+		client:        client,
+	}
+}
+
+func (c *ClientCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{authorizationKey: oauth2.TokenTypeBearer + " " + token}, nil
+}
+
+func (c *ClientCredentials) RequireTransportSecurity() bool {
+	return !c.AllowInsecure
+}
+
+// Token returns a valid access token, obtaining a new one if needed.
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	if c.token != "" && now.Add(refreshBefore).Before(c.expiresAt) {
+		return c.token, nil
+	}
+	response, err := c.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(response.TokenType, oauth2.TokenTypeBearer) {
+		return "", fmt.Errorf("grpcauth: unsupported token type %q", response.TokenType)
+	}
+	c.token = response.AccessToken
+	lifetime := time.Duration(response.ExpiresIn) * time.Second
+	if response.ExpiresIn == 0 {
+		lifetime = defaultTokenLifetime
+	}
+	c.expiresAt = now.Add(lifetime)
+	return c.token, nil
+}
+
+func (c *ClientCredentials) requestToken(ctx context.Context) (*oauth2.AccessTokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if c.scope != "" {
+		form.Set("scope", c.scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.credentials.Id, c.credentials.Secret)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTokenResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errResponse := &oauth2.ErrorResponse{}
+		if json.Unmarshal(body, errResponse) == nil && errResponse.ErrorCode != "" {
+			return nil, errResponse
+		}
+		return nil, fmt.Errorf("grpcauth: token request failed with status %d", resp.StatusCode)
+	}
+	response := &oauth2.AccessTokenResponse{}
+	if err := json.Unmarshal(body, response); err != nil || response.AccessToken == "" {
+		return nil, fmt.Errorf("grpcauth: malformed token response")
+	}
+	return response, nil
+}
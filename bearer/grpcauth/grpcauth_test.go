@@ -0,0 +1,321 @@
+package grpcauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/arjantop/gopherauth/bearer"
+	"github.com/arjantop/gopherauth/bearer/grpcauth"
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/access_token"
+	"github.com/arjantop/gopherauth/oauth2/endpoint"
+	"github.com/arjantop/gopherauth/oauth2/grant_type"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+)
+
+const (
+	checkMethod = "/grpc.health.v1.Health/Check"
+	watchMethod = "/grpc.health.v1.Health/Watch"
+)
+
+var validator = testutil.StaticValidator{
+	"token":    {Subject: "service", Scope: []string{"health"}},
+	"no-scope": {Subject: "other"},
+}
+
+// testServer is a health service behind the interceptor that records the claims
+// handlers see.
+type testServer struct {
+	listener *bufconn.Listener
+	claims   *bearer.Claims
+}
+
+func newTestServer(t *testing.T, requirements map[string]*bearer.Requirement) *testServer {
+	return newTestServerWithValidator(t, validator, requirements)
+}
+
+func newTestServerWithValidator(
+	t *testing.T,
+	validator bearer.Validator,
+	requirements map[string]*bearer.Requirement) *testServer {
+
+	s := &testServer{listener: bufconn.Listen(1 << 20)}
+	interceptor := grpcauth.NewInterceptor("api", validator, requirements)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(interceptor.Unary(), func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+
+			s.claims = bearer.ClaimsFromContext(ctx)
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(interceptor.Stream(), func(
+			srv interface{},
+			ss grpc.ServerStream,
+			info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+
+			s.claims = bearer.ClaimsFromContext(ss.Context())
+			return handler(srv, ss)
+		}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(s.listener)
+	t.Cleanup(server.Stop)
+	return s
+}
+
+func (s *testServer) dial(t *testing.T, opts ...grpc.DialOption) healthpb.HealthClient {
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestUnaryCallWithValidTokenIsAuthorized(t *testing.T) {
+	server := newTestServer(t, map[string]*bearer.Requirement{checkMethod: {Scopes: []string{"health"}}})
+	client := server.dial(t)
+
+	_, err := client.Check(withToken("token"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	if assert.NotNil(t, server.claims) {
+		assert.Equal(t, "service", server.claims.Subject)
+	}
+}
+
+func TestUnaryCallWithoutTokenIsUnauthenticated(t *testing.T) {
+	server := newTestServer(t, nil)
+	client := server.dial(t)
+
+	var header metadata.MD
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, []string{`Bearer realm="api"`}, header.Get("www-authenticate"))
+	assert.Nil(t, server.claims)
+}
+
+func TestUnaryCallWithInvalidTokenIsUnauthenticated(t *testing.T) {
+	server := newTestServer(t, nil)
+	client := server.dial(t)
+
+	var header metadata.MD
+	_, err := client.Check(withToken("invalid"), &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, []string{`Bearer realm="api", error="invalid_token", error_description="Access token is not valid"`}, header.Get("www-authenticate"))
+}
+
+func TestUnaryCallWithoutRequiredScopeIsDenied(t *testing.T) {
+	server := newTestServer(t, map[string]*bearer.Requirement{checkMethod: {Scopes: []string{"health"}}})
+	client := server.dial(t)
+
+	var header metadata.MD
+	_, err := client.Check(withToken("no-scope"), &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, header.Get("www-authenticate")[0], `scope="health"`)
+}
+
+func TestServiceRequirementAppliesToAllMethods(t *testing.T) {
+	server := newTestServer(t, map[string]*bearer.Requirement{
+		"/grpc.health.v1.Health/": {Scopes: []string{"admin"}},
+		checkMethod:               {Scopes: []string{"health"}},
+	})
+	client := server.dial(t)
+
+	_, err := client.Check(withToken("token"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	stream, err := client.Watch(withToken("token"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestStreamWithValidTokenIsAuthorized(t *testing.T) {
+	server := newTestServer(t, map[string]*bearer.Requirement{watchMethod: {Scopes: []string{"health"}}})
+	client := server.dial(t)
+
+	stream, err := client.Watch(withToken("token"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	response, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, response.Status)
+	if assert.NotNil(t, server.claims) {
+		assert.Equal(t, "service", server.claims.Subject)
+	}
+}
+
+func TestStreamWithoutTokenIsUnauthenticated(t *testing.T) {
+	server := newTestServer(t, nil)
+	client := server.dial(t)
+
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	header, _ := stream.Header()
+	assert.Equal(t, []string{`Bearer realm="api"`}, header.Get("www-authenticate"))
+}
+
+type tokenServer struct {
+	server   *httptest.Server
+	requests int
+}
+
+func newTokenServer(t *testing.T, expiresIn uint) *tokenServer {
+	s := &tokenServer{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests++
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "secret" {
+			(&oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidClient}).WriteResponse(w, http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "client_credentials", r.PostFormValue("grant_type"))
+		assert.Equal(t, "health", r.PostFormValue("scope"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&oauth2.AccessTokenResponse{
+			AccessToken: "token",
+			TokenType:   oauth2.TokenTypeBearer,
+			ExpiresIn:   expiresIn,
+		})
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func newClientCredentials(s *tokenServer, secret string) *grpcauth.ClientCredentials {
+	credentials := grpcauth.NewClientCredentials(
+		s.server.URL, &service.ClientCredentials{Id: "client", Secret: secret}, "health", nil)
+	credentials.AllowInsecure = true
+	return credentials
+}
+
+func TestClientCredentialsAttachToken(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	server := newTestServer(t, map[string]*bearer.Requirement{checkMethod: {Scopes: []string{"health"}}})
+	client := server.dial(t, grpc.WithPerRPCCredentials(newClientCredentials(tokens, "secret")))
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, tokens.requests)
+}
+
+func TestClientCredentialsRefreshExpiringToken(t *testing.T) {
+	tokens := newTokenServer(t, 10)
+	credentials := newClientCredentials(tokens, "secret")
+
+	for i := 0; i < 2; i++ {
+		token, err := credentials.Token(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "token", token)
+	}
+	assert.Equal(t, 2, tokens.requests)
+}
+
+func TestClientCredentialsUseDefaultLifetimeWithoutExpiresIn(t *testing.T) {
+	tokens := newTokenServer(t, 0)
+	credentials := newClientCredentials(tokens, "secret")
+
+	for i := 0; i < 3; i++ {
+		_, err := credentials.Token(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, tokens.requests)
+}
+
+func TestClientCredentialsReturnTokenEndpointError(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	server := newTestServer(t, nil)
+	client := server.dial(t, grpc.WithPerRPCCredentials(newClientCredentials(tokens, "wrong")))
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), oauth2.ErrorInvalidClient)
+}
+
+func TestClientCredentialsRequireTransportSecurityByDefault(t *testing.T) {
+	credentials := grpcauth.NewClientCredentials(
+		"https://example.com/token", &service.ClientCredentials{Id: "client", Secret: "secret"}, "", nil)
+	assert.True(t, credentials.RequireTransportSecurity())
+}
+
+const issuer = "https://issuer.example.com"
+
+// newTokenEndpoint serves the client credentials grant of the token endpoint,
+// the service issues signed access tokens to the client with the secret.
+func newTokenEndpoint(t *testing.T, keys *keyring.Keyring) *httptest.Server {
+	tokens, err := access_token.NewIssuer(issuer, keys, time.Hour, []string{"https://api.example.com/"}, nil)
+	assert.NoError(t, err)
+	response, err := tokens.Issue(access_token.TokenGrant("client", "", "health", &service.TokenRequest{}))
+	assert.NoError(t, err)
+	oauth2Service := service.NewOauth2ServiceMock()
+	oauth2Service.On("ClientCredentials",
+		&service.ClientCredentials{Id: "client", Secret: "secret"}, "health", &service.TokenRequest{}).Return(response, nil)
+	oauth2Service.On("ClientCredentials",
+		&service.ClientCredentials{Id: "client", Secret: "wrong"}, "health", &service.TokenRequest{}).
+		Return(nil, &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidClient})
+	handler := endpoint.NewTokenEndpointHandler(map[string]endpoint.GrantType{
+		oauth2.GrantTypeClientCredentials: grant_type.NewClientCredentialsController(oauth2Service),
+	}, nil, oauth2.ProtectedResources{}, nil)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientCredentialsObtainTokenFromTokenEndpoint(t *testing.T) {
+	keys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSigning, keyring.Schedule{
+		Algorithm: jose.AlgorithmES256,
+		RetainFor: time.Hour,
+	})
+	assert.NoError(t, err)
+	tokenEndpoint := newTokenEndpoint(t, keys)
+	server := newTestServerWithValidator(t, bearer.NewJWTValidator(issuer, keys),
+		map[string]*bearer.Requirement{checkMethod: {Scopes: []string{"health"}}})
+
+	credentials := grpcauth.NewClientCredentials(
+		tokenEndpoint.URL, &service.ClientCredentials{Id: "client", Secret: "secret"}, "health", nil)
+	credentials.AllowInsecure = true
+	client := server.dial(t, grpc.WithPerRPCCredentials(credentials))
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	if assert.NotNil(t, server.claims) {
+		assert.Equal(t, "client", server.claims.Subject)
+		assert.Equal(t, []string{"health"}, server.claims.Scope)
+	}
+
+	wrong := grpcauth.NewClientCredentials(
+		tokenEndpoint.URL, &service.ClientCredentials{Id: "client", Secret: "wrong"}, "health", nil)
+	wrong.AllowInsecure = true
+	_, err = wrong.Token(context.Background())
+	assert.Equal(t, &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidClient}, err)
+}
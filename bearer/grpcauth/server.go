@@ -0,0 +1,166 @@
+// Package grpcauth protects gRPC services with OAuth 2.0 access tokens and
+// authenticates gRPC clients with tokens of the client credentials grant.
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/arjantop/gopherauth/bearer"
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// Metadata key of the access token, gRPC keys are lower case
+const authorizationKey = "authorization"
+
+// Metadata key of the challenge of rejected requests
+const challengeKey = "www-authenticate"
+
+// Interceptor authorizes calls with the access tokens accepted by the
+// validator. The token must be sent as a bearer token in the authorization
+// metadata, DPoP-bound tokens are not accepted as there is no request URI the
+// proof could be bound to.
+type Interceptor struct {
+	realm        string
+	validator    bearer.Validator
+	requirements map[string]*bearer.Requirement
+}
+
+// NewInterceptor returns an interceptor that requires a valid access token for
+// every method. Requirements are keyed by the full method name, such as
+// "/package.Service/Method", or by the service prefix, such as
+// "/package.Service/", and the method takes precedence.
+func NewInterceptor(realm string, validator bearer.Validator, requirements map[string]*bearer.Requirement) *Interceptor {
+	return &Interceptor{
+		realm:        realm,
+		validator:    validator,
+		requirements: requirements,
+	}
+}
+
+// Unary returns the interceptor of unary calls. Claims of the token are
+// available with bearer.ClaimsFromContext.
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		claims, challenge, err := i.authorize(ctx, info.FullMethod)
+		if err != nil {
+			if challenge != "" {
+				grpc.SetHeader(ctx, metadata.Pairs(challengeKey, challenge))
+			}
+			return nil, err
+		}
+		return handler(bearer.ContextWithClaims(ctx, claims), req)
+	}
+}
+
+// Stream returns the interceptor of streaming calls.
+func (i *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		claims, challenge, err := i.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			if challenge != "" {
+				ss.SetHeader(metadata.Pairs(challengeKey, challenge))
+			}
+			return err
+		}
+		return handler(srv, &serverStream{
+			ServerStream: ss,
+			ctx:          bearer.ContextWithClaims(ss.Context(), claims),
+		})
+	}
+}
+
+// authorize returns the claims of the token of the call, or the status error and
+// the challenge if the call is not authorized.
+func (i *Interceptor) authorize(ctx context.Context, method string) (*bearer.Claims, string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(authorizationKey)
+	if len(values) == 0 {
+		return nil, bearer.ChallengeHeader(oauth2.TokenTypeBearer, i.realm, nil),
+			status.Error(codes.Unauthenticated, "Access token is required")
+	}
+	if len(values) > 1 {
+		return i.reject(codes.Unauthenticated, &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidRequest,
+			Description: "Access token must be sent once",
+		})
+	}
+	scheme, token, _ := strings.Cut(values[0], " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, oauth2.TokenTypeBearer) || token == "" {
+		return i.reject(codes.Unauthenticated, &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidRequest,
+			Description: "Access token must be sent as a bearer token",
+		})
+	}
+	claims, err := i.validator.Validate(token)
+	if err != nil {
+		var response *oauth2.ErrorResponse
+		if errors.As(err, &response) {
+			return i.reject(codes.Unauthenticated, response)
+		}
+		log.Printf("Access token validation failed: %s", err)
+		return nil, "", status.Error(codes.Unavailable, "Access token can not be validated")
+	}
+	if claims.JKT != "" {
+		return i.reject(codes.Unauthenticated, &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidToken,
+			Description: "Access token is bound to a DPoP key",
+		})
+	}
+	if err := i.requirement(method).Check(claims); err != nil {
+		requirementErr := err.(*bearer.RequirementError)
+		code := codes.Unauthenticated
+		if requirementErr.ErrorCode == oauth2.ErrorInsufficientScope {
+			code = codes.PermissionDenied
+		}
+		return i.reject(code, &requirementErr.ErrorResponse, requirementErr.Params...)
+	}
+	return claims, "", nil
+}
+
+func (i *Interceptor) reject(code codes.Code, response *oauth2.ErrorResponse, params ...string) (*bearer.Claims, string, error) {
+	challenge := bearer.ChallengeHeader(oauth2.TokenTypeBearer, i.realm, response, params...)
+	message := response.ErrorCode
+	if response.Description != "" {
+		message += ": " + response.Description
+	}
+	return nil, challenge, status.Error(code, message)
+}
+
+func (i *Interceptor) requirement(method string) *bearer.Requirement {
+	if requirement, ok := i.requirements[method]; ok {
+		return requirement
+	}
+	if slash := strings.LastIndex(method, "/"); slash > 0 {
+		return i.requirements[method[:slash+1]]
+	}
+	return nil
+}
+
+// serverStream replaces the context of the stream with one carrying the claims.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,153 @@
+package bearer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/service"
+)
+
+// Maximum number of cached introspection results
+const maxCachedTokens = 10000
+
+// Maximum size of an introspection response
+const maxIntrospectionResponse = 1 << 20
+
+// IntrospectionValidator validates access tokens at the introspection endpoint
+// of the authorization server (RFC 7662). Results are cached by the hash of the
+// token, so revoked tokens can be accepted for at most the cache duration.
+type IntrospectionValidator struct {
+	endpoint    string
+	credentials *service.ClientCredentials
+	client      *http.Client
+	cacheFor    time.Duration
+	mutex       sync.Mutex
+	cache       map[[sha256.Size]byte]*introspectionResult
+}
+
+type introspectionResult struct {
+	// Nil if the token is not active
+	claims    *Claims
+	expiresAt time.Time
+}
+
+// NewIntrospectionValidator returns a validator that authenticates to the
+// endpoint with the credentials of the resource server. Zero cacheFor disables
+// caching and nil client uses http.DefaultClient.
+func NewIntrospectionValidator(
+	endpoint string,
+	credentials *service.ClientCredentials,
+	client *http.Client,
+	cacheFor time.Duration) *IntrospectionValidator {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &IntrospectionValidator{
+		endpoint:    endpoint,
+		credentials: credentials,
+		client:      client,
+		cacheFor:    cacheFor,
+		cache:       make(map[[sha256.Size]byte]*introspectionResult),
+	}
+}
+
+func (v *IntrospectionValidator) Validate(token string) (*Claims, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	if result := v.cached(key, now); result != nil {
+		return activeClaims(result.claims)
+	}
+	claims, err := v.introspect(token)
+	if err != nil {
+		return nil, err
+	}
+	if v.cacheFor > 0 {
+		expiresAt := now.Add(v.cacheFor)
+		if claims != nil && !claims.ExpiresAt.IsZero() && claims.ExpiresAt.Before(expiresAt) {
+			expiresAt = claims.ExpiresAt
+		}
+		v.store(key, &introspectionResult{claims: claims, expiresAt: expiresAt}, now)
+	}
+	return activeClaims(claims)
+}
+
+func activeClaims(claims *Claims) (*Claims, error) {
+	if claims == nil || (!claims.ExpiresAt.IsZero() && !time.Now().Before(claims.ExpiresAt)) {
+		return nil, newInvalidTokenError("Access token is not active")
+	}
+	return claims, nil
+}
+
+func (v *IntrospectionValidator) cached(key [sha256.Size]byte, now time.Time) *introspectionResult {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	result, ok := v.cache[key]
+	if !ok {
+		return nil
+	}
+	if !now.Before(result.expiresAt) {
+		delete(v.cache, key)
+		return nil
+	}
+	return result
+}
+
+func (v *IntrospectionValidator) store(key [sha256.Size]byte, result *introspectionResult, now time.Time) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if len(v.cache) >= maxCachedTokens {
+		for k, cached := range v.cache {
+			if !now.Before(cached.expiresAt) {
+				delete(v.cache, k)
+			}
+		}
+		if len(v.cache) >= maxCachedTokens {
+			v.cache = make(map[[sha256.Size]byte]*introspectionResult)
+		}
+	}
+	v.cache[key] = result
+}
+
+// introspect returns the claims of an active token, nil if it is not active.
+func (v *IntrospectionValidator) introspect(token string) (*Claims, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+	req, err := http.NewRequest("POST", v.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if v.credentials != nil {
+		req.SetBasicAuth(v.credentials.Id, v.credentials.Secret)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bearer: introspection failed with status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIntrospectionResponse))
+	if err != nil {
+		return nil, err
+	}
+	parsed, claims, err := parseClaims(body)
+	if err != nil {
+		return nil, errors.New("bearer: malformed introspection response")
+	}
+	if !parsed.Active {
+		return nil, nil
+	}
+	return claims, nil
+}
@@ -0,0 +1,112 @@
+package bearer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/bearer"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+)
+
+type introspectionServer struct {
+	server   *httptest.Server
+	requests int
+}
+
+func newIntrospectionServer(t *testing.T, status int, response string) *introspectionServer {
+	s := &introspectionServer{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests++
+		id, secret, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "resource", id)
+		assert.Equal(t, "secret", secret)
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "token", r.PostFormValue("token"))
+		assert.Equal(t, "access_token", r.PostFormValue("token_type_hint"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func newIntrospectionValidator(s *introspectionServer, cacheFor time.Duration) *bearer.IntrospectionValidator {
+	return bearer.NewIntrospectionValidator(
+		s.server.URL, &service.ClientCredentials{Id: "resource", Secret: "secret"}, nil, cacheFor)
+}
+
+func TestIntrospectionValidatorReturnsClaimsOfActiveToken(t *testing.T) {
+	server := newIntrospectionServer(t, http.StatusOK, `{"active":true,"sub":"user","client_id":"client",
+		"scope":"read write","aud":["a","b"],"acr":"mfa","auth_time":1700000000,"exp":4102444800}`)
+	validator := newIntrospectionValidator(server, time.Minute)
+
+	claims, err := validator.Validate("token")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", claims.Subject)
+	assert.Equal(t, "client", claims.ClientId)
+	assert.Equal(t, []string{"read", "write"}, claims.Scope)
+	assert.Equal(t, []string{"a", "b"}, claims.Audience)
+	assert.Equal(t, "mfa", claims.ACR)
+	assert.Equal(t, time.Unix(1700000000, 0), claims.AuthTime)
+}
+
+func TestIntrospectionValidatorCachesResults(t *testing.T) {
+	server := newIntrospectionServer(t, http.StatusOK, `{"active":true,"sub":"user"}`)
+	validator := newIntrospectionValidator(server, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		_, err := validator.Validate("token")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, server.requests)
+}
+
+func TestIntrospectionValidatorWithoutCacheIntrospectsEveryTime(t *testing.T) {
+	server := newIntrospectionServer(t, http.StatusOK, `{"active":true,"sub":"user"}`)
+	validator := newIntrospectionValidator(server, 0)
+
+	for i := 0; i < 2; i++ {
+		_, err := validator.Validate("token")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, server.requests)
+}
+
+func TestIntrospectionValidatorDoesNotCacheBeyondExpiration(t *testing.T) {
+	server := newIntrospectionServer(t, http.StatusOK, `{"active":true,"sub":"user","exp":1}`)
+	validator := newIntrospectionValidator(server, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := validator.Validate("token")
+		assertInvalidToken(t, err)
+	}
+	assert.Equal(t, 2, server.requests)
+}
+
+func TestIntrospectionValidatorRejectsInactiveTokens(t *testing.T) {
+	server := newIntrospectionServer(t, http.StatusOK, `{"active":false}`)
+	validator := newIntrospectionValidator(server, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := validator.Validate("token")
+		assertInvalidToken(t, err)
+	}
+	assert.Equal(t, 1, server.requests)
+}
+
+func TestIntrospectionValidatorFailsIfEndpointFails(t *testing.T) {
+	server := newIntrospectionServer(t, http.StatusUnauthorized, `{"error":"invalid_client"}`)
+	validator := newIntrospectionValidator(server, time.Minute)
+
+	_, err := validator.Validate("token")
+	assert.Error(t, err)
+	_, isErrorResponse := err.(*oauth2.ErrorResponse)
+	assert.False(t, isErrorResponse)
+}
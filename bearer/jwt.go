@@ -0,0 +1,199 @@
+package bearer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// Type of the JWT access tokens defined in RFC 9068
+const jwtAccessTokenType = "at+jwt"
+
+// Minimum time between fetches of a key set caused by unknown key ids
+const minRefreshInterval = 30 * time.Second
+
+// Validator validates access tokens.
+type Validator interface {
+	// Validate returns the claims of a valid active token. The error is an
+	// invalid_token *oauth2.ErrorResponse if the token is not valid, any other
+	// error means the token could not be validated.
+	Validate(token string) (*Claims, error)
+}
+
+// JWTValidator validates JWT access tokens (RFC 9068) locally.
+type JWTValidator struct {
+	issuer string
+	keys   jose.Verifier
+}
+
+// NewJWTValidator returns a validator of the access tokens of the issuer whose
+// signatures are verified with keys, e.g. a keyring or a RemoteKeySet.
+func NewJWTValidator(issuer string, keys jose.Verifier) *JWTValidator {
+	return &JWTValidator{
+		issuer: issuer,
+		keys:   keys,
+	}
+}
+
+func (v *JWTValidator) Validate(token string) (*Claims, error) {
+	jws, err := jose.ParseJWS(token)
+	if err != nil {
+		return nil, newInvalidTokenError("Malformed access token")
+	}
+	if jws.Header.Type != jwtAccessTokenType && jws.Header.Type != "application/"+jwtAccessTokenType {
+		return nil, newInvalidTokenError("Token is not an access token")
+	}
+	if jws.Header.Algorithm == jose.AlgorithmHS256 {
+		return nil, newInvalidTokenError("Access token signature is not valid")
+	}
+	if err := jws.Verify(v.keys); err != nil {
+		if errors.Is(err, errKeySetUnavailable) {
+			return nil, err
+		}
+		return nil, newInvalidTokenError("Access token signature is not valid")
+	}
+	parsed, claims, err := parseClaims(jws.Payload)
+	if err != nil {
+		return nil, newInvalidTokenError("Malformed access token claims")
+	}
+	if claims.Issuer != v.issuer {
+		return nil, newInvalidTokenError("Access token has an unknown issuer")
+	}
+	now := time.Now()
+	if parsed.ExpiresAt == 0 || !now.Before(claims.ExpiresAt) {
+		return nil, newInvalidTokenError("Access token expired")
+	}
+	if parsed.NotBefore != 0 && now.Before(time.Unix(parsed.NotBefore, 0)) {
+		return nil, newInvalidTokenError("Access token is not valid yet")
+	}
+	return claims, nil
+}
+
+// ActiveFunc reports whether a token with valid claims is still active, e.g.
+// whether its grant was revoked after it was issued.
+type ActiveFunc func(claims *Claims) (bool, error)
+
+// RevocableValidator rejects the tokens accepted by another validator that are
+// not active anymore. JWT access tokens are valid until they expire, the
+// authorization server can revoke them only if validators check it.
+type RevocableValidator struct {
+	validator Validator
+	active    ActiveFunc
+}
+
+func NewRevocableValidator(validator Validator, active ActiveFunc) *RevocableValidator {
+	return &RevocableValidator{
+		validator: validator,
+		active:    active,
+	}
+}
+
+func (v *RevocableValidator) Validate(token string) (*Claims, error) {
+	claims, err := v.validator.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+	active, err := v.active(claims)
+	if err != nil {
+		return nil, err
+	} else if !active {
+		return nil, newInvalidTokenError("Access token was revoked")
+	}
+	return claims, nil
+}
+
+var errKeySetUnavailable = errors.New("bearer: key set is not available")
+
+// RemoteKeySet verifies signatures with the keys published at a JWKS URI. Keys
+// are cached and fetched again when they are older than the maximum age or a
+// token is signed with an unknown key, e.g. after a rotation.
+type RemoteKeySet struct {
+	uri       string
+	client    *http.Client
+	maxAge    time.Duration
+	mutex     sync.Mutex
+	keys      *jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+// NewRemoteKeySet returns the key set published at the URI. Nil client uses
+// http.DefaultClient.
+func NewRemoteKeySet(uri string, client *http.Client, maxAge time.Duration) *RemoteKeySet {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &RemoteKeySet{
+		uri:    uri,
+		client: client,
+		maxAge: maxAge,
+	}
+}
+
+func (s *RemoteKeySet) VerifyJWS(j *jose.JWS) error {
+	key, err := s.key(j.Header.KeyID)
+	if err != nil {
+		return err
+	}
+	if key == nil || (key.Alg != "" && key.Alg != j.Header.Algorithm) || (key.Use != "" && key.Use != "sig") {
+		return jose.ErrInvalidSignature
+	}
+	public, err := key.PublicKey()
+	if err != nil {
+		return jose.ErrInvalidSignature
+	}
+	return j.Verify(public)
+}
+
+func (s *RemoteKeySet) key(kid string) (*jose.JSONWebKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if s.keys != nil && now.Sub(s.fetchedAt) < s.maxAge {
+		if key := s.keys.Key(kid); key != nil {
+			return key, nil
+		}
+		if now.Sub(s.fetchedAt) < minRefreshInterval {
+			return nil, nil
+		}
+	}
+	keys, err := s.fetch()
+	if err != nil {
+		// Keys that are already known are used until the key set is available
+		if s.keys != nil {
+			return s.keys.Key(kid), nil
+		}
+		return nil, err
+	}
+	s.keys = keys
+	s.fetchedAt = now
+	return keys.Key(kid), nil
+}
+
+func (s *RemoteKeySet) fetch() (*jose.JSONWebKeySet, error) {
+	resp, err := s.client.Get(s.uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errKeySetUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", errKeySetUnavailable, resp.StatusCode)
+	}
+	keys := &jose.JSONWebKeySet{}
+	if err := json.NewDecoder(resp.Body).Decode(keys); err != nil {
+		return nil, fmt.Errorf("%w: %v", errKeySetUnavailable, err)
+	}
+	return keys, nil
+}
+
+func newInvalidTokenError(description string) *oauth2.ErrorResponse {
+	return &oauth2.ErrorResponse{
+		ErrorCode:   oauth2.ErrorInvalidToken,
+		Description: description,
+	}
+}
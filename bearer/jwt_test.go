@@ -0,0 +1,188 @@
+package bearer_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/bearer"
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/access_token"
+	"github.com/arjantop/gopherauth/service"
+)
+
+const issuer = "https://issuer.example.com"
+
+func newKeyring(t *testing.T) *keyring.Keyring {
+	keys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSigning, keyring.Schedule{
+		Algorithm: jose.AlgorithmES256,
+		RetainFor: time.Hour,
+	})
+	assert.NoError(t, err)
+	return keys
+}
+
+func issueToken(t *testing.T, keys *keyring.Keyring, scope string) string {
+	tokens, err := access_token.NewIssuer(issuer, keys, time.Hour, []string{"https://api.example.com/"}, nil)
+	assert.NoError(t, err)
+	response, err := tokens.Issue(access_token.TokenGrant("client_id", "user", scope, &service.TokenRequest{}))
+	assert.NoError(t, err)
+	return response.AccessToken
+}
+
+func assertInvalidToken(t *testing.T, err error) {
+	if assert.IsType(t, &oauth2.ErrorResponse{}, err) {
+		assert.Equal(t, oauth2.ErrorInvalidToken, err.(*oauth2.ErrorResponse).ErrorCode)
+	}
+}
+
+type keySetServer struct {
+	server  *httptest.Server
+	fetches int
+}
+
+func newKeySetServer(t *testing.T, keys *keyring.Keyring) *keySetServer {
+	s := &keySetServer{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches++
+		set, err := keys.JSONWebKeySet()
+		assert.NoError(t, err)
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func TestJWTValidatorReturnsClaimsOfValidToken(t *testing.T) {
+	keys := newKeyring(t)
+	validator := bearer.NewJWTValidator(issuer, keys)
+
+	claims, err := validator.Validate(issueToken(t, keys, "read write"))
+	assert.NoError(t, err)
+	assert.Equal(t, issuer, claims.Issuer)
+	assert.Equal(t, "user", claims.Subject)
+	assert.Equal(t, "client_id", claims.ClientId)
+	assert.Equal(t, []string{"read", "write"}, claims.Scope)
+	assert.Equal(t, []string{"https://api.example.com/"}, claims.Audience)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), claims.ExpiresAt, 5*time.Second)
+	assert.Equal(t, "user", claims.Raw["sub"])
+	assert.NotEmpty(t, claims.TokenId)
+}
+
+func TestJWTValidatorRejectsTokensOfOtherIssuers(t *testing.T) {
+	keys := newKeyring(t)
+	validator := bearer.NewJWTValidator("https://other.example.com", keys)
+	_, err := validator.Validate(issueToken(t, keys, "read"))
+	assertInvalidToken(t, err)
+}
+
+func TestJWTValidatorRejectsTokensSignedWithUnknownKeys(t *testing.T) {
+	validator := bearer.NewJWTValidator(issuer, newKeyring(t))
+	_, err := validator.Validate(issueToken(t, newKeyring(t), "read"))
+	assertInvalidToken(t, err)
+}
+
+func TestJWTValidatorRejectsExpiredTokens(t *testing.T) {
+	keys := newKeyring(t)
+	token, err := jose.SignClaims(keys, "at+jwt", map[string]interface{}{
+		"iss": issuer,
+		"sub": "user",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	})
+	assert.NoError(t, err)
+	_, err = bearer.NewJWTValidator(issuer, keys).Validate(token)
+	assertInvalidToken(t, err)
+}
+
+func TestJWTValidatorRejectsOtherTokenTypes(t *testing.T) {
+	keys := newKeyring(t)
+	token, err := jose.SignClaims(keys, "JWT", map[string]interface{}{
+		"iss": issuer,
+		"sub": "user",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	assert.NoError(t, err)
+	_, err = bearer.NewJWTValidator(issuer, keys).Validate(token)
+	assertInvalidToken(t, err)
+}
+
+func TestJWTValidatorRejectsMalformedTokens(t *testing.T) {
+	_, err := bearer.NewJWTValidator(issuer, newKeyring(t)).Validate("not-a-jwt")
+	assertInvalidToken(t, err)
+}
+
+func TestRevocableValidatorRejectsInactiveTokens(t *testing.T) {
+	keys := newKeyring(t)
+	revoked := make(map[string]bool)
+	validator := bearer.NewRevocableValidator(bearer.NewJWTValidator(issuer, keys), func(claims *bearer.Claims) (bool, error) {
+		return !revoked[claims.TokenId], nil
+	})
+	token := issueToken(t, keys, "read")
+
+	claims, err := validator.Validate(token)
+	assert.NoError(t, err)
+	revoked[claims.TokenId] = true
+	_, err = validator.Validate(token)
+	assertInvalidToken(t, err)
+	_, err = validator.Validate("not-a-jwt")
+	assertInvalidToken(t, err)
+}
+
+func TestRemoteKeySetCachesKeys(t *testing.T) {
+	keys := newKeyring(t)
+	server := newKeySetServer(t, keys)
+	validator := bearer.NewJWTValidator(issuer, bearer.NewRemoteKeySet(server.server.URL, nil, time.Hour))
+
+	for i := 0; i < 2; i++ {
+		claims, err := validator.Validate(issueToken(t, keys, "read"))
+		assert.NoError(t, err)
+		assert.Equal(t, "user", claims.Subject)
+	}
+	assert.Equal(t, 1, server.fetches)
+}
+
+func TestRemoteKeySetFetchesKeysAgainAfterMaxAge(t *testing.T) {
+	keys := newKeyring(t)
+	server := newKeySetServer(t, keys)
+	validator := bearer.NewJWTValidator(issuer, bearer.NewRemoteKeySet(server.server.URL, nil, 0))
+
+	token := issueToken(t, keys, "read")
+	for i := 0; i < 2; i++ {
+		_, err := validator.Validate(token)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, server.fetches)
+}
+
+func TestRemoteKeySetLimitsFetchesForUnknownKeys(t *testing.T) {
+	keys := newKeyring(t)
+	server := newKeySetServer(t, keys)
+	validator := bearer.NewJWTValidator(issuer, bearer.NewRemoteKeySet(server.server.URL, nil, time.Hour))
+
+	_, err := validator.Validate(issueToken(t, keys, "read"))
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = validator.Validate(issueToken(t, newKeyring(t), "read"))
+		assertInvalidToken(t, err)
+	}
+	assert.Equal(t, 1, server.fetches)
+}
+
+func TestRemoteKeySetUnavailableIsNotAnInvalidToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	validator := bearer.NewJWTValidator(issuer, bearer.NewRemoteKeySet(server.URL, nil, time.Hour))
+
+	_, err := validator.Validate(issueToken(t, newKeyring(t), "read"))
+	assert.Error(t, err)
+	_, isErrorResponse := err.(*oauth2.ErrorResponse)
+	assert.False(t, isErrorResponse)
+}
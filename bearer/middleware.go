@@ -0,0 +1,220 @@
+// Package bearer protects resources with OAuth 2.0 access tokens (RFC 6750).
+package bearer
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/dpop"
+)
+
+// Name of the form and query parameter of the access token
+const accessTokenParameter = "access_token"
+
+// Sources are the ways a client can send the access token. The Authorization
+// header is always accepted.
+type Sources int
+
+const (
+	// Form-encoded body parameter of non-GET requests
+	SourceForm Sources = 1 << iota
+	// URI query parameter, only for clients that can not use the header
+	SourceQuery
+)
+
+// Middleware authorizes requests with the access tokens accepted by the
+// validator and challenges clients without a sufficient token.
+type Middleware struct {
+	realm     string
+	validator Validator
+	sources   Sources
+	dpop      *dpop.Validator
+}
+
+// NewMiddleware returns a middleware for the realm of the resource server. If
+// dpopValidator is nil DPoP-bound tokens are not accepted.
+func NewMiddleware(realm string, validator Validator, sources Sources, dpopValidator *dpop.Validator) *Middleware {
+	return &Middleware{
+		realm:     realm,
+		validator: validator,
+		sources:   sources,
+		dpop:      dpopValidator,
+	}
+}
+
+// Require only passes requests with a valid access token that satisfies the
+// requirement to h. Claims of the token are available with ClaimsFromContext.
+func (m *Middleware) Require(requirement *Requirement, h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, errResponse := m.extractToken(w, r)
+		if errResponse != nil {
+			m.challenge(w, oauth2.TokenTypeBearer, errResponse, http.StatusBadRequest)
+			return
+		}
+		if token == "" {
+			m.challenge(w, oauth2.TokenTypeBearer, nil, http.StatusUnauthorized)
+			return
+		}
+		claims, err := m.validator.Validate(token)
+		if err != nil {
+			var response *oauth2.ErrorResponse
+			if errors.As(err, &response) {
+				m.challenge(w, scheme, response, http.StatusUnauthorized)
+				return
+			}
+			log.Printf("Access token validation failed: %s", err)
+			errResponse := &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorTemporarilyUnavaliable}
+			errResponse.WriteResponse(w, http.StatusServiceUnavailable)
+			return
+		}
+		if !m.validateBinding(w, r, scheme, token, claims) {
+			return
+		}
+		if err := requirement.Check(claims); err != nil {
+			requirementErr := err.(*RequirementError)
+			code := http.StatusUnauthorized
+			if requirementErr.ErrorCode == oauth2.ErrorInsufficientScope {
+				code = http.StatusForbidden
+			}
+			m.challenge(w, scheme, &requirementErr.ErrorResponse, code, requirementErr.Params...)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+	}
+}
+
+// extractToken returns the access token of the request, empty if there is none.
+// Using more than one method is an invalid request (RFC 6750 section 2).
+func (m *Middleware) extractToken(w http.ResponseWriter, r *http.Request) (string, string, *oauth2.ErrorResponse) {
+	var scheme, token string
+	methods := 0
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		s, credentials, _ := strings.Cut(auth, " ")
+		switch {
+		case strings.EqualFold(s, oauth2.TokenTypeBearer):
+			scheme = oauth2.TokenTypeBearer
+		case strings.EqualFold(s, oauth2.TokenTypeDPoP) && m.dpop != nil:
+			scheme = oauth2.TokenTypeDPoP
+		default:
+			// Other authentication schemes are not access tokens
+			return "", "", nil
+		}
+		token = strings.TrimSpace(credentials)
+		if token == "" {
+			return "", "", newInvalidRequestError("Authorization header has no access token")
+		}
+		methods++
+	}
+	if m.sources&SourceForm != 0 && r.Method != "GET" && isFormEncoded(r) {
+		if err := r.ParseForm(); err != nil {
+			return "", "", newInvalidRequestError("Malformed request body")
+		}
+		if values := r.PostForm[accessTokenParameter]; len(values) > 0 {
+			if len(values) > 1 {
+				return "", "", newInvalidRequestError("Multiple access tokens")
+			}
+			scheme, token = oauth2.TokenTypeBearer, values[0]
+			methods++
+		}
+	}
+	if m.sources&SourceQuery != 0 {
+		if values := r.URL.Query()[accessTokenParameter]; len(values) > 0 {
+			if len(values) > 1 {
+				return "", "", newInvalidRequestError("Multiple access tokens")
+			}
+			scheme, token = oauth2.TokenTypeBearer, values[0]
+			methods++
+			// Responses to requests with a token in the URI must not be shared
+			w.Header().Set("Cache-Control", "private")
+		}
+	}
+	if methods > 1 {
+		return "", "", newInvalidRequestError("Access token must be sent with exactly one method")
+	}
+	return scheme, token, nil
+}
+
+func isFormEncoded(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// validateBinding checks that tokens bound to a DPoP key are sent with a proof
+// of possession of the key, and are not used as bearer tokens.
+func (m *Middleware) validateBinding(w http.ResponseWriter, r *http.Request, scheme, token string, claims *Claims) bool {
+	if scheme != oauth2.TokenTypeDPoP {
+		if claims.JKT != "" {
+			m.challenge(w, scheme, newInvalidTokenError("Access token is bound to a DPoP key"), http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	if claims.JKT == "" {
+		m.challenge(w, scheme, newInvalidTokenError("Access token is not bound to a DPoP key"), http.StatusUnauthorized)
+		return false
+	}
+	if _, err := m.dpop.ValidateRequest(r, token, claims.JKT); err != nil {
+		response := err.(*oauth2.ErrorResponse)
+		if response.ErrorCode == oauth2.ErrorUseDPoPNonce {
+			nonce, err := m.dpop.NewNonce()
+			if err != nil {
+				http.Error(w, "", http.StatusServiceUnavailable)
+				return false
+			}
+			w.Header().Set(dpop.HeaderDPoPNonce, nonce)
+		}
+		m.challenge(w, scheme, response, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// challenge responds with the WWW-Authenticate header of the scheme.
+func (m *Middleware) challenge(w http.ResponseWriter, scheme string, response *oauth2.ErrorResponse, code int, params ...string) {
+	w.Header().Set("WWW-Authenticate", ChallengeHeader(scheme, m.realm, response, params...))
+	if response == nil {
+		w.WriteHeader(code)
+		return
+	}
+	response.WriteResponse(w, code)
+}
+
+// ChallengeHeader returns the WWW-Authenticate value of the scheme for the error
+// response, which is nil if the request had no access token. Params are
+// additional name and value pairs of the challenge.
+func ChallengeHeader(scheme, realm string, response *oauth2.ErrorResponse, params ...string) string {
+	parts := []string{}
+	if realm != "" {
+		parts = append(parts, authParam("realm", realm))
+	}
+	if response != nil {
+		parts = append(parts, authParam("error", response.ErrorCode))
+		if response.Description != "" {
+			parts = append(parts, authParam("error_description", response.Description))
+		}
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		parts = append(parts, authParam(params[i], params[i+1]))
+	}
+	if scheme == oauth2.TokenTypeDPoP {
+		parts = append(parts, authParam("algs", "ES256 RS256 EdDSA"))
+	}
+	return strings.TrimSpace(scheme + " " + strings.Join(parts, ", "))
+}
+
+func authParam(name, value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return name + `="` + value + `"`
+}
+
+func newInvalidRequestError(description string) *oauth2.ErrorResponse {
+	return &oauth2.ErrorResponse{
+		ErrorCode:   oauth2.ErrorInvalidRequest,
+		Description: description,
+	}
+}
@@ -0,0 +1,192 @@
+package bearer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/bearer"
+	"github.com/arjantop/gopherauth/oauth2/dpop"
+	"github.com/arjantop/gopherauth/testutil"
+)
+
+var validator = testutil.StaticValidator{
+	"token": {
+		Subject:  "user",
+		Scope:    []string{"read", "write"},
+		Audience: []string{"https://api.example.com/"},
+		AuthTime: time.Now().Add(-time.Hour),
+		ACR:      "pwd",
+	},
+}
+
+type handlerRecorder struct {
+	claims *bearer.Claims
+	called bool
+}
+
+func (h *handlerRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.called = true
+	h.claims = bearer.ClaimsFromContext(r.Context())
+}
+
+func serve(m *bearer.Middleware, requirement *bearer.Requirement, r *http.Request) (*httptest.ResponseRecorder, *handlerRecorder) {
+	handler := &handlerRecorder{}
+	recorder := httptest.NewRecorder()
+	m.Require(requirement, handler).ServeHTTP(recorder, r)
+	return recorder, handler
+}
+
+func newRequest(token string) *http.Request {
+	r := httptest.NewRequest("GET", "https://api.example.com/resource", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestRequestWithoutTokenIsChallenged(t *testing.T) {
+	m := bearer.NewMiddleware("api", validator, 0, nil)
+	recorder, handler := serve(m, nil, newRequest(""))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, `Bearer realm="api"`, recorder.Header().Get("WWW-Authenticate"))
+	assert.False(t, handler.called)
+}
+
+func TestValidTokenPassesClaimsToHandler(t *testing.T) {
+	m := bearer.NewMiddleware("api", validator, 0, nil)
+	recorder, handler := serve(m, &bearer.Requirement{Scopes: []string{"read"}}, newRequest("token"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, handler.called)
+	if assert.NotNil(t, handler.claims) {
+		assert.Equal(t, "user", handler.claims.Subject)
+	}
+}
+
+func TestAuthorizationSchemeIsCaseInsensitive(t *testing.T) {
+	m := bearer.NewMiddleware("api", validator, 0, nil)
+	r := newRequest("")
+	r.Header.Set("Authorization", "bearer token")
+	recorder, _ := serve(m, nil, r)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestInvalidTokenIsChallenged(t *testing.T) {
+	m := bearer.NewMiddleware("api", validator, 0, nil)
+	recorder, handler := serve(m, nil, newRequest("other"))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, `Bearer realm="api", error="invalid_token", error_description="Access token is not valid"`,
+		recorder.Header().Get("WWW-Authenticate"))
+	assert.False(t, handler.called)
+}
+
+func TestTokenWithoutRequiredScopeIsForbidden(t *testing.T) {
+	m := bearer.NewMiddleware("api", validator, 0, nil)
+	recorder, handler := serve(m, &bearer.Requirement{Scopes: []string{"read", "admin"}}, newRequest("token"))
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	challenge := recorder.Header().Get("WWW-Authenticate")
+	assert.Contains(t, challenge, `error="insufficient_scope"`)
+	assert.Contains(t, challenge, `scope="read admin"`)
+	assert.False(t, handler.called)
+}
+
+func TestTokenForOtherAudienceIsRejected(t *testing.T) {
+	m := bearer.NewMiddleware("api", validator, 0, nil)
+	recorder, _ := serve(m, &bearer.Requirement{Audience: "https://other.example.com/"}, newRequest("token"))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+	recorder, _ = serve(m, &bearer.Requirement{Audience: "https://api.example.com/"}, newRequest("token"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestInsufficientAuthenticationRequestsStepUp(t *testing.T) {
+	m := bearer.NewMiddleware("api", validator, 0, nil)
+	recorder, handler := serve(m, &bearer.Requirement{ACRValues: []string{"mfa", "phr"}}, newRequest("token"))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	challenge := recorder.Header().Get("WWW-Authenticate")
+	assert.Contains(t, challenge, `error="insufficient_user_authentication"`)
+	assert.Contains(t, challenge, `acr_values="mfa phr"`)
+	assert.NotContains(t, challenge, "max_age")
+	assert.False(t, handler.called)
+
+	recorder, _ = serve(m, &bearer.Requirement{MaxAge: 5 * time.Minute}, newRequest("token"))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `max_age="300"`)
+
+	recorder, _ = serve(m, &bearer.Requirement{ACRValues: []string{"pwd"}, MaxAge: 2 * time.Hour}, newRequest("token"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestQueryTokenIsOnlyAcceptedIfEnabled(t *testing.T) {
+	r := httptest.NewRequest("GET", "https://api.example.com/resource?access_token=token", nil)
+	recorder, _ := serve(bearer.NewMiddleware("api", validator, 0, nil), nil, r)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	recorder, handler := serve(bearer.NewMiddleware("api", validator, bearer.SourceQuery, nil), nil, r)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, handler.called)
+	assert.Equal(t, "private", recorder.Header().Get("Cache-Control"))
+}
+
+func TestFormTokenIsOnlyAcceptedIfEnabled(t *testing.T) {
+	newFormRequest := func() *http.Request {
+		r := httptest.NewRequest("POST", "https://api.example.com/resource",
+			strings.NewReader(url.Values{"access_token": {"token"}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	recorder, _ := serve(bearer.NewMiddleware("api", validator, bearer.SourceQuery, nil), nil, newFormRequest())
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	recorder, _ = serve(bearer.NewMiddleware("api", validator, bearer.SourceForm, nil), nil, newFormRequest())
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestTokenInMultipleLocationsIsInvalidRequest(t *testing.T) {
+	m := bearer.NewMiddleware("api", validator, bearer.SourceQuery, nil)
+	r := httptest.NewRequest("GET", "https://api.example.com/resource?access_token=token", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	recorder, handler := serve(m, nil, r)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `error="invalid_request"`)
+	assert.False(t, handler.called)
+}
+
+func TestDPoPBoundTokenIsNotAcceptedAsBearerToken(t *testing.T) {
+	key := testutil.NewDPoPKey(t)
+	bound := testutil.StaticValidator{"token": {Subject: "user", JKT: testutil.DPoPThumbprint(t, key)}}
+	m := bearer.NewMiddleware("api", bound, 0, dpop.NewValidator(dpop.NewMemoryReplayCache(), nil))
+
+	recorder, handler := serve(m, nil, newRequest("token"))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	assert.False(t, handler.called)
+}
+
+func TestDPoPBoundTokenIsAcceptedWithProof(t *testing.T) {
+	key := testutil.NewDPoPKey(t)
+	bound := testutil.StaticValidator{"token": {Subject: "user", JKT: testutil.DPoPThumbprint(t, key)}}
+	m := bearer.NewMiddleware("api", bound, 0, dpop.NewValidator(dpop.NewMemoryReplayCache(), nil))
+
+	r := newRequest("")
+	r.Header.Set("Authorization", "DPoP token")
+	r.Header.Set("DPoP", testutil.NewDPoPProof(t, key, map[string]interface{}{
+		"htm": "GET",
+		"htu": "https://api.example.com/resource",
+		"ath": dpop.AccessTokenHash("token"),
+	}))
+	recorder, handler := serve(m, nil, r)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, handler.called)
+
+	r.Header.Del("DPoP")
+	recorder, _ = serve(m, nil, r)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("WWW-Authenticate"), `DPoP realm="api", error="invalid_dpop_proof"`))
+}
@@ -0,0 +1,81 @@
+package bearer
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// Requirement is what an access token must grant to access a route.
+type Requirement struct {
+	// All scopes are required
+	Scopes []string
+	// Audience of the token must contain it, if set
+	Audience string
+	// The user must have authenticated with one of the ACR values, if set
+	ACRValues []string
+	// The user must have authenticated in the last MaxAge, if set
+	MaxAge time.Duration
+}
+
+// RequirementError is the error of an access token that does not satisfy a
+// requirement.
+type RequirementError struct {
+	oauth2.ErrorResponse
+	// Additional parameters of the challenge as name and value pairs, e.g. the
+	// required scope or the acr_values and max_age of RFC 9470
+	Params []string
+}
+
+// Check returns a *RequirementError if the claims do not satisfy the
+// requirement, nil if they do or the requirement is nil.
+func (r *Requirement) Check(claims *Claims) error {
+	if r == nil {
+		return nil
+	}
+	if r.Audience != "" && !claims.HasAudience(r.Audience) {
+		return &RequirementError{ErrorResponse: oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidToken,
+			Description: "Access token is not intended for this resource",
+		}}
+	}
+	for _, scope := range r.Scopes {
+		if !claims.HasScope(scope) {
+			return &RequirementError{
+				ErrorResponse: oauth2.ErrorResponse{
+					ErrorCode:   oauth2.ErrorInsufficientScope,
+					Description: "Access token does not have the required scope",
+				},
+				Params: []string{"scope", strings.Join(r.Scopes, " ")},
+			}
+		}
+	}
+	var params []string
+	if len(r.ACRValues) > 0 && !containsString(r.ACRValues, claims.ACR) {
+		params = append(params, "acr_values", strings.Join(r.ACRValues, " "))
+	}
+	if r.MaxAge > 0 && (claims.AuthTime.IsZero() || time.Since(claims.AuthTime) > r.MaxAge) {
+		params = append(params, "max_age", strconv.FormatInt(int64(r.MaxAge/time.Second), 10))
+	}
+	if len(params) > 0 {
+		return &RequirementError{
+			ErrorResponse: oauth2.ErrorResponse{
+				ErrorCode:   oauth2.ErrorInsufficientUserAuthentication,
+				Description: "A stronger or more recent authentication is required",
+			},
+			Params: params,
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
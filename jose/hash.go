@@ -0,0 +1,21 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+)
+
+// HalfHash computes the left-most half of the hash of value, base64url encoded,
+// as used by the OpenID Connect at_hash and c_hash claims. The hash function
+// is the one used by the signing algorithm.
+func HalfHash(algorithm, value string) string {
+	var sum []byte
+	if algorithm == AlgorithmEdDSA {
+		digest := sha512.Sum512([]byte(value))
+		sum = digest[:]
+	} else {
+		digest := sha256.Sum256([]byte(value))
+		sum = digest[:]
+	}
+	return b64.EncodeToString(sum[:len(sum)/2])
+}
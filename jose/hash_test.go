@@ -0,0 +1,15 @@
+package jose_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+)
+
+func TestHalfHashIsLeftHalfOfDigest(t *testing.T) {
+	// Example from OpenID Connect Core, section A.3
+	assert.Equal(t, "77QmUPtjPfzWtF2AnpK9RQ",
+		jose.HalfHash(jose.AlgorithmRS256, "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y"))
+}
@@ -0,0 +1,278 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+const (
+	KeyTypeEC  = "EC"
+	KeyTypeRSA = "RSA"
+	KeyTypeOKP = "OKP"
+	KeyTypeOct = "oct"
+
+	CurveP256    = "P-256"
+	CurveEd25519 = "Ed25519"
+)
+
+// JSONWebKey is a public key in the JSON Web Key format defined in RFC 7517.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	// Private key members, must never be present in a published key
+	D  string `json:"d,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+	K  string `json:"k,omitempty"`
+}
+
+// JSONWebKeySet is a set of keys as published on a JWKS endpoint.
+type JSONWebKeySet struct {
+	Keys []*JSONWebKey `json:"keys"`
+}
+
+// Key returns the key in the set with the given key id or nil if not found.
+func (s *JSONWebKeySet) Key(kid string) *JSONWebKey {
+	for _, key := range s.Keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+var b64 = base64.RawURLEncoding
+
+// NewJSONWebKey returns a JWK representation of a RSA, P-256 ECDSA or Ed25519
+// public key.
+func NewJSONWebKey(key crypto.PublicKey) (*JSONWebKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &JSONWebKey{
+			Kty: KeyTypeRSA,
+			N:   b64.EncodeToString(k.N.Bytes()),
+			E:   b64.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("jose: unsupported elliptic curve")
+		}
+		return &JSONWebKey{
+			Kty: KeyTypeEC,
+			Crv: CurveP256,
+			X:   b64.EncodeToString(padLeft(k.X.Bytes(), 32)),
+			Y:   b64.EncodeToString(padLeft(k.Y.Bytes(), 32)),
+		}, nil
+	case ed25519.PublicKey:
+		return &JSONWebKey{
+			Kty: KeyTypeOKP,
+			Crv: CurveEd25519,
+			X:   b64.EncodeToString(k),
+		}, nil
+	}
+	return nil, errors.New("jose: unsupported key type")
+}
+
+// PublicKey decodes the public key described by the JWK.
+func (k *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case KeyTypeRSA:
+		n, errN := b64.DecodeString(k.N)
+		e, errE := b64.DecodeString(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("jose: invalid RSA key")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case KeyTypeEC:
+		if k.Crv != CurveP256 {
+			return nil, errors.New("jose: unsupported elliptic curve")
+		}
+		x, errX := b64.DecodeString(k.X)
+		y, errY := b64.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("jose: invalid EC key")
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("jose: EC point is not on curve")
+		}
+		return key, nil
+	case KeyTypeOKP:
+		if k.Crv != CurveEd25519 {
+			return nil, errors.New("jose: unsupported curve")
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("jose: invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("jose: unsupported key type")
+}
+
+// NewPrivateJSONWebKey returns a JWK representation of a RSA, P-256 ECDSA or
+// Ed25519 private key, or of a []byte secret as an oct key.
+func NewPrivateJSONWebKey(key crypto.PrivateKey) (*JSONWebKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, errors.New("jose: unsupported multi-prime RSA key")
+		}
+		k.Precompute()
+		jwk, err := NewJSONWebKey(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		jwk.D = b64.EncodeToString(k.D.Bytes())
+		jwk.P = b64.EncodeToString(k.Primes[0].Bytes())
+		jwk.Q = b64.EncodeToString(k.Primes[1].Bytes())
+		jwk.DP = b64.EncodeToString(k.Precomputed.Dp.Bytes())
+		jwk.DQ = b64.EncodeToString(k.Precomputed.Dq.Bytes())
+		jwk.QI = b64.EncodeToString(k.Precomputed.Qinv.Bytes())
+		return jwk, nil
+	case *ecdsa.PrivateKey:
+		jwk, err := NewJSONWebKey(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		jwk.D = b64.EncodeToString(padLeft(k.D.Bytes(), 32))
+		return jwk, nil
+	case ed25519.PrivateKey:
+		jwk, err := NewJSONWebKey(k.Public())
+		if err != nil {
+			return nil, err
+		}
+		jwk.D = b64.EncodeToString(k.Seed())
+		return jwk, nil
+	case []byte:
+		return &JSONWebKey{
+			Kty: KeyTypeOct,
+			K:   b64.EncodeToString(k),
+		}, nil
+	}
+	return nil, errors.New("jose: unsupported key type")
+}
+
+// PrivateKey decodes the private key described by the JWK, a []byte secret for
+// oct keys.
+func (k *JSONWebKey) PrivateKey() (crypto.PrivateKey, error) {
+	if k.Kty == KeyTypeOct {
+		secret, err := b64.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("jose: invalid oct key")
+		}
+		return secret, nil
+	}
+	public, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	d, err := b64.DecodeString(k.D)
+	if err != nil || len(d) == 0 {
+		return nil, errors.New("jose: missing private key")
+	}
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		p, errP := b64.DecodeString(k.P)
+		q, errQ := b64.DecodeString(k.Q)
+		if errP != nil || errQ != nil || len(p) == 0 || len(q) == 0 {
+			return nil, errors.New("jose: invalid RSA private key")
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: *public,
+			D:         new(big.Int).SetBytes(d),
+			Primes:    []*big.Int{new(big.Int).SetBytes(p), new(big.Int).SetBytes(q)},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, errors.New("jose: invalid RSA private key")
+		}
+		key.Precompute()
+		return key, nil
+	case *ecdsa.PublicKey:
+		key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), padLeft(d, 32))
+		if err != nil || !key.PublicKey.Equal(public) {
+			return nil, errors.New("jose: EC private key does not match public key")
+		}
+		return key, nil
+	case ed25519.PublicKey:
+		if len(d) != ed25519.SeedSize {
+			return nil, errors.New("jose: invalid Ed25519 private key")
+		}
+		key := ed25519.NewKeyFromSeed(d)
+		if !public.Equal(key.Public()) {
+			return nil, errors.New("jose: Ed25519 private key does not match public key")
+		}
+		return key, nil
+	}
+	return nil, errors.New("jose: unsupported key type")
+}
+
+// Public returns a copy of the key without any private key members.
+func (k *JSONWebKey) Public() *JSONWebKey {
+	public := *k
+	public.D = ""
+	public.P = ""
+	public.Q = ""
+	public.DP = ""
+	public.DQ = ""
+	public.QI = ""
+	public.K = ""
+	return &public
+}
+
+// Thumbprint computes the base64url encoded SHA-256 JWK thumbprint as defined
+// in RFC 7638.
+func (k *JSONWebKey) Thumbprint() (string, error) {
+	// Members must be in lexicographic order, encoding/json sorts map keys
+	var members map[string]string
+	switch k.Kty {
+	case KeyTypeRSA:
+		members = map[string]string{"e": k.E, "kty": k.Kty, "n": k.N}
+	case KeyTypeEC:
+		members = map[string]string{"crv": k.Crv, "kty": k.Kty, "x": k.X, "y": k.Y}
+	case KeyTypeOKP:
+		members = map[string]string{"crv": k.Crv, "kty": k.Kty, "x": k.X}
+	default:
+		return "", errors.New("jose: unsupported key type")
+	}
+	encoded, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return b64.EncodeToString(sum[:]), nil
+}
+
+func padLeft(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
@@ -0,0 +1,89 @@
+package jose_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+)
+
+func TestThumbprintMatchesRFC7638Example(t *testing.T) {
+	jwk := &jose.JSONWebKey{
+		Kty: "RSA",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECP" +
+			"ebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2Qv" +
+			"zqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6" +
+			"WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E: "AQAB",
+	}
+	thumbprint, err := jwk.Thumbprint()
+	assert.Nil(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+}
+
+func TestPublicKeyRoundTrip(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, err := jose.NewJSONWebKey(&key.PublicKey)
+	assert.Nil(t, err)
+
+	public, err := jwk.PublicKey()
+	assert.Nil(t, err)
+	assert.True(t, key.PublicKey.Equal(public))
+}
+
+func TestPointNotOnCurveIsRejected(t *testing.T) {
+	jwk := &jose.JSONWebKey{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAE",
+		Y:   "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAE",
+	}
+	_, err := jwk.PublicKey()
+	assert.NotNil(t, err)
+}
+
+func TestPrivateKeyRoundTrip(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	for _, key := range []crypto.PrivateKey{rsaKey, ecKey, edKey, []byte("secret")} {
+		jwk, err := jose.NewPrivateJSONWebKey(key)
+		assert.Nil(t, err)
+
+		private, err := jwk.PrivateKey()
+		assert.Nil(t, err)
+		if equal, ok := key.(interface{ Equal(crypto.PrivateKey) bool }); ok {
+			assert.True(t, equal.Equal(private))
+		} else {
+			assert.Equal(t, key, private)
+		}
+	}
+}
+
+func TestPublicRemovesPrivateMembers(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwk, err := jose.NewPrivateJSONWebKey(key)
+	assert.Nil(t, err)
+
+	public := jwk.Public()
+	assert.Equal(t, &jose.JSONWebKey{Kty: jwk.Kty, N: jwk.N, E: jwk.E}, public)
+	assert.NotEmpty(t, jwk.D)
+}
+
+func TestMismatchedPrivateKeyIsRejected(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := jose.NewPrivateJSONWebKey(key)
+	otherJwk, _ := jose.NewPrivateJSONWebKey(other)
+	jwk.D = otherJwk.D
+
+	_, err := jwk.PrivateKey()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,233 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmHS256 = "HS256"
+)
+
+var ErrInvalidSignature = errors.New("jose: invalid signature")
+
+// Header is the protected header of a JWS.
+type Header struct {
+	Algorithm string      `json:"alg"`
+	Type      string      `json:"typ,omitempty"`
+	KeyID     string      `json:"kid,omitempty"`
+	JWK       *JSONWebKey `json:"jwk,omitempty"`
+}
+
+// Signer computes signatures with a single key and algorithm.
+type Signer interface {
+	Algorithm() string
+	KeyID() string
+	Sign(signingInput []byte) ([]byte, error)
+}
+
+// RotatingSigner is a signer whose key can change between calls, such as a
+// keyring. Current returns the signer of the current key.
+type RotatingSigner interface {
+	Signer
+	Current() Signer
+}
+
+// Verifier verifies signatures with a set of keys, e.g. by the key id.
+type Verifier interface {
+	VerifyJWS(j *JWS) error
+}
+
+// CurrentSigner returns the signer of the current key of a rotating signer or
+// the signer itself.
+func CurrentSigner(s Signer) Signer {
+	if rotating, ok := s.(RotatingSigner); ok {
+		return rotating.Current()
+	}
+	return s
+}
+
+type signer struct {
+	algorithm string
+	keyID     string
+	key       interface{}
+}
+
+// NewSigner returns a signer for the given algorithm. The key must be a
+// *rsa.PrivateKey for RS256, *ecdsa.PrivateKey for ES256, ed25519.PrivateKey for
+// EdDSA and a []byte secret for HS256.
+func NewSigner(algorithm string, key interface{}, keyID string) (Signer, error) {
+	ok := false
+	switch algorithm {
+	case AlgorithmRS256:
+		_, ok = key.(*rsa.PrivateKey)
+	case AlgorithmES256:
+		_, ok = key.(*ecdsa.PrivateKey)
+	case AlgorithmEdDSA:
+		_, ok = key.(ed25519.PrivateKey)
+	case AlgorithmHS256:
+		_, ok = key.([]byte)
+	}
+	if !ok {
+		return nil, errors.New("jose: key does not match algorithm " + algorithm)
+	}
+	return &signer{algorithm, keyID, key}, nil
+}
+
+func (s *signer) Algorithm() string {
+	return s.algorithm
+}
+
+func (s *signer) KeyID() string {
+	return s.keyID
+}
+
+func (s *signer) Sign(signingInput []byte) ([]byte, error) {
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(signingInput)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(signingInput)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		return append(padLeft(r.Bytes(), 32), padLeft(s.Bytes(), 32)...), nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, signingInput), nil
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	}
+	return nil, errors.New("jose: unsupported key type")
+}
+
+// Sign serializes the payload as a compact JWS. Algorithm and key id of the
+// header are set from the signer.
+func Sign(s Signer, header Header, payload []byte) (string, error) {
+	s = CurrentSigner(s)
+	header.Algorithm = s.Algorithm()
+	if header.KeyID == "" {
+		header.KeyID = s.KeyID()
+	}
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64.EncodeToString(encodedHeader) + "." + b64.EncodeToString(payload)
+	signature, err := s.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64.EncodeToString(signature), nil
+}
+
+// SignClaims serializes claims as JSON and signs them as a JWT of the given type.
+func SignClaims(s Signer, typ string, claims interface{}) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return Sign(s, Header{Type: typ}, payload)
+}
+
+// JWS is a parsed compact JWS whose signature has not been verified yet.
+type JWS struct {
+	Header       Header
+	Payload      []byte
+	signingInput []byte
+	signature    []byte
+}
+
+// ParseJWS parses a compact serialized JWS.
+func ParseJWS(token string) (*JWS, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jose: malformed JWS")
+	}
+	encodedHeader, errH := b64.DecodeString(parts[0])
+	payload, errP := b64.DecodeString(parts[1])
+	signature, errS := b64.DecodeString(parts[2])
+	if errH != nil || errP != nil || errS != nil {
+		return nil, errors.New("jose: malformed JWS encoding")
+	}
+	jws := &JWS{
+		Payload:      payload,
+		signingInput: []byte(parts[0] + "." + parts[1]),
+		signature:    signature,
+	}
+	if err := json.Unmarshal(encodedHeader, &jws.Header); err != nil {
+		return nil, errors.New("jose: malformed JWS header")
+	}
+	return jws, nil
+}
+
+// Verify checks the signature using the given public key, or secret for HS256.
+// The algorithm from the header must match the type of the key. If the key is a
+// Verifier the signature is verified by it.
+func (j *JWS) Verify(key interface{}) error {
+	if verifier, ok := key.(Verifier); ok {
+		return verifier.VerifyJWS(j)
+	}
+	switch j.Header.Algorithm {
+	case AlgorithmRS256:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		digest := sha256.Sum256(j.signingInput)
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], j.signature) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgorithmES256:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(j.signature) != 64 {
+			return ErrInvalidSignature
+		}
+		digest := sha256.Sum256(j.signingInput)
+		r := new(big.Int).SetBytes(j.signature[:32])
+		s := new(big.Int).SetBytes(j.signature[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgorithmEdDSA:
+		k, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, j.signingInput, j.signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgorithmHS256:
+		k, ok := key.([]byte)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(j.signingInput)
+		if !hmac.Equal(mac.Sum(nil), j.signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	return errors.New("jose: unsupported algorithm " + j.Header.Algorithm)
+}
+
+// Claims decodes the JSON payload into v.
+func (j *JWS) Claims(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
@@ -0,0 +1,81 @@
+package jose_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+)
+
+func TestSignedPayloadIsVerified(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys := []struct {
+		algorithm string
+		private   interface{}
+		public    interface{}
+	}{
+		{jose.AlgorithmRS256, rsaKey, &rsaKey.PublicKey},
+		{jose.AlgorithmES256, ecKey, &ecKey.PublicKey},
+		{jose.AlgorithmEdDSA, edKey, edPublic},
+		{jose.AlgorithmHS256, []byte("secret"), []byte("secret")},
+	}
+	for _, key := range keys {
+		signer, err := jose.NewSigner(key.algorithm, key.private, "kid1")
+		assert.Nil(t, err)
+
+		token, err := jose.Sign(signer, jose.Header{Type: "JWT"}, []byte(`{"sub":"user"}`))
+		assert.Nil(t, err)
+
+		jws, err := jose.ParseJWS(token)
+		assert.Nil(t, err)
+		assert.Equal(t, key.algorithm, jws.Header.Algorithm)
+		assert.Equal(t, "kid1", jws.Header.KeyID)
+		assert.Nil(t, jws.Verify(key.public), "Algorithm: %s", key.algorithm)
+		assert.Equal(t, `{"sub":"user"}`, string(jws.Payload))
+	}
+}
+
+func TestSignatureWithDifferentKeyIsInvalid(t *testing.T) {
+	key1, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key2, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signer, _ := jose.NewSigner(jose.AlgorithmES256, key1, "")
+
+	token, err := jose.Sign(signer, jose.Header{}, []byte("{}"))
+	assert.Nil(t, err)
+
+	jws, err := jose.ParseJWS(token)
+	assert.Nil(t, err)
+	assert.Equal(t, jose.ErrInvalidSignature, jws.Verify(&key2.PublicKey))
+}
+
+func TestAlgorithmMustMatchKeyType(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signer, _ := jose.NewSigner(jose.AlgorithmHS256, []byte("secret"), "")
+
+	token, err := jose.Sign(signer, jose.Header{}, []byte("{}"))
+	assert.Nil(t, err)
+
+	jws, err := jose.ParseJWS(token)
+	assert.Nil(t, err)
+	assert.NotNil(t, jws.Verify(&key.PublicKey))
+}
+
+func TestSignerKeyMustMatchAlgorithm(t *testing.T) {
+	_, err := jose.NewSigner(jose.AlgorithmRS256, []byte("secret"), "")
+	assert.NotNil(t, err)
+}
+
+func TestMalformedJWSIsRejected(t *testing.T) {
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!.e30.", "e30.!.a"} {
+		_, err := jose.ParseJWS(token)
+		assert.NotNil(t, err, "Token: %s", token)
+	}
+}
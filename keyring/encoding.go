@@ -0,0 +1,117 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+)
+
+const pemTypePrivateKey = "PRIVATE KEY"
+
+// MarshalPEM exports the private key as a PKCS #8 PEM block. Secrets have no
+// standard PEM format and are exported only as JWK.
+func MarshalPEM(key *Key) ([]byte, error) {
+	if key.Symmetric() {
+		return nil, errors.New("keyring: secret keys can only be exported as JWK")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der}), nil
+}
+
+// ParsePEM imports a PKCS #8, PKCS #1 RSA or SEC 1 EC private key PEM block as
+// a pending key. The algorithm is chosen by the type of the key and a key id is
+// generated.
+func ParsePEM(data []byte, now time.Time) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("keyring: no PEM block found")
+	}
+	var private interface{}
+	var err error
+	switch block.Type {
+	case pemTypePrivateKey:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, errors.New("keyring: unsupported PEM block " + block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := algorithmOf(private)
+	if err != nil {
+		return nil, err
+	}
+	return newKey("", algorithm, private, now)
+}
+
+// MarshalJWK exports the private key as a JWK with its key id and algorithm.
+func MarshalJWK(key *Key) ([]byte, error) {
+	jwk, err := jose.NewPrivateJSONWebKey(key.Private)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Kid = key.ID
+	jwk.Alg = key.Algorithm
+	return json.Marshal(jwk)
+}
+
+// ParseJWK imports a private JWK as a pending key. The key id and algorithm of
+// the JWK are kept, they are generated or chosen by the type of the key if
+// missing.
+func ParseJWK(data []byte, now time.Time) (*Key, error) {
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	private, err := jwk.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := algorithmOf(private)
+	if err != nil {
+		return nil, err
+	}
+	if jwk.Alg != "" && jwk.Alg != algorithm {
+		return nil, errors.New("keyring: JWK algorithm does not match the key")
+	}
+	return newKey(jwk.Kid, algorithm, private, now)
+}
+
+// algorithmOf returns the signing algorithm used with the private key.
+func algorithmOf(private interface{}) (string, error) {
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < 2048 {
+			return "", errors.New("keyring: RSA keys must have at least 2048 bits")
+		}
+		return jose.AlgorithmRS256, nil
+	case *ecdsa.PrivateKey:
+		if private.Curve != elliptic.P256() {
+			return "", errors.New("keyring: unsupported elliptic curve")
+		}
+		return jose.AlgorithmES256, nil
+	case ed25519.PrivateKey:
+		return jose.AlgorithmEdDSA, nil
+	case []byte:
+		if len(private) < 32 {
+			return "", errors.New("keyring: secrets must have at least 256 bits")
+		}
+		return jose.AlgorithmHS256, nil
+	}
+	return "", errors.New("keyring: unsupported key type")
+}
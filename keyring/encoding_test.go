@@ -0,0 +1,101 @@
+package keyring_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+)
+
+func TestPEMRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{jose.AlgorithmRS256, jose.AlgorithmES256, jose.AlgorithmEdDSA} {
+		key, err := keyring.NewKey(algorithm, time.Now())
+		assert.NoError(t, err)
+		encoded, err := keyring.MarshalPEM(key)
+		assert.NoError(t, err)
+
+		imported, err := keyring.ParsePEM(encoded, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, algorithm, imported.Algorithm)
+		assert.Equal(t, keyring.StatePending, imported.State)
+		assert.NotEmpty(t, imported.ID)
+		expected, _ := key.JSONWebKey()
+		actual, _ := imported.JSONWebKey()
+		expectedThumbprint, _ := expected.Thumbprint()
+		actualThumbprint, _ := actual.Thumbprint()
+		assert.Equal(t, expectedThumbprint, actualThumbprint)
+	}
+}
+
+func TestPEMImportsLegacyFormats(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDer, _ := x509.MarshalECPrivateKey(ecKey)
+
+	key, err := keyring.ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, jose.AlgorithmRS256, key.Algorithm)
+
+	key, err = keyring.ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDer}), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, jose.AlgorithmES256, key.Algorithm)
+}
+
+func TestPEMRejectsSecretsAndWeakKeys(t *testing.T) {
+	_, err := keyring.MarshalPEM(newSecretKey(t))
+	assert.Error(t, err)
+
+	weak, _ := rsa.GenerateKey(rand.Reader, 1024)
+	der, _ := x509.MarshalPKCS8PrivateKey(weak)
+	_, err = keyring.ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), time.Now())
+	assert.Error(t, err)
+
+	_, err = keyring.ParsePEM([]byte("not a pem"), time.Now())
+	assert.Error(t, err)
+}
+
+func TestJWKRoundTripKeepsKeyIdAndAlgorithm(t *testing.T) {
+	for _, algorithm := range []string{jose.AlgorithmRS256, jose.AlgorithmES256, jose.AlgorithmEdDSA, jose.AlgorithmHS256} {
+		key, err := keyring.NewKey(algorithm, time.Now())
+		assert.NoError(t, err)
+		encoded, err := keyring.MarshalJWK(key)
+		assert.NoError(t, err)
+
+		imported, err := keyring.ParseJWK(encoded, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, key.ID, imported.ID)
+		assert.Equal(t, algorithm, imported.Algorithm)
+		reencoded, _ := keyring.MarshalJWK(imported)
+		assert.Equal(t, string(encoded), string(reencoded))
+	}
+}
+
+func TestJWKWithMismatchedAlgorithmIsRejected(t *testing.T) {
+	key, _ := keyring.NewKey(jose.AlgorithmES256, time.Now())
+	key.Algorithm = jose.AlgorithmRS256
+	encoded, err := keyring.MarshalJWK(key)
+	assert.NoError(t, err)
+
+	_, err = keyring.ParseJWK(encoded, time.Now())
+	assert.Error(t, err)
+}
+
+func TestImportedKeyCanBeAddedToKeyring(t *testing.T) {
+	keys, _ := newKeyring(t)
+	key, _ := keyring.NewKey(jose.AlgorithmES256, time.Now())
+	encoded, _ := keyring.MarshalPEM(key)
+	imported, err := keyring.ParsePEM(encoded, time.Now())
+	assert.NoError(t, err)
+	imported.State = keyring.StateActive
+	assert.NoError(t, keys.Add(imported, time.Now()))
+	assert.Equal(t, imported.ID, keys.KeyID())
+}
@@ -0,0 +1,288 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// Size of the key-encryption and data keys in bytes
+const kekSize = 32
+
+// Iterations of PBKDF2-HMAC-SHA256 used to derive a KEK from a passphrase
+const kekIterations = 600000
+
+var (
+	ErrNoKEK      = errors.New("keyring: key-encryption key is not configured")
+	ErrUnknownKEK = errors.New("keyring: key is encrypted with an unknown key-encryption key")
+)
+
+// KEK is a key-encryption key. Key material is encrypted with a random data key
+// per key and only the data key is encrypted with the KEK, so changing the KEK
+// only re-wraps the data keys.
+type KEK struct {
+	// ID identifies the KEK a key was encrypted with, it is derived from the
+	// KEK and does not reveal it
+	ID  string
+	key []byte
+}
+
+// NewKEK returns the KEK of the 256-bit key.
+func NewKEK(key []byte) (*KEK, error) {
+	if len(key) != kekSize {
+		return nil, errors.New("keyring: key-encryption key must be 256 bits")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("kek-id"))
+	return &KEK{
+		ID:  base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:8]),
+		key: append([]byte(nil), key...),
+	}, nil
+}
+
+// NewPassphraseKEK derives the KEK from the passphrase. The salt must be unique
+// to the installation and must not change, e.g. the issuer.
+func NewPassphraseKEK(passphrase, salt string) (*KEK, error) {
+	if passphrase == "" || salt == "" {
+		return nil, errors.New("keyring: passphrase and salt must not be empty")
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, []byte("gopherauth-kek:"+salt), kekIterations, kekSize)
+	if err != nil {
+		return nil, err
+	}
+	return NewKEK(key)
+}
+
+// ReadKEKFile reads the base64 encoded KEK from the file, e.g. a mounted secret.
+func ReadKEKFile(path string) (*KEK, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeKEK(string(encoded))
+}
+
+// KEKFromEnv reads the base64 encoded KEK from the environment variable.
+// ErrNoKEK is returned if it is not set.
+func KEKFromEnv(name string) (*KEK, error) {
+	encoded, ok := os.LookupEnv(name)
+	if !ok || encoded == "" {
+		return nil, ErrNoKEK
+	}
+	return decodeKEK(encoded)
+}
+
+func decodeKEK(encoded string) (*KEK, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("keyring: key-encryption key is not base64 encoded")
+	}
+	return NewKEK(key)
+}
+
+// EncryptedKey is a key whose material is encrypted with a data key, which is
+// encrypted with a KEK. It is the form keys are persisted in.
+type EncryptedKey struct {
+	ID          string    `json:"id"`
+	Algorithm   string    `json:"alg"`
+	State       State     `json:"state"`
+	CreatedAt   time.Time `json:"created_at"`
+	ActivatedAt time.Time `json:"activated_at,omitzero"`
+	RetiredAt   time.Time `json:"retired_at,omitzero"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+	// ID of the KEK the data key is encrypted with
+	KEKID      string `json:"kek_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Seal encrypts the key material with a new data key wrapped with the KEK.
+func (k *KEK) Seal(key *Key) (*EncryptedKey, error) {
+	material, err := marshalMaterial(key.Private)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, kekSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(dataKey, material, materialAAD(key.ID, key.Algorithm))
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.key, dataKey, []byte(key.ID))
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedKey{
+		ID:          key.ID,
+		Algorithm:   key.Algorithm,
+		State:       key.State,
+		CreatedAt:   key.CreatedAt,
+		ActivatedAt: key.ActivatedAt,
+		RetiredAt:   key.RetiredAt,
+		ExpiresAt:   key.ExpiresAt,
+		KEKID:       k.ID,
+		WrappedKey:  wrapped,
+		Ciphertext:  ciphertext,
+	}, nil
+}
+
+// Open decrypts the key encrypted with the KEK.
+func (k *KEK) Open(encrypted *EncryptedKey) (*Key, error) {
+	dataKey, err := k.unwrap(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	material, err := open(dataKey, encrypted.Ciphertext, materialAAD(encrypted.ID, encrypted.Algorithm))
+	if err != nil {
+		return nil, err
+	}
+	private, err := unmarshalMaterial(material)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		ID:          encrypted.ID,
+		Algorithm:   encrypted.Algorithm,
+		State:       encrypted.State,
+		Private:     private,
+		CreatedAt:   encrypted.CreatedAt,
+		ActivatedAt: encrypted.ActivatedAt,
+		RetiredAt:   encrypted.RetiredAt,
+		ExpiresAt:   encrypted.ExpiresAt,
+	}, nil
+}
+
+// Rewrap returns a copy of the key encrypted with the previous KEK whose data
+// key is wrapped with this KEK. The key material is not decrypted.
+func (k *KEK) Rewrap(encrypted *EncryptedKey, previous *KEK) (*EncryptedKey, error) {
+	dataKey, err := previous.unwrap(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.key, dataKey, []byte(encrypted.ID))
+	if err != nil {
+		return nil, err
+	}
+	rewrapped := *encrypted
+	rewrapped.KEKID = k.ID
+	rewrapped.WrappedKey = wrapped
+	return &rewrapped, nil
+}
+
+func (k *KEK) unwrap(encrypted *EncryptedKey) ([]byte, error) {
+	if encrypted.KEKID != k.ID {
+		return nil, ErrUnknownKEK
+	}
+	return open(k.key, encrypted.WrappedKey, []byte(encrypted.ID))
+}
+
+// EncryptedData is data other than keys encrypted the same way, e.g. shared
+// secrets of users or tokens waiting for delivery.
+type EncryptedData struct {
+	KEKID      string `json:"kek_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SealData encrypts the data with a new data key wrapped with the KEK. The
+// additional data is authenticated, it binds the data to its record so it can
+// not be moved to another one.
+func (k *KEK) SealData(plaintext, additionalData []byte) (*EncryptedData, error) {
+	dataKey := make([]byte, kekSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(dataKey, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.key, dataKey, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedData{KEKID: k.ID, WrappedKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// OpenData decrypts the data with the KEK it was encrypted with, previous KEKs
+// can be given so data is readable until it is sealed again after a KEK change.
+func OpenData(encrypted *EncryptedData, additionalData []byte, keks ...*KEK) ([]byte, error) {
+	for _, k := range keks {
+		if k.ID != encrypted.KEKID {
+			continue
+		}
+		dataKey, err := open(k.key, encrypted.WrappedKey, additionalData)
+		if err != nil {
+			return nil, err
+		}
+		return open(dataKey, encrypted.Ciphertext, additionalData)
+	}
+	return nil, ErrUnknownKEK
+}
+
+// materialAAD binds the key material to its key id and algorithm so it can not
+// be moved to another stored key.
+func materialAAD(id, algorithm string) []byte {
+	return []byte(id + "\x00" + algorithm)
+}
+
+// Key material is a PKCS #8 private key or a secret prefixed by a zero byte,
+// which can not start a DER sequence.
+func marshalMaterial(private interface{}) ([]byte, error) {
+	if secret, ok := private.([]byte); ok {
+		return append([]byte{0}, secret...), nil
+	}
+	return x509.MarshalPKCS8PrivateKey(private)
+}
+
+func unmarshalMaterial(material []byte) (interface{}, error) {
+	if len(material) > 0 && material[0] == 0 {
+		return material[1:], nil
+	}
+	return x509.ParsePKCS8PrivateKey(material)
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("keyring: malformed encrypted key")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, errors.New("keyring: key can not be decrypted")
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,139 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+)
+
+// State is the state of a key in its rotation.
+type State string
+
+const (
+	// Key is published but not used for signing yet
+	StatePending State = "pending"
+	// Key is used for signing
+	StateActive State = "active"
+	// Key is no longer used for signing, signatures are accepted until it expires
+	StateRetired State = "retired"
+)
+
+// Key is a signing key of the keyring.
+type Key struct {
+	ID        string
+	Algorithm string
+	State     State
+	// Private key as accepted by jose.NewSigner, a []byte secret for HS256
+	Private     interface{}
+	CreatedAt   time.Time
+	ActivatedAt time.Time
+	RetiredAt   time.Time
+	// Retired keys are removed when they expire, zero for other keys
+	ExpiresAt time.Time
+}
+
+// NewKey generates a pending key for the algorithm.
+func NewKey(algorithm string, now time.Time) (*Key, error) {
+	private, err := GenerateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return newKey("", algorithm, private, now)
+}
+
+// newKey returns a pending key, a random key id is generated if it is empty.
+func newKey(id, algorithm string, private interface{}, now time.Time) (*Key, error) {
+	if id == "" {
+		random := make([]byte, 12)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		id = base64.RawURLEncoding.EncodeToString(random)
+	}
+	return &Key{
+		ID:        id,
+		Algorithm: algorithm,
+		State:     StatePending,
+		Private:   private,
+		CreatedAt: now,
+	}, nil
+}
+
+// GenerateKey generates a private key for the algorithm, RSA keys have 2048
+// bits and HS256 secrets 256 bits.
+func GenerateKey(algorithm string) (interface{}, error) {
+	switch algorithm {
+	case jose.AlgorithmRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case jose.AlgorithmES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jose.AlgorithmEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	case jose.AlgorithmHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return secret, nil
+	}
+	return nil, errors.New("keyring: unsupported algorithm " + algorithm)
+}
+
+// Symmetric reports whether the key is a secret that must not be published.
+func (k *Key) Symmetric() bool {
+	_, ok := k.Private.([]byte)
+	return ok
+}
+
+// Signer returns the signer of the key.
+func (k *Key) Signer() (jose.Signer, error) {
+	return jose.NewSigner(k.Algorithm, k.Private, k.ID)
+}
+
+// VerificationKey returns the key signatures are verified with, the public key
+// or the secret for HS256.
+func (k *Key) VerificationKey() (interface{}, error) {
+	switch private := k.Private.(type) {
+	case *rsa.PrivateKey:
+		return &private.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		return &private.PublicKey, nil
+	case ed25519.PrivateKey:
+		return private.Public(), nil
+	case []byte:
+		return private, nil
+	}
+	return nil, errors.New("keyring: unsupported key type")
+}
+
+// JSONWebKey returns the public key as a JWK with its key id and algorithm.
+func (k *Key) JSONWebKey() (*jose.JSONWebKey, error) {
+	if k.Symmetric() {
+		return nil, errors.New("keyring: secret keys can not be published")
+	}
+	public, err := k.VerificationKey()
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := jose.NewJSONWebKey(public)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Kid = k.ID
+	jwk.Alg = k.Algorithm
+	jwk.Use = "sig"
+	return jwk, nil
+}
+
+// Valid reports whether signatures of the key are accepted.
+func (k *Key) Valid(now time.Time) bool {
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
@@ -0,0 +1,347 @@
+package keyring
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+)
+
+var (
+	ErrUnknownKey = errors.New("keyring: unknown key")
+	ErrNotSecret  = errors.New("keyring: secret keyrings hold only HS256 keys")
+)
+
+// Usage is what the keys of a keyring are used for.
+type Usage int
+
+const (
+	// Keys sign tokens and responses
+	UsageSigning Usage = iota
+	// Keys are HS256 secrets used for MACs and cookie encryption
+	UsageSecret
+)
+
+// Schedule configures the rotation of the keys.
+type Schedule struct {
+	// Algorithm of the generated keys
+	Algorithm string
+	// Time a key is active before it is replaced by the pending key, zero
+	// disables scheduled rotation
+	RotateAfter time.Duration
+	// Time signatures of a retired key are still accepted, it must be longer
+	// than the lifetime of anything signed with it
+	RetainFor time.Duration
+}
+
+// Keyring holds the keys used for signing. The active key signs, the pending
+// key is published before it becomes active so verifiers can fetch it in time,
+// and retired keys verify signatures until they expire.
+type Keyring struct {
+	mutex    sync.Mutex
+	store    Store
+	usage    Usage
+	schedule Schedule
+	keys     []*Key
+}
+
+// NewKeyring returns the keyring with the stored keys, missing active and
+// pending keys are generated. Secret keyrings refuse keys that are not HS256.
+func NewKeyring(store Store, usage Usage, schedule Schedule) (*Keyring, error) {
+	keys, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	keyring := &Keyring{
+		store:    store,
+		usage:    usage,
+		schedule: schedule,
+		keys:     keys,
+	}
+	if usage == UsageSecret && schedule.Algorithm != jose.AlgorithmHS256 {
+		return nil, ErrNotSecret
+	}
+	for _, key := range keys {
+		if err := keyring.checkUsage(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := keyring.Update(time.Now()); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// Update rotates the keys if the active key is due by the schedule and removes
+// expired keys.
+func (k *Keyring) Update(now time.Time) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.update(now, false)
+}
+
+// Rotate immediately replaces the active key by the pending key, e.g. when the
+// active key is compromised.
+func (k *Keyring) Rotate(now time.Time) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.update(now, true)
+}
+
+// Run updates the keys on every interval until stop is closed.
+func (k *Keyring) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := k.Update(time.Now()); err != nil {
+				log.Printf("Key rotation failed: %s", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (k *Keyring) update(now time.Time, force bool) error {
+	keys := make([]*Key, 0, len(k.keys)+2)
+	var active, pending *Key
+	changed := false
+	for _, key := range k.keys {
+		if !key.Valid(now) {
+			changed = true
+			continue
+		}
+		key = copyKey(key)
+		switch key.State {
+		case StateActive:
+			if active != nil || force ||
+				(k.schedule.RotateAfter > 0 && !now.Before(key.ActivatedAt.Add(k.schedule.RotateAfter))) {
+				retire(key, now, k.schedule.RetainFor)
+				changed = true
+			} else {
+				active = key
+			}
+		case StatePending:
+			if pending == nil || key.CreatedAt.Before(pending.CreatedAt) {
+				pending = key
+			}
+		}
+		keys = append(keys, key)
+	}
+	if active == nil {
+		if pending == nil {
+			generated, err := NewKey(k.schedule.Algorithm, now)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, generated)
+			pending = generated
+		}
+		pending.State = StateActive
+		pending.ActivatedAt = now
+		pending = nil
+		changed = true
+	}
+	hasPending := false
+	for _, key := range keys {
+		hasPending = hasPending || key.State == StatePending
+	}
+	if !hasPending {
+		generated, err := NewKey(k.schedule.Algorithm, now)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, generated)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err := k.store.Save(keys); err != nil {
+		return err
+	}
+	k.keys = keys
+	return nil
+}
+
+func (k *Keyring) checkUsage(key *Key) error {
+	if k.usage == UsageSecret && (key.Algorithm != jose.AlgorithmHS256 || !key.Symmetric()) {
+		return ErrNotSecret
+	}
+	return nil
+}
+
+func retire(key *Key, now time.Time, retainFor time.Duration) {
+	key.State = StateRetired
+	key.RetiredAt = now
+	key.ExpiresAt = now.Add(retainFor)
+}
+
+func copyKey(key *Key) *Key {
+	copied := *key
+	return &copied
+}
+
+// Add adds the key to the keyring, e.g. a key imported from another system. An
+// added active key retires the current active key.
+func (k *Keyring) Add(key *Key, now time.Time) error {
+	if _, err := key.Signer(); err != nil {
+		return err
+	}
+	if err := k.checkUsage(key); err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	keys := make([]*Key, 0, len(k.keys)+1)
+	for _, existing := range k.keys {
+		if existing.ID == key.ID {
+			return errors.New("keyring: duplicate key id " + key.ID)
+		}
+		existing = copyKey(existing)
+		if key.State == StateActive && existing.State == StateActive {
+			retire(existing, now, k.schedule.RetainFor)
+		}
+		keys = append(keys, existing)
+	}
+	keys = append(keys, copyKey(key))
+	if err := k.store.Save(keys); err != nil {
+		return err
+	}
+	k.keys = keys
+	return nil
+}
+
+// Keys returns copies of all keys ordered by creation time.
+func (k *Keyring) Keys() []*Key {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	keys := make([]*Key, len(k.keys))
+	for i, key := range k.keys {
+		keys[i] = copyKey(key)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// active returns the active key, there is always one after the keyring is
+// created.
+func (k *Keyring) active() *Key {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for _, key := range k.keys {
+		if key.State == StateActive {
+			return key
+		}
+	}
+	return nil
+}
+
+// valid returns the keys whose signatures are accepted, the active key first.
+func (k *Keyring) valid(now time.Time) []*Key {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	keys := make([]*Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		if key.State == StateActive {
+			keys = append([]*Key{key}, keys...)
+		} else if key.Valid(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Current returns the signer of the active key. It should be used when more
+// than one signature must be made with the same key.
+func (k *Keyring) Current() jose.Signer {
+	signer, err := k.active().Signer()
+	if err != nil {
+		// Keys are checked when they are added or generated
+		panic(err)
+	}
+	return signer
+}
+
+// Algorithm, KeyID and Sign make the keyring a signer that always signs with
+// the active key.
+func (k *Keyring) Algorithm() string {
+	return k.active().Algorithm
+}
+
+func (k *Keyring) KeyID() string {
+	return k.active().ID
+}
+
+func (k *Keyring) Sign(signingInput []byte) ([]byte, error) {
+	return k.Current().Sign(signingInput)
+}
+
+// VerifyJWS verifies the signature with the key of the key id in the header,
+// or with any key if the header has none. Keys that expired are not used.
+func (k *Keyring) VerifyJWS(jws *jose.JWS) error {
+	for _, key := range k.valid(time.Now()) {
+		if jws.Header.KeyID != "" && jws.Header.KeyID != key.ID {
+			continue
+		}
+		verificationKey, err := key.VerificationKey()
+		if err != nil {
+			return err
+		}
+		if err := jws.Verify(verificationKey); err == nil || jws.Header.KeyID != "" {
+			return err
+		}
+	}
+	if jws.Header.KeyID != "" {
+		return ErrUnknownKey
+	}
+	return jose.ErrInvalidSignature
+}
+
+// Secret returns the secret of the active key. It must only be used with
+// secret keyrings.
+func (k *Keyring) Secret() []byte {
+	secret, ok := k.active().Private.([]byte)
+	if k.usage != UsageSecret || !ok {
+		// Keys are checked when the keyring is created and when they are added
+		panic(ErrNotSecret)
+	}
+	return secret
+}
+
+// Secrets returns the secrets of all keys that are accepted, the active key
+// first. Values signed with any of them must be accepted.
+func (k *Keyring) Secrets() [][]byte {
+	keys := k.valid(time.Now())
+	secrets := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if secret, ok := key.Private.([]byte); ok {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// JSONWebKeySet returns the public keys of the pending, active and retired
+// keys. Secret keys are never published.
+func (k *Keyring) JSONWebKeySet() (*jose.JSONWebKeySet, error) {
+	set := &jose.JSONWebKeySet{Keys: []*jose.JSONWebKey{}}
+	for _, key := range k.valid(time.Now()) {
+		if key.Symmetric() {
+			continue
+		}
+		jwk, err := key.JSONWebKey()
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
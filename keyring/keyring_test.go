@@ -0,0 +1,230 @@
+package keyring_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+)
+
+var schedule = keyring.Schedule{
+	Algorithm:   jose.AlgorithmES256,
+	RotateAfter: 24 * time.Hour,
+	RetainFor:   time.Hour,
+}
+
+func newKeyring(t *testing.T) (*keyring.Keyring, *keyring.MemoryStore) {
+	store := keyring.NewMemoryStore()
+	keys, err := keyring.NewKeyring(store, keyring.UsageSigning, schedule)
+	assert.NoError(t, err)
+	return keys, store
+}
+
+func keysInState(keys []*keyring.Key, state keyring.State) []*keyring.Key {
+	var inState []*keyring.Key
+	for _, key := range keys {
+		if key.State == state {
+			inState = append(inState, key)
+		}
+	}
+	return inState
+}
+
+func publishedKeyIDs(t *testing.T, keys *keyring.Keyring) []string {
+	set, err := keys.JSONWebKeySet()
+	assert.NoError(t, err)
+	var ids []string
+	for _, jwk := range set.Keys {
+		ids = append(ids, jwk.Kid)
+	}
+	return ids
+}
+
+func TestNewKeyringGeneratesActiveAndPendingKeys(t *testing.T) {
+	keys, store := newKeyring(t)
+	all := keys.Keys()
+	assert.Len(t, all, 2)
+	assert.Len(t, keysInState(all, keyring.StateActive), 1)
+	assert.Len(t, keysInState(all, keyring.StatePending), 1)
+	assert.Equal(t, keysInState(all, keyring.StateActive)[0].ID, keys.KeyID())
+	assert.Equal(t, jose.AlgorithmES256, keys.Algorithm())
+	stored, _ := store.Load()
+	assert.Len(t, stored, 2)
+}
+
+func TestNewKeyringUsesStoredKeys(t *testing.T) {
+	keys, store := newKeyring(t)
+	loaded, err := keyring.NewKeyring(store, keyring.UsageSigning, schedule)
+	assert.NoError(t, err)
+	assert.Equal(t, keys.KeyID(), loaded.KeyID())
+	assert.Len(t, loaded.Keys(), 2)
+}
+
+func TestUpdateDoesNotRotateBeforeSchedule(t *testing.T) {
+	keys, _ := newKeyring(t)
+	active := keys.KeyID()
+	assert.NoError(t, keys.Update(time.Now().Add(23*time.Hour)))
+	assert.Equal(t, active, keys.KeyID())
+	assert.Len(t, keys.Keys(), 2)
+}
+
+func TestUpdateRotatesToPendingKeyBySchedule(t *testing.T) {
+	keys, _ := newKeyring(t)
+	active := keys.KeyID()
+	pending := keysInState(keys.Keys(), keyring.StatePending)[0].ID
+	now := time.Now().Add(24 * time.Hour)
+	assert.NoError(t, keys.Update(now))
+	assert.Equal(t, pending, keys.KeyID())
+
+	all := keys.Keys()
+	assert.Len(t, all, 3)
+	retired := keysInState(all, keyring.StateRetired)
+	if assert.Len(t, retired, 1) {
+		assert.Equal(t, active, retired[0].ID)
+		assert.Equal(t, now.Add(time.Hour), retired[0].ExpiresAt)
+	}
+	assert.Len(t, keysInState(all, keyring.StatePending), 1)
+}
+
+func TestUpdateRemovesExpiredKeys(t *testing.T) {
+	keys, _ := newKeyring(t)
+	retired := keys.KeyID()
+	now := time.Now()
+	assert.NoError(t, keys.Rotate(now))
+	assert.NoError(t, keys.Update(now.Add(time.Hour)))
+	for _, key := range keys.Keys() {
+		assert.NotEqual(t, retired, key.ID)
+	}
+	assert.Len(t, keys.Keys(), 2)
+}
+
+func TestRotateReplacesActiveKeyImmediately(t *testing.T) {
+	keys, _ := newKeyring(t)
+	active := keys.KeyID()
+	assert.NoError(t, keys.Rotate(time.Now()))
+	assert.NotEqual(t, active, keys.KeyID())
+	assert.Len(t, keysInState(keys.Keys(), keyring.StateRetired), 1)
+}
+
+func TestSignaturesOfRetiredKeysAreAccepted(t *testing.T) {
+	keys, _ := newKeyring(t)
+	token, err := jose.Sign(keys, jose.Header{}, []byte("payload"))
+	assert.NoError(t, err)
+	assert.NoError(t, keys.Rotate(time.Now()))
+
+	jws, err := jose.ParseJWS(token)
+	assert.NoError(t, err)
+	assert.NoError(t, jws.Verify(keys))
+}
+
+func TestSignaturesOfExpiredKeysAreRejected(t *testing.T) {
+	keys, _ := newKeyring(t)
+	token, err := jose.Sign(keys, jose.Header{}, []byte("payload"))
+	assert.NoError(t, err)
+	assert.NoError(t, keys.Rotate(time.Now().Add(-2*time.Hour)))
+
+	jws, err := jose.ParseJWS(token)
+	assert.NoError(t, err)
+	assert.Equal(t, keyring.ErrUnknownKey, jws.Verify(keys))
+}
+
+func TestSignaturesOfUnknownKeysAreRejected(t *testing.T) {
+	keys, _ := newKeyring(t)
+	other, _ := newKeyring(t)
+	token, err := jose.Sign(other, jose.Header{}, []byte("payload"))
+	assert.NoError(t, err)
+
+	jws, err := jose.ParseJWS(token)
+	assert.NoError(t, err)
+	assert.Equal(t, keyring.ErrUnknownKey, jws.Verify(keys))
+}
+
+func TestTamperedSignaturesAreRejected(t *testing.T) {
+	keys, _ := newKeyring(t)
+	token, err := jose.Sign(keys, jose.Header{}, []byte("payload"))
+	assert.NoError(t, err)
+	other, err := jose.Sign(keys, jose.Header{}, []byte("other"))
+	assert.NoError(t, err)
+
+	jws, err := jose.ParseJWS(token[:len(token)-86] + other[len(other)-86:])
+	assert.NoError(t, err)
+	assert.Equal(t, jose.ErrInvalidSignature, jws.Verify(keys))
+}
+
+func TestKeySetPublishesPendingActiveAndRetiredKeys(t *testing.T) {
+	keys, _ := newKeyring(t)
+	assert.NoError(t, keys.Rotate(time.Now()))
+	ids := publishedKeyIDs(t, keys)
+	assert.Len(t, ids, 3)
+	for _, key := range keys.Keys() {
+		assert.Contains(t, ids, key.ID)
+	}
+
+	set, _ := keys.JSONWebKeySet()
+	for _, jwk := range set.Keys {
+		assert.Equal(t, jose.AlgorithmES256, jwk.Alg)
+		assert.Equal(t, "sig", jwk.Use)
+	}
+}
+
+func TestKeySetDoesNotPublishSecrets(t *testing.T) {
+	keys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSecret, keyring.Schedule{Algorithm: jose.AlgorithmHS256})
+	assert.NoError(t, err)
+	assert.Empty(t, publishedKeyIDs(t, keys))
+	assert.Len(t, keys.Secret(), 32)
+	assert.Len(t, keys.Secrets(), 2)
+}
+
+func TestSecretsStartWithActiveKey(t *testing.T) {
+	keys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSecret, keyring.Schedule{
+		Algorithm: jose.AlgorithmHS256,
+		RetainFor: time.Hour,
+	})
+	assert.NoError(t, err)
+	retired := keys.Secret()
+	assert.NoError(t, keys.Rotate(time.Now()))
+	secrets := keys.Secrets()
+	assert.Len(t, secrets, 3)
+	assert.Equal(t, keys.Secret(), secrets[0])
+	assert.Contains(t, secrets, retired)
+}
+
+func TestAddActiveKeyRetiresCurrentKey(t *testing.T) {
+	keys, _ := newKeyring(t)
+	active := keys.KeyID()
+	key, err := keyring.NewKey(jose.AlgorithmES256, time.Now())
+	assert.NoError(t, err)
+	key.State = keyring.StateActive
+	assert.NoError(t, keys.Add(key, time.Now()))
+	assert.Equal(t, key.ID, keys.KeyID())
+	retired := keysInState(keys.Keys(), keyring.StateRetired)
+	if assert.Len(t, retired, 1) {
+		assert.Equal(t, active, retired[0].ID)
+	}
+}
+
+func TestAddRejectsDuplicateAndInvalidKeys(t *testing.T) {
+	keys, _ := newKeyring(t)
+	assert.Error(t, keys.Add(keys.Keys()[0], time.Now()))
+	assert.Error(t, keys.Add(&keyring.Key{ID: "invalid", Algorithm: jose.AlgorithmES256}, time.Now()))
+	assert.Len(t, keys.Keys(), 2)
+}
+
+func TestSecretKeyringRefusesOtherAlgorithms(t *testing.T) {
+	_, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSecret, schedule)
+	assert.Equal(t, keyring.ErrNotSecret, err)
+
+	signing, store := newKeyring(t)
+	_, err = keyring.NewKeyring(store, keyring.UsageSecret, keyring.Schedule{Algorithm: jose.AlgorithmHS256})
+	assert.Equal(t, keyring.ErrNotSecret, err)
+	assert.Panics(t, func() { signing.Secret() })
+
+	keys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSecret, keyring.Schedule{Algorithm: jose.AlgorithmHS256})
+	assert.NoError(t, err)
+	key, err := keyring.NewKey(jose.AlgorithmES256, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, keyring.ErrNotSecret, keys.Add(key, time.Now()))
+}
@@ -0,0 +1,202 @@
+package keyring
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists the keys of a keyring.
+type Store interface {
+	Load() ([]*Key, error)
+	// Save replaces all stored keys.
+	Save(keys []*Key) error
+}
+
+// MemoryStore keeps the keys in memory, they are lost on restart.
+type MemoryStore struct {
+	mutex sync.Mutex
+	keys  []*Key
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Load() ([]*Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*Key(nil), s.keys...), nil
+}
+
+func (s *MemoryStore) Save(keys []*Key) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys = append([]*Key(nil), keys...)
+	return nil
+}
+
+// RecordStore persists encrypted keys, e.g. in a file or a database table.
+type RecordStore interface {
+	LoadRecords() ([]*EncryptedKey, error)
+	// SaveRecords replaces all stored keys.
+	SaveRecords(records []*EncryptedKey) error
+}
+
+// EncryptedStore stores the keys encrypted with the KEK. Keys encrypted with a
+// previous KEK are re-wrapped with the current KEK when they are loaded.
+type EncryptedStore struct {
+	mutex    sync.Mutex
+	records  RecordStore
+	kek      *KEK
+	previous []*KEK
+}
+
+func NewEncryptedStore(records RecordStore, kek *KEK, previous ...*KEK) *EncryptedStore {
+	return &EncryptedStore{
+		records:  records,
+		kek:      kek,
+		previous: previous,
+	}
+}
+
+func (s *EncryptedStore) Load() ([]*Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	records, err := s.rewrap()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*Key, 0, len(records))
+	for _, record := range records {
+		key, err := s.kek.Open(record)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *EncryptedStore) Save(keys []*Key) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	records := make([]*EncryptedKey, 0, len(keys))
+	for _, key := range keys {
+		record, err := s.kek.Seal(key)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	return s.records.SaveRecords(records)
+}
+
+// Rewrap re-wraps the keys encrypted with a previous KEK with the current KEK.
+// Previous KEKs can be removed once it succeeds.
+func (s *EncryptedStore) Rewrap() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err := s.rewrap()
+	return err
+}
+
+func (s *EncryptedStore) rewrap() ([]*EncryptedKey, error) {
+	records, err := s.records.LoadRecords()
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	for i, record := range records {
+		if record.KEKID == s.kek.ID {
+			continue
+		}
+		previous := s.previousKEK(record.KEKID)
+		if previous == nil {
+			return nil, ErrUnknownKEK
+		}
+		rewrapped, err := s.kek.Rewrap(record, previous)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = rewrapped
+		changed = true
+	}
+	if changed {
+		if err := s.records.SaveRecords(records); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (s *EncryptedStore) previousKEK(id string) *KEK {
+	for _, kek := range s.previous {
+		if kek.ID == id {
+			return kek
+		}
+	}
+	return nil
+}
+
+// FileStore stores encrypted keys as JSON in a file readable only by the owner.
+type FileStore struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) LoadRecords() ([]*EncryptedKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	encoded, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var records []*EncryptedKey
+	if err := json.Unmarshal(encoded, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// SaveRecords replaces the file atomically so a failed write does not lose
+// the keys.
+func (s *FileStore) SaveRecords(records []*EncryptedKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	encoded, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFile(s.path, encoded)
+}
+
+// WriteFile writes the data to a synced temporary file that replaces the file
+// at path, so a crash never leaves a partially written file. The file is only
+// readable by the owner.
+func WriteFile(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
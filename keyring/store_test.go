@@ -0,0 +1,175 @@
+package keyring_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/testutil"
+)
+
+type recordStore struct {
+	records []*keyring.EncryptedKey
+	saves   int
+}
+
+func (s *recordStore) LoadRecords() ([]*keyring.EncryptedKey, error) {
+	return append([]*keyring.EncryptedKey(nil), s.records...), nil
+}
+
+func (s *recordStore) SaveRecords(records []*keyring.EncryptedKey) error {
+	s.records = append([]*keyring.EncryptedKey(nil), records...)
+	s.saves++
+	return nil
+}
+
+func TestEncryptedStoreDoesNotStorePlaintextKeys(t *testing.T) {
+	records := &recordStore{}
+	store := keyring.NewEncryptedStore(records, testutil.NewKEK())
+	keys, err := keyring.NewKeyring(store, keyring.UsageSecret, keyring.Schedule{Algorithm: jose.AlgorithmHS256})
+	assert.NoError(t, err)
+
+	assert.Len(t, records.records, 2)
+	for _, record := range records.records {
+		assert.NotContains(t, string(record.Ciphertext), string(keys.Secret()))
+		assert.NotEmpty(t, record.WrappedKey)
+	}
+
+	loaded, err := keyring.NewKeyring(store, keyring.UsageSecret, keyring.Schedule{Algorithm: jose.AlgorithmHS256})
+	assert.NoError(t, err)
+	assert.Equal(t, keys.Secret(), loaded.Secret())
+	assert.Equal(t, keys.Keys(), loaded.Keys())
+}
+
+func TestEncryptedStoreRoundTripsAllAlgorithms(t *testing.T) {
+	store := keyring.NewEncryptedStore(&recordStore{}, testutil.NewKEK())
+	var keys []*keyring.Key
+	for _, algorithm := range []string{jose.AlgorithmRS256, jose.AlgorithmES256, jose.AlgorithmEdDSA, jose.AlgorithmHS256} {
+		key, err := keyring.NewKey(algorithm, time.Now())
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	assert.NoError(t, store.Save(keys))
+
+	loaded, err := store.Load()
+	assert.NoError(t, err)
+	if assert.Len(t, loaded, len(keys)) {
+		for i, key := range keys {
+			expected, _ := keyring.MarshalJWK(key)
+			actual, _ := keyring.MarshalJWK(loaded[i])
+			assert.Equal(t, string(expected), string(actual))
+			assert.Equal(t, key.State, loaded[i].State)
+		}
+	}
+}
+
+func TestEncryptedStoreRejectsUnknownKEK(t *testing.T) {
+	records := &recordStore{}
+	assert.NoError(t, keyring.NewEncryptedStore(records, testutil.NewKEK()).Save([]*keyring.Key{newSecretKey(t)}))
+
+	_, err := keyring.NewEncryptedStore(records, testutil.NewKEK()).Load()
+	assert.Equal(t, keyring.ErrUnknownKEK, err)
+}
+
+func TestEncryptedStoreRejectsMovedKeyMaterial(t *testing.T) {
+	records := &recordStore{}
+	kek := testutil.NewKEK()
+	store := keyring.NewEncryptedStore(records, kek)
+	assert.NoError(t, store.Save([]*keyring.Key{newSecretKey(t), newSecretKey(t)}))
+	records.records[0].Ciphertext = records.records[1].Ciphertext
+
+	_, err := store.Load()
+	assert.Error(t, err)
+}
+
+func TestEncryptedStoreRewrapsKeysWhenKEKChanges(t *testing.T) {
+	records := &recordStore{}
+	previous := testutil.NewKEK()
+	key := newSecretKey(t)
+	assert.NoError(t, keyring.NewEncryptedStore(records, previous).Save([]*keyring.Key{key}))
+	ciphertext := records.records[0].Ciphertext
+
+	kek := testutil.NewKEK()
+	loaded, err := keyring.NewEncryptedStore(records, kek, previous).Load()
+	assert.NoError(t, err)
+	assert.Equal(t, key.Private, loaded[0].Private)
+	assert.Equal(t, kek.ID, records.records[0].KEKID)
+	assert.Equal(t, ciphertext, records.records[0].Ciphertext, "Only the data key is re-wrapped")
+
+	loaded, err = keyring.NewEncryptedStore(records, kek).Load()
+	assert.NoError(t, err)
+	assert.Equal(t, key.Private, loaded[0].Private)
+}
+
+func TestRewrapDoesNotSaveIfNothingChanged(t *testing.T) {
+	records := &recordStore{}
+	store := keyring.NewEncryptedStore(records, testutil.NewKEK())
+	assert.NoError(t, store.Save([]*keyring.Key{newSecretKey(t)}))
+	assert.NoError(t, store.Rewrap())
+	assert.Equal(t, 1, records.saves)
+}
+
+func TestFileStorePersistsEncryptedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	kek := testutil.NewKEK()
+	keys, err := keyring.NewKeyring(keyring.NewEncryptedStore(keyring.NewFileStore(path), kek), keyring.UsageSigning, schedule)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	contents, _ := os.ReadFile(path)
+	assert.NotContains(t, string(contents), "PRIVATE KEY")
+
+	loaded, err := keyring.NewKeyring(keyring.NewEncryptedStore(keyring.NewFileStore(path), kek), keyring.UsageSigning, schedule)
+	assert.NoError(t, err)
+	assert.Equal(t, keys.KeyID(), loaded.KeyID())
+}
+
+func TestFileStoreWithoutFileIsEmpty(t *testing.T) {
+	records, err := keyring.NewFileStore(filepath.Join(t.TempDir(), "keys.json")).LoadRecords()
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestPassphraseKEKIsDerivedDeterministically(t *testing.T) {
+	kek, err := keyring.NewPassphraseKEK("passphrase", "https://issuer.example.com")
+	assert.NoError(t, err)
+	same, _ := keyring.NewPassphraseKEK("passphrase", "https://issuer.example.com")
+	assert.Equal(t, kek.ID, same.ID)
+
+	_, err = keyring.NewPassphraseKEK("", "salt")
+	assert.Error(t, err)
+}
+
+func TestKEKIsReadFromFileAndEnvironment(t *testing.T) {
+	encoded := "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	path := filepath.Join(t.TempDir(), "kek")
+	assert.NoError(t, os.WriteFile(path, []byte(encoded+"\n"), 0600))
+	fromFile, err := keyring.ReadKEKFile(path)
+	assert.NoError(t, err)
+
+	t.Setenv("TEST_KEK", encoded)
+	fromEnv, err := keyring.KEKFromEnv("TEST_KEK")
+	assert.NoError(t, err)
+	assert.Equal(t, fromFile.ID, fromEnv.ID)
+
+	_, err = keyring.KEKFromEnv("TEST_KEK_MISSING")
+	assert.Equal(t, keyring.ErrNoKEK, err)
+
+	t.Setenv("TEST_KEK", strings.Repeat("A", 8))
+	_, err = keyring.KEKFromEnv("TEST_KEK")
+	assert.Error(t, err)
+}
+
+func newSecretKey(t *testing.T) *keyring.Key {
+	key, err := keyring.NewKey(jose.AlgorithmHS256, time.Now())
+	assert.NoError(t, err)
+	return key
+}
@@ -0,0 +1,50 @@
+package login
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ContinueURLs decides where the user is returned to after login.
+type ContinueURLs struct {
+	defaultURL     string
+	allowedOrigins []string
+}
+
+// NewContinueURLs returns continue URLs that allow paths on the same origin and
+// absolute URLs of the allowed origins, e.g. https://app.example.com. Users are
+// returned to the default URL if the login has no allowed continue URL.
+func NewContinueURLs(defaultURL string, allowedOrigins []string) *ContinueURLs {
+	origins := make([]string, len(allowedOrigins))
+	for i, origin := range allowedOrigins {
+		origins[i] = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	}
+	return &ContinueURLs{defaultURL: defaultURL, allowedOrigins: origins}
+}
+
+// Resolve returns the continue URL if it is allowed or the default URL.
+func (c *ContinueURLs) Resolve(continueUrl string) string {
+	if c.Allowed(continueUrl) {
+		return continueUrl
+	}
+	return c.defaultURL
+}
+
+// Allowed reports whether the user can be redirected to the URL.
+func (c *ContinueURLs) Allowed(continueUrl string) bool {
+	// Browsers treat backslashes as slashes, /\example.com is another host
+	if continueUrl == "" || strings.ContainsAny(continueUrl, "\\\t\r\n") {
+		return false
+	}
+	parsed, err := url.Parse(continueUrl)
+	if err != nil || parsed.User != nil || parsed.Opaque != "" {
+		return false
+	}
+	if parsed.Scheme == "" && parsed.Host == "" {
+		return strings.HasPrefix(continueUrl, "/") && !strings.HasPrefix(continueUrl, "//")
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return false
+	}
+	return containsString(c.allowedOrigins, strings.ToLower(parsed.Scheme+"://"+parsed.Host))
+}
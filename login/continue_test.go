@@ -0,0 +1,101 @@
+package login_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/util"
+)
+
+func TestContinueURLsAllowSameOriginPathsAndAllowedOrigins(t *testing.T) {
+	allowed := []string{
+		"/auth?client_id=client",
+		"https://example.com/redirect",
+		"HTTPS://Example.com",
+	}
+	for _, continueUrl := range allowed {
+		assert.True(t, continueURLs.Allowed(continueUrl), continueUrl)
+		assert.Equal(t, continueUrl, continueURLs.Resolve(continueUrl))
+	}
+	disallowed := []string{
+		"",
+		"//evil.example.com/path",
+		"/\\evil.example.com",
+		"https://evil.example.com/redirect",
+		"https://example.com.evil.example.com",
+		"https://user@example.com/redirect",
+		"javascript:alert(1)",
+		"relative/path",
+	}
+	for _, continueUrl := range disallowed {
+		assert.False(t, continueURLs.Allowed(continueUrl), continueUrl)
+		assert.Equal(t, landingUrl, continueURLs.Resolve(continueUrl))
+	}
+}
+
+// loginLocation logs in with the login parameters and returns where the user
+// is redirected to.
+func loginLocation(t *testing.T, deps loginDeps, params url.Values) string {
+	request := testutil.NewEndpointPostRequest(t, "login", params, deps.postParams)
+	request.AddCookie(&http.Cookie{Name: "nonce", Value: deps.nonce, HttpOnly: true})
+	deps.userAuthService.On(
+		"AuthenticateUser",
+		deps.postParams.Get("email"),
+		deps.postParams.Get("password")).Return(nil)
+	deps.userAuthService.On(
+		"StartSession", deps.postParams.Get("email"), []string{"pwd"}, "").Return(&service.Session{Id: "SessionId"}, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	return recorder.Header().Get("Location")
+}
+
+func TestDirectLoginContinuesToDefaultURL(t *testing.T) {
+	deps := makeLogin()
+
+	assert.Equal(t, landingUrl, loginLocation(t, deps, nil))
+}
+
+func TestSignedSameOriginPathIsAccepted(t *testing.T) {
+	deps := makeLogin()
+	params := url.Values{
+		"continue":     {"/auth?client_id=client"},
+		"continue_sig": {util.SignContinue("/auth?client_id=client", []byte("ServerKey"))},
+	}
+
+	assert.Equal(t, "/auth?client_id=client", loginLocation(t, deps, params))
+}
+
+func TestUnsignedContinueIsIgnored(t *testing.T) {
+	deps := makeLogin()
+	params := url.Values{"continue": {"https://example.com/other"}}
+
+	assert.Equal(t, landingUrl, loginLocation(t, deps, params))
+}
+
+func TestSwappedContinueIsIgnored(t *testing.T) {
+	deps := makeLogin()
+	params := url.Values{
+		"continue":     {"https://example.com/other"},
+		"continue_sig": {deps.getParams.Get("continue_sig")},
+	}
+
+	assert.Equal(t, landingUrl, loginLocation(t, deps, params))
+}
+
+func TestSignedContinueOfOtherOriginIsRejected(t *testing.T) {
+	deps := makeLogin()
+	params := url.Values{
+		"continue":     {"https://evil.example.com/"},
+		"continue_sig": {util.SignContinue("https://evil.example.com/", []byte("ServerKey"))},
+	}
+
+	assert.Equal(t, landingUrl, loginLocation(t, deps, params))
+}
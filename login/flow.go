@@ -0,0 +1,177 @@
+package login
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/throttle"
+	"github.com/arjantop/gopherauth/webauthn"
+)
+
+// Step is one step of a login flow, e.g. asking for the password. Steps keep
+// all their state in the transaction.
+type Step interface {
+	// Skip reports whether the step does not apply to the transaction, e.g.
+	// the user has not enrolled the factor the step asks for.
+	Skip(tx *Transaction) (bool, error)
+	// Prepare adds the form of the step to the login page.
+	Prepare(tx *Transaction, page *Login) error
+	// Handles reports whether the request is a submission of the step form.
+	Handles(r *http.Request) bool
+	// Submit handles the submitted form and records the result in the
+	// transaction. StepFailed is returned if the input is not accepted.
+	Submit(tx *Transaction, r *http.Request) error
+}
+
+// StepFailed is returned by steps when the user input is not accepted, the
+// message is displayed to the user.
+type StepFailed struct {
+	Message string
+}
+
+func (e StepFailed) Error() string {
+	return e.Message
+}
+
+// errTransactionExpired is returned by steps when the state of the transaction
+// does not match the submitted form.
+var errTransactionExpired = errors.New("Login transaction has expired")
+
+// Flow is a sequence of steps the user must complete to log in.
+type Flow struct {
+	// Name identifies the flow in transactions
+	Name string
+	// Authentication context class the flow satisfies, recorded in the session
+	ACR   string
+	Steps []Step
+}
+
+// Flows selects the flow of a login.
+type Flows struct {
+	// Flow used if no other flow is selected
+	Default *Flow
+	// Flows selected when their acr is requested
+	ACR []*Flow
+	// Flows selected by client id
+	Clients map[string]*Flow
+}
+
+// Select returns the flow for the client and the requested acr values. The
+// first requested acr with a flow has precedence over the flow of the client.
+func (f *Flows) Select(clientId string, acrValues []string) *Flow {
+	for _, acr := range acrValues {
+		for _, flow := range f.ACR {
+			if flow.ACR == acr {
+				return flow
+			}
+		}
+	}
+	if flow, ok := f.Clients[clientId]; ok {
+		return flow
+	}
+	return f.Default
+}
+
+// Named returns the flow with the name or nil if there is none.
+func (f *Flows) Named(name string) *Flow {
+	flows := append([]*Flow{f.Default}, f.ACR...)
+	for _, flow := range f.Clients {
+		flows = append(flows, flow)
+	}
+	for _, flow := range flows {
+		if flow != nil && flow.Name == name {
+			return flow
+		}
+	}
+	return nil
+}
+
+// DefaultFlow returns the flow where users sign in with a password or a
+// passkey, users that signed in with a password then enter a second factor
+// they enrolled. Failures of all steps are throttled by the throttler. Nil
+// throttler, secondFactor or passkeys disables them.
+func DefaultFlow(
+	userAuthService service.UserAuthenticationService,
+	throttler *throttle.Throttler,
+	secondFactor SecondFactor,
+	passkeys *webauthn.RelyingParty) *Flow {
+
+	firstFactors := []Step{NewPasswordStep(userAuthService, throttler)}
+	secondFactors := []Step{}
+	if secondFactor != nil {
+		secondFactors = append(secondFactors, NewTOTPStep(secondFactor, throttler))
+	}
+	if passkeys != nil {
+		firstFactors = append(firstFactors, NewPasskeyStep(passkeys, throttler))
+		secondFactors = append(secondFactors, NewPasskeyStep(passkeys, throttler))
+	}
+	return &Flow{
+		Name:  "default",
+		Steps: []Step{NewAnyStep(firstFactors...), NewAnyStep(secondFactors...)},
+	}
+}
+
+type anyStep struct {
+	steps []Step
+}
+
+// NewAnyStep returns a step where the user completes one of the steps, e.g.
+// signs in with a password or a passkey. The step is skipped if all of the
+// steps are skipped.
+func NewAnyStep(steps ...Step) Step {
+	return &anyStep{steps: steps}
+}
+
+func (s *anyStep) Skip(tx *Transaction) (bool, error) {
+	steps, err := s.available(tx)
+	return len(steps) == 0, err
+}
+
+func (s *anyStep) Prepare(tx *Transaction, page *Login) error {
+	steps, err := s.available(tx)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if err := step.Prepare(tx, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *anyStep) Handles(r *http.Request) bool {
+	for _, step := range s.steps {
+		if step.Handles(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *anyStep) Submit(tx *Transaction, r *http.Request) error {
+	steps, err := s.available(tx)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if step.Handles(r) {
+			return step.Submit(tx, r)
+		}
+	}
+	return errTransactionExpired
+}
+
+func (s *anyStep) available(tx *Transaction) ([]Step, error) {
+	available := make([]Step, 0, len(s.steps))
+	for _, step := range s.steps {
+		skip, err := step.Skip(tx)
+		if err != nil {
+			return nil, err
+		} else if !skip {
+			available = append(available, step)
+		}
+	}
+	return available, nil
+}
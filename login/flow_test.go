@@ -0,0 +1,183 @@
+package login_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/login"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/util"
+)
+
+var terms = login.Terms{Version: "2", URL: "https://example.com/terms"}
+
+// makeLoginWithFlows returns login dependencies with an identifier-first flow
+// for acr urn:example:terms and a password only flow for the client.
+func makeLoginWithFlows() loginDeps {
+	deps := makeLogin()
+	flows := &login.Flows{
+		Default: login.DefaultFlow(deps.userAuthService, nil, nil, nil),
+		ACR: []*login.Flow{{
+			Name: "identifier-first",
+			ACR:  "urn:example:terms",
+			Steps: []login.Step{
+				login.NewIdentifierStep(),
+				login.NewPasswordStep(deps.userAuthService, nil),
+				login.NewTermsStep(terms, login.NewMemoryTermsStore()),
+			},
+		}},
+		Clients: map[string]*login.Flow{
+			"identifier_client": {
+				Name:  "identifier-client",
+				Steps: []login.Step{login.NewIdentifierStep(), login.NewPasswordStep(deps.userAuthService, nil)},
+			},
+		},
+	}
+	deps.handler = login.NewLoginHandler(
+		serverKeys, cookies, deps.userAuthService, flows, continueURLs, deps.tokenGenerator, deps.templateFactory)
+	return deps
+}
+
+// continueTo returns the redirect url with the parameters of the authorization
+// request the user returns to after login.
+func continueTo(params url.Values) string {
+	if len(params) == 0 {
+		return redirectUrl
+	}
+	return redirectUrl + "?" + params.Encode()
+}
+
+// startLogin starts the login for the authorization request with the parameters,
+// they are part of the signed continue url.
+func startLogin(t *testing.T, deps loginDeps, params url.Values) *httptest.ResponseRecorder {
+	continueUrl := continueTo(params)
+	request := testutil.NewEndpointRequest(t, "GET", "login", url.Values{
+		"continue":     {continueUrl},
+		"continue_sig": {util.SignContinue(continueUrl, []byte("ServerKey"))},
+	})
+	deps.tokenGenerator.On("Generate", uint(login.TokenSize)).Return([]byte("NewNonce"))
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	return recorder
+}
+
+// submitStep submits the form of the current step with the transaction cookie
+// of the previous response.
+func submitStep(
+	t *testing.T, deps loginDeps, previous *httptest.ResponseRecorder, form url.Values) *httptest.ResponseRecorder {
+
+	form.Add("csrf", deps.postParams.Get("csrf"))
+	request := testutil.NewEndpointPostRequest(t, "login", nil, form)
+	request.AddCookie(&http.Cookie{Name: "nonce", Value: deps.nonce, HttpOnly: true})
+	transaction := findCookie(previous, login.CookieTransaction)
+	assert.NotNil(t, transaction)
+	request.AddCookie(transaction)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestFlowIsSelectedByACRAndContinueSurvivesAllSteps(t *testing.T) {
+	deps := makeLoginWithFlows()
+	email := deps.postParams.Get("email")
+
+	authRequest := url.Values{"acr_values": {"urn:example:other urn:example:terms"}}
+	recorder := startLogin(t, deps, authRequest)
+	assert.Contains(t, recorder.Body.String(), `id="next"`, "Only the email is asked for")
+	assert.NotContains(t, recorder.Body.String(), `name="password"`)
+
+	recorder = submitStep(t, deps, recorder, url.Values{"email": {email}})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `name="password"`)
+	assert.Contains(t, recorder.Body.String(), `<p class="identity">`+email+`</p>`)
+
+	deps.userAuthService.On("AuthenticateUser", email, "password").Return(nil)
+	recorder = submitStep(t, deps, recorder, url.Values{"password": {"password"}})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `href="https://example.com/terms"`)
+	assert.Nil(t, findCookie(recorder, "sessionid"), "Session must not be started before the last step")
+
+	deps.userAuthService.On(
+		"StartSession", email, []string{"pwd"}, "urn:example:terms").Return(&service.Session{Id: "SessionId"}, nil)
+	recorder = submitStep(t, deps, recorder, url.Values{"accept_terms": {"2"}})
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, continueTo(authRequest), recorder.Header().Get("Location"))
+	assert.Equal(t, "SessionId", findCookie(recorder, "sessionid").Value)
+	deps.userAuthService.Mock.AssertExpectations(t)
+}
+
+func TestFlowIsSelectedByClient(t *testing.T) {
+	deps := makeLoginWithFlows()
+
+	recorder := startLogin(t, deps, url.Values{"client_id": {"identifier_client"}})
+	assert.Contains(t, recorder.Body.String(), `id="next"`)
+
+	recorder = startLogin(t, deps, url.Values{"client_id": {"other_client"}})
+	assert.NotContains(t, recorder.Body.String(), `id="next"`)
+	assert.Contains(t, recorder.Body.String(), `name="password"`)
+}
+
+func TestFlowIsNotSelectedByLoginParameters(t *testing.T) {
+	deps := makeLoginWithFlows()
+
+	params := url.Values{
+		"client_id":    {"identifier_client"},
+		"acr_values":   {"urn:example:terms"},
+		"continue":     {redirectUrl},
+		"continue_sig": {util.SignContinue(redirectUrl, []byte("ServerKey"))},
+	}
+	deps.tokenGenerator.On("Generate", uint(login.TokenSize)).Return([]byte("NewNonce"))
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, testutil.NewEndpointRequest(t, "GET", "login", params))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), `id="next"`, "Default flow must be used")
+	assert.Contains(t, recorder.Body.String(), `name="password"`)
+}
+
+func TestFailedStepIsDisplayedAgain(t *testing.T) {
+	deps := makeLoginWithFlows()
+	email := deps.postParams.Get("email")
+
+	recorder := startLogin(t, deps, url.Values{"client_id": {"identifier_client"}})
+	recorder = submitStep(t, deps, recorder, url.Values{"email": {email}})
+	deps.userAuthService.On("AuthenticateUser", email, "wrong").Return(service.CredentialsMismatch{})
+	recorder = submitStep(t, deps, recorder, url.Values{"password": {"wrong"}})
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "The email or password you entered is incorrect.")
+	assert.Contains(t, recorder.Body.String(), `<p class="identity">`+email+`</p>`)
+	assert.Nil(t, findCookie(recorder, "sessionid"))
+}
+
+func TestFormOfOtherStepIsRejected(t *testing.T) {
+	deps := makeLoginWithFlows()
+
+	recorder := startLogin(t, deps, url.Values{"acr_values": {"urn:example:terms"}})
+	recorder = submitStep(t, deps, recorder, url.Values{"accept_terms": {"2"}})
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Nil(t, findCookie(recorder, "sessionid"))
+}
+
+func TestTransactionIsEncrypted(t *testing.T) {
+	deps := makeLoginWithFlows()
+
+	recorder := startLogin(t, deps, url.Values{"client_id": {"identifier_client"}})
+	recorder = submitStep(t, deps, recorder, url.Values{"email": {deps.postParams.Get("email")}})
+
+	sealed, err := base64.RawURLEncoding.DecodeString(findCookie(recorder, login.CookieTransaction).Value)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(sealed), deps.postParams.Get("email")))
+	assert.False(t, strings.Contains(string(sealed), "example.com/redirect"))
+}
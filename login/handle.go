@@ -7,32 +7,72 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/oauth2"
 	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/util"
+	"github.com/arjantop/gopherauth/webauthn"
 )
 
 const (
 	TokenSize   = 128
 	CookieNonce = "nonce"
+	// Form fields of the steps
+	FieldEmail    = "email"
+	FieldPassword = "password"
+	FieldCode     = "otp"
+	FieldPasskey  = "passkey"
+	// Authentication method references (RFC 8176)
+	MethodPassword    = "pwd"
+	MethodOTP         = "otp"
+	MethodHardwareKey = "hwk"
+	MethodMultiFactor = "mfa"
+	// Recovery codes are not registered, they are weaker than one-time codes
+	MethodRecoveryCode = "rec"
 )
 
+// SecondFactor verifies one-time codes of users that enrolled a second factor.
+type SecondFactor interface {
+	Enrolled(user string) (bool, error)
+	// Verify returns totp.InvalidCode if the code is not accepted.
+	Verify(user, code string) error
+	// Recover returns totp.InvalidCode if the recovery code is not accepted.
+	Recover(user, code string) error
+}
+
 type loginHandler struct {
-	serverKey       []byte
+	serverKeys      *keyring.Keyring
+	cookies         *util.CookiePolicy
 	userAuthService service.UserAuthenticationService
+	flows           *Flows
+	continueURLs    *ContinueURLs
 	tokenGenerator  service.TokenGenerator
 	templateFactory *util.TemplateFactory
 }
 
+// NewLoginHandler returns the login handler. The user completes the steps of
+// the flow selected by the client and the requested acr values, the session is
+// started after the last step. The user is then returned to the signed continue
+// URL of the login or the default URL.
 func NewLoginHandler(
-	serverKey []byte,
+	serverKeys *keyring.Keyring,
+	cookies *util.CookiePolicy,
 	userAuthService service.UserAuthenticationService,
+	flows *Flows,
+	continueURLs *ContinueURLs,
 	tokenGenerator service.TokenGenerator,
 	templateFactory *util.TemplateFactory) http.Handler {
 
 	return &loginHandler{
-		serverKey:       serverKey,
+		serverKeys:      serverKeys,
+		cookies:         cookies,
 		userAuthService: userAuthService,
+		flows:           flows,
+		continueURLs:    continueURLs,
 		tokenGenerator:  tokenGenerator,
 		templateFactory: templateFactory,
 	}
@@ -42,65 +82,63 @@ type Login struct {
 	User, Password string
 	ErrorMessage   string
 	Csrf           string
+	// The user already completed an authentication step
+	SecondFactor bool
+	// Forms of the current step
+	AskIdentifier bool
+	AskPassword   bool
+	// The user is identified and only the password is asked for
+	Identified bool
+	OTP        bool
+	// Options of a passkey sign in, nil if passkeys can not be used
+	PasskeyOptions *webauthn.RequestOptions
+	// Terms the user must accept
+	Terms *Terms
 }
 
 func (h *loginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		randomNonce := base64.StdEncoding.EncodeToString(h.tokenGenerator.Generate(TokenSize))
-		nonceCookie := http.Cookie{
-			Name:     CookieNonce,
-			Value:    randomNonce,
-			HttpOnly: true,
+		nonce, err := h.tokenGenerator.Generate(TokenSize)
+		if err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
+			return
 		}
-		http.SetCookie(w, &nonceCookie)
-		data := Login{
-			Csrf: base64.StdEncoding.EncodeToString(computeMAC(randomNonce, h.serverKey)),
+		randomNonce := base64.StdEncoding.EncodeToString(nonce)
+		if err := h.cookies.Set(w, CookieNonce, randomNonce); err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
+			return
+		}
+		csrf := base64.StdEncoding.EncodeToString(computeMAC(randomNonce, h.serverKeys.Secret()))
+		tx, err := h.newTransaction(r.URL.Query())
+		if err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+			return
 		}
-		w.Header().Set("Content-Type", util.ContentTypeHtml)
-		h.templateFactory.ExecuteTemplate(w, "login", data)
+		h.advance(w, r, tx, csrf)
 	case "POST":
-		// TODO: should not allow redirects to arbitrary URLs
-		// TODO: should redirect to a configured default url if parameter not present
-		continueUrl, errQ := url.QueryUnescape(r.URL.Query().Get("continue"))
-		paramsValid := errQ == nil && continueUrl != ""
-
-		email := r.PostFormValue("email")
-		password := r.PostFormValue("password")
+		tx := transactionFromCookie(r, h.cookies, time.Now(), h.serverKeys)
+		if tx == nil || h.flows.Named(tx.Flow) == nil {
+			var err error
+			if tx, err = h.newTransaction(r.URL.Query()); err != nil {
+				util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+				return
+			}
+		}
 		macEncoded := r.PostFormValue("csrf")
 
-		nonce, errC := r.Cookie(CookieNonce)
-		nonceValid := errC == nil && nonce.Value != ""
+		nonce, errC := h.cookies.Get(r, CookieNonce)
+		nonceValid := errC == nil && nonce != ""
 		mac, errM := base64.StdEncoding.DecodeString(macEncoded)
 
-		if !paramsValid || !nonceValid || errM != nil || !hmac.Equal(mac, computeMAC(nonce.Value, h.serverKey)) {
+		if !nonceValid || errM != nil || !verifyMAC(nonce, mac, h.serverKeys) {
 			util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
 				StatusCode:  http.StatusBadRequest,
 				Description: "Some request parameters were invalid.",
 			})
 			return
 		}
-
-		sessionId, err := h.userAuthService.AuthenticateUser(email, password)
-		if err != nil {
-			if _, ok := err.(service.CredentialsMismatch); ok {
-				data := Login{
-					User:         email,
-					ErrorMessage: "The email or password you entered is incorrect.",
-				}
-				h.templateFactory.ExecuteTemplate(w, "login", data)
-			} else {
-				util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
-			}
-			return
-		}
-		sessionCookie := http.Cookie{
-			Name:     "sessionid",
-			Value:    sessionId,
-			HttpOnly: true,
-		}
-		http.SetCookie(w, &sessionCookie)
-		http.Redirect(w, r, continueUrl, http.StatusFound)
+		h.submit(w, r, tx, macEncoded)
 	default:
 		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
 			StatusCode: http.StatusMethodNotAllowed,
@@ -110,8 +148,167 @@ func (h *loginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// newTransaction starts a login with the flow selected by the client and acr
+// values of the request in the signed continue URL. The parameters of the login
+// URL are not used, the user could change them to select a weaker flow. Continue
+// URLs without a valid signature are ignored, e.g. when the user opens the login
+// page directly, and the default flow is used.
+func (h *loginHandler) newTransaction(query url.Values) (*Transaction, error) {
+	continueUrl := query.Get(util.ParameterContinue)
+	var request url.Values
+	if util.VerifyContinue(continueUrl, query.Get(util.ParameterContinueSignature), h.serverKeys) {
+		if parsed, err := url.Parse(continueUrl); err == nil {
+			request = parsed.Query()
+		}
+	} else {
+		continueUrl = ""
+	}
+	flow := h.flows.Select(
+		request.Get(oauth2.ParameterClientId), oauth2.ParseScope(request.Get(oauth2.ParameterACRValues)))
+	id, err := newTransactionID()
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{
+		ID:        id,
+		Flow:      flow.Name,
+		Continue:  h.continueURLs.Resolve(continueUrl),
+		LoginHint: query.Get(oauth2.ParameterLoginHint),
+		Client:    request.Get(oauth2.ParameterClientId),
+		ExpiresAt: time.Now().Add(TransactionLifetime).Unix(),
+	}, nil
+}
+
+// submit handles the form of the current step and continues with the next one.
+func (h *loginHandler) submit(w http.ResponseWriter, r *http.Request, tx *Transaction, csrf string) {
+	flow := h.flows.Named(tx.Flow)
+	if tx.Step >= len(flow.Steps) || !flow.Steps[tx.Step].Handles(r) {
+		renderLoginExpired(w, h.templateFactory)
+		return
+	}
+	if err := flow.Steps[tx.Step].Submit(tx, r); err != nil {
+		if failed, ok := err.(StepFailed); ok {
+			data := Login{User: r.PostFormValue(FieldEmail), ErrorMessage: failed.Message, Csrf: csrf}
+			h.render(w, tx, flow.Steps[tx.Step], data)
+		} else if err == errTransactionExpired {
+			renderLoginExpired(w, h.templateFactory)
+		} else {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		}
+		return
+	}
+	tx.Step++
+	h.advance(w, r, tx, csrf)
+}
+
+// advance skips the steps that do not apply and displays the next step or
+// starts the session if the flow is completed.
+func (h *loginHandler) advance(w http.ResponseWriter, r *http.Request, tx *Transaction, csrf string) {
+	flow := h.flows.Named(tx.Flow)
+	for ; tx.Step < len(flow.Steps); tx.Step++ {
+		skip, err := flow.Steps[tx.Step].Skip(tx)
+		if err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+			return
+		} else if !skip {
+			h.render(w, tx, flow.Steps[tx.Step], Login{Csrf: csrf})
+			return
+		}
+	}
+	// Skipped steps must not complete the login on their own
+	if tx.Subject == "" || len(tx.Methods) == 0 {
+		renderLoginExpired(w, h.templateFactory)
+		return
+	}
+	h.cookies.Delete(w, CookieTransaction)
+	h.startSession(w, r, tx.Subject, tx.Methods, flow.ACR, tx.Continue)
+}
+
+// render displays the login page with the form of the step and stores the
+// transaction.
+func (h *loginHandler) render(w http.ResponseWriter, tx *Transaction, step Step, data Login) {
+	if tx.Subject != "" {
+		data.User = tx.Subject
+	} else if data.User == "" {
+		data.User = tx.LoginHint
+	}
+	data.SecondFactor = len(tx.Methods) > 0
+	if err := step.Prepare(tx, &data); err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		return
+	}
+	if err := setTransactionCookie(w, h.cookies, tx, h.serverKeys.Secret()); err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
+		return
+	}
+	w.Header().Set("Content-Type", util.ContentTypeHtml)
+	h.templateFactory.ExecuteTemplate(w, "login", data)
+}
+
+func renderLoginExpired(w http.ResponseWriter, templateFactory *util.TemplateFactory) {
+	util.RenderHTTPError(w, templateFactory, util.HTTPError{
+		StatusCode:  http.StatusBadRequest,
+		Description: "Your sign in has expired, please sign in again.",
+	})
+}
+
+func (h *loginHandler) startSession(
+	w http.ResponseWriter, r *http.Request, user string, methods []string, acr, continueUrl string) {
+
+	session, err := h.userAuthService.StartSession(user, methods, acr)
+	if err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		return
+	}
+	if err := h.cookies.Set(w, util.CookieSession, session.Id); err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
+		return
+	}
+	http.Redirect(w, r, continueUrl, http.StatusFound)
+}
+
+// signValue authenticates the value, its purpose and the expiration time with
+// the server key.
+func signValue(purpose, value string, expiresAt time.Time, key []byte) string {
+	signed := base64.RawURLEncoding.EncodeToString([]byte(value)) + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(computeMAC(purpose+signed, key))
+}
+
+// verifyValue returns the value of a signed value with the given purpose or an
+// empty string if it is invalid or has expired.
+func verifyValue(purpose, signed string, now time.Time, keys *keyring.Keyring) string {
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !verifyMAC(purpose+parts[0]+"."+parts[1], mac, keys) {
+		return ""
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return ""
+	}
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ""
+	}
+	return string(value)
+}
+
 func computeMAC(value string, key []byte) []byte {
 	mac := hmac.New(sha256.New, key)
 	mac.Write([]byte(value))
 	return mac.Sum(nil)
 }
+
+// verifyMAC reports whether the MAC of the value was computed with any of the
+// server keys, so values computed before a key rotation are still accepted.
+func verifyMAC(value string, mac []byte, keys *keyring.Keyring) bool {
+	for _, key := range keys.Secrets() {
+		if hmac.Equal(mac, computeMAC(value, key)) {
+			return true
+		}
+	}
+	return false
+}
@@ -10,15 +10,27 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/arjantop/gopherauth/login"
 	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/throttle"
+	"github.com/arjantop/gopherauth/totp"
 	"github.com/arjantop/gopherauth/util"
 	"github.com/stretchr/testify/assert"
 )
 
-const redirectUrl = "https://example.com/redirect"
+const (
+	redirectUrl = "https://example.com/redirect"
+	landingUrl  = "/account"
+)
+
+var (
+	serverKeys   = testutil.NewSecretKeyring("ServerKey")
+	cookies      = &util.CookiePolicy{}
+	continueURLs = login.NewContinueURLs(landingUrl, []string{"https://example.com"})
+)
 
 type loginDeps struct {
 	userAuthService *service.UserAuthenticationServiceMock
@@ -36,7 +48,8 @@ func makeLogin() loginDeps {
 	templateFactory := util.NewTemplateFactory("../templates")
 
 	getParams := url.Values{}
-	getParams.Add("continue", url.QueryEscape(redirectUrl))
+	getParams.Add("continue", redirectUrl)
+	getParams.Add("continue_sig", util.SignContinue(redirectUrl, []byte("ServerKey")))
 
 	postParams := url.Values{}
 	postParams.Add("email", "email@example.com")
@@ -49,10 +62,13 @@ func makeLogin() loginDeps {
 		userAuthService: userAuthService,
 		tokenGenerator:  tokenGenerator,
 		templateFactory: templateFactory,
-		handler:         login.NewLoginHandler([]byte("ServerKey"), userAuthService, tokenGenerator, templateFactory),
-		nonce:           "Nonce",
-		getParams:       getParams,
-		postParams:      postParams,
+		handler: login.NewLoginHandler(
+			serverKeys, cookies, userAuthService,
+			&login.Flows{Default: login.DefaultFlow(userAuthService, nil, nil, nil)},
+			continueURLs, tokenGenerator, templateFactory),
+		nonce:      "Nonce",
+		getParams:  getParams,
+		postParams: postParams,
 	}
 }
 
@@ -88,6 +104,21 @@ func TestLoginFormIsDisplayed(t *testing.T) {
 	assert.Contains(t, recorder.Body.String(), "Sign in")
 }
 
+func TestLoginFormEmailIsPrefilledFromLoginHint(t *testing.T) {
+	deps := makeLogin()
+
+	params := url.Values{}
+	params.Add("login_hint", "hint@example.com")
+	request := testutil.NewEndpointRequest(t, "GET", "login", params)
+
+	deps.tokenGenerator.On("Generate", uint(login.TokenSize)).Return([]byte("NewNonce"))
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Contains(t, recorder.Body.String(), `value="hint@example.com"`)
+}
+
 func TestLoginSessionIdIsSet(t *testing.T) {
 	deps := makeLogin()
 
@@ -99,7 +130,9 @@ func TestLoginSessionIdIsSet(t *testing.T) {
 	deps.userAuthService.On(
 		"AuthenticateUser",
 		deps.postParams.Get("email"),
-		deps.postParams.Get("password")).Return("SessionId", nil)
+		deps.postParams.Get("password")).Return(nil)
+	deps.userAuthService.On(
+		"StartSession", deps.postParams.Get("email"), []string{"pwd"}, "").Return(&service.Session{Id: "SessionId"}, nil)
 
 	recorder := httptest.NewRecorder()
 	deps.handler.ServeHTTP(recorder, request)
@@ -121,7 +154,7 @@ func TestLoginCredentialsErrorIsDisplayed(t *testing.T) {
 	deps.userAuthService.On(
 		"AuthenticateUser",
 		deps.postParams.Get("email"),
-		deps.postParams.Get("password")).Return("", service.CredentialsMismatch{})
+		deps.postParams.Get("password")).Return(service.CredentialsMismatch{})
 
 	recorder := httptest.NewRecorder()
 	deps.handler.ServeHTTP(recorder, request)
@@ -143,7 +176,7 @@ func TestLoginServiceUnavaliableIsDisplayed(t *testing.T) {
 	deps.userAuthService.On(
 		"AuthenticateUser",
 		deps.postParams.Get("email"),
-		deps.postParams.Get("password")).Return("", errors.New("error"))
+		deps.postParams.Get("password")).Return(errors.New("error"))
 
 	recorder := httptest.NewRecorder()
 	deps.handler.ServeHTTP(recorder, request)
@@ -152,3 +185,303 @@ func TestLoginServiceUnavaliableIsDisplayed(t *testing.T) {
 	assert.Contains(t, recorder.Body.String(), "Service Unavailable")
 	deps.userAuthService.Mock.AssertExpectations(t)
 }
+
+// makeLoginWithSecondFactor returns login dependencies where the user has
+// enrolled a second factor with the returned secret.
+func makeLoginWithSecondFactor(t *testing.T) (loginDeps, string) {
+	deps, secret, _ := makeLoginWithRecoveryCodes(t, nil)
+	return deps, secret
+}
+
+// makeLoginWithRecoveryCodes is makeLoginWithSecondFactor with the throttler
+// that also returns the recovery codes of the enrollment.
+func makeLoginWithRecoveryCodes(t *testing.T, throttler *throttle.Throttler) (loginDeps, string, []string) {
+	deps := makeLogin()
+	manager := totp.NewManager(totp.NewMemoryStore(), service.NewCryptoTokenGenerator(), 1)
+	email := deps.postParams.Get("email")
+	secret, err := manager.Enroll(email)
+	assert.Nil(t, err)
+	code, err := totp.Code(secret, time.Now().Add(-totp.Period))
+	assert.Nil(t, err)
+	recoveryCodes, err := manager.Confirm(email, code)
+	assert.Nil(t, err)
+	flows := &login.Flows{Default: login.DefaultFlow(deps.userAuthService, throttler, manager, nil)}
+	deps.handler = login.NewLoginHandler(
+		serverKeys, cookies, deps.userAuthService, flows, continueURLs, deps.tokenGenerator, deps.templateFactory)
+	return deps, secret, recoveryCodes
+}
+
+func findCookie(recorder *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// submitPassword submits valid credentials and returns the transaction cookie.
+func submitPassword(t *testing.T, deps loginDeps) *http.Cookie {
+	request := testutil.NewEndpointPostRequest(t, "login", deps.getParams, deps.postParams)
+	request.AddCookie(&http.Cookie{Name: "nonce", Value: deps.nonce, HttpOnly: true})
+	deps.userAuthService.On(
+		"AuthenticateUser",
+		deps.postParams.Get("email"),
+		deps.postParams.Get("password")).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `name="otp"`, "Second factor form is displayed")
+	assert.Nil(t, findCookie(recorder, "sessionid"), "Session must not be started before second factor")
+	transaction := findCookie(recorder, login.CookieTransaction)
+	assert.NotNil(t, transaction)
+	return transaction
+}
+
+func submitCode(t *testing.T, deps loginDeps, transaction *http.Cookie, code string) *httptest.ResponseRecorder {
+	postParams := url.Values{}
+	postParams.Add("otp", code)
+	postParams.Add("csrf", deps.postParams.Get("csrf"))
+	request := testutil.NewEndpointPostRequest(t, "login", deps.getParams, postParams)
+	request.AddCookie(&http.Cookie{Name: "nonce", Value: deps.nonce, HttpOnly: true})
+	if transaction != nil {
+		request.AddCookie(transaction)
+	}
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestEnrolledUserMustEnterCodeBeforeSessionStarts(t *testing.T) {
+	deps, secret := makeLoginWithSecondFactor(t)
+	transaction := submitPassword(t, deps)
+
+	code, err := totp.Code(secret, time.Now())
+	assert.Nil(t, err)
+	deps.userAuthService.On(
+		"StartSession", deps.postParams.Get("email"), []string{"pwd", "otp"}, "").Return(&service.Session{Id: "SessionId"}, nil)
+	recorder := submitCode(t, deps, transaction, code)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, redirectUrl, recorder.Header().Get("Location"))
+	assert.Equal(t, "SessionId", findCookie(recorder, "sessionid").Value)
+	deps.userAuthService.Mock.AssertExpectations(t)
+}
+
+func TestRecoveryCodeIsRecordedAsOtherMethod(t *testing.T) {
+	deps, _, recoveryCodes := makeLoginWithRecoveryCodes(t, nil)
+	transaction := submitPassword(t, deps)
+
+	deps.userAuthService.On(
+		"StartSession", deps.postParams.Get("email"), []string{"pwd", "rec"}, "").Return(&service.Session{Id: "SessionId"}, nil)
+	recorder := submitCode(t, deps, transaction, recoveryCodes[0])
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, "SessionId", findCookie(recorder, "sessionid").Value)
+	deps.userAuthService.Mock.AssertExpectations(t)
+}
+
+func TestIncorrectCodeIsDisplayed(t *testing.T) {
+	deps, secret := makeLoginWithSecondFactor(t)
+	transaction := submitPassword(t, deps)
+
+	code, err := totp.Code(secret, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	recorder := submitCode(t, deps, transaction, code)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "The code you entered is incorrect.")
+	assert.Contains(t, recorder.Body.String(), `name="otp"`)
+	assert.Nil(t, findCookie(recorder, "sessionid"))
+}
+
+func TestCodeWithoutTransactionIsRejected(t *testing.T) {
+	deps, secret := makeLoginWithSecondFactor(t)
+
+	code, err := totp.Code(secret, time.Now())
+	assert.Nil(t, err)
+	recorder := submitCode(t, deps, nil, code)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Nil(t, findCookie(recorder, "sessionid"))
+}
+
+func TestTamperedTransactionIsRejected(t *testing.T) {
+	deps, secret := makeLoginWithSecondFactor(t)
+	transaction := submitPassword(t, deps)
+	sealed, err := base64.RawURLEncoding.DecodeString(transaction.Value)
+	assert.Nil(t, err)
+	sealed[len(sealed)/2] ^= 0x01
+	transaction.Value = base64.RawURLEncoding.EncodeToString(sealed)
+
+	code, err := totp.Code(secret, time.Now())
+	assert.Nil(t, err)
+	recorder := submitCode(t, deps, transaction, code)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestRepeatedLoginFailuresAreThrottled(t *testing.T) {
+	deps := makeLogin()
+	throttler := throttle.NewThrottler(throttle.NewMemoryStore(), map[string]throttle.Policy{
+		throttle.ScopeAccount: throttle.Policy{BaseDelay: time.Minute},
+	}, nil)
+	flows := &login.Flows{Default: login.DefaultFlow(deps.userAuthService, throttler, nil, nil)}
+	deps.handler = login.NewLoginHandler(
+		serverKeys, cookies, deps.userAuthService, flows, continueURLs, deps.tokenGenerator, deps.templateFactory)
+	deps.userAuthService.On(
+		"AuthenticateUser",
+		deps.postParams.Get("email"),
+		deps.postParams.Get("password")).Return(service.CredentialsMismatch{}).Once()
+
+	for _, message := range []string{"The email or password you entered is incorrect.", "Too many failed attempts"} {
+		request := testutil.NewEndpointPostRequest(t, "login", deps.getParams, deps.postParams)
+		request.AddCookie(&http.Cookie{Name: "nonce", Value: deps.nonce, HttpOnly: true})
+		recorder := httptest.NewRecorder()
+		deps.handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), message)
+	}
+	deps.userAuthService.Mock.AssertExpectations(t)
+}
+
+func TestFailedCodesAreThrottledAfterPasswordSucceeds(t *testing.T) {
+	throttler := throttle.NewThrottler(throttle.NewMemoryStore(), map[string]throttle.Policy{
+		throttle.ScopeAccount: throttle.Policy{BaseDelay: time.Minute},
+	}, nil)
+	deps, secret, _ := makeLoginWithRecoveryCodes(t, throttler)
+
+	recorder := submitCode(t, deps, submitPassword(t, deps), "000000")
+	assert.Contains(t, recorder.Body.String(), "The code you entered is incorrect.")
+
+	// Password is accepted again, failed codes are not forgotten
+	code, err := totp.Code(secret, time.Now())
+	assert.Nil(t, err)
+	recorder = submitCode(t, deps, submitPassword(t, deps), code)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Too many failed attempts")
+	assert.Nil(t, findCookie(recorder, "sessionid"))
+}
+
+func TestLoginTransactionIsLockedAfterRepeatedFailures(t *testing.T) {
+	throttler := throttle.NewThrottler(throttle.NewMemoryStore(), map[string]throttle.Policy{
+		throttle.ScopeTransaction: throttle.Policy{LockoutFailures: 2, LockoutDuration: login.TransactionLifetime},
+	}, nil)
+	deps, secret, _ := makeLoginWithRecoveryCodes(t, throttler)
+	transaction := submitPassword(t, deps)
+
+	for i := 0; i < 2; i++ {
+		recorder := submitCode(t, deps, transaction, "000000")
+		assert.Contains(t, recorder.Body.String(), "The code you entered is incorrect.")
+	}
+	// Failures are counted by the server, replaying the transaction does not
+	// reset them
+	code, err := totp.Code(secret, time.Now())
+	assert.Nil(t, err)
+	recorder := submitCode(t, deps, transaction, code)
+	assert.Contains(t, recorder.Body.String(), "Too many failed attempts, please sign in again.")
+	assert.Nil(t, findCookie(recorder, "sessionid"))
+}
+
+func makeLoginWithHardenedCookies() (loginDeps, *util.CookiePolicy) {
+	deps := makeLogin()
+	policy := &util.CookiePolicy{
+		Names:      map[string]string{util.CookieSession: "sid"},
+		Secure:     true,
+		SameSite:   http.SameSiteStrictMode,
+		HostPrefix: true,
+		Lifetime:   map[string]time.Duration{login.CookieNonce: time.Minute},
+		Keys:       testutil.NewSecretKeyring("NewKey", "OldKey"),
+	}
+	deps.handler = login.NewLoginHandler(
+		serverKeys, policy, deps.userAuthService,
+		&login.Flows{Default: login.DefaultFlow(deps.userAuthService, nil, nil, nil)},
+		continueURLs, deps.tokenGenerator, deps.templateFactory)
+	return deps, policy
+}
+
+func TestCookiesFollowCookiePolicy(t *testing.T) {
+	deps, policy := makeLoginWithHardenedCookies()
+	assert.Nil(t, policy.Validate())
+
+	recorder := startLogin(t, deps, url.Values{})
+	nonce := findCookie(recorder, "__Host-nonce")
+	assert.NotNil(t, nonce)
+	assert.True(t, nonce.Secure)
+	assert.True(t, nonce.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, nonce.SameSite)
+	assert.Equal(t, "/", nonce.Path)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), nonce.Expires, 2*time.Second)
+	randomNonce := base64.StdEncoding.EncodeToString([]byte("NewNonce"))
+	assert.NotEqual(t, randomNonce, nonce.Value, "Nonce must be encrypted")
+
+	mac := hmac.New(sha256.New, []byte("ServerKey"))
+	mac.Write([]byte(randomNonce))
+	postParams := url.Values{
+		"email":    {deps.postParams.Get("email")},
+		"password": {deps.postParams.Get("password")},
+		"csrf":     {base64.StdEncoding.EncodeToString(mac.Sum(nil))},
+	}
+	request := testutil.NewEndpointPostRequest(t, "login", deps.getParams, postParams)
+	request.AddCookie(nonce)
+	deps.userAuthService.On(
+		"AuthenticateUser", deps.postParams.Get("email"), deps.postParams.Get("password")).Return(nil)
+	deps.userAuthService.On(
+		"StartSession", deps.postParams.Get("email"), []string{"pwd"}, "").Return(&service.Session{Id: "SessionId"}, nil)
+
+	recorder = httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	session := findCookie(recorder, "__Host-sid")
+	assert.NotNil(t, session)
+	assert.NotEqual(t, "SessionId", session.Value, "Session id must be encrypted")
+	assert.True(t, session.Expires.IsZero(), "Session cookie without lifetime")
+
+	request = testutil.NewEndpointRequest(t, "GET", "login", nil)
+	request.AddCookie(session)
+	sessionId, err := policy.Get(request, util.CookieSession)
+	assert.Nil(t, err)
+	assert.Equal(t, "SessionId", sessionId)
+}
+
+func TestCookieValueCanNotBeMovedToOtherCookie(t *testing.T) {
+	deps, policy := makeLoginWithHardenedCookies()
+	recorder := httptest.NewRecorder()
+	assert.Nil(t, policy.Set(recorder, util.CookieSession, deps.nonce))
+	session := findCookie(recorder, "__Host-sid")
+
+	request := testutil.NewEndpointPostRequest(t, "login", deps.getParams, deps.postParams)
+	request.AddCookie(&http.Cookie{Name: "__Host-nonce", Value: session.Value})
+	recorder = httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestCookiesEncryptedWithOldKeyAreAccepted(t *testing.T) {
+	_, policy := makeLoginWithHardenedCookies()
+	old := &util.CookiePolicy{HostPrefix: true, Secure: true, Keys: testutil.NewSecretKeyring("OldKey")}
+	recorder := httptest.NewRecorder()
+	assert.Nil(t, old.Set(recorder, login.CookieNonce, "value"))
+
+	request := testutil.NewEndpointRequest(t, "GET", "login", nil)
+	request.AddCookie(findCookie(recorder, "__Host-nonce"))
+	value, err := policy.Get(request, login.CookieNonce)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", value)
+
+	unknown := &util.CookiePolicy{HostPrefix: true, Secure: true, Keys: testutil.NewSecretKeyring("UnknownKey")}
+	_, err = unknown.Get(request, login.CookieNonce)
+	assert.Equal(t, http.ErrNoCookie, err)
+}
+
+func TestHostPrefixRequiresSecureCookiesWithoutDomain(t *testing.T) {
+	assert.NotNil(t, (&util.CookiePolicy{HostPrefix: true}).Validate())
+	assert.NotNil(t, (&util.CookiePolicy{HostPrefix: true, Secure: true, Domain: "example.com"}).Validate())
+	assert.Nil(t, (&util.CookiePolicy{HostPrefix: true, Secure: true}).Validate())
+}
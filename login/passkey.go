@@ -0,0 +1,243 @@
+package login
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/throttle"
+	"github.com/arjantop/gopherauth/util"
+	"github.com/arjantop/gopherauth/webauthn"
+)
+
+// Cookie holding the challenge and user handle of a passkey registration
+const CookiePasskeyRegistration = "passkey_registration"
+
+type passkeyStep struct {
+	passkeys  *webauthn.RelyingParty
+	throttler *throttle.Throttler
+}
+
+// NewPasskeyStep returns a step where the user signs in with a passkey. If the
+// user is not identified yet any discoverable credential identifies the user
+// and is accepted as the only factor, otherwise the passkey must be one of the
+// user's and is an additional factor. It is skipped for identified users
+// without passkeys or already authenticated with two factors. Failed passkeys
+// are throttled per identified account separately from passwords, nil
+// throttler disables it.
+func NewPasskeyStep(passkeys *webauthn.RelyingParty, throttler *throttle.Throttler) Step {
+	return &passkeyStep{passkeys: passkeys, throttler: throttler.ForFactor(MethodHardwareKey)}
+}
+
+func (s *passkeyStep) Skip(tx *Transaction) (bool, error) {
+	if tx.Subject == "" {
+		return false, nil
+	} else if tx.MultiFactor() {
+		return true, nil
+	}
+	hasPasskeys, err := s.passkeys.HasCredentials(tx.Subject)
+	return !hasPasskeys, err
+}
+
+func (s *passkeyStep) Prepare(tx *Transaction, page *Login) error {
+	options, err := s.passkeys.BeginLogin(tx.Subject)
+	if err != nil {
+		return err
+	}
+	tx.Challenge = options.Challenge
+	page.PasskeyOptions = options
+	return nil
+}
+
+func (s *passkeyStep) Handles(r *http.Request) bool {
+	_, ok := r.PostForm[FieldPasskey]
+	return ok
+}
+
+func (s *passkeyStep) Submit(tx *Transaction, r *http.Request) error {
+	challenge := tx.Challenge
+	if len(challenge) == 0 {
+		return errTransactionExpired
+	}
+	// A challenge is answered only once
+	tx.Challenge = nil
+	var response webauthn.AssertionResponse
+	if err := json.Unmarshal([]byte(r.PostFormValue(FieldPasskey)), &response); err != nil {
+		return StepFailed{"Your passkey could not be verified."}
+	}
+	attempt := throttle.Attempt{Account: tx.Subject, IP: util.RemoteIP(r), Client: tx.Client, Transaction: tx.ID}
+	if err := countAttempt(s.throttler, attempt); err != nil {
+		return err
+	}
+	credential, authData, err := s.passkeys.FinishLogin(tx.Subject, challenge, &response)
+	if _, ok := err.(*webauthn.VerificationError); ok {
+		if err := reportAttempt(s.throttler, attempt, err); err != nil {
+			return err
+		}
+		return StepFailed{"Your passkey could not be verified."}
+	} else if err := reportAttempt(s.throttler, attempt, err); err != nil {
+		return err
+	}
+	if tx.Subject != "" {
+		tx.AddMethods(MethodHardwareKey)
+		return nil
+	}
+	// User verification is required, possession of the key and the verification
+	// by the authenticator are two factors
+	tx.Subject = credential.Subject
+	tx.AddMethods(MethodHardwareKey)
+	if authData.UserVerified() {
+		tx.AddMethods(MethodMultiFactor)
+	}
+	return nil
+}
+
+type passkeyRegistrationHandler struct {
+	serverKeys      *keyring.Keyring
+	cookies         *util.CookiePolicy
+	loginURL        url.URL
+	userAuthService service.UserAuthenticationService
+	passkeys        *webauthn.RelyingParty
+	templateFactory *util.TemplateFactory
+}
+
+// NewPasskeyRegistrationHandler returns the handler where signed in users
+// register passkeys.
+func NewPasskeyRegistrationHandler(
+	serverKeys *keyring.Keyring,
+	cookies *util.CookiePolicy,
+	loginURL url.URL,
+	userAuthService service.UserAuthenticationService,
+	passkeys *webauthn.RelyingParty,
+	templateFactory *util.TemplateFactory) http.Handler {
+
+	handler := &passkeyRegistrationHandler{
+		serverKeys:      serverKeys,
+		cookies:         cookies,
+		loginURL:        loginURL,
+		userAuthService: userAuthService,
+		passkeys:        passkeys,
+		templateFactory: templateFactory,
+	}
+	return util.NoCachingMiddleware(handler)
+}
+
+type PasskeyRegistration struct {
+	Options      *webauthn.CreationOptions
+	Csrf         string
+	ErrorMessage string
+	Registered   bool
+}
+
+func (h *passkeyRegistrationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode: http.StatusMethodNotAllowed,
+			Description: fmt.Sprintf(
+				"The request method %s is not supported for the URL %s.", r.Method, r.URL.Path),
+		})
+		return
+	}
+
+	var session *service.Session
+	if sessionId, err := h.cookies.Get(r, util.CookieSession); err == nil {
+		session, err = h.userAuthService.Session(sessionId)
+		if err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+			return
+		}
+	}
+	if session == nil {
+		util.RedirectToLogin(w, r, h.serverKeys.Secret(), h.loginURL, r.URL, nil)
+		return
+	}
+
+	csrf := computeMAC("passkey"+session.Id, h.serverKeys.Secret())
+	if r.Method == "GET" {
+		h.render(w, session, csrf, "")
+		return
+	}
+
+	mac, err := base64.StdEncoding.DecodeString(r.PostFormValue("csrf"))
+	if err != nil || !verifyMAC("passkey"+session.Id, mac, h.serverKeys) {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: "Some request parameters were invalid.",
+		})
+		return
+	}
+	challenge, userHandle := h.registrationFromCookie(r, session)
+	if challenge == nil {
+		h.render(w, session, csrf, "Your registration has expired, please try again.")
+		return
+	}
+	h.cookies.Delete(w, CookiePasskeyRegistration)
+	var response webauthn.RegistrationResponse
+	if err := json.Unmarshal([]byte(r.PostFormValue("credential")), &response); err != nil {
+		h.render(w, session, csrf, "Your passkey could not be registered.")
+		return
+	}
+	_, err = h.passkeys.FinishRegistration(session.Subject, userHandle, challenge, &response)
+	if _, ok := err.(*webauthn.VerificationError); ok {
+		h.render(w, session, csrf, "Your passkey could not be registered.")
+		return
+	} else if err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		return
+	}
+	w.Header().Set("Content-Type", util.ContentTypeHtml)
+	h.templateFactory.ExecuteTemplate(w, "passkey_registration", PasskeyRegistration{Registered: true})
+}
+
+// render starts a new registration ceremony, the challenge and user handle are
+// kept in a cookie bound to the session.
+func (h *passkeyRegistrationHandler) render(
+	w http.ResponseWriter, session *service.Session, csrf []byte, errorMessage string) {
+
+	options, err := h.passkeys.BeginRegistration(session.Subject, session.Subject)
+	if err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		return
+	}
+	expiresAt := time.Now().Add(webauthn.CeremonyTimeout)
+	value := base64.RawURLEncoding.EncodeToString(options.Challenge) + "." +
+		base64.RawURLEncoding.EncodeToString(options.User.ID)
+	signed := signValue(CookiePasskeyRegistration+session.Id, value, expiresAt, h.serverKeys.Secret())
+	if err := h.cookies.SetExpiring(w, CookiePasskeyRegistration, signed, expiresAt); err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
+		return
+	}
+	data := PasskeyRegistration{
+		Options:      options,
+		Csrf:         base64.StdEncoding.EncodeToString(csrf),
+		ErrorMessage: errorMessage,
+	}
+	w.Header().Set("Content-Type", util.ContentTypeHtml)
+	h.templateFactory.ExecuteTemplate(w, "passkey_registration", data)
+}
+
+func (h *passkeyRegistrationHandler) registrationFromCookie(
+	r *http.Request, session *service.Session) ([]byte, []byte) {
+
+	signed, err := h.cookies.Get(r, CookiePasskeyRegistration)
+	if err != nil {
+		return nil, nil
+	}
+	value := verifyValue(CookiePasskeyRegistration+session.Id, signed, time.Now(), h.serverKeys)
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return nil, nil
+	}
+	challenge, errC := base64.RawURLEncoding.DecodeString(parts[0])
+	userHandle, errU := base64.RawURLEncoding.DecodeString(parts[1])
+	if errC != nil || errU != nil {
+		return nil, nil
+	}
+	return challenge, userHandle
+}
@@ -0,0 +1,241 @@
+package login_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/login"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/util"
+	"github.com/arjantop/gopherauth/webauthn"
+)
+
+const (
+	passkeyRPID   = "example.com"
+	passkeyOrigin = "https://example.com"
+)
+
+var challengePattern = regexp.MustCompile(`"challenge":"([A-Za-z0-9_-]+)"`)
+
+// makeLoginWithPasskey returns login dependencies where the user registered
+// a passkey with the returned authenticator.
+func makeLoginWithPasskey(t *testing.T) (loginDeps, *testutil.SoftwareAuthenticator) {
+	deps := makeLogin()
+	passkeys := webauthn.NewRelyingParty(
+		passkeyRPID, "gopherauth", []string{passkeyOrigin},
+		webauthn.NewMemoryCredentialStore(), service.NewCryptoTokenGenerator())
+	authenticator := testutil.NewSoftwareAuthenticator(t, passkeyRPID, passkeyOrigin)
+	email := deps.postParams.Get("email")
+	options, err := passkeys.BeginRegistration(email, email)
+	assert.Nil(t, err)
+	_, err = passkeys.FinishRegistration(email, options.User.ID, options.Challenge, authenticator.Create(t, options))
+	assert.Nil(t, err)
+	flows := &login.Flows{Default: login.DefaultFlow(deps.userAuthService, nil, nil, passkeys)}
+	deps.handler = login.NewLoginHandler(
+		serverKeys, cookies, deps.userAuthService, flows, continueURLs, deps.tokenGenerator, deps.templateFactory)
+	return deps, authenticator
+}
+
+// passkeyChallenge returns the challenge of the passkey options on the page.
+func passkeyChallenge(t *testing.T, recorder *httptest.ResponseRecorder) []byte {
+	match := challengePattern.FindStringSubmatch(recorder.Body.String())
+	assert.NotNil(t, match, "Passkey options are displayed")
+	if match == nil {
+		return nil
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(match[1])
+	assert.Nil(t, err)
+	return challenge
+}
+
+// beginPasskeyLogin displays the login form and returns the passkey challenge
+// and the transaction cookie.
+func beginPasskeyLogin(t *testing.T, deps loginDeps) ([]byte, *http.Cookie) {
+	request := testutil.NewEndpointRequest(t, "GET", "login", deps.getParams)
+	deps.tokenGenerator.On("Generate", uint(login.TokenSize)).Return([]byte("NewNonce"))
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `id="passkeySignIn"`)
+	cookie := findCookie(recorder, login.CookieTransaction)
+	assert.NotNil(t, cookie)
+	return passkeyChallenge(t, recorder), cookie
+}
+
+func submitPasskey(
+	t *testing.T, deps loginDeps, response *webauthn.AssertionResponse, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+
+	encoded, err := json.Marshal(response)
+	assert.Nil(t, err)
+	postParams := url.Values{}
+	postParams.Add("passkey", string(encoded))
+	postParams.Add("csrf", deps.postParams.Get("csrf"))
+	request := testutil.NewEndpointPostRequest(t, "login", deps.getParams, postParams)
+	request.AddCookie(&http.Cookie{Name: "nonce", Value: deps.nonce, HttpOnly: true})
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestPasskeySignsInWithoutPassword(t *testing.T) {
+	deps, authenticator := makeLoginWithPasskey(t)
+	challenge, cookie := beginPasskeyLogin(t, deps)
+
+	deps.userAuthService.On(
+		"StartSession", deps.postParams.Get("email"), []string{"hwk", "mfa"}, "").Return(&service.Session{Id: "SessionId"}, nil)
+	response := authenticator.Get(t, &webauthn.RequestOptions{Challenge: challenge})
+	recorder := submitPasskey(t, deps, response, cookie)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, redirectUrl, recorder.Header().Get("Location"))
+	assert.Equal(t, "SessionId", findCookie(recorder, "sessionid").Value)
+	deps.userAuthService.Mock.AssertExpectations(t)
+}
+
+func TestPasskeyWithoutUserVerificationIsRejected(t *testing.T) {
+	deps, authenticator := makeLoginWithPasskey(t)
+	challenge, cookie := beginPasskeyLogin(t, deps)
+
+	authenticator.UserVerified = false
+	response := authenticator.Get(t, &webauthn.RequestOptions{Challenge: challenge})
+	recorder := submitPasskey(t, deps, response, cookie)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Your passkey could not be verified.")
+	assert.Nil(t, findCookie(recorder, "sessionid"))
+}
+
+func TestPasskeyWithoutTransactionIsRejected(t *testing.T) {
+	deps, authenticator := makeLoginWithPasskey(t)
+	challenge, _ := beginPasskeyLogin(t, deps)
+
+	response := authenticator.Get(t, &webauthn.RequestOptions{Challenge: challenge})
+	recorder := submitPasskey(t, deps, response)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Nil(t, findCookie(recorder, "sessionid"))
+}
+
+func TestPasskeyForOtherChallengeIsRejected(t *testing.T) {
+	deps, authenticator := makeLoginWithPasskey(t)
+	_, cookie := beginPasskeyLogin(t, deps)
+
+	response := authenticator.Get(t, &webauthn.RequestOptions{Challenge: []byte("OtherChallenge")})
+	recorder := submitPasskey(t, deps, response, cookie)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Your passkey could not be verified.")
+	assert.Nil(t, findCookie(recorder, "sessionid"))
+}
+
+func TestPasskeyIsSecondFactorAfterPassword(t *testing.T) {
+	deps, authenticator := makeLoginWithPasskey(t)
+
+	request := testutil.NewEndpointPostRequest(t, "login", deps.getParams, deps.postParams)
+	request.AddCookie(&http.Cookie{Name: "nonce", Value: deps.nonce, HttpOnly: true})
+	deps.userAuthService.On(
+		"AuthenticateUser",
+		deps.postParams.Get("email"),
+		deps.postParams.Get("password")).Return(nil)
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), `name="otp"`, "User has no codes enrolled")
+	assert.Contains(t, recorder.Body.String(), base64.RawURLEncoding.EncodeToString(authenticator.CredentialID))
+	assert.Nil(t, findCookie(recorder, "sessionid"), "Session must not be started before second factor")
+	transaction := findCookie(recorder, login.CookieTransaction)
+	assert.NotNil(t, transaction)
+
+	deps.userAuthService.On(
+		"StartSession", deps.postParams.Get("email"), []string{"pwd", "hwk"}, "").Return(&service.Session{Id: "SessionId"}, nil)
+	response := authenticator.Get(t, &webauthn.RequestOptions{Challenge: passkeyChallenge(t, recorder)})
+	recorder = submitPasskey(t, deps, response, transaction)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, "SessionId", findCookie(recorder, "sessionid").Value)
+	deps.userAuthService.Mock.AssertExpectations(t)
+}
+
+func makePasskeyRegistration() (*service.UserAuthenticationServiceMock, *webauthn.RelyingParty, http.Handler) {
+	userAuthService := service.NewUserAuthenticationServiceMock()
+	passkeys := webauthn.NewRelyingParty(
+		passkeyRPID, "gopherauth", []string{passkeyOrigin},
+		webauthn.NewMemoryCredentialStore(), service.NewCryptoTokenGenerator())
+	loginURL, _ := url.Parse("https://example.com/login")
+	handler := login.NewPasskeyRegistrationHandler(
+		serverKeys, cookies, *loginURL, userAuthService, passkeys, util.NewTemplateFactory("../templates"))
+	return userAuthService, passkeys, handler
+}
+
+func TestPasskeyRegistrationRequiresSession(t *testing.T) {
+	_, _, handler := makePasskeyRegistration()
+
+	request := testutil.NewEndpointRequest(t, "GET", "login/passkey", nil)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/login", location.Path)
+}
+
+func TestPasskeyIsRegistered(t *testing.T) {
+	userAuthService, passkeys, handler := makePasskeyRegistration()
+	userAuthService.On("Session", enrollmentSession.Id).Return(enrollmentSession, nil)
+	sessionCookie := &http.Cookie{Name: "sessionid", Value: enrollmentSession.Id}
+
+	request := testutil.NewEndpointRequest(t, "GET", "login/passkey", nil)
+	request.AddCookie(sessionCookie)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	testutil.AssertContentTypeHtml(t, recorder)
+	registration := findCookie(recorder, login.CookiePasskeyRegistration)
+	assert.NotNil(t, registration)
+	match := regexp.MustCompile(`"user":{"id":"([A-Za-z0-9_-]+)"`).FindStringSubmatch(recorder.Body.String())
+	assert.NotNil(t, match)
+	userHandle, err := base64.RawURLEncoding.DecodeString(match[1])
+	assert.Nil(t, err)
+
+	authenticator := testutil.NewSoftwareAuthenticator(t, passkeyRPID, passkeyOrigin)
+	options := &webauthn.CreationOptions{Challenge: passkeyChallenge(t, recorder)}
+	options.User.ID = userHandle
+	encoded, err := json.Marshal(authenticator.Create(t, options))
+	assert.Nil(t, err)
+	mac := hmac.New(sha256.New, []byte("ServerKey"))
+	mac.Write([]byte("passkey" + enrollmentSession.Id))
+	postParams := url.Values{}
+	postParams.Add("credential", string(encoded))
+	postParams.Add("csrf", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	request = testutil.NewEndpointPostRequest(t, "login/passkey", nil, postParams)
+	request.AddCookie(sessionCookie)
+	request.AddCookie(registration)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Your passkey has been added")
+	registered, err := passkeys.HasCredentials(enrollmentSession.Subject)
+	assert.Nil(t, err)
+	assert.True(t, registered)
+}
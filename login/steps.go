@@ -0,0 +1,200 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/throttle"
+	"github.com/arjantop/gopherauth/totp"
+	"github.com/arjantop/gopherauth/util"
+	"github.com/arjantop/gopherauth/webauthn"
+)
+
+type identifierStep struct{}
+
+// NewIdentifierStep returns a step that asks only for the email, the following
+// steps authenticate the identified user.
+func NewIdentifierStep() Step {
+	return identifierStep{}
+}
+
+func (identifierStep) Skip(tx *Transaction) (bool, error) {
+	return tx.Subject != "", nil
+}
+
+func (identifierStep) Prepare(tx *Transaction, page *Login) error {
+	page.AskIdentifier = true
+	return nil
+}
+
+func (identifierStep) Handles(r *http.Request) bool {
+	_, hasEmail := r.PostForm[FieldEmail]
+	_, hasPassword := r.PostForm[FieldPassword]
+	return hasEmail && !hasPassword
+}
+
+func (identifierStep) Submit(tx *Transaction, r *http.Request) error {
+	email := r.PostFormValue(FieldEmail)
+	if email == "" {
+		return StepFailed{"Enter your email."}
+	}
+	tx.Subject = email
+	return nil
+}
+
+type passwordStep struct {
+	userAuthService service.UserAuthenticationService
+	throttler       *throttle.Throttler
+}
+
+// NewPasswordStep returns a step that asks for the password. If the user is
+// not identified yet the email is asked for on the same form. Failed attempts
+// are throttled per account, IP address and client, nil throttler disables it.
+func NewPasswordStep(userAuthService service.UserAuthenticationService, throttler *throttle.Throttler) Step {
+	return &passwordStep{userAuthService: userAuthService, throttler: throttler}
+}
+
+func (s *passwordStep) Skip(tx *Transaction) (bool, error) {
+	return containsString(tx.Methods, MethodPassword), nil
+}
+
+func (s *passwordStep) Prepare(tx *Transaction, page *Login) error {
+	page.AskPassword = true
+	page.Identified = tx.Subject != ""
+	return nil
+}
+
+func (s *passwordStep) Handles(r *http.Request) bool {
+	_, ok := r.PostForm[FieldPassword]
+	return ok
+}
+
+func (s *passwordStep) Submit(tx *Transaction, r *http.Request) error {
+	user := tx.Subject
+	if user == "" {
+		user = r.PostFormValue(FieldEmail)
+	}
+	attempt := throttle.Attempt{Account: user, IP: util.RemoteIP(r), Client: tx.Client, Transaction: tx.ID}
+	if err := countAttempt(s.throttler, attempt); err != nil {
+		return err
+	}
+	err := s.userAuthService.AuthenticateUser(user, r.PostFormValue(FieldPassword))
+	if _, ok := err.(service.CredentialsMismatch); ok {
+		if err := reportAttempt(s.throttler, attempt, err); err != nil {
+			return err
+		}
+		return StepFailed{"The email or password you entered is incorrect."}
+	} else if err := reportAttempt(s.throttler, attempt, err); err != nil {
+		return err
+	}
+	tx.Subject = user
+	tx.AddMethods(MethodPassword)
+	return nil
+}
+
+// countAttempt counts the attempt of a step before the user input is verified,
+// StepFailed is returned if the attempt is throttled. Nil throttler does not
+// count attempts.
+func countAttempt(throttler *throttle.Throttler, attempt throttle.Attempt) error {
+	if throttler == nil {
+		return nil
+	}
+	err := throttler.Attempt(attempt, time.Now())
+	if throttled, ok := err.(throttle.Throttled); ok {
+		if throttled.Scope == throttle.ScopeTransaction {
+			return StepFailed{"Too many failed attempts, please sign in again."}
+		}
+		return StepFailed{fmt.Sprintf(
+			"Too many failed attempts, please try again in %s.", formatRetryAfter(throttled.RetryAfter))}
+	}
+	return err
+}
+
+// reportAttempt reports the outcome of a counted attempt with the error of the
+// verification. Rejected input is a failure and no error a success, other
+// errors cancel the attempt because the input was not verified and are
+// returned.
+func reportAttempt(throttler *throttle.Throttler, attempt throttle.Attempt, err error) error {
+	switch err.(type) {
+	case nil:
+		if throttler != nil {
+			return throttler.Success(attempt)
+		}
+		return nil
+	case service.CredentialsMismatch, totp.InvalidCode, *webauthn.VerificationError:
+		if throttler != nil {
+			return throttler.Failure(attempt, time.Now())
+		}
+		return nil
+	}
+	if throttler != nil {
+		if cancelErr := throttler.Cancel(attempt); cancelErr != nil {
+			return cancelErr
+		}
+	}
+	return err
+}
+
+// formatRetryAfter returns the duration rounded up to whole seconds.
+func formatRetryAfter(d time.Duration) string {
+	return ((d + time.Second - 1) / time.Second * time.Second).String()
+}
+
+type totpStep struct {
+	secondFactor SecondFactor
+	throttler    *throttle.Throttler
+}
+
+// NewTOTPStep returns a step that asks for a one-time code. It is skipped for
+// users that have not enrolled or already authenticated with two factors.
+// Failed codes are throttled per account separately from passwords, nil
+// throttler disables it.
+func NewTOTPStep(secondFactor SecondFactor, throttler *throttle.Throttler) Step {
+	return &totpStep{secondFactor: secondFactor, throttler: throttler.ForFactor(MethodOTP)}
+}
+
+func (s *totpStep) Skip(tx *Transaction) (bool, error) {
+	if tx.Subject == "" || tx.MultiFactor() {
+		return true, nil
+	}
+	enrolled, err := s.secondFactor.Enrolled(tx.Subject)
+	return !enrolled, err
+}
+
+func (s *totpStep) Prepare(tx *Transaction, page *Login) error {
+	page.OTP = true
+	return nil
+}
+
+func (s *totpStep) Handles(r *http.Request) bool {
+	_, ok := r.PostForm[FieldCode]
+	return ok
+}
+
+// Submit accepts a one-time code or a recovery code, the methods are recorded
+// separately so clients can tell them apart.
+func (s *totpStep) Submit(tx *Transaction, r *http.Request) error {
+	attempt := throttle.Attempt{Account: tx.Subject, IP: util.RemoteIP(r), Client: tx.Client, Transaction: tx.ID}
+	if err := countAttempt(s.throttler, attempt); err != nil {
+		return err
+	}
+	code := r.PostFormValue(FieldCode)
+	method := MethodOTP
+	err := s.secondFactor.Verify(tx.Subject, code)
+	if _, ok := err.(totp.InvalidCode); ok {
+		method = MethodRecoveryCode
+		err = s.secondFactor.Recover(tx.Subject, code)
+	}
+	if _, ok := err.(totp.InvalidCode); ok {
+		if err := reportAttempt(s.throttler, attempt, err); err != nil {
+			return err
+		}
+		return StepFailed{"The code you entered is incorrect."}
+	} else if err := reportAttempt(s.throttler, attempt, err); err != nil {
+		return err
+	}
+	tx.AddMethods(method)
+	return nil
+}
@@ -0,0 +1,77 @@
+package login
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Form field of the terms acceptance
+const FieldAcceptTerms = "accept_terms"
+
+// Terms are the terms of service users accept on login.
+type Terms struct {
+	// Version of the terms, users accept each version once
+	Version string
+	URL     string
+}
+
+// TermsStore persists the terms versions users accepted.
+type TermsStore interface {
+	Accepted(subject, version string) (bool, error)
+	Accept(subject, version string) error
+}
+
+// MemoryTermsStore is a store that keeps accepted terms in memory.
+type MemoryTermsStore struct {
+	accepted map[string]bool
+	mutex    sync.Mutex
+}
+
+func NewMemoryTermsStore() *MemoryTermsStore {
+	return &MemoryTermsStore{accepted: make(map[string]bool)}
+}
+
+func (s *MemoryTermsStore) Accepted(subject, version string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.accepted[subject+"\x00"+version], nil
+}
+
+func (s *MemoryTermsStore) Accept(subject, version string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.accepted[subject+"\x00"+version] = true
+	return nil
+}
+
+type termsStep struct {
+	terms Terms
+	store TermsStore
+}
+
+// NewTermsStep returns a step where the identified user accepts the terms, it
+// is skipped if the user already accepted their version.
+func NewTermsStep(terms Terms, store TermsStore) Step {
+	return &termsStep{terms: terms, store: store}
+}
+
+func (s *termsStep) Skip(tx *Transaction) (bool, error) {
+	if tx.Subject == "" {
+		return true, nil
+	}
+	return s.store.Accepted(tx.Subject, s.terms.Version)
+}
+
+func (s *termsStep) Prepare(tx *Transaction, page *Login) error {
+	page.Terms = &s.terms
+	return nil
+}
+
+func (s *termsStep) Handles(r *http.Request) bool {
+	_, ok := r.PostForm[FieldAcceptTerms]
+	return ok
+}
+
+func (s *termsStep) Submit(tx *Transaction, r *http.Request) error {
+	return s.store.Accept(tx.Subject, s.terms.Version)
+}
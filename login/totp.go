@@ -0,0 +1,147 @@
+package login
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/skip2/go-qrcode"
+
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/totp"
+	"github.com/arjantop/gopherauth/util"
+)
+
+// Size of the provisioning QR code in pixels
+const QRCodeSize = 256
+
+type totpEnrollmentHandler struct {
+	serverKeys      *keyring.Keyring
+	cookies         *util.CookiePolicy
+	issuer          string
+	loginURL        url.URL
+	userAuthService service.UserAuthenticationService
+	manager         *totp.Manager
+	templateFactory *util.TemplateFactory
+}
+
+// NewTOTPEnrollmentHandler returns the handler where signed in users enroll an
+// authenticator app as the second factor. The new secret is displayed as a QR
+// code and becomes active when the user enters a valid code, the user is then
+// shown recovery codes. Issuer is the name authenticator apps display with the
+// account.
+func NewTOTPEnrollmentHandler(
+	serverKeys *keyring.Keyring,
+	cookies *util.CookiePolicy,
+	issuer string,
+	loginURL url.URL,
+	userAuthService service.UserAuthenticationService,
+	manager *totp.Manager,
+	templateFactory *util.TemplateFactory) http.Handler {
+
+	handler := &totpEnrollmentHandler{
+		serverKeys:      serverKeys,
+		cookies:         cookies,
+		issuer:          issuer,
+		loginURL:        loginURL,
+		userAuthService: userAuthService,
+		manager:         manager,
+		templateFactory: templateFactory,
+	}
+	return util.NoCachingMiddleware(handler)
+}
+
+type TOTPEnrollment struct {
+	// PNG image of the provisioning uri
+	QRCode          template.URL
+	ProvisioningURI template.URL
+	Secret          string
+	Csrf            string
+	ErrorMessage    string
+	// Recovery codes displayed once after the enrollment is confirmed
+	RecoveryCodes []string
+}
+
+func (h *totpEnrollmentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode: http.StatusMethodNotAllowed,
+			Description: fmt.Sprintf(
+				"The request method %s is not supported for the URL %s.", r.Method, r.URL.Path),
+		})
+		return
+	}
+
+	var session *service.Session
+	if sessionId, err := h.cookies.Get(r, util.CookieSession); err == nil {
+		session, err = h.userAuthService.Session(sessionId)
+		if err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+			return
+		}
+	}
+	if session == nil {
+		util.RedirectToLogin(w, r, h.serverKeys.Secret(), h.loginURL, r.URL, nil)
+		return
+	}
+
+	csrf := computeMAC("totp"+session.Id, h.serverKeys.Secret())
+	if r.Method == "GET" {
+		secret, err := h.manager.Enroll(session.Subject)
+		if err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+			return
+		}
+		h.render(w, session, secret, csrf, "")
+		return
+	}
+
+	mac, err := base64.StdEncoding.DecodeString(r.PostFormValue("csrf"))
+	if err != nil || !verifyMAC("totp"+session.Id, mac, h.serverKeys) {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: "Some request parameters were invalid.",
+		})
+		return
+	}
+	recoveryCodes, err := h.manager.Confirm(session.Subject, r.PostFormValue(FieldCode))
+	if _, ok := err.(totp.InvalidCode); ok {
+		secret, err := h.manager.PendingSecret(session.Subject)
+		if err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		} else if secret == "" {
+			http.Redirect(w, r, r.URL.String(), http.StatusFound)
+		} else {
+			h.render(w, session, secret, csrf, "The code you entered is incorrect.")
+		}
+		return
+	} else if err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		return
+	}
+	w.Header().Set("Content-Type", util.ContentTypeHtml)
+	h.templateFactory.ExecuteTemplate(w, "totp_enrollment", TOTPEnrollment{RecoveryCodes: recoveryCodes})
+}
+
+func (h *totpEnrollmentHandler) render(
+	w http.ResponseWriter, session *service.Session, secret string, csrf []byte, errorMessage string) {
+
+	provisioningURI := totp.ProvisioningURI(h.issuer, session.Subject, secret)
+	png, err := qrcode.Encode(provisioningURI, qrcode.Medium, QRCodeSize)
+	if err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
+		return
+	}
+	data := TOTPEnrollment{
+		QRCode:          template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+		ProvisioningURI: template.URL(provisioningURI),
+		Secret:          secret,
+		Csrf:            base64.StdEncoding.EncodeToString(csrf),
+		ErrorMessage:    errorMessage,
+	}
+	w.Header().Set("Content-Type", util.ContentTypeHtml)
+	h.templateFactory.ExecuteTemplate(w, "totp_enrollment", data)
+}
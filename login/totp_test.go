@@ -0,0 +1,147 @@
+package login_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/login"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/totp"
+	"github.com/arjantop/gopherauth/util"
+)
+
+var enrollmentSession = &service.Session{Id: "SessionId", Subject: "email@example.com"}
+
+type enrollmentDeps struct {
+	userAuthService *service.UserAuthenticationServiceMock
+	manager         *totp.Manager
+	handler         http.Handler
+}
+
+func makeEnrollment() enrollmentDeps {
+	userAuthService := service.NewUserAuthenticationServiceMock()
+	manager := totp.NewManager(totp.NewMemoryStore(), service.NewCryptoTokenGenerator(), 1)
+	loginURL, _ := url.Parse("https://example.com/login")
+	return enrollmentDeps{
+		userAuthService: userAuthService,
+		manager:         manager,
+		handler: login.NewTOTPEnrollmentHandler(
+			serverKeys, cookies, "gopherauth", *loginURL, userAuthService, manager,
+			util.NewTemplateFactory("../templates")),
+	}
+}
+
+func makeEnrollmentCsrf() string {
+	mac := hmac.New(sha256.New, []byte("ServerKey"))
+	mac.Write([]byte("totp" + enrollmentSession.Id))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestEnrollmentRequiresSession(t *testing.T) {
+	deps := makeEnrollment()
+
+	request := testutil.NewEndpointRequest(t, "GET", "login/totp", nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/login", location.Path)
+	assert.NotEmpty(t, location.Query().Get("continue"))
+}
+
+func TestEnrollmentDisplaysQRCode(t *testing.T) {
+	deps := makeEnrollment()
+
+	request := testutil.NewEndpointRequest(t, "GET", "login/totp", nil)
+	request.AddCookie(&http.Cookie{Name: "sessionid", Value: enrollmentSession.Id})
+	deps.userAuthService.On("Session", enrollmentSession.Id).Return(enrollmentSession, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	testutil.AssertContentTypeHtml(t, recorder)
+	assert.Contains(t, recorder.Body.String(), `src="data:image/png;base64,`)
+	assert.Contains(t, recorder.Body.String(), `href="otpauth://totp/`)
+	secret, err := deps.manager.PendingSecret(enrollmentSession.Subject)
+	assert.Nil(t, err)
+	assert.Contains(t, recorder.Body.String(), secret)
+}
+
+func TestReloadedEnrollmentDisplaysSameSecret(t *testing.T) {
+	deps := makeEnrollment()
+	deps.userAuthService.On("Session", enrollmentSession.Id).Return(enrollmentSession, nil)
+
+	for i := 0; i < 2; i++ {
+		request := testutil.NewEndpointRequest(t, "GET", "login/totp", nil)
+		request.AddCookie(&http.Cookie{Name: "sessionid", Value: enrollmentSession.Id})
+		recorder := httptest.NewRecorder()
+		deps.handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		secret, err := deps.manager.PendingSecret(enrollmentSession.Subject)
+		assert.Nil(t, err)
+		assert.Contains(t, recorder.Body.String(), secret)
+	}
+	first, err := deps.manager.PendingSecret(enrollmentSession.Subject)
+	assert.Nil(t, err)
+	secret, err := deps.manager.Enroll(enrollmentSession.Subject)
+	assert.Nil(t, err)
+	assert.Equal(t, first, secret)
+}
+
+func TestConfirmedEnrollmentDisplaysRecoveryCodes(t *testing.T) {
+	deps := makeEnrollment()
+	secret, err := deps.manager.Enroll(enrollmentSession.Subject)
+	assert.Nil(t, err)
+	code, err := totp.Code(secret, time.Now())
+	assert.Nil(t, err)
+
+	postParams := url.Values{}
+	postParams.Add("otp", code)
+	postParams.Add("csrf", makeEnrollmentCsrf())
+	request := testutil.NewEndpointPostRequest(t, "login/totp", nil, postParams)
+	request.AddCookie(&http.Cookie{Name: "sessionid", Value: enrollmentSession.Id})
+	deps.userAuthService.On("Session", enrollmentSession.Id).Return(enrollmentSession, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recoveryCodes := regexp.MustCompile(`<li>([a-z0-9]+-[a-z0-9]+)</li>`).FindAllStringSubmatch(recorder.Body.String(), -1)
+	assert.Len(t, recoveryCodes, totp.RecoveryCodeCount)
+	enrolled, err := deps.manager.Enrolled(enrollmentSession.Subject)
+	assert.Nil(t, err)
+	assert.True(t, enrolled)
+}
+
+func TestEnrollmentWithInvalidCsrfIsRejected(t *testing.T) {
+	deps := makeEnrollment()
+	_, err := deps.manager.Enroll(enrollmentSession.Subject)
+	assert.Nil(t, err)
+
+	postParams := url.Values{}
+	postParams.Add("otp", "123456")
+	postParams.Add("csrf", base64.StdEncoding.EncodeToString([]byte("invalid")))
+	request := testutil.NewEndpointPostRequest(t, "login/totp", nil, postParams)
+	request.AddCookie(&http.Cookie{Name: "sessionid", Value: enrollmentSession.Id})
+	deps.userAuthService.On("Session", enrollmentSession.Id).Return(enrollmentSession, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
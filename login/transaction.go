@@ -0,0 +1,142 @@
+package login
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/util"
+)
+
+const (
+	// Cookie holding the encrypted transaction of a login in progress
+	CookieTransaction = "login_transaction"
+	// Time in which all steps of the login must be completed
+	TransactionLifetime = 10 * time.Minute
+	// Size of the random transaction id in bytes
+	transactionIDSize = 16
+)
+
+// Transaction is the state of a login in progress. It is carried between the
+// steps in a cookie that is encrypted and authenticated with the server key, so
+// the user can neither read nor change it.
+type Transaction struct {
+	// Random id that failed attempts of the transaction are counted by
+	ID string `json:"jti"`
+	// Name of the flow and the index of its current step
+	Flow string `json:"flow"`
+	Step int    `json:"step"`
+	// URL the user is returned to after login
+	Continue  string `json:"continue"`
+	LoginHint string `json:"login_hint,omitempty"`
+	// Client the user logs in to, if known
+	Client string `json:"client_id,omitempty"`
+	// User identified by the completed steps
+	Subject string `json:"sub,omitempty"`
+	// Authentication methods of the completed steps
+	Methods []string `json:"amr,omitempty"`
+	// Challenge of the passkey options displayed to the user
+	Challenge []byte `json:"challenge,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func newTransactionID() (string, error) {
+	id := make([]byte, transactionIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// AddMethods records authentication methods of a completed step.
+func (tx *Transaction) AddMethods(methods ...string) {
+	for _, method := range methods {
+		if !containsString(tx.Methods, method) {
+			tx.Methods = append(tx.Methods, method)
+		}
+	}
+}
+
+// MultiFactor reports whether the user already authenticated with more than
+// one factor.
+func (tx *Transaction) MultiFactor() bool {
+	return len(tx.Methods) > 1 || containsString(tx.Methods, MethodMultiFactor)
+}
+
+// setTransactionCookie stores the transaction in the transaction cookie.
+func setTransactionCookie(
+	w http.ResponseWriter, cookies *util.CookiePolicy, tx *Transaction, serverKey []byte) error {
+
+	plaintext, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+	aead, err := transactionCipher(serverKey)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(CookieTransaction))
+	return cookies.SetExpiring(
+		w, CookieTransaction, base64.RawURLEncoding.EncodeToString(sealed), time.Unix(tx.ExpiresAt, 0))
+}
+
+// transactionFromCookie returns the transaction of the request or nil if there
+// is none or it is invalid or has expired.
+func transactionFromCookie(
+	r *http.Request, cookies *util.CookiePolicy, now time.Time, serverKeys *keyring.Keyring) *Transaction {
+
+	value, err := cookies.Get(r, CookieTransaction)
+	if err != nil {
+		return nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	var plaintext []byte
+	for _, serverKey := range serverKeys.Secrets() {
+		aead, err := transactionCipher(serverKey)
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return nil
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if plaintext, err = aead.Open(nil, nonce, ciphertext, []byte(CookieTransaction)); err == nil {
+			break
+		}
+	}
+	if plaintext == nil {
+		return nil
+	}
+	var tx Transaction
+	if err := json.Unmarshal(plaintext, &tx); err != nil || now.Unix() >= tx.ExpiresAt {
+		return nil
+	}
+	return &tx
+}
+
+// transactionCipher returns AES-256-GCM with a key derived from the server key.
+func transactionCipher(serverKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(computeMAC(CookieTransaction, serverKey))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package logout
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/helpers"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/util"
+)
+
+const ParameterCsrf = "csrf"
+
+type logoutHandler struct {
+	serverKeys      *keyring.Keyring
+	cookies         *util.CookiePolicy
+	issuer          string
+	idTokenKey      interface{}
+	userAuthService service.UserAuthenticationService
+	oauth2Service   service.Oauth2Service
+	notifier        *Notifier
+	templateFactory *util.TemplateFactory
+}
+
+// NewLogoutHandler returns the handler that signs the user out. It is also the
+// OpenID Connect end_session_endpoint, id_token_hint is verified with idTokenKey,
+// the public key, or secret for HS256, that ID tokens are signed with, or the
+// keyring that signs them. Clients
+// of the ended session are notified with notifier, nil disables notifications.
+func NewLogoutHandler(
+	serverKeys *keyring.Keyring,
+	cookies *util.CookiePolicy,
+	issuer string,
+	idTokenKey interface{},
+	userAuthService service.UserAuthenticationService,
+	oauth2Service service.Oauth2Service,
+	notifier *Notifier,
+	templateFactory *util.TemplateFactory) http.Handler {
+
+	handler := &logoutHandler{
+		serverKeys:      serverKeys,
+		cookies:         cookies,
+		issuer:          issuer,
+		idTokenKey:      idTokenKey,
+		userAuthService: userAuthService,
+		oauth2Service:   oauth2Service,
+		notifier:        notifier,
+		templateFactory: templateFactory,
+	}
+	return util.NoCachingMiddleware(handler)
+}
+
+type Logout struct {
+	SignedOut bool
+	Csrf      string
+	// Request parameters that are submitted again with the confirmation
+	Parameters map[string]string
+	// Front-channel logout uris of clients that are loaded in iframes
+	FrontChannelURIs []string
+	// Where the user continues after front-channel logout
+	RedirectURI string
+}
+
+// logoutRequest is a validated RP-initiated logout request.
+type logoutRequest struct {
+	clientId    string
+	redirectURI *url.URL
+	state       string
+}
+
+func (h *logoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode: http.StatusMethodNotAllowed,
+			Description: fmt.Sprintf(
+				"The request method %s is not supported for the URL %s.", r.Method, r.URL.Path),
+		})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: "Some request parameters were invalid.",
+		})
+		return
+	}
+	request, err := h.parseRequest(r.Form)
+	if err != nil {
+		if response, ok := err.(*oauth2.ErrorResponse); ok {
+			helpers.RenderError(w, h.templateFactory, response)
+		} else {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		}
+		return
+	}
+
+	var session *service.Session
+	if sessionId, err := h.cookies.Get(r, util.CookieSession); err == nil {
+		session, err = h.userAuthService.Session(sessionId)
+		if err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+			return
+		}
+	}
+	if session == nil {
+		h.finish(w, r, request, nil)
+		return
+	}
+
+	csrf := r.PostFormValue(ParameterCsrf)
+	if r.Method == "GET" || csrf == "" {
+		h.renderConfirmation(w, session, request)
+		return
+	}
+	mac, err := base64.StdEncoding.DecodeString(csrf)
+	if err != nil || !verifyMAC(session.Id, mac, h.serverKeys) {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode:  http.StatusBadRequest,
+			Description: "Some request parameters were invalid.",
+		})
+		return
+	}
+	if err := h.userAuthService.EndSession(session.Id); err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		return
+	}
+	h.cookies.Delete(w, util.CookieSession)
+	var frontChannelURIs []string
+	if h.notifier != nil {
+		frontChannelURIs, err = h.notifier.Notify(session)
+		if err != nil {
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+			return
+		}
+	}
+	h.finish(w, r, request, frontChannelURIs)
+}
+
+// parseRequest validates the optional parameters of an RP-initiated logout. The
+// client is identified by client_id or the audience of id_token_hint.
+func (h *logoutHandler) parseRequest(params url.Values) (*logoutRequest, error) {
+	request := &logoutRequest{
+		clientId: params.Get(oauth2.ParameterClientId),
+		state:    params.Get(oauth2.ParameterState),
+	}
+	if hint := params.Get(oauth2.ParameterIDTokenHint); hint != "" {
+		audience, err := h.verifyIDTokenHint(hint)
+		if err != nil {
+			return nil, err
+		}
+		if request.clientId == "" && len(audience) == 1 {
+			request.clientId = audience[0]
+		} else if !containsString(audience, request.clientId) {
+			return nil, oauth2.NewInvalidRequestError("Client is not the audience of id_token_hint")
+		}
+	}
+	redirectURIString := params.Get(oauth2.ParameterPostLogoutRedirectUri)
+	if redirectURIString == "" {
+		return request, nil
+	}
+	if request.clientId == "" {
+		return nil, oauth2.NewInvalidRequestError(
+			"Parameter client_id or id_token_hint is required with post_logout_redirect_uri")
+	}
+	redirectURI, err := url.Parse(redirectURIString)
+	if err != nil || !redirectURI.IsAbs() {
+		return nil, oauth2.NewInvalidRequestError("Invalid post_logout_redirect_uri")
+	}
+	err = h.oauth2Service.ValidatePostLogoutRedirectURI(request.clientId, redirectURIString)
+	if err != nil {
+		return nil, err
+	}
+	request.redirectURI = redirectURI
+	return request, nil
+}
+
+// verifyIDTokenHint checks that the hint is an ID token issued by this server
+// and returns its audience. Expired tokens are accepted as hints.
+func (h *logoutHandler) verifyIDTokenHint(hint string) ([]string, error) {
+	invalidHint := oauth2.NewInvalidRequestError("Invalid id_token_hint")
+	jws, err := jose.ParseJWS(hint)
+	if err != nil || jws.Verify(h.idTokenKey) != nil {
+		return nil, invalidHint
+	}
+	var claims struct {
+		Issuer   string      `json:"iss"`
+		Audience interface{} `json:"aud"`
+	}
+	if jws.Claims(&claims) != nil || claims.Issuer != h.issuer {
+		return nil, invalidHint
+	}
+	switch aud := claims.Audience.(type) {
+	case string:
+		return []string{aud}, nil
+	case []interface{}:
+		audience := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+		return audience, nil
+	}
+	return nil, invalidHint
+}
+
+func (h *logoutHandler) renderConfirmation(
+	w http.ResponseWriter, session *service.Session, request *logoutRequest) {
+
+	params := make(map[string]string)
+	if request.clientId != "" {
+		params[oauth2.ParameterClientId] = request.clientId
+	}
+	if request.redirectURI != nil {
+		params[oauth2.ParameterPostLogoutRedirectUri] = request.redirectURI.String()
+	}
+	if request.state != "" {
+		params[oauth2.ParameterState] = request.state
+	}
+	data := Logout{
+		Csrf:       base64.StdEncoding.EncodeToString(computeMAC(session.Id, h.serverKeys.Secret())),
+		Parameters: params,
+	}
+	w.Header().Set("Content-Type", util.ContentTypeHtml)
+	h.templateFactory.ExecuteTemplate(w, "logout", data)
+}
+
+// finish returns the user to the client if a post logout redirect uri was
+// requested, otherwise it displays the signed out page. If there are front-channel
+// logout uris the signed out page loads them first and then continues to the
+// client.
+func (h *logoutHandler) finish(
+	w http.ResponseWriter, r *http.Request, request *logoutRequest, frontChannelURIs []string) {
+
+	redirectURI := ""
+	if request.redirectURI != nil {
+		uri := *request.redirectURI
+		if request.state != "" {
+			query := uri.Query()
+			query.Set(oauth2.ParameterState, request.state)
+			uri.RawQuery = query.Encode()
+		}
+		redirectURI = uri.String()
+	}
+	if redirectURI != "" && len(frontChannelURIs) == 0 {
+		http.Redirect(w, r, redirectURI, http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", util.ContentTypeHtml)
+	h.templateFactory.ExecuteTemplate(w, "logout", Logout{
+		SignedOut:        true,
+		FrontChannelURIs: frontChannelURIs,
+		RedirectURI:      redirectURI,
+	})
+}
+
+func computeMAC(sessionId string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("logout"))
+	mac.Write([]byte(sessionId))
+	return mac.Sum(nil)
+}
+
+// verifyMAC reports whether the MAC of the session was computed with any of
+// the server keys.
+func verifyMAC(sessionId string, mac []byte, keys *keyring.Keyring) bool {
+	for _, key := range keys.Secrets() {
+		if hmac.Equal(mac, computeMAC(sessionId, key)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package logout_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/logout"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/util"
+)
+
+const (
+	issuer            = "https://example.com"
+	postLogoutURI     = "https://client.example.com/logged-out"
+	clientId          = "client_id"
+	sessionCookieName = "sessionid"
+)
+
+var (
+	serverKey  = []byte("ServerKey")
+	idTokenKey = []byte("IDTokenKey")
+	session    = &service.Session{Id: "SessionId", Subject: "user"}
+)
+
+type logoutDeps struct {
+	userAuthService *service.UserAuthenticationServiceMock
+	oauth2Service   *service.Oauth2ServiceMock
+	handler         http.Handler
+}
+
+func makeLogout() logoutDeps {
+	userAuthService := service.NewUserAuthenticationServiceMock()
+	oauth2Service := service.NewOauth2ServiceMock()
+	return logoutDeps{
+		userAuthService: userAuthService,
+		oauth2Service:   oauth2Service,
+		handler: logout.NewLogoutHandler(
+			testutil.NewSecretKeyring(string(serverKey)), &util.CookiePolicy{}, issuer, idTokenKey, userAuthService, oauth2Service, nil,
+			util.NewTemplateFactory("../templates")),
+	}
+}
+
+func makeCsrf() string {
+	mac := hmac.New(sha256.New, serverKey)
+	mac.Write([]byte("logout"))
+	mac.Write([]byte(session.Id))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func makeIDToken(t *testing.T, iss, aud string) string {
+	signer, err := jose.NewSigner(jose.AlgorithmHS256, idTokenKey, "")
+	assert.Nil(t, err)
+	idToken, err := jose.SignClaims(signer, "JWT", map[string]interface{}{
+		"iss": iss,
+		"aud": aud,
+		"sub": "user",
+	})
+	assert.Nil(t, err)
+	return idToken
+}
+
+func TestLogoutConfirmationIsDisplayed(t *testing.T) {
+	deps := makeLogout()
+
+	request := testutil.NewEndpointRequest(t, "GET", "logout", nil)
+	request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.Id})
+	deps.userAuthService.On("Session", session.Id).Return(session, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	testutil.AssertContentTypeHtml(t, recorder)
+	assert.Contains(t, recorder.Body.String(), "Do you want to sign out?")
+	csrf := regexp.MustCompile(`name="csrf" type="hidden" value="([^"]+)"`).FindStringSubmatch(recorder.Body.String())
+	assert.NotNil(t, csrf)
+	assert.Equal(t, makeCsrf(), html.UnescapeString(csrf[1]))
+	deps.userAuthService.Mock.AssertExpectations(t)
+}
+
+func TestConfirmedLogoutEndsSessionAndClearsCookie(t *testing.T) {
+	deps := makeLogout()
+
+	postParams := url.Values{}
+	postParams.Add("csrf", makeCsrf())
+	request := testutil.NewEndpointPostRequest(t, "logout", nil, postParams)
+	request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.Id})
+	deps.userAuthService.On("Session", session.Id).Return(session, nil)
+	deps.userAuthService.On("EndSession", session.Id).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "You have been signed out")
+	cookie := recorder.Result().Cookies()[0]
+	assert.Equal(t, sessionCookieName, cookie.Name)
+	assert.True(t, cookie.MaxAge < 0, "Session cookie must be deleted")
+	deps.userAuthService.Mock.AssertExpectations(t)
+}
+
+func TestLogoutWithInvalidCsrfIsRejected(t *testing.T) {
+	deps := makeLogout()
+
+	postParams := url.Values{}
+	postParams.Add("csrf", base64.StdEncoding.EncodeToString([]byte("invalid")))
+	request := testutil.NewEndpointPostRequest(t, "logout", nil, postParams)
+	request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.Id})
+	deps.userAuthService.On("Session", session.Id).Return(session, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	deps.userAuthService.Mock.AssertExpectations(t)
+}
+
+func TestLogoutRedirectsToPostLogoutRedirectUriWithState(t *testing.T) {
+	deps := makeLogout()
+
+	postParams := url.Values{}
+	postParams.Add("csrf", makeCsrf())
+	postParams.Add("client_id", clientId)
+	postParams.Add("post_logout_redirect_uri", postLogoutURI)
+	postParams.Add("state", "state")
+	request := testutil.NewEndpointPostRequest(t, "logout", nil, postParams)
+	request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.Id})
+	deps.userAuthService.On("Session", session.Id).Return(session, nil)
+	deps.userAuthService.On("EndSession", session.Id).Return(nil)
+	deps.oauth2Service.On("ValidatePostLogoutRedirectURI", clientId, postLogoutURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, postLogoutURI+"?state=state", recorder.Header().Get("Location"))
+	deps.userAuthService.Mock.AssertExpectations(t)
+	deps.oauth2Service.Mock.AssertExpectations(t)
+}
+
+func TestUnregisteredPostLogoutRedirectUriIsRejected(t *testing.T) {
+	deps := makeLogout()
+
+	params := url.Values{}
+	params.Add("client_id", clientId)
+	params.Add("post_logout_redirect_uri", postLogoutURI)
+	request := testutil.NewEndpointRequest(t, "GET", "logout", params)
+	deps.oauth2Service.On("ValidatePostLogoutRedirectURI", clientId, postLogoutURI).Return(
+		&oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidRequest})
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	deps.oauth2Service.Mock.AssertExpectations(t)
+}
+
+func TestClientIsIdentifiedByIDTokenHint(t *testing.T) {
+	deps := makeLogout()
+
+	params := url.Values{}
+	params.Add("id_token_hint", makeIDToken(t, issuer, clientId))
+	params.Add("post_logout_redirect_uri", postLogoutURI)
+	request := testutil.NewEndpointRequest(t, "GET", "logout", params)
+	deps.oauth2Service.On("ValidatePostLogoutRedirectURI", clientId, postLogoutURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusFound, recorder.Code, "User without session is returned to the client")
+	assert.Equal(t, postLogoutURI, recorder.Header().Get("Location"))
+	deps.oauth2Service.Mock.AssertExpectations(t)
+}
+
+func TestIDTokenHintFromOtherIssuerIsRejected(t *testing.T) {
+	deps := makeLogout()
+
+	params := url.Values{}
+	params.Add("id_token_hint", makeIDToken(t, "https://other.example.com", clientId))
+	request := testutil.NewEndpointRequest(t, "GET", "logout", params)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "id_token_hint")
+}
+
+func TestIDTokenHintMustMatchClientId(t *testing.T) {
+	deps := makeLogout()
+
+	params := url.Values{}
+	params.Add("id_token_hint", makeIDToken(t, issuer, clientId))
+	params.Add("client_id", "other_client")
+	request := testutil.NewEndpointRequest(t, "GET", "logout", params)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestFrontChannelLogoutUrisAreLoadedBeforeRedirect(t *testing.T) {
+	deps := makeLogout()
+	notifier := makeNotifier(t, clientRegistryStub{
+		clientId: &logout.Client{ClientId: clientId, FrontChannelLogoutURI: "https://client.example.com/frontchannel"},
+	}, logout.NewMemoryQueue(), 0)
+	handler := logout.NewLogoutHandler(
+		testutil.NewSecretKeyring(string(serverKey)), &util.CookiePolicy{}, issuer, idTokenKey, deps.userAuthService, deps.oauth2Service, notifier,
+		util.NewTemplateFactory("../templates"))
+
+	postParams := url.Values{}
+	postParams.Add("csrf", makeCsrf())
+	postParams.Add("client_id", clientId)
+	postParams.Add("post_logout_redirect_uri", postLogoutURI)
+	request := testutil.NewEndpointPostRequest(t, "logout", nil, postParams)
+	request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.Id})
+	deps.userAuthService.On("Session", session.Id).Return(makeSessionWithClients(clientId), nil)
+	deps.userAuthService.On("EndSession", session.Id).Return(nil)
+	deps.oauth2Service.On("ValidatePostLogoutRedirectURI", clientId, postLogoutURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `<iframe class="frontchannel" src="https://client.example.com/frontchannel">`)
+	assert.Contains(t, recorder.Body.String(), `href="`+postLogoutURI+`"`)
+	deps.userAuthService.Mock.AssertExpectations(t)
+	deps.oauth2Service.Mock.AssertExpectations(t)
+}
@@ -0,0 +1,221 @@
+package logout
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/service"
+)
+
+const (
+	// Event identifying a logout token
+	EventBackChannelLogout = "http://schemas.openid.net/event/backchannel-logout"
+	// Logout tokens are short lived, they are only used once by the receiver
+	LogoutTokenLifetime = 2 * time.Minute
+	// Size of the random logout token and delivery identifiers in bytes
+	idSize = 16
+)
+
+// Client holds the logout registration of a client.
+type Client struct {
+	ClientId                          string
+	BackChannelLogoutURI              string
+	BackChannelLogoutSessionRequired  bool
+	FrontChannelLogoutURI             string
+	FrontChannelLogoutSessionRequired bool
+}
+
+// ClientRegistry returns logout registrations of clients.
+type ClientRegistry interface {
+	// LogoutClient returns the client's registration or nil if the client does
+	// not exist.
+	LogoutClient(clientId string) (*Client, error)
+}
+
+// Notifier tells clients that were issued tokens during a session that the
+// session has ended. Back-channel logout tokens are queued and delivered with
+// exponential backoff until the client accepts them or maxAttempts is reached.
+type Notifier struct {
+	issuer         string
+	signer         jose.Signer
+	clients        ClientRegistry
+	queue          Queue
+	tokenGenerator service.TokenGenerator
+	httpClient     *http.Client
+	maxAttempts    int
+	backoff        time.Duration
+}
+
+func NewNotifier(
+	issuer string,
+	signer jose.Signer,
+	clients ClientRegistry,
+	queue Queue,
+	tokenGenerator service.TokenGenerator,
+	httpClient *http.Client,
+	maxAttempts int,
+	backoff time.Duration) *Notifier {
+
+	return &Notifier{
+		issuer:         issuer,
+		signer:         signer,
+		clients:        clients,
+		queue:          queue,
+		tokenGenerator: tokenGenerator,
+		httpClient:     httpClient,
+		maxAttempts:    maxAttempts,
+		backoff:        backoff,
+	}
+}
+
+// Notify queues logout tokens for clients of the session that registered a
+// back-channel logout uri and returns the front-channel logout uris that the
+// user agent must load.
+func (n *Notifier) Notify(session *service.Session) ([]string, error) {
+	frontChannelURIs := make([]string, 0)
+	for _, clientId := range session.Clients {
+		client, err := n.clients.LogoutClient(clientId)
+		if err != nil {
+			return nil, err
+		} else if client == nil {
+			continue
+		}
+		if client.BackChannelLogoutURI != "" {
+			if err := n.enqueue(client, session); err != nil {
+				return nil, err
+			}
+		}
+		if client.FrontChannelLogoutURI != "" {
+			uri, err := n.frontChannelURI(client, session)
+			if err != nil {
+				return nil, err
+			}
+			frontChannelURIs = append(frontChannelURIs, uri)
+		}
+	}
+	return frontChannelURIs, nil
+}
+
+func (n *Notifier) enqueue(client *Client, session *service.Session) error {
+	jti, err := n.generateId()
+	if err != nil {
+		return err
+	}
+	id, err := n.generateId()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": n.issuer,
+		"aud": client.ClientId,
+		"iat": now.Unix(),
+		"exp": now.Add(LogoutTokenLifetime).Unix(),
+		"jti": jti,
+		"sub": session.Subject,
+		"sid": session.Sid,
+		"events": map[string]interface{}{
+			EventBackChannelLogout: map[string]interface{}{},
+		},
+	}
+	logoutToken, err := jose.SignClaims(n.signer, "logout+jwt", claims)
+	if err != nil {
+		return err
+	}
+	return n.queue.Add(&Delivery{
+		Id:          id,
+		ClientId:    client.ClientId,
+		URI:         client.BackChannelLogoutURI,
+		LogoutToken: logoutToken,
+		NextAttempt: now,
+	})
+}
+
+// frontChannelURI adds the issuer and session id to the client's uri if the
+// client requires them.
+func (n *Notifier) frontChannelURI(client *Client, session *service.Session) (string, error) {
+	if !client.FrontChannelLogoutSessionRequired {
+		return client.FrontChannelLogoutURI, nil
+	}
+	uri, err := url.Parse(client.FrontChannelLogoutURI)
+	if err != nil {
+		return "", err
+	}
+	query := uri.Query()
+	query.Set("iss", n.issuer)
+	query.Set("sid", session.Sid)
+	uri.RawQuery = query.Encode()
+	return uri.String(), nil
+}
+
+// Deliver posts all due logout tokens. Failed deliveries are rescheduled, the
+// delay doubles with every attempt.
+func (n *Notifier) Deliver() error {
+	now := time.Now()
+	deliveries, err := n.queue.Due(now)
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		if n.post(d) {
+			err = n.queue.Remove(d.Id)
+		} else if d.Attempts++; d.Attempts >= n.maxAttempts {
+			err = n.queue.Remove(d.Id)
+		} else {
+			d.NextAttempt = now.Add(n.backoff << uint(d.Attempts-1))
+			err = n.queue.Update(d)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post reports whether the client accepted the logout token.
+func (n *Notifier) post(d *Delivery) bool {
+	form := url.Values{}
+	form.Set("logout_token", d.LogoutToken)
+	response, err := n.httpClient.PostForm(d.URI, form)
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode >= 200 && response.StatusCode < 300
+}
+
+// Run delivers due logout tokens every interval until stop is closed. Queued
+// tokens are delivered at start, they could be left by a previous run. Failed
+// deliveries are logged and retried after the backoff if it is shorter than the
+// interval, e.g. when the queue is briefly unavailable.
+func (n *Notifier) Run(interval time.Duration, stop <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			next := interval
+			if err := n.Deliver(); err != nil {
+				log.Printf("Delivery of logout tokens failed: %s", err)
+				if n.backoff < interval {
+					next = n.backoff
+				}
+			}
+			timer.Reset(next)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (n *Notifier) generateId() (string, error) {
+	id, err := n.tokenGenerator.Generate(idSize)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
@@ -0,0 +1,208 @@
+package logout_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/logout"
+	"github.com/arjantop/gopherauth/service"
+)
+
+var logoutTokenKey = []byte("LogoutTokenKey")
+
+type clientRegistryStub map[string]*logout.Client
+
+func (r clientRegistryStub) LogoutClient(clientId string) (*logout.Client, error) {
+	return r[clientId], nil
+}
+
+// receiver is a back-channel logout endpoint that fails the first failures
+// requests.
+type receiver struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	failures int
+	tokens   []string
+}
+
+func newReceiver(failures int) *receiver {
+	r := &receiver{failures: failures}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.failures > 0 {
+			r.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.tokens = append(r.tokens, req.PostFormValue("logout_token"))
+	}))
+	return r
+}
+
+func makeNotifier(t *testing.T, clients clientRegistryStub, queue logout.Queue, backoff time.Duration) *logout.Notifier {
+	signer, err := jose.NewSigner(jose.AlgorithmHS256, logoutTokenKey, "")
+	assert.Nil(t, err)
+	return logout.NewNotifier(
+		issuer, signer, clients, queue, service.NewCryptoTokenGenerator(), http.DefaultClient, 3, backoff)
+}
+
+func makeSessionWithClients(clients ...string) *service.Session {
+	return &service.Session{Id: "SessionId", Sid: "sid", Subject: "user", Clients: clients}
+}
+
+func TestLogoutTokenIsDeliveredToBackChannelLogoutUri(t *testing.T) {
+	r := newReceiver(0)
+	defer r.server.Close()
+	notifier := makeNotifier(t, clientRegistryStub{
+		clientId: &logout.Client{ClientId: clientId, BackChannelLogoutURI: r.server.URL},
+	}, logout.NewMemoryQueue(), 0)
+
+	frontChannelURIs, err := notifier.Notify(makeSessionWithClients(clientId))
+	assert.Nil(t, err)
+	assert.Empty(t, frontChannelURIs)
+	assert.Nil(t, notifier.Deliver())
+
+	assert.Len(t, r.tokens, 1)
+	jws, err := jose.ParseJWS(r.tokens[0])
+	assert.Nil(t, err)
+	assert.Nil(t, jws.Verify(logoutTokenKey))
+	assert.Equal(t, "logout+jwt", jws.Header.Type)
+	var claims map[string]interface{}
+	assert.Nil(t, jws.Claims(&claims))
+	assert.Equal(t, issuer, claims["iss"])
+	assert.Equal(t, clientId, claims["aud"])
+	assert.Equal(t, "user", claims["sub"])
+	assert.Equal(t, "sid", claims["sid"])
+	assert.NotEmpty(t, claims["jti"])
+	assert.Contains(t, claims["events"], logout.EventBackChannelLogout)
+	assert.NotContains(t, claims, "nonce")
+}
+
+func TestFailedDeliveryIsRetried(t *testing.T) {
+	r := newReceiver(1)
+	defer r.server.Close()
+	queue := logout.NewMemoryQueue()
+	notifier := makeNotifier(t, clientRegistryStub{
+		clientId: &logout.Client{ClientId: clientId, BackChannelLogoutURI: r.server.URL},
+	}, queue, 0)
+
+	_, err := notifier.Notify(makeSessionWithClients(clientId))
+	assert.Nil(t, err)
+	assert.Nil(t, notifier.Deliver())
+	assert.Empty(t, r.tokens)
+	assert.Nil(t, notifier.Deliver())
+
+	assert.Len(t, r.tokens, 1)
+	due, err := queue.Due(time.Now())
+	assert.Nil(t, err)
+	assert.Empty(t, due, "Delivered token is removed from the queue")
+}
+
+// unavailableQueue fails the first failures calls to Due.
+type unavailableQueue struct {
+	*logout.MemoryQueue
+	mutex    sync.Mutex
+	failures int
+}
+
+func (q *unavailableQueue) Due(now time.Time) ([]*logout.Delivery, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.failures > 0 {
+		q.failures--
+		return nil, errors.New("queue is unavailable")
+	}
+	return q.MemoryQueue.Due(now)
+}
+
+func TestFailedDeliveryRunIsRetriedAfterBackoff(t *testing.T) {
+	r := newReceiver(0)
+	defer r.server.Close()
+	queue := &unavailableQueue{MemoryQueue: logout.NewMemoryQueue(), failures: 2}
+	notifier := makeNotifier(t, clientRegistryStub{
+		clientId: &logout.Client{ClientId: clientId, BackChannelLogoutURI: r.server.URL},
+	}, queue, 10*time.Millisecond)
+	_, err := notifier.Notify(makeSessionWithClients(clientId))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go notifier.Run(time.Hour, stop)
+
+	assert.Eventually(t, func() bool {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return len(r.tokens) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFailedDeliveryIsDelayedWithBackoff(t *testing.T) {
+	r := newReceiver(1)
+	defer r.server.Close()
+	queue := logout.NewMemoryQueue()
+	notifier := makeNotifier(t, clientRegistryStub{
+		clientId: &logout.Client{ClientId: clientId, BackChannelLogoutURI: r.server.URL},
+	}, queue, time.Hour)
+
+	_, err := notifier.Notify(makeSessionWithClients(clientId))
+	assert.Nil(t, err)
+	assert.Nil(t, notifier.Deliver())
+
+	due, err := queue.Due(time.Now())
+	assert.Nil(t, err)
+	assert.Empty(t, due)
+	due, err = queue.Due(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, due, 1)
+	assert.Equal(t, 1, due[0].Attempts)
+}
+
+func TestDeliveryIsDroppedAfterMaxAttempts(t *testing.T) {
+	r := newReceiver(3)
+	defer r.server.Close()
+	queue := logout.NewMemoryQueue()
+	notifier := makeNotifier(t, clientRegistryStub{
+		clientId: &logout.Client{ClientId: clientId, BackChannelLogoutURI: r.server.URL},
+	}, queue, 0)
+
+	_, err := notifier.Notify(makeSessionWithClients(clientId))
+	assert.Nil(t, err)
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, notifier.Deliver())
+	}
+
+	assert.Empty(t, r.tokens)
+	due, err := queue.Due(time.Now())
+	assert.Nil(t, err)
+	assert.Empty(t, due)
+}
+
+func TestFrontChannelLogoutUrisAreReturned(t *testing.T) {
+	notifier := makeNotifier(t, clientRegistryStub{
+		"client1": &logout.Client{
+			ClientId:              "client1",
+			FrontChannelLogoutURI: "https://client1.example.com/logout",
+		},
+		"client2": &logout.Client{
+			ClientId:                          "client2",
+			FrontChannelLogoutURI:             "https://client2.example.com/logout?a=b",
+			FrontChannelLogoutSessionRequired: true,
+		},
+	}, logout.NewMemoryQueue(), 0)
+
+	frontChannelURIs, err := notifier.Notify(makeSessionWithClients("client1", "client2", "unknown"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"https://client1.example.com/logout",
+		"https://client2.example.com/logout?a=b&iss=https%3A%2F%2Fexample.com&sid=sid",
+	}, frontChannelURIs)
+}
@@ -0,0 +1,201 @@
+package logout
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/keyring"
+)
+
+// Delivery is a pending back-channel logout notification of a single client.
+type Delivery struct {
+	Id          string
+	ClientId    string
+	URI         string
+	LogoutToken string
+	Attempts    int
+	NextAttempt time.Time
+}
+
+// Queue holds deliveries until they succeed or are given up.
+type Queue interface {
+	Add(d *Delivery) error
+	// Due returns deliveries whose next attempt is not after now.
+	Due(now time.Time) ([]*Delivery, error)
+	Update(d *Delivery) error
+	Remove(id string) error
+}
+
+// MemoryQueue is a queue that keeps deliveries in memory only.
+type MemoryQueue struct {
+	mutex      sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		deliveries: make(map[string]*Delivery),
+	}
+}
+
+func (q *MemoryQueue) Add(d *Delivery) error {
+	return q.Update(d)
+}
+
+func (q *MemoryQueue) Due(now time.Time) ([]*Delivery, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	due := make([]*Delivery, 0)
+	for _, d := range q.deliveries {
+		if !d.NextAttempt.After(now) {
+			copied := *d
+			due = append(due, &copied)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+	return due, nil
+}
+
+func (q *MemoryQueue) Update(d *Delivery) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	copied := *d
+	q.deliveries[d.Id] = &copied
+	return nil
+}
+
+func (q *MemoryQueue) Remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.deliveries, id)
+	return nil
+}
+
+func (q *MemoryQueue) all() []*Delivery {
+	deliveries := make([]*Delivery, 0, len(q.deliveries))
+	for _, d := range q.deliveries {
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
+}
+
+// FileQueue is a queue persisted as a JSON file, so pending deliveries survive a
+// restart. The file is rewritten on every change.
+type FileQueue struct {
+	mutex  sync.Mutex
+	path   string
+	memory *MemoryQueue
+	// The file is sealed with the KEK if it is set, logout tokens must not be
+	// readable on disk
+	kek      *keyring.KEK
+	previous []*keyring.KEK
+}
+
+// NewFileQueue returns a queue loaded from the file at path, a missing file is
+// an empty queue.
+func NewFileQueue(path string) (*FileQueue, error) {
+	return newFileQueue(path, nil, nil)
+}
+
+// NewEncryptedFileQueue returns a queue stored in the file at path sealed with
+// the KEK. Files sealed with a previous KEK or not sealed at all are read and
+// sealed with the KEK on the next change.
+func NewEncryptedFileQueue(path string, kek *keyring.KEK, previous ...*keyring.KEK) (*FileQueue, error) {
+	return newFileQueue(path, kek, previous)
+}
+
+func newFileQueue(path string, kek *keyring.KEK, previous []*keyring.KEK) (*FileQueue, error) {
+	queue := &FileQueue{
+		path:     path,
+		memory:   NewMemoryQueue(),
+		kek:      kek,
+		previous: previous,
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return queue, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if data, err = queue.open(data); err != nil {
+		return nil, err
+	}
+	var deliveries []*Delivery
+	if err := json.Unmarshal(data, &deliveries); err != nil {
+		return nil, err
+	}
+	for _, d := range deliveries {
+		queue.memory.deliveries[d.Id] = d
+	}
+	return queue, nil
+}
+
+func (q *FileQueue) Add(d *Delivery) error {
+	return q.Update(d)
+}
+
+func (q *FileQueue) Due(now time.Time) ([]*Delivery, error) {
+	return q.memory.Due(now)
+}
+
+func (q *FileQueue) Update(d *Delivery) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.memory.Update(d)
+	return q.save()
+}
+
+func (q *FileQueue) Remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.memory.Remove(id)
+	return q.save()
+}
+
+// save writes the queue to a temporary file that replaces the queue file, so a
+// crash never leaves a partially written queue.
+func (q *FileQueue) save() error {
+	q.memory.mutex.Lock()
+	data, err := json.Marshal(q.memory.all())
+	q.memory.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if data, err = q.seal(data); err != nil {
+		return err
+	}
+	return keyring.WriteFile(q.path, data)
+}
+
+// queueAAD binds the sealed queue to its purpose.
+var queueAAD = []byte("logout-queue")
+
+func (q *FileQueue) seal(data []byte) ([]byte, error) {
+	if q.kek == nil {
+		return data, nil
+	}
+	encrypted, err := q.kek.SealData(data, queueAAD)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encrypted)
+}
+
+// open returns the deliveries of a sealed file. A JSON array is a file written
+// before the queue was sealed.
+func (q *FileQueue) open(data []byte) ([]byte, error) {
+	if q.kek == nil || bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return data, nil
+	}
+	var encrypted keyring.EncryptedData
+	if err := json.Unmarshal(data, &encrypted); err != nil {
+		return nil, err
+	}
+	return keyring.OpenData(&encrypted, queueAAD, append([]*keyring.KEK{q.kek}, q.previous...)...)
+}
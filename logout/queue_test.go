@@ -0,0 +1,108 @@
+package logout_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/logout"
+	"github.com/arjantop/gopherauth/testutil"
+)
+
+func TestMemoryQueueReturnsOnlyDueDeliveries(t *testing.T) {
+	queue := logout.NewMemoryQueue()
+	now := time.Now()
+	assert.Nil(t, queue.Add(&logout.Delivery{Id: "later", NextAttempt: now.Add(time.Minute)}))
+	assert.Nil(t, queue.Add(&logout.Delivery{Id: "due", NextAttempt: now}))
+
+	due, err := queue.Due(now)
+
+	assert.Nil(t, err)
+	assert.Len(t, due, 1)
+	assert.Equal(t, "due", due[0].Id)
+}
+
+func TestFileQueueIsPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logout")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	queuePath := path.Join(dir, "queue.json")
+	now := time.Now()
+
+	queue, err := logout.NewFileQueue(queuePath)
+	assert.Nil(t, err)
+	assert.Nil(t, queue.Add(&logout.Delivery{Id: "d1", URI: "https://client.example.com", NextAttempt: now}))
+	assert.Nil(t, queue.Add(&logout.Delivery{Id: "d2", NextAttempt: now}))
+	assert.Nil(t, queue.Remove("d2"))
+
+	reloaded, err := logout.NewFileQueue(queuePath)
+	assert.Nil(t, err)
+	due, err := reloaded.Due(now)
+	assert.Nil(t, err)
+	assert.Len(t, due, 1)
+	assert.Equal(t, "d1", due[0].Id)
+	assert.Equal(t, "https://client.example.com", due[0].URI)
+
+	// Temporary files are renamed over the queue file
+	entries, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "queue.json", entries[0].Name())
+		assert.Equal(t, os.FileMode(0600), entries[0].Mode().Perm())
+	}
+}
+
+func TestEncryptedFileQueueDoesNotStorePlaintextTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logout")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	queuePath := path.Join(dir, "queue.json")
+	kek := testutil.NewKEK()
+	now := time.Now()
+
+	queue, err := logout.NewEncryptedFileQueue(queuePath, kek)
+	assert.Nil(t, err)
+	assert.Nil(t, queue.Add(&logout.Delivery{Id: "d1", LogoutToken: "logout.token.value", NextAttempt: now}))
+
+	data, err := ioutil.ReadFile(queuePath)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "logout.token.value")
+	_, err = logout.NewFileQueue(queuePath)
+	assert.NotNil(t, err)
+	_, err = logout.NewEncryptedFileQueue(queuePath, testutil.NewKEK())
+	assert.Equal(t, keyring.ErrUnknownKEK, err)
+
+	reloaded, err := logout.NewEncryptedFileQueue(queuePath, testutil.NewKEK(), kek)
+	assert.Nil(t, err)
+	due, err := reloaded.Due(now)
+	assert.Nil(t, err)
+	assert.Len(t, due, 1)
+	assert.Equal(t, "logout.token.value", due[0].LogoutToken)
+}
+
+func TestPlaintextFileQueueIsSealedOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logout")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	queuePath := path.Join(dir, "queue.json")
+	now := time.Now()
+	plaintext, err := logout.NewFileQueue(queuePath)
+	assert.Nil(t, err)
+	assert.Nil(t, plaintext.Add(&logout.Delivery{Id: "d1", LogoutToken: "logout.token.value", NextAttempt: now}))
+
+	queue, err := logout.NewEncryptedFileQueue(queuePath, testutil.NewKEK())
+	assert.Nil(t, err)
+	due, err := queue.Due(now)
+	assert.Nil(t, err)
+	assert.Len(t, due, 1)
+	assert.Nil(t, queue.Add(&logout.Delivery{Id: "d2", NextAttempt: now}))
+
+	data, err := ioutil.ReadFile(queuePath)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "logout.token.value")
+}
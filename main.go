@@ -1,77 +1,294 @@
 package main
 
 import (
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/arjantop/gopherauth/bearer"
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
 	"github.com/arjantop/gopherauth/login"
+	"github.com/arjantop/gopherauth/logout"
 	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/access_token"
+	"github.com/arjantop/gopherauth/oauth2/dpop"
 	"github.com/arjantop/gopherauth/oauth2/endpoint"
 	"github.com/arjantop/gopherauth/oauth2/grant_type"
+	"github.com/arjantop/gopherauth/oauth2/response_mode"
 	"github.com/arjantop/gopherauth/oauth2/response_type"
+	"github.com/arjantop/gopherauth/proxy"
 	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/session"
+	"github.com/arjantop/gopherauth/throttle"
+	"github.com/arjantop/gopherauth/token"
+	"github.com/arjantop/gopherauth/totp"
 	"github.com/arjantop/gopherauth/util"
+	"github.com/arjantop/gopherauth/webauthn"
 )
 
 type UserAuthenticationServiceTest struct {
-	sessionMap map[string]string
+	sessions *session.Manager
 }
 
-func (m *UserAuthenticationServiceTest) IsSessionValid(sessionId string) (bool, error) {
-	return m.sessionMap[sessionId] != "", nil
+func (m *UserAuthenticationServiceTest) Session(sessionId string) (*service.Session, error) {
+	return m.sessions.Session(sessionId)
 }
 
-func (m *UserAuthenticationServiceTest) AuthenticateUser(user, password string) (string, error) {
-	if user == "user1@example.com" && password == "pass1" {
-		m.sessionMap["session1"] = "user1@example.com"
-		return "session1", nil
+func (m *UserAuthenticationServiceTest) AuthenticateUser(user, password string) error {
+	if user == demoUser && password == demoPassword {
+		return nil
 	} else if user == "error@example.com" {
-		return "", errors.New("error")
+		return errors.New("error")
 	} else {
-		return "", service.CredentialsMismatch{}
+		return service.CredentialsMismatch{}
 	}
 }
 
+func (m *UserAuthenticationServiceTest) StartSession(user string, methods []string, acr string) (*service.Session, error) {
+	return m.sessions.Create(user, methods, acr)
+}
+
+func (m *UserAuthenticationServiceTest) EndSession(sessionId string) error {
+	return m.sessions.End(sessionId)
+}
+
+func (m *UserAuthenticationServiceTest) AddSessionClient(sessionId, clientId string) error {
+	return m.sessions.AddClient(sessionId, clientId)
+}
+
+type UsersTest struct {
+}
+
+func (u UsersTest) User(subject string) (*proxy.User, error) {
+	if subject != demoUser {
+		return nil, nil
+	}
+	return &proxy.User{Subject: subject, Email: subject, Groups: []string{"staff"}}, nil
+}
+
+type LockoutLogger struct {
+}
+
+func (l LockoutLogger) Locked(lockout throttle.Lockout) {
+	log.Printf("Locked %s %s after %d failed attempts until %s",
+		lockout.Scope, lockout.Value, lockout.Failures, lockout.LockedUntil)
+}
+
+// The only user of the demo
+const (
+	demoUser     = "user1@example.com"
+	demoPassword = "pass1"
+)
+
+// Lifetime of refresh tokens
+const refreshTokenLifetime = 30 * 24 * time.Hour
+
+const accessTokenLifetime = time.Hour
+
+// Secrets of the confidential clients, e.g. the demo client and resource servers
+// that introspect tokens
+var clientSecrets = map[string]string{
+	"client":          "client_secret",
+	"resource_server": "resource_server_secret",
+}
+
 type Oauth2ServiceTest struct {
+	issuer        string
+	users         service.UserAuthenticationService
+	tokens        *access_token.Issuer
+	refreshTokens *token.Manager
+	// Validates the JWT access tokens for introspection, tokens whose grant
+	// was revoked are not active
+	accessTokens bearer.Validator
+}
+
+// issue registers the JWT access token of the grant in the token family, so it
+// is revoked with the family, e.g. when the authorization code is replayed.
+// Resource servers that validate the token locally instead of introspecting it
+// accept a revoked token until it expires.
+func (s *Oauth2ServiceTest) issue(grant *access_token.Grant, familyId string) (*oauth2.AccessTokenResponse, error) {
+	jti, err := s.refreshTokens.RegisterJWT(&token.Token{
+		ClientId:             grant.ClientId,
+		Subject:              grant.Subject,
+		Scope:                grant.Scope,
+		Audience:             grant.Audience,
+		FamilyId:             familyId,
+		Confirmation:         grant.DPoPJKT,
+		AuthorizationDetails: grant.AuthorizationDetails,
+	}, accessTokenLifetime)
+	if err != nil {
+		return nil, err
+	}
+	grant.TokenId = jti
+	return s.tokens.Issue(grant)
+}
+
+func (s *Oauth2ServiceTest) AuthenticateClient(c *service.ClientCredentials) error {
+	secret, ok := clientSecrets[c.Id]
+	if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) != 1 {
+		return &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidClient,
+			Description: "Client authentication failed",
+		}
+	}
+	return nil
 }
 
 func (s *Oauth2ServiceTest) ValidateRequest(clientID, scope, redirectURI string) error {
 	return nil
 }
 
+func (s *Oauth2ServiceTest) ValidatePostLogoutRedirectURI(clientID, redirectURI string) error {
+	return nil
+}
+
+func (s *Oauth2ServiceTest) LogoutClient(clientId string) (*logout.Client, error) {
+	return &logout.Client{ClientId: clientId}, nil
+}
+
 func (s *Oauth2ServiceTest) Password(
 	c *service.ClientCredentials,
-	username, password string) (*oauth2.AccessTokenResponse, error) {
+	username, password string,
+	tr *service.TokenRequest) (*oauth2.AccessTokenResponse, error) {
 
-	response := oauth2.AccessTokenResponse{
-		AccessToken: "token",
-		TokenType:   "Bearer",
-		ExpiresIn:   1000,
+	if err := s.AuthenticateClient(c); err != nil {
+		return nil, err
 	}
-	return &response, nil
+	// Mismatched credentials are an invalid_grant, so the password grant
+	// counts them as failures of the account
+	if err := s.users.AuthenticateUser(username, password); err != nil {
+		if _, ok := err.(service.CredentialsMismatch); ok {
+			return nil, &oauth2.ErrorResponse{
+				ErrorCode:   oauth2.ErrorInvalidGrant,
+				Description: "Username or password is invalid",
+			}
+		}
+		return nil, err
+	}
+	return s.issue(access_token.TokenGrant(c.Id, username, "", tr), "")
 }
 
-func (s *Oauth2ServiceTest) Code(
-	clientId string, redirectURI *url.URL, scope, state string) (*oauth2.AuthorizationResponse, error) {
-
+func (s *Oauth2ServiceTest) Code(r *service.AuthorizationRequest) (*oauth2.AuthorizationResponse, error) {
 	response := oauth2.AuthorizationResponse{
 		Code:  "code",
-		State: state,
+		State: r.State,
 	}
 	return &response, nil
 }
 
+func (s *Oauth2ServiceTest) Token(r *service.AuthorizationRequest) (*oauth2.AccessTokenResponse, error) {
+	return s.issue(access_token.AuthorizationGrant(r), "")
+}
+
+func (s *Oauth2ServiceTest) IDTokenClaims(r *service.AuthorizationRequest) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"email": r.Session.Subject,
+	}, nil
+}
+
 func (s *Oauth2ServiceTest) AuthorizationCode(
-	c *service.ClientCredentials, code string, redirectURI *url.URL) (*oauth2.AccessTokenResponse, error) {
+	c *service.ClientCredentials,
+	code string,
+	redirectURI *url.URL,
+	tr *service.TokenRequest) (*oauth2.AccessTokenResponse, error) {
 
-	response := oauth2.AccessTokenResponse{
-		AccessToken: "token",
-		TokenType:   "Bearer",
-		ExpiresIn:   1000,
+	if err := s.AuthenticateClient(c); err != nil {
+		return nil, err
 	}
-	return &response, nil
+	// Codes are verified by the built-in code store. The access token is
+	// restricted to the requested resources and authorization details the code
+	// was granted for.
+	restricted, err := restrictTokenRequest(tr, tr.Code.Resources, tr.Code.AuthorizationDetails)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.issue(access_token.TokenGrant(c.Id, tr.Code.Subject, tr.Code.Scope, restricted), tr.Code.FamilyId)
+	if err != nil {
+		return nil, err
+	}
+	response.RefreshToken, err = s.refreshTokens.Issue(&token.Token{
+		Type:                 token.TypeRefreshToken,
+		ClientId:             c.Id,
+		Subject:              tr.Code.Subject,
+		Scope:                tr.Code.Scope,
+		Audience:             tr.Code.Resources,
+		FamilyId:             tr.Code.FamilyId,
+		Confirmation:         tr.DPoPJKT,
+		AuthorizationDetails: tr.Code.AuthorizationDetails,
+	}, refreshTokenLifetime)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (s *Oauth2ServiceTest) RefreshToken(
+	c *service.ClientCredentials,
+	refreshToken, scope string,
+	tr *service.TokenRequest) (*oauth2.AccessTokenResponse, error) {
+
+	if err := s.AuthenticateClient(c); err != nil {
+		return nil, err
+	}
+	grant, err := s.refreshTokens.Refresh(refreshToken, c.Id, tr.DPoPJKT)
+	if err != nil {
+		return nil, err
+	}
+	if scope == "" {
+		scope = grant.Scope
+	}
+	granted := oauth2.ParseScope(grant.Scope)
+	for _, requested := range oauth2.ParseScope(scope) {
+		if !slices.Contains(granted, requested) {
+			return nil, &oauth2.ErrorResponse{
+				ErrorCode:   oauth2.ErrorInvalidScope,
+				Description: "Requested scope exceeds the scope of the refresh token",
+			}
+		}
+	}
+	restricted, err := restrictTokenRequest(tr, grant.Audience, grant.AuthorizationDetails)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(access_token.TokenGrant(c.Id, grant.Subject, scope, restricted), grant.FamilyId)
+}
+
+// restrictTokenRequest returns a copy of the token request for a code or refresh
+// token restricted to the granted resources and authorization details.
+func restrictTokenRequest(
+	tr *service.TokenRequest,
+	resources []string,
+	details []oauth2.AuthorizationDetail) (*service.TokenRequest, error) {
+
+	var err error
+	restricted := *tr
+	if restricted.Resources, err = oauth2.RestrictResources(tr.Resources, resources); err != nil {
+		return nil, err
+	}
+	if restricted.AuthorizationDetails, err = oauth2.RestrictAuthorizationDetails(tr.AuthorizationDetails, details); err != nil {
+		return nil, err
+	}
+	return &restricted, nil
+}
+
+func (s *Oauth2ServiceTest) ClientCredentials(
+	c *service.ClientCredentials,
+	scope string,
+	tr *service.TokenRequest) (*oauth2.AccessTokenResponse, error) {
+
+	if err := s.AuthenticateClient(c); err != nil {
+		return nil, err
+	}
+	return s.issue(access_token.TokenGrant(c.Id, "", scope, tr), "")
 }
 
 func (s *Oauth2ServiceTest) ScopeInfo(scope, locale string) ([]*service.ScopeInfo, error) {
@@ -84,45 +301,381 @@ func (s *Oauth2ServiceTest) ScopeInfo(scope, locale string) ([]*service.ScopeInf
 	return scopeInfo, nil
 }
 
+func (s *Oauth2ServiceTest) Introspect(
+	c *service.ClientCredentials,
+	value, tokenTypeHint string) (*oauth2.IntrospectionResponse, error) {
+
+	if strings.HasPrefix(value, token.PrefixAccessToken) || strings.HasPrefix(value, token.PrefixRefreshToken) {
+		response, err := s.refreshTokens.Introspect(value, s.issuer)
+		// Refresh tokens are only introspected by the client they were issued to
+		if err != nil || !response.Active ||
+			(strings.HasPrefix(value, token.PrefixRefreshToken) && response.Claims["client_id"] != c.Id) {
+			return &oauth2.IntrospectionResponse{}, err
+		}
+		return response, nil
+	}
+	claims, err := s.accessTokens.Validate(value)
+	if _, ok := err.(*oauth2.ErrorResponse); ok {
+		return &oauth2.IntrospectionResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &oauth2.IntrospectionResponse{Active: true, Claims: claims.Raw}, nil
+}
+
+// keks returns the GOPHERAUTH_KEK key-encryption key and GOPHERAUTH_PREVIOUS_KEK
+// if it is set. The KEK is nil if it is not configured.
+func keks() (*keyring.KEK, []*keyring.KEK) {
+	kek, err := keyring.KEKFromEnv("GOPHERAUTH_KEK")
+	if err == keyring.ErrNoKEK {
+		return nil, nil
+	} else if err != nil {
+		panic(err)
+	}
+	var previous []*keyring.KEK
+	if previousKEK, err := keyring.KEKFromEnv("GOPHERAUTH_PREVIOUS_KEK"); err == nil {
+		previous = append(previous, previousKEK)
+	} else if err != keyring.ErrNoKEK {
+		panic(err)
+	}
+	return kek, previous
+}
+
+// keyStore stores the keys encrypted in the GOPHERAUTH_KEY_DIR directory if the
+// GOPHERAUTH_KEK key-encryption key is set, otherwise keys are kept in memory.
+// Keys encrypted with GOPHERAUTH_PREVIOUS_KEK are re-wrapped with the new KEK.
+func keyStore(name string) keyring.Store {
+	kek, previous := keks()
+	if kek == nil {
+		log.Printf("GOPHERAUTH_KEK is not set, %s keys are not persisted", name)
+		return keyring.NewMemoryStore()
+	}
+	dir := os.Getenv("GOPHERAUTH_KEY_DIR")
+	if dir == "" {
+		dir = "."
+	}
+	records := keyring.NewFileStore(filepath.Join(dir, name+"_keys.json"))
+	return keyring.NewEncryptedStore(records, kek, previous...)
+}
+
+// totpStore seals the shared secrets of second factor enrollments with the KEK.
+func totpStore() totp.Store {
+	kek, previous := keks()
+	if kek == nil {
+		log.Printf("GOPHERAUTH_KEK is not set, second factor secrets are not encrypted")
+		return totp.NewMemoryStore()
+	}
+	return totp.NewEncryptedStore(totp.NewMemoryStore(), kek, previous...)
+}
+
+// newLogoutQueue persists pending back-channel logout deliveries in the
+// GOPHERAUTH_LOGOUT_QUEUE file sealed with the KEK, otherwise they are kept in
+// memory. Logout tokens are never written to disk in plaintext.
+func newLogoutQueue() logout.Queue {
+	path := os.Getenv("GOPHERAUTH_LOGOUT_QUEUE")
+	kek, previous := keks()
+	if path == "" || kek == nil {
+		log.Printf("GOPHERAUTH_LOGOUT_QUEUE or GOPHERAUTH_KEK is not set, logout deliveries are not persisted")
+		return logout.NewMemoryQueue()
+	}
+	queue, err := logout.NewEncryptedFileQueue(path, kek, previous...)
+	if err != nil {
+		panic(err)
+	}
+	return queue
+}
+
+// newOauth2Service returns the demo service. Access tokens are JWTs signed with
+// the signing keys, refresh tokens are opaque tokens of the manager. Users of
+// the password grant are authenticated with the user authentication service.
+func newOauth2Service(
+	issuer string,
+	signingKeys *keyring.Keyring,
+	refreshTokens *token.Manager,
+	users service.UserAuthenticationService) (*Oauth2ServiceTest, error) {
+
+	accessTokens, err := access_token.NewIssuer(issuer, signingKeys, accessTokenLifetime, []string{issuer}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Oauth2ServiceTest{
+		issuer:        issuer,
+		users:         users,
+		tokens:        accessTokens,
+		refreshTokens: refreshTokens,
+		accessTokens: bearer.NewRevocableValidator(
+			bearer.NewJWTValidator(issuer, signingKeys),
+			func(claims *bearer.Claims) (bool, error) {
+				return refreshTokens.ActiveJWT(claims.TokenId)
+			}),
+	}, nil
+}
+
+// throttlePolicies limit the failed attempts of the login and the password
+// grant.
+func throttlePolicies() map[string]throttle.Policy {
+	return map[string]throttle.Policy{
+		throttle.ScopeAccount: throttle.Policy{
+			FreeFailures:    3,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			LockoutFailures: 10,
+			LockoutDuration: 15 * time.Minute,
+			ResetAfter:      time.Hour,
+		},
+		throttle.ScopeIP: throttle.Policy{
+			FreeFailures: 20,
+			BaseDelay:    time.Second,
+			MaxDelay:     time.Minute,
+			ResetAfter:   time.Hour,
+		},
+		throttle.ScopeClient: throttle.Policy{
+			FreeFailures: 100,
+			BaseDelay:    100 * time.Millisecond,
+			MaxDelay:     10 * time.Second,
+			ResetAfter:   10 * time.Minute,
+		},
+		// The user must start the login again after repeated failures of
+		// any of its steps
+		throttle.ScopeTransaction: throttle.Policy{
+			LockoutFailures: 5,
+			LockoutDuration: login.TransactionLifetime,
+		},
+	}
+}
+
+// newGrantTypes returns the grant types of the token endpoint.
+func newGrantTypes(
+	oauth2Service service.Oauth2Service,
+	codes *token.Codes,
+	throttler *throttle.Throttler,
+	idTokenIssuer grant_type.IDTokenIssuer) map[string]endpoint.GrantType {
+
+	grantTypeHandlers := map[string]endpoint.GrantType{}
+	passwordHandler := grant_type.NewPasswordController(oauth2Service, throttler)
+	grantTypeHandlers[oauth2.GrantTypePassword] = passwordHandler
+	authCodeHandler := grant_type.NewAuthorizationCodeController(oauth2Service, codes, idTokenIssuer)
+	grantTypeHandlers[oauth2.GrantTypeAuthorizationCode] = authCodeHandler
+	refreshTokenHandler := grant_type.NewRefreshTokenController(oauth2Service)
+	grantTypeHandlers[oauth2.GrantTypeRefreshToken] = refreshTokenHandler
+	clientCredentialsHandler := grant_type.NewClientCredentialsController(oauth2Service)
+	grantTypeHandlers[oauth2.GrantTypeClientCredentials] = clientCredentialsHandler
+	return grantTypeHandlers
+}
+
 func main() {
-	serverKey := []byte("server_key")
+	issuer := "http://localhost:3000"
+	// Server keys sign approvals, CSRF tokens and cookies, they must be accepted
+	// for longer than the lifetime of the session cookie
+	serverKeys, err := keyring.NewKeyring(keyStore("server"), keyring.UsageSecret, keyring.Schedule{
+		Algorithm:   jose.AlgorithmHS256,
+		RotateAfter: 24 * time.Hour,
+		RetainFor:   24 * time.Hour,
+	})
+	if err != nil {
+		panic(err)
+	}
+	go serverKeys.Run(time.Hour, nil)
+	// Signing keys sign tokens and responses, retired keys are published for
+	// longer than the lifetime of access tokens
+	signingKeys, err := keyring.NewKeyring(keyStore("signing"), keyring.UsageSigning, keyring.Schedule{
+		Algorithm:   jose.AlgorithmES256,
+		RotateAfter: 30 * 24 * time.Hour,
+		RetainFor:   2 * time.Hour,
+	})
+	if err != nil {
+		panic(err)
+	}
+	go signingKeys.Run(time.Hour, nil)
 	tokenGenerator := service.NewCryptoTokenGenerator()
 
+	sessionStore, err := session.NewMemoryStore(nil)
+	if err != nil {
+		panic(err)
+	}
 	userAuthService := &UserAuthenticationServiceTest{
-		sessionMap: make(map[string]string),
+		sessions: session.NewManager(sessionStore, tokenGenerator, 12*time.Hour, 30*time.Minute),
 	}
 
 	loginUrl, _ := url.Parse("/login")
 
-	oauth2Service := &Oauth2ServiceTest{}
+	// Browsers accept secure cookies from http://localhost
+	cookies := &util.CookiePolicy{
+		Secure:     true,
+		SameSite:   http.SameSiteLaxMode,
+		HostPrefix: true,
+		Lifetime: map[string]time.Duration{
+			util.CookieSession: 12 * time.Hour,
+			login.CookieNonce:  login.TransactionLifetime,
+		},
+		Keys: serverKeys,
+	}
+	if err := cookies.Validate(); err != nil {
+		panic(err)
+	}
 
 	templateFactory := util.NewTemplateFactory("templates")
 
-	grantTypeHandlers := map[string]endpoint.GrantType{}
-	passwordHandler := grant_type.NewPasswordController(oauth2Service)
-	grantTypeHandlers[oauth2.GrantTypePassword] = passwordHandler
-	authCodeHandler := grant_type.NewAuthorizationCodeController(oauth2Service)
-	grantTypeHandlers[oauth2.GrantTypeAuthorizationCode] = authCodeHandler
+	responseModes := response_mode.NewResponseModes(issuer, templateFactory, signingKeys)
+
+	tokenStore := token.NewMemoryStore()
+	refreshTokens := token.NewManager(tokenStore, tokenGenerator)
+	codes := token.NewCodes(refreshTokens, 5*time.Minute)
+	oauth2Service, err := newOauth2Service(issuer, signingKeys, refreshTokens, userAuthService)
+	if err != nil {
+		panic(err)
+	}
+
+	authorizationDetails := &endpoint.AuthorizationDetails{
+		Types: oauth2.AuthorizationDetailTypes{
+			"payment_initiation": &oauth2.AuthorizationDetailSchema{
+				Fields: map[string]oauth2.DetailField{
+					"instructedAmount":                  oauth2.DetailField{Kind: oauth2.DetailFieldObject, Required: true},
+					"creditorName":                      oauth2.DetailField{Kind: oauth2.DetailFieldString, Required: true},
+					"creditorAccount":                   oauth2.DetailField{Kind: oauth2.DetailFieldObject, Required: true},
+					"remittanceInformationUnstructured": oauth2.DetailField{Kind: oauth2.DetailFieldString},
+				},
+			},
+		},
+	}
+
+	throttler := throttle.NewThrottler(throttle.NewMemoryStore(), throttlePolicies(), LockoutLogger{})
+
+	idTokenIssuer := response_type.NewIDTokenIssuer(issuer, signingKeys, 10*time.Minute, oauth2Service)
 
-	http.Handle("/token", endpoint.NewTokenEndpointHandler(nil))
+	grantTypeHandlers := newGrantTypes(oauth2Service, codes, throttler, idTokenIssuer)
+
+	resources := oauth2.ProtectedResources{
+		"https://billing.example.com/": &oauth2.ProtectedResource{
+			Scopes: []string{"billing.read", "billing.write"},
+		},
+		"https://profile.example.com/": &oauth2.ProtectedResource{
+			Scopes: []string{"profile"},
+		},
+	}
+
+	dpopValidator := dpop.NewValidator(
+		dpop.NewMemoryReplayCache(), dpop.NewMACNonceProvider(serverKeys, 5*time.Minute))
+
+	http.Handle("/token", endpoint.NewTokenEndpointHandler(
+		grantTypeHandlers, authorizationDetails.Types, resources, dpopValidator))
 
 	responseTypeHandlers := map[string]endpoint.ResponseType{}
-	tokenHandler := response_type.NewTokenController()
+	tokenHandler := response_type.NewTokenController(oauth2Service)
 	responseTypeHandlers[oauth2.ResponseTypeToken] = tokenHandler
-	codeHandler := response_type.NewCodeController(oauth2Service)
+	codeHandler := response_type.NewCodeController(oauth2Service, codes)
 	responseTypeHandlers[oauth2.ResponseTypeCode] = codeHandler
+	for _, responseType := range []string{"id_token", "id_token token", "code id_token", "code token", "code id_token token"} {
+		responseTypeHandlers[oauth2.NormalizeResponseType(responseType)] =
+			response_type.NewHybridController(responseType, oauth2Service, idTokenIssuer, codes)
+	}
+
+	pushedRequests := endpoint.NewPushedRequests(tokenGenerator, 90*time.Second)
+	http.Handle("/par", endpoint.NewPAREndpointHandler(
+		oauth2Service, responseTypeHandlers, authorizationDetails, resources, responseModes, pushedRequests))
 
 	authEndpointController := endpoint.NewAuthEndpointHandler(
-		serverKey, loginUrl, oauth2Service, userAuthService,
+		serverKeys, cookies, loginUrl, oauth2Service, userAuthService,
 		templateFactory,
-		responseTypeHandlers)
+		responseTypeHandlers,
+		authorizationDetails,
+		resources,
+		responseModes,
+		pushedRequests)
 	http.Handle("/auth", authEndpointController)
 
-	approvalHandler := endpoint.NewApprovalEndpointHandler(serverKey, userAuthService, responseTypeHandlers)
+	approvalHandler := endpoint.NewApprovalEndpointHandler(
+		serverKeys, cookies, userAuthService, responseTypeHandlers, responseModes)
 	http.Handle("/approval", approvalHandler)
 
-	loginHandler := login.NewLoginHandler(serverKey, userAuthService, tokenGenerator, templateFactory)
+	totpManager := totp.NewManager(totpStore(), tokenGenerator, 1)
+
+	passkeys := webauthn.NewRelyingParty(
+		"localhost", "gopherauth", []string{issuer}, webauthn.NewMemoryCredentialStore(), tokenGenerator)
+
+	loginFlows := &login.Flows{Default: login.DefaultFlow(userAuthService, throttler, totpManager, passkeys)}
+	// Users that open the login page directly land on their passkeys
+	continueURLs := login.NewContinueURLs("/login/passkey", []string{issuer})
+	loginHandler := login.NewLoginHandler(
+		serverKeys, cookies, userAuthService, loginFlows, continueURLs, tokenGenerator, templateFactory)
 	http.Handle("/login", loginHandler)
 
+	totpEnrollmentHandler := login.NewTOTPEnrollmentHandler(
+		serverKeys, cookies, "gopherauth", *loginUrl, userAuthService, totpManager, templateFactory)
+	http.Handle("/login/totp", totpEnrollmentHandler)
+
+	passkeyRegistrationHandler := login.NewPasskeyRegistrationHandler(
+		serverKeys, cookies, *loginUrl, userAuthService, passkeys, templateFactory)
+	http.Handle("/login/passkey", passkeyRegistrationHandler)
+
+	logoutQueue := newLogoutQueue()
+	logoutNotifier := logout.NewNotifier(
+		issuer, signingKeys, oauth2Service, logoutQueue, tokenGenerator,
+		&http.Client{Timeout: 10 * time.Second}, 5, 30*time.Second)
+	go logoutNotifier.Run(10*time.Second, nil)
+
+	logoutHandler := logout.NewLogoutHandler(
+		serverKeys, cookies, issuer, signingKeys, userAuthService, oauth2Service,
+		logoutNotifier, templateFactory)
+	http.Handle("/logout", logoutHandler)
+
+	http.Handle("/jwks", endpoint.NewJWKSEndpointHandler(signingKeys))
+	http.Handle("/introspect", endpoint.NewIntrospectionEndpointHandler(oauth2Service))
+
+	discoveryHandler := endpoint.NewDiscoveryEndpointHandler(&endpoint.Metadata{
+		Issuer:                             issuer,
+		AuthorizationEndpoint:              issuer + "/auth",
+		TokenEndpoint:                      issuer + "/token",
+		JWKSURI:                            issuer + "/jwks",
+		EndSessionEndpoint:                 issuer + "/logout",
+		IntrospectionEndpoint:              issuer + "/introspect",
+		PushedAuthorizationRequestEndpoint: issuer + "/par",
+		ScopesSupported:                    []string{oauth2.ScopeOpenID},
+		ResponseTypesSupported: []string{
+			"code", "token", "id_token", "id_token token", "code id_token", "code token", "code id_token token"},
+		ResponseModesSupported: []string{
+			response_mode.Query, response_mode.Fragment, response_mode.FormPost, response_mode.JWT,
+			response_mode.QueryJWT, response_mode.FragmentJWT, response_mode.FormPostJWT},
+		GrantTypesSupported: []string{
+			oauth2.GrantTypeAuthorizationCode, oauth2.GrantTypeRefreshToken,
+			oauth2.GrantTypePassword, oauth2.GrantTypeClientCredentials},
+		SubjectTypesSupported:                      []string{"public"},
+		IDTokenSigningAlgValuesSupported:           []string{jose.AlgorithmES256},
+		TokenEndpointAuthMethodsSupported:          []string{"client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:              []string{oauth2.CodeChallengeMethodS256},
+		DPoPSigningAlgValuesSupported:              []string{jose.AlgorithmES256, jose.AlgorithmRS256, jose.AlgorithmEdDSA},
+		AuthorizationResponseIssParameterSupported: true,
+		BackchannelLogoutSupported:                 true,
+		BackchannelLogoutSessionSupported:          true,
+	})
+	http.Handle(endpoint.PathOpenIDConfiguration, discoveryHandler)
+	http.Handle(endpoint.PathAuthorizationServerMetadata, discoveryHandler)
+
+	// Internal apps are served under /apps/ to signed in staff. Apps should be
+	// served on a separate host, e.g. apps.example.com, so they do not share the
+	// origin of the server. The login pages are served on all hosts and users
+	// sign in on the apps host separately, session cookies are bound to the host.
+	if upstream := os.Getenv("GOPHERAUTH_PROXY_UPSTREAM"); upstream != "" {
+		upstreamURL, err := url.Parse(upstream)
+		if err != nil {
+			panic(err)
+		}
+		identityTokens, err := proxy.NewIdentityTokens(issuer, signingKeys, 5*time.Minute)
+		if err != nil {
+			panic(err)
+		}
+		routes := []*proxy.Route{
+			&proxy.Route{Prefix: "/apps/", Upstream: upstreamURL, StripPrefix: true, Groups: []string{"staff"}},
+		}
+		proxyHandler := proxy.NewProxyHandler(
+			serverKeys, cookies, *loginUrl, userAuthService, UsersTest{}, routes, identityTokens, templateFactory)
+		if host := os.Getenv("GOPHERAUTH_PROXY_HOST"); host != "" {
+			http.Handle(host+"/apps/", proxyHandler)
+		} else {
+			log.Printf("Apps are served on the host of the server, set GOPHERAUTH_PROXY_HOST to serve them separately")
+			http.Handle("/apps/", proxyHandler)
+		}
+	}
+
 	http.ListenAndServe(":3000", nil)
 }
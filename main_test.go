@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/endpoint"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/session"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/throttle"
+	"github.com/arjantop/gopherauth/token"
+)
+
+const (
+	issuer       = "https://example.com"
+	clientId     = "client"
+	clientSecret = "client_secret"
+	redirectURI  = "https://client.example.com/cb"
+)
+
+const approvedDetails = `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"45.00"}}]`
+
+type serviceDeps struct {
+	oauth2Service *Oauth2ServiceTest
+	codes         *token.Codes
+	tokenEndpoint http.Handler
+}
+
+// makeService returns the demo service behind the token endpoint with the
+// grant types of the server.
+func makeService(t *testing.T, throttler *throttle.Throttler) serviceDeps {
+	signingKeys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSigning, keyring.Schedule{
+		Algorithm: jose.AlgorithmES256,
+		RetainFor: time.Hour,
+	})
+	assert.NoError(t, err)
+	tokenGenerator := service.NewCryptoTokenGenerator()
+	refreshTokens := token.NewManager(token.NewMemoryStore(), tokenGenerator)
+	codes := token.NewCodes(refreshTokens, time.Minute)
+	sessionStore, err := session.NewMemoryStore(nil)
+	assert.NoError(t, err)
+	users := &UserAuthenticationServiceTest{
+		sessions: session.NewManager(sessionStore, tokenGenerator, time.Hour, time.Hour),
+	}
+	oauth2Service, err := newOauth2Service(issuer, signingKeys, refreshTokens, users)
+	assert.NoError(t, err)
+	detailTypes := oauth2.AuthorizationDetailTypes{
+		"payment_initiation": &oauth2.AuthorizationDetailSchema{
+			Fields: map[string]oauth2.DetailField{
+				"instructedAmount": oauth2.DetailField{Kind: oauth2.DetailFieldObject, Required: true},
+			},
+		},
+	}
+	return serviceDeps{
+		oauth2Service: oauth2Service,
+		codes:         codes,
+		tokenEndpoint: endpoint.NewTokenEndpointHandler(
+			newGrantTypes(oauth2Service, codes, throttler, nil), detailTypes, oauth2.ProtectedResources{}, nil),
+	}
+}
+
+// issueCode issues a code as if the user approved the authorization details.
+func (d serviceDeps) issueCode(t *testing.T, details string) string {
+	uri, _ := url.Parse(redirectURI)
+	approved, err := oauth2.ParseAuthorizationDetails(details)
+	assert.NoError(t, err)
+	code, err := d.codes.Issue(&service.AuthorizationRequest{
+		ClientId:             clientId,
+		RedirectURI:          uri,
+		Scope:                "payments",
+		AuthorizationDetails: approved,
+		Session:              &service.Session{Sid: "sid", Subject: demoUser, AuthTime: time.Now()},
+	})
+	assert.NoError(t, err)
+	return code
+}
+
+// requestToken sends the token request of the client and decodes the response.
+func (d serviceDeps) requestToken(t *testing.T, params url.Values) (int, map[string]interface{}) {
+	return d.requestTokenWith(t, clientSecret, params)
+}
+
+func (d serviceDeps) requestTokenWith(t *testing.T, secret string, params url.Values) (int, map[string]interface{}) {
+	request := testutil.NewEndpointRequest(t, "POST", "token", params)
+	request.SetBasicAuth(clientId, secret)
+	recorder := httptest.NewRecorder()
+	d.tokenEndpoint.ServeHTTP(recorder, request)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	return recorder.Code, response
+}
+
+func TestCodeGrantIsRestrictedToApprovedAuthorizationDetails(t *testing.T) {
+	deps := makeService(t, nil)
+
+	code, response := deps.requestToken(t, url.Values{
+		oauth2.ParameterGrantType:            {oauth2.GrantTypeAuthorizationCode},
+		oauth2.ParameterCode:                 {deps.issueCode(t, approvedDetails)},
+		oauth2.ParameterRedirectUri:          {redirectURI},
+		oauth2.ParameterAuthorizationDetails: {`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"4500.00"}}]`},
+	})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, response["error"])
+
+	// Approved details are used if the client does not send any
+	code, response = deps.requestToken(t, url.Values{
+		oauth2.ParameterGrantType:   {oauth2.GrantTypeAuthorizationCode},
+		oauth2.ParameterCode:        {deps.issueCode(t, approvedDetails)},
+		oauth2.ParameterRedirectUri: {redirectURI},
+	})
+	assert.Equal(t, http.StatusOK, code)
+	var approved interface{}
+	assert.NoError(t, json.Unmarshal([]byte(approvedDetails), &approved))
+	assert.Equal(t, approved, response["authorization_details"])
+
+	refreshToken, _ := response["refresh_token"].(string)
+	introspection, err := deps.oauth2Service.Introspect(
+		&service.ClientCredentials{Id: clientId, Secret: clientSecret}, refreshToken, "")
+	assert.NoError(t, err)
+	assert.True(t, introspection.Active)
+	encoded, err := json.Marshal(introspection.Claims["authorization_details"])
+	assert.NoError(t, err)
+	assert.JSONEq(t, approvedDetails, string(encoded))
+
+	code, response = deps.requestToken(t, url.Values{
+		oauth2.ParameterGrantType:            {oauth2.GrantTypeRefreshToken},
+		oauth2.ParameterRefreshToken:         {refreshToken},
+		oauth2.ParameterAuthorizationDetails: {`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"4500.00"}}]`},
+	})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, response["error"])
+}
+
+func passwordGrant(username, password string) url.Values {
+	return url.Values{
+		oauth2.ParameterGrantType: {oauth2.GrantTypePassword},
+		oauth2.ParameterUsername:  {username},
+		oauth2.ParameterPassword:  {password},
+	}
+}
+
+func TestPasswordGrantAuthenticatesClientAndUser(t *testing.T) {
+	deps := makeService(t, nil)
+
+	code, response := deps.requestToken(t, passwordGrant(demoUser, "wrong"))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, oauth2.ErrorInvalidGrant, response["error"])
+
+	code, response = deps.requestToken(t, passwordGrant("unknown@example.com", demoPassword))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, oauth2.ErrorInvalidGrant, response["error"])
+
+	code, response = deps.requestTokenWith(t, "wrong", passwordGrant(demoUser, demoPassword))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, oauth2.ErrorInvalidClient, response["error"])
+
+	code, response = deps.requestToken(t, passwordGrant(demoUser, demoPassword))
+	assert.Equal(t, http.StatusOK, code)
+	accessToken, _ := response["access_token"].(string)
+	introspection, err := deps.oauth2Service.Introspect(
+		&service.ClientCredentials{Id: "resource_server", Secret: "resource_server_secret"}, accessToken, "")
+	assert.NoError(t, err)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, demoUser, introspection.Claims["sub"])
+}
+
+func TestCodeAndRefreshGrantsAuthenticateClient(t *testing.T) {
+	deps := makeService(t, nil)
+
+	code, response := deps.requestTokenWith(t, "wrong", url.Values{
+		oauth2.ParameterGrantType:   {oauth2.GrantTypeAuthorizationCode},
+		oauth2.ParameterCode:        {deps.issueCode(t, "")},
+		oauth2.ParameterRedirectUri: {redirectURI},
+	})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, oauth2.ErrorInvalidClient, response["error"])
+
+	code, response = deps.requestToken(t, url.Values{
+		oauth2.ParameterGrantType:   {oauth2.GrantTypeAuthorizationCode},
+		oauth2.ParameterCode:        {deps.issueCode(t, "")},
+		oauth2.ParameterRedirectUri: {redirectURI},
+	})
+	assert.Equal(t, http.StatusOK, code)
+	refreshToken, _ := response["refresh_token"].(string)
+
+	code, response = deps.requestTokenWith(t, "wrong", url.Values{
+		oauth2.ParameterGrantType:    {oauth2.GrantTypeRefreshToken},
+		oauth2.ParameterRefreshToken: {refreshToken},
+	})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, oauth2.ErrorInvalidClient, response["error"])
+}
+
+// lockouts records the lockouts of the throttler.
+type lockouts []throttle.Lockout
+
+func (l *lockouts) Locked(lockout throttle.Lockout) {
+	*l = append(*l, lockout)
+}
+
+func TestPasswordGrantLocksAccountAfterConfiguredFailures(t *testing.T) {
+	policies := throttlePolicies()
+	// Failures before the lockout are not delayed, so the test does not wait
+	account := policies[throttle.ScopeAccount]
+	account.BaseDelay = 0
+	policies[throttle.ScopeAccount] = account
+	locked := &lockouts{}
+	deps := makeService(t, throttle.NewThrottler(throttle.NewMemoryStore(), policies, locked))
+
+	for i := 0; i < account.LockoutFailures; i++ {
+		code, response := deps.requestToken(t, passwordGrant(demoUser, "wrong"))
+		assert.Equal(t, http.StatusBadRequest, code, "Attempt %d", i)
+		assert.Equal(t, oauth2.ErrorInvalidGrant, response["error"], "Attempt %d", i)
+	}
+	if assert.Len(t, *locked, 1) {
+		assert.Equal(t, throttle.ScopeAccount, (*locked)[0].Scope)
+		assert.Equal(t, demoUser, (*locked)[0].Value)
+		assert.Equal(t, account.LockoutFailures, (*locked)[0].Failures)
+	}
+
+	// The password is not checked while the account is locked
+	code, _ := deps.requestToken(t, passwordGrant(demoUser, demoPassword))
+	assert.Equal(t, http.StatusTooManyRequests, code)
+}
@@ -0,0 +1,189 @@
+package access_token
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+)
+
+// Type of the JWT access tokens defined in RFC 9068
+const TokenType = "at+jwt"
+
+// Grant describes the access an access token is issued for. Backends decide
+// whether the grant is allowed and the issuer mints the token.
+type Grant struct {
+	ClientId string
+	// User the token is issued to, empty if the client acts on its own behalf
+	Subject string
+	Scope   string
+	// Resources the token is restricted to, the default audience is used if empty
+	Audience             []string
+	AuthorizationDetails []oauth2.AuthorizationDetail
+	// Thumbprint of the DPoP key the token is bound to
+	DPoPJKT string
+	// Authentication of the user, zero if it is not known
+	AuthTime time.Time
+	ACR      string
+	Methods  []string
+	// Id (jti) the token is issued with, e.g. an id it is registered by so it
+	// can be revoked. A random id is used if empty.
+	TokenId string
+}
+
+// AuthorizationGrant returns the grant of a request approved by the user.
+func AuthorizationGrant(r *service.AuthorizationRequest) *Grant {
+	grant := &Grant{
+		ClientId:             r.ClientId,
+		Scope:                r.Scope,
+		Audience:             r.Resources,
+		AuthorizationDetails: r.AuthorizationDetails,
+		DPoPJKT:              r.DPoPJKT,
+	}
+	if r.Session != nil {
+		grant.Subject = r.Session.Subject
+		grant.AuthTime = r.Session.AuthTime
+		grant.ACR = r.Session.ACR
+		grant.Methods = r.Session.Methods
+	}
+	return grant
+}
+
+// TokenGrant returns the grant of a token request of the client for the user,
+// empty if the client requests a token for itself.
+func TokenGrant(clientId, subject, scope string, tr *service.TokenRequest) *Grant {
+	return &Grant{
+		ClientId:             clientId,
+		Subject:              subject,
+		Scope:                scope,
+		Audience:             tr.Resources,
+		AuthorizationDetails: tr.AuthorizationDetails,
+		DPoPJKT:              tr.DPoPJKT,
+	}
+}
+
+// ClaimsFunc returns additional claims of the access token for the grant, such
+// as roles of the user. Nil map adds no claims.
+type ClaimsFunc func(grant *Grant) (map[string]interface{}, error)
+
+// Issuer mints JWT access tokens as profiled by RFC 9068.
+type Issuer struct {
+	issuer   string
+	signer   jose.Signer
+	lifetime time.Duration
+	audience []string
+	claims   ClaimsFunc
+}
+
+// NewIssuer returns an issuer that signs access tokens with signer, that must
+// use RS256, ES256 or EdDSA, so resource servers can verify them with the
+// published keys. Audience is used for grants that are not restricted to
+// resources. Claims adds claims to every token, nil adds none.
+func NewIssuer(
+	issuer string,
+	signer jose.Signer,
+	lifetime time.Duration,
+	audience []string,
+	claims ClaimsFunc) (*Issuer, error) {
+
+	switch signer.Algorithm() {
+	case jose.AlgorithmRS256, jose.AlgorithmES256, jose.AlgorithmEdDSA:
+	default:
+		return nil, errors.New("Access tokens can not be signed with " + signer.Algorithm())
+	}
+	return &Issuer{
+		issuer:   issuer,
+		signer:   signer,
+		lifetime: lifetime,
+		audience: audience,
+		claims:   claims,
+	}, nil
+}
+
+// Issue returns the token response with a signed access token for the grant.
+// Protocol claims can not be changed by the claims function.
+func (i *Issuer) Issue(grant *Grant) (*oauth2.AccessTokenResponse, error) {
+	audience := grant.Audience
+	if len(audience) == 0 {
+		audience = i.audience
+	}
+	if len(audience) == 0 {
+		return nil, errors.New("Access token has no audience")
+	}
+	claims := make(map[string]interface{})
+	if i.claims != nil {
+		extra, err := i.claims(grant)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range extra {
+			claims[name] = value
+		}
+	}
+	jti := grant.TokenId
+	if jti == "" {
+		var err error
+		if jti, err = newTokenId(); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	claims["iss"] = i.issuer
+	// Tokens the client requests for itself have the client as the subject
+	if grant.Subject != "" {
+		claims["sub"] = grant.Subject
+	} else {
+		claims["sub"] = grant.ClientId
+	}
+	if len(audience) == 1 {
+		claims["aud"] = audience[0]
+	} else {
+		claims["aud"] = audience
+	}
+	claims["client_id"] = grant.ClientId
+	claims["jti"] = jti
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(i.lifetime).Unix()
+	if grant.Scope != "" {
+		claims["scope"] = grant.Scope
+	}
+	if !grant.AuthTime.IsZero() {
+		claims["auth_time"] = grant.AuthTime.Unix()
+	}
+	if grant.ACR != "" {
+		claims["acr"] = grant.ACR
+	}
+	if len(grant.Methods) > 0 {
+		claims["amr"] = grant.Methods
+	}
+	if len(grant.AuthorizationDetails) > 0 {
+		claims["authorization_details"] = grant.AuthorizationDetails
+	}
+	tokenType := oauth2.TokenTypeBearer
+	if grant.DPoPJKT != "" {
+		claims["cnf"] = map[string]string{"jkt": grant.DPoPJKT}
+		tokenType = oauth2.TokenTypeDPoP
+	}
+	signed, err := jose.SignClaims(i.signer, TokenType, claims)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.AccessTokenResponse{
+		AccessToken:          signed,
+		TokenType:            tokenType,
+		ExpiresIn:            uint(i.lifetime / time.Second),
+		AuthorizationDetails: grant.AuthorizationDetails,
+	}, nil
+}
+
+func newTokenId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
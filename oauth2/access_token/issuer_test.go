@@ -0,0 +1,201 @@
+package access_token_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/access_token"
+	"github.com/arjantop/gopherauth/service"
+)
+
+type accessTokenClaims struct {
+	Iss      string                       `json:"iss"`
+	Sub      string                       `json:"sub"`
+	Aud      interface{}                  `json:"aud"`
+	ClientId string                       `json:"client_id"`
+	Scope    string                       `json:"scope"`
+	Jti      string                       `json:"jti"`
+	Iat      int64                        `json:"iat"`
+	Exp      int64                        `json:"exp"`
+	AuthTime int64                        `json:"auth_time"`
+	ACR      string                       `json:"acr"`
+	AMR      []string                     `json:"amr"`
+	Cnf      map[string]string            `json:"cnf"`
+	Role     string                       `json:"role"`
+	Details  []oauth2.AuthorizationDetail `json:"authorization_details"`
+}
+
+func makeIssuer(t *testing.T, claims access_token.ClaimsFunc) (*access_token.Issuer, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	signer, err := jose.NewSigner(jose.AlgorithmES256, key, "kid1")
+	assert.Nil(t, err)
+	issuer, err := access_token.NewIssuer(
+		"https://issuer.example.com", signer, time.Hour, []string{"https://api.example.com/"}, claims)
+	assert.Nil(t, err)
+	return issuer, key
+}
+
+func parseAccessToken(t *testing.T, token string, key *ecdsa.PrivateKey) (*jose.JWS, *accessTokenClaims) {
+	jws, err := jose.ParseJWS(token)
+	assert.Nil(t, err)
+	assert.Nil(t, jws.Verify(&key.PublicKey))
+	claims := &accessTokenClaims{}
+	assert.Nil(t, jws.Claims(claims))
+	return jws, claims
+}
+
+func TestAccessTokenHasRequiredClaims(t *testing.T) {
+	issuer, key := makeIssuer(t, nil)
+	grant := access_token.TokenGrant("client_id", "user", "scope1 scope2", &service.TokenRequest{})
+
+	response, err := issuer.Issue(grant)
+	assert.Nil(t, err)
+	assert.Equal(t, oauth2.TokenTypeBearer, response.TokenType)
+	assert.Equal(t, uint(3600), response.ExpiresIn)
+
+	jws, claims := parseAccessToken(t, response.AccessToken, key)
+	assert.Equal(t, "at+jwt", jws.Header.Type)
+	assert.Equal(t, "kid1", jws.Header.KeyID)
+	assert.Equal(t, "https://issuer.example.com", claims.Iss)
+	assert.Equal(t, "user", claims.Sub)
+	assert.Equal(t, "https://api.example.com/", claims.Aud)
+	assert.Equal(t, "client_id", claims.ClientId)
+	assert.Equal(t, "scope1 scope2", claims.Scope)
+	assert.NotEmpty(t, claims.Jti)
+	assert.InDelta(t, time.Now().Unix(), claims.Iat, 5)
+	assert.Equal(t, claims.Iat+3600, claims.Exp)
+}
+
+func TestAccessTokensHaveUniqueIds(t *testing.T) {
+	issuer, key := makeIssuer(t, nil)
+	grant := access_token.TokenGrant("client_id", "user", "", &service.TokenRequest{})
+
+	first, err := issuer.Issue(grant)
+	assert.Nil(t, err)
+	second, err := issuer.Issue(grant)
+	assert.Nil(t, err)
+
+	_, firstClaims := parseAccessToken(t, first.AccessToken, key)
+	_, secondClaims := parseAccessToken(t, second.AccessToken, key)
+	assert.NotEqual(t, firstClaims.Jti, secondClaims.Jti)
+}
+
+func TestAccessTokenIsIssuedWithIdOfGrant(t *testing.T) {
+	issuer, key := makeIssuer(t, nil)
+	grant := access_token.TokenGrant("client_id", "user", "", &service.TokenRequest{})
+	grant.TokenId = "registered"
+
+	response, err := issuer.Issue(grant)
+	assert.Nil(t, err)
+
+	_, claims := parseAccessToken(t, response.AccessToken, key)
+	assert.Equal(t, "registered", claims.Jti)
+}
+
+func TestClientTokenHasClientAsSubject(t *testing.T) {
+	issuer, key := makeIssuer(t, nil)
+
+	response, err := issuer.Issue(access_token.TokenGrant("client_id", "", "", &service.TokenRequest{}))
+	assert.Nil(t, err)
+
+	_, claims := parseAccessToken(t, response.AccessToken, key)
+	assert.Equal(t, "client_id", claims.Sub)
+}
+
+func TestAccessTokenIsRestrictedToResourcesAndBoundToDPoPKey(t *testing.T) {
+	issuer, key := makeIssuer(t, nil)
+	details := []oauth2.AuthorizationDetail{{"type": "payment_initiation"}}
+	grant := access_token.TokenGrant("client_id", "user", "", &service.TokenRequest{
+		Resources:            []string{"https://billing.example.com/", "https://profile.example.com/"},
+		AuthorizationDetails: details,
+		DPoPJKT:              "thumbprint",
+	})
+
+	response, err := issuer.Issue(grant)
+	assert.Nil(t, err)
+	assert.Equal(t, oauth2.TokenTypeDPoP, response.TokenType)
+	assert.Equal(t, details, response.AuthorizationDetails)
+
+	_, claims := parseAccessToken(t, response.AccessToken, key)
+	assert.Equal(t, []interface{}{"https://billing.example.com/", "https://profile.example.com/"}, claims.Aud)
+	assert.Equal(t, map[string]string{"jkt": "thumbprint"}, claims.Cnf)
+	assert.Equal(t, details, claims.Details)
+}
+
+func TestAuthorizationGrantHasAuthenticationOfUser(t *testing.T) {
+	issuer, key := makeIssuer(t, nil)
+	authTime := time.Now().Add(-time.Minute)
+	grant := access_token.AuthorizationGrant(&service.AuthorizationRequest{
+		ClientId: "client_id",
+		Scope:    "scope1",
+		Session: &service.Session{
+			Subject:  "user",
+			AuthTime: authTime,
+			Methods:  []string{"pwd", "otp"},
+			ACR:      "urn:example:mfa",
+		},
+	})
+
+	response, err := issuer.Issue(grant)
+	assert.Nil(t, err)
+
+	_, claims := parseAccessToken(t, response.AccessToken, key)
+	assert.Equal(t, "user", claims.Sub)
+	assert.Equal(t, authTime.Unix(), claims.AuthTime)
+	assert.Equal(t, "urn:example:mfa", claims.ACR)
+	assert.Equal(t, []string{"pwd", "otp"}, claims.AMR)
+}
+
+func TestClaimsFunctionAddsClaims(t *testing.T) {
+	issuer, key := makeIssuer(t, func(grant *access_token.Grant) (map[string]interface{}, error) {
+		return map[string]interface{}{"role": "admin:" + grant.Subject, "iss": "https://evil.example.com"}, nil
+	})
+
+	response, err := issuer.Issue(access_token.TokenGrant("client_id", "user", "", &service.TokenRequest{}))
+	assert.Nil(t, err)
+
+	_, claims := parseAccessToken(t, response.AccessToken, key)
+	assert.Equal(t, "admin:user", claims.Role)
+	assert.Equal(t, "https://issuer.example.com", claims.Iss, "Protocol claims can not be changed")
+}
+
+func TestAccessTokensAreSignedWithAsymmetricAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	for algorithm, key := range map[string]interface{}{jose.AlgorithmRS256: rsaKey, jose.AlgorithmEdDSA: edKey} {
+		signer, err := jose.NewSigner(algorithm, key, "")
+		assert.Nil(t, err)
+		issuer, err := access_token.NewIssuer("https://issuer.example.com", signer, time.Hour, []string{"api"}, nil)
+		assert.Nil(t, err)
+		response, err := issuer.Issue(access_token.TokenGrant("client_id", "", "", &service.TokenRequest{}))
+		assert.Nil(t, err)
+		jws, err := jose.ParseJWS(response.AccessToken)
+		assert.Nil(t, err)
+		assert.Equal(t, algorithm, jws.Header.Algorithm)
+	}
+
+	signer, err := jose.NewSigner(jose.AlgorithmHS256, []byte("secret"), "")
+	assert.Nil(t, err)
+	_, err = access_token.NewIssuer("https://issuer.example.com", signer, time.Hour, []string{"api"}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestAccessTokenWithoutAudienceIsNotIssued(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signer, _ := jose.NewSigner(jose.AlgorithmES256, key, "")
+	issuer, err := access_token.NewIssuer("https://issuer.example.com", signer, time.Hour, nil, nil)
+	assert.Nil(t, err)
+
+	_, err = issuer.Issue(access_token.TokenGrant("client_id", "user", "", &service.TokenRequest{}))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,207 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+)
+
+const (
+	DetailFieldString  = "string"
+	DetailFieldNumber  = "number"
+	DetailFieldBoolean = "boolean"
+	DetailFieldArray   = "array"
+	DetailFieldObject  = "object"
+)
+
+// AuthorizationDetail is a single object of the authorization_details parameter
+// as defined in RFC 9396. All members, including type, are kept as decoded JSON.
+type AuthorizationDetail map[string]interface{}
+
+// Type returns the value of the type member or an empty string if it is missing.
+func (d AuthorizationDetail) Type() string {
+	t, _ := d["type"].(string)
+	return t
+}
+
+// includes reports whether the detail grants at least the access of the other
+// detail. Both must have the same members, arrays of the other detail may omit
+// elements and all other members must be equal. A member that is left out can
+// widen the access, e.g. locations, so it is not a restriction.
+func (d AuthorizationDetail) includes(other AuthorizationDetail) bool {
+	if len(d) != len(other) {
+		return false
+	}
+	for name, value := range other {
+		granted, ok := d[name]
+		if !ok {
+			return false
+		}
+		values, isArray := value.([]interface{})
+		grantedValues, grantedIsArray := granted.([]interface{})
+		if isArray && grantedIsArray {
+			for _, v := range values {
+				if !slices.ContainsFunc(grantedValues, func(g interface{}) bool { return reflect.DeepEqual(g, v) }) {
+					return false
+				}
+			}
+		} else if !reflect.DeepEqual(granted, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// RestrictAuthorizationDetails returns the authorization details of a token
+// issued from a grant, e.g. a refresh token or an authorization code, for the
+// requested details. Every requested detail must be included in a granted one
+// (RFC 9396 section 6.1), the granted details are returned if none are requested.
+func RestrictAuthorizationDetails(requested, granted []AuthorizationDetail) ([]AuthorizationDetail, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+	for _, detail := range requested {
+		if !slices.ContainsFunc(granted, func(g AuthorizationDetail) bool { return g.includes(detail) }) {
+			return nil, NewInvalidAuthorizationDetailsError(
+				fmt.Sprintf("Authorization details of type %s were not granted", detail.Type()))
+		}
+	}
+	return requested, nil
+}
+
+// ParseAuthorizationDetails parses a JSON encoded array of authorization details.
+// An empty string is parsed as a nil list.
+func ParseAuthorizationDetails(s string) ([]AuthorizationDetail, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var details []AuthorizationDetail
+	if err := json.Unmarshal([]byte(s), &details); err != nil {
+		return nil, NewInvalidAuthorizationDetailsError("Parameter must be a JSON array of objects")
+	}
+	return details, nil
+}
+
+// EncodeAuthorizationDetails encodes authorization details into the JSON form
+// used as a request parameter value.
+func EncodeAuthorizationDetails(details []AuthorizationDetail) (string, error) {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// DetailField describes a single member of an authorization detail object.
+type DetailField struct {
+	Kind     string
+	Required bool
+}
+
+// AuthorizationDetailSchema describes the members allowed for one authorization
+// detail type. Common data fields defined in RFC 9396 section 2.2 are always allowed.
+type AuthorizationDetailSchema struct {
+	Fields map[string]DetailField
+	// Allow members that are not described by the schema
+	AllowUnknown bool
+}
+
+var commonDetailFields = map[string]DetailField{
+	"type":       DetailField{Kind: DetailFieldString, Required: true},
+	"locations":  DetailField{Kind: DetailFieldArray},
+	"actions":    DetailField{Kind: DetailFieldArray},
+	"datatypes":  DetailField{Kind: DetailFieldArray},
+	"identifier": DetailField{Kind: DetailFieldString},
+	"privileges": DetailField{Kind: DetailFieldArray},
+}
+
+// Validate checks that the detail only contains members allowed by the schema and
+// that all members have the correct kind.
+func (s *AuthorizationDetailSchema) Validate(detail AuthorizationDetail) error {
+	for name, value := range detail {
+		field, ok := s.Fields[name]
+		if !ok {
+			field, ok = commonDetailFields[name]
+		}
+		if !ok {
+			if s.AllowUnknown {
+				continue
+			}
+			return NewInvalidAuthorizationDetailsError(
+				fmt.Sprintf("Unknown field %s for type %s", name, detail.Type()))
+		}
+		if !isDetailFieldKind(value, field.Kind) {
+			return NewInvalidAuthorizationDetailsError(
+				fmt.Sprintf("Field %s of type %s must be of kind %s", name, detail.Type(), field.Kind))
+		}
+	}
+	for _, name := range sortedFieldNames(s.Fields) {
+		if _, ok := detail[name]; s.Fields[name].Required && !ok {
+			return NewInvalidAuthorizationDetailsError(
+				fmt.Sprintf("Missing required field %s for type %s", name, detail.Type()))
+		}
+	}
+	return nil
+}
+
+// AuthorizationDetailTypes is a registry of supported authorization detail types
+// mapping the type identifier to its schema.
+type AuthorizationDetailTypes map[string]*AuthorizationDetailSchema
+
+// Parse parses the parameter value and validates every detail against the
+// registered schema of its type.
+func (t AuthorizationDetailTypes) Parse(s string) ([]AuthorizationDetail, error) {
+	details, err := ParseAuthorizationDetails(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, detail := range details {
+		detailType := detail.Type()
+		if detailType == "" {
+			return nil, NewInvalidAuthorizationDetailsError("Missing required field type")
+		}
+		schema, ok := t[detailType]
+		if !ok {
+			return nil, NewInvalidAuthorizationDetailsError(
+				fmt.Sprintf("Unsupported type: %s", detailType))
+		}
+		if err := schema.Validate(detail); err != nil {
+			return nil, err
+		}
+	}
+	return details, nil
+}
+
+func NewInvalidAuthorizationDetailsError(description string) *ErrorResponse {
+	return &ErrorResponse{
+		ErrorCode:   ErrorInvalidAuthorizationDetails,
+		Description: description,
+	}
+}
+
+func isDetailFieldKind(value interface{}, kind string) bool {
+	switch value.(type) {
+	case string:
+		return kind == DetailFieldString
+	case float64:
+		return kind == DetailFieldNumber
+	case bool:
+		return kind == DetailFieldBoolean
+	case []interface{}:
+		return kind == DetailFieldArray
+	case map[string]interface{}:
+		return kind == DetailFieldObject
+	}
+	return false
+}
+
+func sortedFieldNames(fields map[string]DetailField) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
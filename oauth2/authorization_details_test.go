@@ -0,0 +1,104 @@
+package oauth2_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+func makeDetailTypes() oauth2.AuthorizationDetailTypes {
+	return oauth2.AuthorizationDetailTypes{
+		"payment_initiation": &oauth2.AuthorizationDetailSchema{
+			Fields: map[string]oauth2.DetailField{
+				"instructedAmount": oauth2.DetailField{Kind: oauth2.DetailFieldObject, Required: true},
+				"creditorName":     oauth2.DetailField{Kind: oauth2.DetailFieldString},
+			},
+		},
+	}
+}
+
+func TestEmptyAuthorizationDetailsAreParsed(t *testing.T) {
+	details, err := oauth2.ParseAuthorizationDetails("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(details))
+}
+
+func TestAuthorizationDetailsMustBeAnArray(t *testing.T) {
+	_, err := oauth2.ParseAuthorizationDetails(`{"type":"payment_initiation"}`)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, err.Error())
+}
+
+func TestValidAuthorizationDetailsAreParsed(t *testing.T) {
+	details, err := makeDetailTypes().Parse(
+		`[{"type":"payment_initiation","actions":["initiate"],"instructedAmount":{"currency":"EUR","amount":"45.00"}}]`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(details))
+	assert.Equal(t, "payment_initiation", details[0].Type())
+}
+
+func TestAuthorizationDetailsUnsupportedTypeIsRejected(t *testing.T) {
+	_, err := makeDetailTypes().Parse(`[{"type":"unknown"}]`)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, err.Error())
+}
+
+func TestAuthorizationDetailsMissingTypeIsRejected(t *testing.T) {
+	_, err := makeDetailTypes().Parse(`[{"creditorName":"Merchant A"}]`)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, err.Error())
+}
+
+func TestAuthorizationDetailsMissingRequiredFieldIsRejected(t *testing.T) {
+	_, err := makeDetailTypes().Parse(`[{"type":"payment_initiation","creditorName":"Merchant A"}]`)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, err.Error())
+}
+
+func TestAuthorizationDetailsFieldOfWrongKindIsRejected(t *testing.T) {
+	_, err := makeDetailTypes().Parse(`[{"type":"payment_initiation","instructedAmount":45}]`)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, err.Error())
+}
+
+func parseDetails(t *testing.T, s string) []oauth2.AuthorizationDetail {
+	details, err := oauth2.ParseAuthorizationDetails(s)
+	assert.Nil(t, err)
+	return details
+}
+
+func TestGrantedAuthorizationDetailsAreUsedIfNoneAreRequested(t *testing.T) {
+	granted := parseDetails(t, `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"45.00"}}]`)
+	details, err := oauth2.RestrictAuthorizationDetails(nil, granted)
+	assert.Nil(t, err)
+	assert.Equal(t, granted, details)
+}
+
+func TestRequestedAuthorizationDetailsMustBeGranted(t *testing.T) {
+	granted := parseDetails(t,
+		`[{"type":"account_information","actions":["read","list"],"locations":["https://bank.example.com/"]},`+
+			`{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"45.00"}}]`)
+
+	requested := parseDetails(t, `[{"type":"account_information","actions":["read"],"locations":["https://bank.example.com/"]}]`)
+	details, err := oauth2.RestrictAuthorizationDetails(requested, granted)
+	assert.Nil(t, err)
+	assert.Equal(t, requested, details)
+
+	for _, requested := range []string{
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"4500.00"}}]`,
+		`[{"type":"account_information","actions":["write"],"locations":["https://bank.example.com/"]}]`,
+		// Leaving out a member does not restrict the access
+		`[{"type":"account_information","actions":["read"]}]`,
+		`[{"type":"other"}]`,
+	} {
+		_, err := oauth2.RestrictAuthorizationDetails(parseDetails(t, requested), granted)
+		if assert.NotNil(t, err, requested) {
+			assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, err.Error(), requested)
+		}
+	}
+	// Grants without authorization details do not grant any
+	_, err = oauth2.RestrictAuthorizationDetails(requested, nil)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, err.Error())
+}
+
+func TestAuthorizationDetailsUnknownFieldIsRejected(t *testing.T) {
+	_, err := makeDetailTypes().Parse(`[{"type":"payment_initiation","instructedAmount":{},"extra":1}]`)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, err.Error())
+}
@@ -0,0 +1,285 @@
+// Package client is a relying party library for authorization servers such as
+// gopherauth. It uses the authorization code flow with PKCE and OpenID Connect
+// ID tokens, and optionally binds tokens to a DPoP key.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/arjantop/gopherauth/bearer"
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/dpop"
+	"github.com/arjantop/gopherauth/service"
+)
+
+// Maximum age of the cached keys of the authorization server
+const keySetMaxAge = time.Hour
+
+var (
+	ErrStateMismatch  = errors.New("client: state of the authorization response does not match")
+	ErrIssuerMismatch = errors.New("client: authorization response is not from the issuer")
+)
+
+// Client is a client registered at an authorization server.
+type Client struct {
+	// Tokens are bound to the key if it is set
+	DPoPKey     *DPoPKey
+	metadata    *Metadata
+	credentials *service.ClientCredentials
+	redirectURI string
+	httpClient  *http.Client
+	keys        jose.Verifier
+}
+
+// NewClient returns the client with the credentials, a public client has an
+// empty secret. ID tokens are verified with the keys published at the jwks_uri
+// of the metadata. Nil httpClient uses http.DefaultClient.
+func NewClient(
+	metadata *Metadata,
+	credentials *service.ClientCredentials,
+	redirectURI string,
+	httpClient *http.Client) *Client {
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		metadata:    metadata,
+		credentials: credentials,
+		redirectURI: redirectURI,
+		httpClient:  httpClient,
+		keys:        bearer.NewRemoteKeySet(metadata.JWKSURI, httpClient, keySetMaxAge),
+	}
+}
+
+// Metadata returns the metadata of the authorization server.
+func (c *Client) Metadata() *Metadata {
+	return c.metadata
+}
+
+// AuthorizationRequest is an authorization request the user is redirected to.
+// The app must keep it, e.g. in an encrypted cookie, until the callback.
+type AuthorizationRequest struct {
+	URL          string `json:"-"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// AuthorizationRequest returns a request for the scope with a fresh state, nonce
+// and PKCE code verifier. Params are added to the request, such as prompt or
+// acr_values.
+func (c *Client) AuthorizationRequest(scope string, params url.Values) (*AuthorizationRequest, error) {
+	endpoint, err := url.Parse(c.metadata.AuthorizationEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	state, errS := randomString()
+	nonce, errN := randomString()
+	verifier, errV := randomString()
+	if err := errors.Join(errS, errN, errV); err != nil {
+		return nil, err
+	}
+	query := endpoint.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	query.Set(oauth2.ParameterResponseType, oauth2.ResponseTypeCode)
+	query.Set(oauth2.ParameterClientId, c.credentials.Id)
+	query.Set(oauth2.ParameterRedirectUri, c.redirectURI)
+	if scope != "" {
+		query.Set(oauth2.ParameterScope, scope)
+	}
+	query.Set(oauth2.ParameterState, state)
+	query.Set(oauth2.ParameterNonce, nonce)
+	query.Set(oauth2.ParameterCodeChallenge, codeChallenge(verifier))
+	query.Set(oauth2.ParameterCodeChallengeMethod, oauth2.CodeChallengeMethodS256)
+	if c.DPoPKey != nil {
+		query.Set(oauth2.ParameterDPoPJKT, c.DPoPKey.Thumbprint())
+	}
+	endpoint.RawQuery = query.Encode()
+	return &AuthorizationRequest{
+		URL:          endpoint.String(),
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}, nil
+}
+
+// HandleCallback validates the authorization response the user was redirected
+// back with and exchanges the code for tokens. An error response of the
+// authorization server is returned as *oauth2.ErrorResponse.
+func (c *Client) HandleCallback(ctx context.Context, request *AuthorizationRequest, response url.Values) (*Token, error) {
+	state := response.Get(oauth2.ParameterState)
+	if request.State == "" || subtle.ConstantTimeCompare([]byte(state), []byte(request.State)) != 1 {
+		return nil, ErrStateMismatch
+	}
+	// RFC 9207 prevents mix-up attacks with multiple authorization servers
+	if issuer, ok := response[oauth2.ParameterIssuer]; ok {
+		if len(issuer) != 1 || issuer[0] != c.metadata.Issuer {
+			return nil, ErrIssuerMismatch
+		}
+	} else if c.metadata.AuthorizationResponseIssParameterSupported {
+		return nil, ErrIssuerMismatch
+	}
+	if errorCode := response.Get("error"); errorCode != "" {
+		return nil, &oauth2.ErrorResponse{
+			ErrorCode:   errorCode,
+			Description: response.Get("error_description"),
+		}
+	}
+	code := response.Get(oauth2.ParameterCode)
+	if code == "" {
+		return nil, errors.New("client: authorization response has no code")
+	}
+	token, err := c.Exchange(ctx, code, request.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	if token.IDToken != "" {
+		claims, err := c.VerifyIDToken(token.IDToken, request.Nonce, token.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		token.IDTokenClaims = claims
+	}
+	return token, nil
+}
+
+// Exchange exchanges the authorization code for tokens.
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier string) (*Token, error) {
+	form := url.Values{}
+	form.Set(oauth2.ParameterGrantType, oauth2.GrantTypeAuthorizationCode)
+	form.Set(oauth2.ParameterCode, code)
+	form.Set(oauth2.ParameterRedirectUri, c.redirectURI)
+	form.Set(oauth2.ParameterCodeVerifier, codeVerifier)
+	return c.requestToken(ctx, form)
+}
+
+// Refresh returns new tokens for the refresh token. The refresh token is kept
+// if the authorization server does not rotate it.
+func (c *Client) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	form := url.Values{}
+	form.Set(oauth2.ParameterGrantType, oauth2.GrantTypeRefreshToken)
+	form.Set(oauth2.ParameterRefreshToken, refreshToken)
+	token, err := c.requestToken(ctx, form)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return token, nil
+}
+
+// AuthorizeRequest adds the access token to a request to a resource server,
+// with a DPoP proof if the token is bound to the key of the client.
+func (c *Client) AuthorizeRequest(r *http.Request, token *Token) error {
+	if !strings.EqualFold(token.TokenType, oauth2.TokenTypeDPoP) {
+		r.Header.Set("Authorization", oauth2.TokenTypeBearer+" "+token.AccessToken)
+		return nil
+	}
+	if c.DPoPKey == nil {
+		return errors.New("client: DPoP-bound token requires a DPoP key")
+	}
+	proof, err := c.DPoPKey.Proof(r.Method, r.URL, token.AccessToken)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", oauth2.TokenTypeDPoP+" "+token.AccessToken)
+	r.Header.Set(dpop.HeaderDPoP, proof)
+	return nil
+}
+
+// requestToken sends the token request, once more with the nonce if the
+// authorization server requires a DPoP nonce.
+func (c *Client) requestToken(ctx context.Context, form url.Values) (*Token, error) {
+	token, err := c.sendTokenRequest(ctx, form)
+	var response *oauth2.ErrorResponse
+	if c.DPoPKey != nil && errors.As(err, &response) && response.ErrorCode == oauth2.ErrorUseDPoPNonce {
+		return c.sendTokenRequest(ctx, form)
+	}
+	return token, err
+}
+
+func (c *Client) sendTokenRequest(ctx context.Context, form url.Values) (*Token, error) {
+	endpoint, err := url.Parse(c.metadata.TokenEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	body := url.Values{}
+	for name, values := range form {
+		body[name] = values
+	}
+	if c.credentials.Secret == "" {
+		body.Set(oauth2.ParameterClientId, c.credentials.Id)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.String(), strings.NewReader(body.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.credentials.Secret != "" {
+		req.SetBasicAuth(c.credentials.Id, c.credentials.Secret)
+	}
+	if c.DPoPKey != nil {
+		proof, err := c.DPoPKey.Proof("POST", endpoint, "")
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(dpop.HeaderDPoP, proof)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if c.DPoPKey != nil {
+		c.DPoPKey.SetNonce(endpoint, resp.Header.Get(dpop.HeaderDPoPNonce))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResponse struct {
+			ErrorCode   string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(data, &errResponse) == nil && errResponse.ErrorCode != "" {
+			return nil, &oauth2.ErrorResponse{
+				ErrorCode:   errResponse.ErrorCode,
+				Description: errResponse.Description,
+			}
+		}
+		return nil, fmt.Errorf("client: token request failed with status %d", resp.StatusCode)
+	}
+	return parseToken(data)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
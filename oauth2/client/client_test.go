@@ -0,0 +1,450 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/client"
+	"github.com/arjantop/gopherauth/oauth2/dpop"
+	"github.com/arjantop/gopherauth/oauth2/endpoint"
+	"github.com/arjantop/gopherauth/oauth2/grant_type"
+	"github.com/arjantop/gopherauth/oauth2/response_type"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/token"
+)
+
+const redirectURI = "https://app.example.com/callback"
+
+// authorizationServer fakes the token endpoint of an authorization server that
+// issued a code for the last authorization request.
+type authorizationServer struct {
+	server   *httptest.Server
+	metadata *client.Metadata
+	keys     *keyring.Keyring
+	dpop     *dpop.Validator
+	// Parameters of the authorization request the code was issued for
+	request url.Values
+	// Claims added to the ID token, replacing the defaults
+	idTokenClaims map[string]interface{}
+	tokenRequests int
+}
+
+func newAuthorizationServer(t *testing.T) *authorizationServer {
+	keys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSigning, keyring.Schedule{
+		Algorithm: jose.AlgorithmES256,
+		RetainFor: time.Hour,
+	})
+	assert.NoError(t, err)
+	s := &authorizationServer{keys: keys, idTokenClaims: map[string]interface{}{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(s.metadata)
+	})
+	mux.Handle("/jwks", endpoint.NewJWKSEndpointHandler(keys))
+	mux.HandleFunc("/token", s.token)
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	s.metadata = &client.Metadata{
+		Issuer:                s.server.URL,
+		AuthorizationEndpoint: s.server.URL + "/auth",
+		TokenEndpoint:         s.server.URL + "/token",
+		JWKSURI:               s.server.URL + "/jwks",
+		AuthorizationResponseIssParameterSupported: true,
+	}
+	return s
+}
+
+func (s *authorizationServer) token(w http.ResponseWriter, r *http.Request) {
+	s.tokenRequests++
+	id, secret, _ := r.BasicAuth()
+	if id != "client" || secret != "secret" {
+		(&oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidClient}).WriteResponse(w, http.StatusUnauthorized)
+		return
+	}
+	tokenType := oauth2.TokenTypeBearer
+	if s.dpop != nil {
+		proof, err := s.dpop.ValidateRequest(r, "", "")
+		if err != nil && err.Error() == oauth2.ErrorUseDPoPNonce {
+			nonce, _ := s.dpop.NewNonce()
+			w.Header().Set(dpop.HeaderDPoPNonce, nonce)
+			err.(*oauth2.ErrorResponse).WriteResponse(w, http.StatusBadRequest)
+			return
+		}
+		// The proof is not bound to a token so the key thumbprint never matches
+		if proof != nil || err.Error() != oauth2.ErrorInvalidToken {
+			(&oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidDPoPProof}).WriteResponse(w, http.StatusBadRequest)
+			return
+		}
+		tokenType = oauth2.TokenTypeDPoP
+	}
+	response := map[string]interface{}{
+		"access_token": "access-token",
+		"token_type":   tokenType,
+		"expires_in":   3600,
+	}
+	switch r.PostFormValue("grant_type") {
+	case oauth2.GrantTypeAuthorizationCode:
+		if r.PostFormValue("code") != "code" ||
+			r.PostFormValue("redirect_uri") != redirectURI ||
+			!oauth2.VerifyCodeVerifier(r.PostFormValue("code_verifier"), s.request.Get("code_challenge")) {
+			(&oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidGrant}).WriteResponse(w, http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":     s.server.URL,
+			"sub":     "user",
+			"aud":     "client",
+			"iat":     time.Now().Unix(),
+			"exp":     time.Now().Add(time.Minute).Unix(),
+			"nonce":   s.request.Get("nonce"),
+			"at_hash": jose.HalfHash(jose.AlgorithmES256, "access-token"),
+			"sid":     "session",
+		}
+		for name, value := range s.idTokenClaims {
+			claims[name] = value
+		}
+		idToken, _ := jose.SignClaims(s.keys, "JWT", claims)
+		response["id_token"] = idToken
+		response["refresh_token"] = "refresh-token"
+	case oauth2.GrantTypeRefreshToken:
+		if r.PostFormValue("refresh_token") != "refresh-token" {
+			(&oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidGrant}).WriteResponse(w, http.StatusBadRequest)
+			return
+		}
+		response["access_token"] = "refreshed-token"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *authorizationServer) newClient() *client.Client {
+	return client.NewClient(
+		s.metadata, &service.ClientCredentials{Id: "client", Secret: "secret"}, redirectURI, nil)
+}
+
+// authorize starts the authorization and returns the successful response.
+func (s *authorizationServer) authorize(t *testing.T, c *client.Client) (*client.AuthorizationRequest, url.Values) {
+	request, err := c.AuthorizationRequest("openid profile", nil)
+	assert.NoError(t, err)
+	location, err := url.Parse(request.URL)
+	assert.NoError(t, err)
+	s.request = location.Query()
+	return request, url.Values{
+		"code":  {"code"},
+		"state": {request.State},
+		"iss":   {s.server.URL},
+	}
+}
+
+func TestDiscoverFetchesOpenIDConfiguration(t *testing.T) {
+	s := newAuthorizationServer(t)
+	metadata, err := client.Discover(context.Background(), nil, s.server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, s.metadata, metadata)
+}
+
+func TestDiscoverFallsBackToAuthorizationServerMetadata(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/oauth-authorization-server/tenant" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(&client.Metadata{
+			Issuer:                server.URL + "/tenant",
+			AuthorizationEndpoint: server.URL + "/tenant/auth",
+			TokenEndpoint:         server.URL + "/tenant/token",
+		})
+	}))
+	defer server.Close()
+
+	metadata, err := client.Discover(context.Background(), nil, server.URL+"/tenant")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/tenant/token", metadata.TokenEndpoint)
+}
+
+func TestDiscoverRejectsMetadataOfOtherIssuer(t *testing.T) {
+	s := newAuthorizationServer(t)
+	s.metadata.Issuer = "https://other.example.com"
+	_, err := client.Discover(context.Background(), nil, s.server.URL)
+	assert.Error(t, err)
+}
+
+func TestAuthorizationRequestHasStateNonceAndPKCE(t *testing.T) {
+	s := newAuthorizationServer(t)
+	c := s.newClient()
+	request, err := c.AuthorizationRequest("openid", url.Values{"prompt": {"login"}})
+	assert.NoError(t, err)
+
+	location, err := url.Parse(request.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "/auth", location.Path)
+	query := location.Query()
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, "client", query.Get("client_id"))
+	assert.Equal(t, redirectURI, query.Get("redirect_uri"))
+	assert.Equal(t, "openid", query.Get("scope"))
+	assert.Equal(t, "login", query.Get("prompt"))
+	assert.Equal(t, request.State, query.Get("state"))
+	assert.Equal(t, request.Nonce, query.Get("nonce"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.True(t, oauth2.VerifyCodeVerifier(request.CodeVerifier, query.Get("code_challenge")))
+	assert.Empty(t, query.Get("dpop_jkt"))
+
+	other, err := c.AuthorizationRequest("openid", nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, request.State, other.State)
+	assert.NotEqual(t, request.Nonce, other.Nonce)
+	assert.NotEqual(t, request.CodeVerifier, other.CodeVerifier)
+}
+
+func TestCallbackExchangesCodeAndVerifiesIDToken(t *testing.T) {
+	s := newAuthorizationServer(t)
+	c := s.newClient()
+	request, response := s.authorize(t, c)
+
+	token, err := c.HandleCallback(context.Background(), request, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "access-token", token.AccessToken)
+	assert.Equal(t, oauth2.TokenTypeBearer, token.TokenType)
+	assert.Equal(t, "refresh-token", token.RefreshToken)
+	assert.True(t, token.Valid())
+	if assert.NotNil(t, token.IDTokenClaims) {
+		assert.Equal(t, "user", token.IDTokenClaims.Subject)
+		assert.Equal(t, "session", token.IDTokenClaims.SessionId)
+	}
+}
+
+func TestCallbackRejectsWrongState(t *testing.T) {
+	s := newAuthorizationServer(t)
+	c := s.newClient()
+	request, response := s.authorize(t, c)
+	response.Set("state", "other")
+
+	_, err := c.HandleCallback(context.Background(), request, response)
+	assert.Equal(t, client.ErrStateMismatch, err)
+	assert.Equal(t, 0, s.tokenRequests)
+}
+
+func TestCallbackRejectsWrongOrMissingIssuer(t *testing.T) {
+	s := newAuthorizationServer(t)
+	c := s.newClient()
+	request, response := s.authorize(t, c)
+
+	response.Set("iss", "https://other.example.com")
+	_, err := c.HandleCallback(context.Background(), request, response)
+	assert.Equal(t, client.ErrIssuerMismatch, err)
+
+	response.Del("iss")
+	_, err = c.HandleCallback(context.Background(), request, response)
+	assert.Equal(t, client.ErrIssuerMismatch, err)
+	assert.Equal(t, 0, s.tokenRequests)
+}
+
+func TestCallbackReturnsErrorResponse(t *testing.T) {
+	s := newAuthorizationServer(t)
+	c := s.newClient()
+	request, response := s.authorize(t, c)
+	response.Del("code")
+	response.Set("error", oauth2.ErrorAccessDenied)
+
+	_, err := c.HandleCallback(context.Background(), request, response)
+	assert.Equal(t, &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorAccessDenied}, err)
+}
+
+func TestCallbackRejectsInvalidIDTokens(t *testing.T) {
+	modifications := []map[string]interface{}{
+		{"nonce": "other"},
+		{"aud": "other"},
+		{"aud": []string{"client", "other"}},
+		{"iss": "https://other.example.com"},
+		{"exp": time.Now().Add(-time.Hour).Unix()},
+		{"at_hash": "other"},
+	}
+	for i, claims := range modifications {
+		s := newAuthorizationServer(t)
+		s.idTokenClaims = claims
+		c := s.newClient()
+		request, response := s.authorize(t, c)
+
+		_, err := c.HandleCallback(context.Background(), request, response)
+		assert.Error(t, err, "Modification %d", i)
+	}
+}
+
+func TestExchangeReturnsTokenEndpointError(t *testing.T) {
+	s := newAuthorizationServer(t)
+	c := s.newClient()
+	s.authorize(t, c)
+
+	_, err := c.Exchange(context.Background(), "code", "wrong-verifier-wrong-verifier-wrong-verifier")
+	assert.Equal(t, &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidGrant}, err)
+}
+
+func TestRefreshKeepsRefreshTokenThatIsNotRotated(t *testing.T) {
+	s := newAuthorizationServer(t)
+	token, err := s.newClient().Refresh(context.Background(), "refresh-token")
+	assert.NoError(t, err)
+	assert.Equal(t, "refreshed-token", token.AccessToken)
+	assert.Equal(t, "refresh-token", token.RefreshToken)
+}
+
+func TestDPoPTokenRequestsAreRetriedWithNonce(t *testing.T) {
+	s := newAuthorizationServer(t)
+	s.dpop = dpop.NewValidator(
+		dpop.NewMemoryReplayCache(), dpop.NewMACNonceProvider(testutil.NewSecretKeyring("ServerKey"), time.Minute))
+	c := s.newClient()
+	key, err := client.NewDPoPKey()
+	assert.NoError(t, err)
+	c.DPoPKey = key
+	request, response := s.authorize(t, c)
+	assert.Equal(t, key.Thumbprint(), s.request.Get("dpop_jkt"))
+
+	token, err := c.HandleCallback(context.Background(), request, response)
+	assert.NoError(t, err)
+	assert.Equal(t, oauth2.TokenTypeDPoP, token.TokenType)
+	assert.Equal(t, 2, s.tokenRequests)
+
+	_, err = c.Refresh(context.Background(), "refresh-token")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, s.tokenRequests)
+}
+
+func TestAuthorizeRequestAddsProofForDPoPTokens(t *testing.T) {
+	s := newAuthorizationServer(t)
+	c := s.newClient()
+	key, err := client.NewDPoPKey()
+	assert.NoError(t, err)
+	c.DPoPKey = key
+
+	r := httptest.NewRequest("GET", "https://api.example.com/resource?id=1", nil)
+	assert.NoError(t, c.AuthorizeRequest(r, &client.Token{AccessToken: "token", TokenType: oauth2.TokenTypeDPoP}))
+	assert.Equal(t, "DPoP token", r.Header.Get("Authorization"))
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache(), nil)
+	_, err = validator.ValidateRequest(r, "token", key.Thumbprint())
+	assert.NoError(t, err)
+
+	r = httptest.NewRequest("GET", "https://api.example.com/resource", nil)
+	assert.NoError(t, c.AuthorizeRequest(r, &client.Token{AccessToken: "token", TokenType: oauth2.TokenTypeBearer}))
+	assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+	assert.Empty(t, r.Header.Get("DPoP"))
+}
+
+// gopherauthServer serves the discovery, JWKS and token endpoints of gopherauth
+// with the built-in code store. Codes are issued as if the user approved the
+// authorization request.
+type gopherauthServer struct {
+	server *httptest.Server
+	codes  *token.Codes
+}
+
+func newGopherauthServer(t *testing.T) *gopherauthServer {
+	keys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSigning, keyring.Schedule{
+		Algorithm: jose.AlgorithmES256,
+		RetainFor: time.Hour,
+	})
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	s := &gopherauthServer{
+		server: httptest.NewServer(mux),
+		codes:  token.NewCodes(token.NewManager(token.NewMemoryStore(), service.NewCryptoTokenGenerator()), time.Minute),
+	}
+	t.Cleanup(s.server.Close)
+	issuer := s.server.URL
+
+	oauth2Service := service.NewOauth2ServiceMock()
+	oauth2Service.On("AuthorizationCode",
+		&service.ClientCredentials{Id: "client", Secret: "secret"}, mock.Anything, mock.Anything, mock.Anything).
+		Return(&oauth2.AccessTokenResponse{
+			AccessToken: "access-token",
+			TokenType:   oauth2.TokenTypeBearer,
+			ExpiresIn:   3600,
+		}, nil)
+	oauth2Service.On("IDTokenClaims", mock.Anything).Return(map[string]interface{}{"email": "user@example.com"}, nil)
+	idTokens := response_type.NewIDTokenIssuer(issuer, keys, time.Minute, oauth2Service)
+
+	discovery := endpoint.NewDiscoveryEndpointHandler(&endpoint.Metadata{
+		Issuer:                           issuer,
+		AuthorizationEndpoint:            issuer + "/auth",
+		TokenEndpoint:                    issuer + "/token",
+		JWKSURI:                          issuer + "/jwks",
+		ResponseTypesSupported:           []string{oauth2.ResponseTypeCode},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{jose.AlgorithmES256},
+		AuthorizationResponseIssParameterSupported: true,
+	})
+	mux.Handle(endpoint.PathOpenIDConfiguration, discovery)
+	mux.Handle(endpoint.PathAuthorizationServerMetadata, discovery)
+	mux.Handle("/jwks", endpoint.NewJWKSEndpointHandler(keys))
+	mux.Handle("/token", endpoint.NewTokenEndpointHandler(map[string]endpoint.GrantType{
+		oauth2.GrantTypeAuthorizationCode: grant_type.NewAuthorizationCodeController(oauth2Service, s.codes, idTokens),
+	}, nil, oauth2.ProtectedResources{}, nil))
+	return s
+}
+
+// approve issues a code for the authorization request of the client.
+func (s *gopherauthServer) approve(t *testing.T, request *client.AuthorizationRequest) string {
+	location, err := url.Parse(request.URL)
+	assert.NoError(t, err)
+	query := location.Query()
+	uri, _ := url.Parse(query.Get("redirect_uri"))
+	code, err := s.codes.Issue(&service.AuthorizationRequest{
+		ClientId:            query.Get("client_id"),
+		RedirectURI:         uri,
+		Scope:               query.Get("scope"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+		Session: &service.Session{
+			Sid:      "session",
+			Subject:  "user",
+			AuthTime: time.Now().Add(-time.Minute),
+			Methods:  []string{"pwd"},
+		},
+		Nonce: query.Get("nonce"),
+	})
+	assert.NoError(t, err)
+	return code
+}
+
+func TestClientSignsInWithGopherauthEndpoints(t *testing.T) {
+	s := newGopherauthServer(t)
+	metadata, err := client.Discover(context.Background(), nil, s.server.URL)
+	assert.NoError(t, err)
+	c := client.NewClient(metadata, &service.ClientCredentials{Id: "client", Secret: "secret"}, redirectURI, nil)
+
+	request, err := c.AuthorizationRequest("openid", nil)
+	assert.NoError(t, err)
+	code := s.approve(t, request)
+	token, err := c.HandleCallback(context.Background(), request, url.Values{
+		"code":  {code},
+		"state": {request.State},
+		"iss":   {s.server.URL},
+	})
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, token) && assert.NotNil(t, token.IDTokenClaims) {
+		assert.Equal(t, "access-token", token.AccessToken)
+		assert.Equal(t, "user", token.IDTokenClaims.Subject)
+		assert.Equal(t, "session", token.IDTokenClaims.SessionId)
+		assert.Equal(t, []string{"pwd"}, token.IDTokenClaims.AMR)
+		assert.Equal(t, "user@example.com", token.IDTokenClaims.Raw["email"])
+	}
+
+	// Codes are single-use
+	_, err = c.Exchange(context.Background(), code, request.CodeVerifier)
+	if assert.IsType(t, &oauth2.ErrorResponse{}, err) {
+		assert.Equal(t, oauth2.ErrorInvalidGrant, err.(*oauth2.ErrorResponse).ErrorCode)
+	}
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2/dpop"
+)
+
+// DPoPKey is the key a client proves possession of with DPoP proofs (RFC
+// 9449). It remembers the latest nonce of every server it sends proofs to.
+type DPoPKey struct {
+	signer     jose.Signer
+	jwk        *jose.JSONWebKey
+	thumbprint string
+	mutex      sync.Mutex
+	nonces     map[string]string
+}
+
+// NewDPoPKey generates a P-256 key.
+func NewDPoPKey() (*DPoPKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewDPoPKeyFromPrivate(jose.AlgorithmES256, key)
+}
+
+// NewDPoPKeyFromPrivate returns the DPoP key of an existing private key the app
+// keeps, with the algorithm and key types of jose.NewSigner.
+func NewDPoPKeyFromPrivate(algorithm string, key crypto.Signer) (*DPoPKey, error) {
+	signer, err := jose.NewSigner(algorithm, key, "")
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := jose.NewJSONWebKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	return &DPoPKey{
+		signer:     signer,
+		jwk:        jwk,
+		thumbprint: thumbprint,
+		nonces:     make(map[string]string),
+	}, nil
+}
+
+// Thumbprint returns the JWK thumbprint tokens are bound to.
+func (k *DPoPKey) Thumbprint() string {
+	return k.thumbprint
+}
+
+// Proof returns a proof for a request with the method to the URI. If accessToken
+// is not empty the proof is bound to it.
+func (k *DPoPKey) Proof(method string, uri *url.URL, accessToken string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	claims := map[string]interface{}{
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"htm": method,
+		"htu": (&url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: uri.Path}).String(),
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		claims["ath"] = dpop.AccessTokenHash(accessToken)
+	}
+	if nonce := k.nonce(uri); nonce != "" {
+		claims["nonce"] = nonce
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return jose.Sign(k.signer, jose.Header{Type: dpop.ProofType, JWK: k.jwk}, payload)
+}
+
+// SetNonce remembers the nonce the server of the URI provided in the DPoP-Nonce
+// header, it is included in later proofs for the server.
+func (k *DPoPKey) SetNonce(uri *url.URL, nonce string) {
+	if nonce == "" {
+		return
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.nonces[origin(uri)] = nonce
+}
+
+func (k *DPoPKey) nonce(uri *url.URL) string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.nonces[origin(uri)]
+}
+
+func origin(uri *url.URL) string {
+	return uri.Scheme + "://" + uri.Host
+}
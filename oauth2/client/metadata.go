@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Maximum size of responses of the authorization server
+const maxResponseSize = 1 << 20
+
+// Metadata describes the endpoints and capabilities of an authorization server
+// as defined in RFC 8414 and OpenID Connect Discovery.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint,omitempty"`
+	// The iss parameter of RFC 9207 is required in authorization responses
+	AuthorizationResponseIssParameterSupported bool     `json:"authorization_response_iss_parameter_supported,omitempty"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported,omitempty"`
+	DPoPSigningAlgValuesSupported              []string `json:"dpop_signing_alg_values_supported,omitempty"`
+}
+
+// Discover fetches the metadata of the issuer from its OpenID Connect
+// configuration, or the RFC 8414 metadata if the former does not exist. The
+// issuer of the metadata must be the issuer it was fetched for.
+func Discover(ctx context.Context, httpClient *http.Client, issuer string) (*Metadata, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	issuerURL, err := url.Parse(issuer)
+	if err != nil || issuerURL.Scheme == "" || issuerURL.Host == "" {
+		return nil, fmt.Errorf("client: invalid issuer %q", issuer)
+	}
+	path := strings.TrimSuffix(issuerURL.Path, "/")
+	locations := []string{
+		// OpenID Connect appends the well-known path to the issuer
+		issuerURL.Scheme + "://" + issuerURL.Host + path + "/.well-known/openid-configuration",
+		// RFC 8414 inserts it between the host and the path
+		issuerURL.Scheme + "://" + issuerURL.Host + "/.well-known/oauth-authorization-server" + path,
+	}
+	for _, location := range locations {
+		metadata, err := fetchMetadata(ctx, httpClient, location)
+		if err != nil {
+			return nil, err
+		}
+		if metadata == nil {
+			continue
+		}
+		if metadata.Issuer != issuer {
+			return nil, fmt.Errorf("client: metadata issuer %q does not match %q", metadata.Issuer, issuer)
+		}
+		if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" {
+			return nil, fmt.Errorf("client: metadata of %q has no authorization or token endpoint", issuer)
+		}
+		return metadata, nil
+	}
+	return nil, fmt.Errorf("client: no metadata is published for %q", issuer)
+}
+
+// fetchMetadata returns nil if there is no metadata at the location.
+func fetchMetadata(ctx context.Context, httpClient *http.Client, location string) (*Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("client: fetching metadata failed with status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	metadata := &Metadata{}
+	if err := json.Unmarshal(body, metadata); err != nil {
+		return nil, fmt.Errorf("client: malformed metadata")
+	}
+	return metadata, nil
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// Allowed difference between the clocks of the client and the server
+const clockSkew = time.Minute
+
+// Token holds the tokens issued to the client.
+type Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+	// Zero if the lifetime of the access token is not known
+	Expiry time.Time
+	// Scope granted by the authorization server, empty if it is as requested
+	Scope   string
+	IDToken string
+	// Claims of the verified ID token, nil if there is none
+	IDTokenClaims *IDTokenClaims
+}
+
+// Valid returns true if the access token is not expired.
+func (t *Token) Valid() bool {
+	return t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Before(t.Expiry))
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token"`
+}
+
+func parseToken(data []byte) (*Token, error) {
+	var response tokenResponse
+	if err := json.Unmarshal(data, &response); err != nil || response.AccessToken == "" {
+		return nil, errors.New("client: malformed token response")
+	}
+	if !strings.EqualFold(response.TokenType, oauth2.TokenTypeBearer) &&
+		!strings.EqualFold(response.TokenType, oauth2.TokenTypeDPoP) {
+		return nil, fmt.Errorf("client: unsupported token type %q", response.TokenType)
+	}
+	token := &Token{
+		AccessToken:  response.AccessToken,
+		TokenType:    response.TokenType,
+		RefreshToken: response.RefreshToken,
+		Scope:        response.Scope,
+		IDToken:      response.IDToken,
+	}
+	if response.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// IDTokenClaims are the claims of a verified OpenID Connect ID token.
+type IDTokenClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Authentication of the user, zero or empty if it is not known
+	AuthTime  time.Time
+	ACR       string
+	AMR       []string
+	SessionId string
+	// All claims of the token, including the ones above
+	Raw map[string]interface{}
+}
+
+type idTokenClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	AZP       string          `json:"azp"`
+	IssuedAt  int64           `json:"iat"`
+	ExpiresAt int64           `json:"exp"`
+	AuthTime  int64           `json:"auth_time"`
+	Nonce     string          `json:"nonce"`
+	ACR       string          `json:"acr"`
+	AMR       []string        `json:"amr"`
+	SessionId string          `json:"sid"`
+	ATHash    string          `json:"at_hash"`
+}
+
+// VerifyIDToken verifies the signature and the claims of an ID token issued to
+// the client in response to a request with the nonce. If accessToken is not
+// empty and the token has an at_hash claim it must match the access token.
+func (c *Client) VerifyIDToken(idToken, nonce, accessToken string) (*IDTokenClaims, error) {
+	jws, err := jose.ParseJWS(idToken)
+	if err != nil {
+		return nil, errors.New("client: malformed ID token")
+	}
+	// The client has no secret keys of the authorization server
+	if jws.Header.Algorithm == jose.AlgorithmHS256 {
+		return nil, errors.New("client: ID token must be signed with an asymmetric algorithm")
+	}
+	if err := jws.Verify(c.keys); err != nil {
+		return nil, fmt.Errorf("client: ID token signature is not valid: %w", err)
+	}
+	var parsed idTokenClaims
+	raw := make(map[string]interface{})
+	if jws.Claims(&parsed) != nil || jws.Claims(&raw) != nil {
+		return nil, errors.New("client: malformed ID token claims")
+	}
+	audience, err := parseAudience(parsed.Audience)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch {
+	case parsed.Issuer != c.metadata.Issuer:
+		return nil, errors.New("client: ID token has an unknown issuer")
+	case !containsString(audience, c.credentials.Id):
+		return nil, errors.New("client: ID token is not issued to the client")
+	case len(audience) > 1 && parsed.AZP != c.credentials.Id:
+		return nil, errors.New("client: ID token is authorized for another party")
+	case parsed.ExpiresAt == 0 || !now.Before(time.Unix(parsed.ExpiresAt, 0).Add(clockSkew)):
+		return nil, errors.New("client: ID token expired")
+	case time.Unix(parsed.IssuedAt, 0).After(now.Add(clockSkew)):
+		return nil, errors.New("client: ID token is issued in the future")
+	case parsed.Nonce != nonce:
+		return nil, errors.New("client: nonce of the ID token does not match")
+	case accessToken != "" && parsed.ATHash != "" && parsed.ATHash != jose.HalfHash(jws.Header.Algorithm, accessToken):
+		return nil, errors.New("client: ID token does not match the access token")
+	}
+	claims := &IDTokenClaims{
+		Issuer:    parsed.Issuer,
+		Subject:   parsed.Subject,
+		Audience:  audience,
+		IssuedAt:  time.Unix(parsed.IssuedAt, 0),
+		ExpiresAt: time.Unix(parsed.ExpiresAt, 0),
+		ACR:       parsed.ACR,
+		AMR:       parsed.AMR,
+		SessionId: parsed.SessionId,
+		Raw:       raw,
+	}
+	if parsed.AuthTime != 0 {
+		claims.AuthTime = time.Unix(parsed.AuthTime, 0)
+	}
+	return claims, nil
+}
+
+// The audience is a single string or an array of strings.
+func parseAudience(data json.RawMessage) ([]string, error) {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		return []string{single}, nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return nil, errors.New("client: ID token has an invalid audience")
+	}
+	return multiple, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+// Package dpop implements validation of DPoP proofs as defined in RFC 9449.
+package dpop
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+const (
+	HeaderDPoP      = "DPoP"
+	HeaderDPoPNonce = "DPoP-Nonce"
+	ProofType       = "dpop+jwt"
+	DefaultWindow   = time.Minute
+)
+
+// Claims are the claims of a DPoP proof JWT.
+type Claims struct {
+	Jti   string `json:"jti"`
+	Htm   string `json:"htm"`
+	Htu   string `json:"htu"`
+	Iat   int64  `json:"iat"`
+	Ath   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// Proof is a validated DPoP proof.
+type Proof struct {
+	Claims
+	JWK *jose.JSONWebKey
+	// JWK SHA-256 thumbprint of the proof key used for the cnf.jkt confirmation
+	Thumbprint string
+}
+
+// Validator validates DPoP proofs presented to the token endpoint and to
+// resource servers.
+type Validator struct {
+	// Maximum allowed difference between iat and the current time
+	Window time.Duration
+	replay ReplayCache
+	nonces NonceProvider
+}
+
+// NewValidator returns a validator that uses the replay cache to reject reused
+// proofs. If nonces is not nil every proof must contain a valid server provided
+// nonce.
+func NewValidator(replay ReplayCache, nonces NonceProvider) *Validator {
+	return &Validator{
+		Window: DefaultWindow,
+		replay: replay,
+		nonces: nonces,
+	}
+}
+
+// Validate validates a proof for a request with the given method and URI. If
+// accessToken is not empty the proof must contain a matching ath claim.
+func (v *Validator) Validate(proof, method string, uri *url.URL, accessToken string) (*Proof, error) {
+	jws, err := jose.ParseJWS(proof)
+	if err != nil {
+		return nil, NewInvalidProofError("Malformed proof")
+	}
+	if jws.Header.Type != ProofType {
+		return nil, NewInvalidProofError("Invalid proof type")
+	}
+	if jws.Header.Algorithm == "" || jws.Header.Algorithm == jose.AlgorithmHS256 {
+		return nil, NewInvalidProofError("Proof must use an asymmetric algorithm")
+	}
+	if jws.Header.JWK == nil || jws.Header.JWK.D != "" {
+		return nil, NewInvalidProofError("Proof must contain a public JWK")
+	}
+	key, err := jws.Header.JWK.PublicKey()
+	if err != nil {
+		return nil, NewInvalidProofError("Invalid proof JWK")
+	}
+	if err := jws.Verify(key); err != nil {
+		return nil, NewInvalidProofError("Invalid proof signature")
+	}
+	var claims Claims
+	if err := jws.Claims(&claims); err != nil {
+		return nil, NewInvalidProofError("Malformed proof claims")
+	}
+	if claims.Jti == "" {
+		return nil, NewInvalidProofError("Missing jti claim")
+	}
+	if claims.Htm != method {
+		return nil, NewInvalidProofError("Proof htm does not match request method")
+	}
+	if !uriEqual(claims.Htu, uri) {
+		return nil, NewInvalidProofError("Proof htu does not match request URI")
+	}
+	issuedAt := time.Unix(claims.Iat, 0)
+	now := time.Now()
+	if issuedAt.Before(now.Add(-v.Window)) || issuedAt.After(now.Add(v.Window)) {
+		return nil, NewInvalidProofError("Proof iat is outside of the acceptable window")
+	}
+	if accessToken != "" && claims.Ath != AccessTokenHash(accessToken) {
+		return nil, NewInvalidProofError("Proof ath does not match access token")
+	}
+	if v.nonces != nil && !v.nonces.Valid(claims.Nonce) {
+		return nil, &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorUseDPoPNonce,
+			Description: "Authorization server requires nonce in DPoP proof",
+		}
+	}
+	thumbprint, err := jws.Header.JWK.Thumbprint()
+	if err != nil {
+		return nil, NewInvalidProofError("Invalid proof JWK")
+	}
+	// Only a fully valid proof is remembered so an attacker can not block a jti
+	if v.replay.Seen(thumbprint+":"+claims.Jti, issuedAt.Add(2*v.Window)) {
+		return nil, NewInvalidProofError("Proof has already been used")
+	}
+	return &Proof{
+		Claims:     claims,
+		JWK:        jws.Header.JWK,
+		Thumbprint: thumbprint,
+	}, nil
+}
+
+// ValidateRequest validates the DPoP header of a request made to a resource
+// server with an access token bound to the key with thumbprint jkt.
+func (v *Validator) ValidateRequest(r *http.Request, accessToken, jkt string) (*Proof, error) {
+	proofs := r.Header.Values(HeaderDPoP)
+	if len(proofs) != 1 {
+		return nil, NewInvalidProofError("Exactly one DPoP proof is required")
+	}
+	proof, err := v.Validate(proofs[0], r.Method, RequestURI(r), accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if proof.Thumbprint != jkt {
+		return nil, &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidToken,
+			Description: "Access token is not bound to the proof key",
+		}
+	}
+	return proof, nil
+}
+
+// NewNonce returns a fresh nonce or an empty string if nonces are not required.
+func (v *Validator) NewNonce() (string, error) {
+	if v.nonces == nil {
+		return "", nil
+	}
+	return v.nonces.NewNonce()
+}
+
+// AccessTokenHash computes the ath claim value for an access token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RequestURI returns the URI of the request without query and fragment, as
+// it is compared to the htu claim.
+func RequestURI(r *http.Request) *url.URL {
+	if r.URL.IsAbs() {
+		return &url.URL{Scheme: r.URL.Scheme, Host: r.URL.Host, Path: r.URL.Path}
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path}
+}
+
+func uriEqual(htu string, uri *url.URL) bool {
+	parsed, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Scheme, uri.Scheme) &&
+		strings.EqualFold(parsed.Host, uri.Host) &&
+		parsed.Path == uri.Path
+}
+
+func NewInvalidProofError(description string) *oauth2.ErrorResponse {
+	return &oauth2.ErrorResponse{
+		ErrorCode:   oauth2.ErrorInvalidDPoPProof,
+		Description: description,
+	}
+}
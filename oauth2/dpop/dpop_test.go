@@ -0,0 +1,151 @@
+package dpop_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/dpop"
+	"github.com/arjantop/gopherauth/testutil"
+)
+
+const tokenEndpoint = "https://example.com/token"
+
+func makeTokenEndpointURI() *url.URL {
+	uri, _ := url.Parse(tokenEndpoint)
+	return uri
+}
+
+func makeProofClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"htm": "POST",
+		"htu": tokenEndpoint,
+	}
+}
+
+func TestValidProofIsAccepted(t *testing.T) {
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache(), nil)
+	key := testutil.NewDPoPKey(t)
+
+	proof, err := validator.Validate(
+		testutil.NewDPoPProof(t, key, makeProofClaims()), "POST", makeTokenEndpointURI(), "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, testutil.DPoPThumbprint(t, key), proof.Thumbprint)
+}
+
+func TestInvalidProofClaimsAreRejected(t *testing.T) {
+	modifications := []func(map[string]interface{}){
+		func(c map[string]interface{}) { c["htm"] = "GET" },
+		func(c map[string]interface{}) { c["htu"] = "https://example.com/other" },
+		func(c map[string]interface{}) { c["iat"] = time.Now().Add(-time.Hour).Unix() },
+		func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		func(c map[string]interface{}) { c["jti"] = "" },
+	}
+	for i, modify := range modifications {
+		validator := dpop.NewValidator(dpop.NewMemoryReplayCache(), nil)
+		claims := makeProofClaims()
+		modify(claims)
+
+		_, err := validator.Validate(
+			testutil.NewDPoPProof(t, testutil.NewDPoPKey(t), claims), "POST", makeTokenEndpointURI(), "")
+
+		assert.Equal(t, oauth2.ErrorInvalidDPoPProof, err.Error(), "Modification %d", i)
+	}
+}
+
+func TestQueryIsIgnoredWhenComparingHtu(t *testing.T) {
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache(), nil)
+	uri, _ := url.Parse(tokenEndpoint + "?query=1")
+
+	_, err := validator.Validate(
+		testutil.NewDPoPProof(t, testutil.NewDPoPKey(t), makeProofClaims()), "POST", uri, "")
+
+	assert.Nil(t, err)
+}
+
+func TestReplayedProofIsRejected(t *testing.T) {
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache(), nil)
+	proof := testutil.NewDPoPProof(t, testutil.NewDPoPKey(t), makeProofClaims())
+
+	_, err := validator.Validate(proof, "POST", makeTokenEndpointURI(), "")
+	assert.Nil(t, err)
+	_, err = validator.Validate(proof, "POST", makeTokenEndpointURI(), "")
+	assert.Equal(t, oauth2.ErrorInvalidDPoPProof, err.Error())
+}
+
+func TestMissingNonceIsRejectedWhenRequired(t *testing.T) {
+	nonces := dpop.NewMACNonceProvider(testutil.NewSecretKeyring("ServerKey"), time.Minute)
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache(), nonces)
+	key := testutil.NewDPoPKey(t)
+
+	_, err := validator.Validate(
+		testutil.NewDPoPProof(t, key, makeProofClaims()), "POST", makeTokenEndpointURI(), "")
+	assert.Equal(t, oauth2.ErrorUseDPoPNonce, err.Error())
+
+	nonce, err := validator.NewNonce()
+	assert.Nil(t, err)
+	claims := makeProofClaims()
+	claims["nonce"] = nonce
+	_, err = validator.Validate(
+		testutil.NewDPoPProof(t, key, claims), "POST", makeTokenEndpointURI(), "")
+	assert.Nil(t, err)
+}
+
+func TestNonceFromDifferentKeyIsInvalid(t *testing.T) {
+	nonce, _ := dpop.NewMACNonceProvider(testutil.NewSecretKeyring("OtherKey"), time.Minute).NewNonce()
+	assert.False(t, dpop.NewMACNonceProvider(testutil.NewSecretKeyring("ServerKey"), time.Minute).Valid(nonce))
+}
+
+func TestNonceOfRetiredKeyIsValid(t *testing.T) {
+	nonce, _ := dpop.NewMACNonceProvider(testutil.NewSecretKeyring("OldKey"), time.Minute).NewNonce()
+	nonces := dpop.NewMACNonceProvider(testutil.NewSecretKeyring("ServerKey", "OldKey"), time.Minute)
+	assert.True(t, nonces.Valid(nonce))
+}
+
+func TestResourceRequestProofIsBoundToToken(t *testing.T) {
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache(), nil)
+	key := testutil.NewDPoPKey(t)
+
+	request, _ := http.NewRequest("GET", "https://api.example.com/resource?id=1", nil)
+	request.Header.Set("DPoP", testutil.NewDPoPProof(t, key, map[string]interface{}{
+		"htm": "GET",
+		"htu": "https://api.example.com/resource",
+		"ath": dpop.AccessTokenHash("token"),
+	}))
+
+	_, err := validator.ValidateRequest(request, "token", testutil.DPoPThumbprint(t, key))
+	assert.Nil(t, err)
+}
+
+func TestResourceRequestProofWithDifferentKeyIsRejected(t *testing.T) {
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache(), nil)
+
+	request, _ := http.NewRequest("GET", "https://api.example.com/resource", nil)
+	request.Header.Set("DPoP", testutil.NewDPoPProof(t, testutil.NewDPoPKey(t), map[string]interface{}{
+		"htm": "GET",
+		"htu": "https://api.example.com/resource",
+		"ath": dpop.AccessTokenHash("token"),
+	}))
+
+	_, err := validator.ValidateRequest(request, "token", testutil.DPoPThumbprint(t, testutil.NewDPoPKey(t)))
+	assert.Equal(t, oauth2.ErrorInvalidToken, err.Error())
+}
+
+func TestResourceRequestProofWithoutAccessTokenHashIsRejected(t *testing.T) {
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache(), nil)
+	key := testutil.NewDPoPKey(t)
+
+	request, _ := http.NewRequest("GET", "https://api.example.com/resource", nil)
+	request.Header.Set("DPoP", testutil.NewDPoPProof(t, key, map[string]interface{}{
+		"htm": "GET",
+		"htu": "https://api.example.com/resource",
+	}))
+
+	_, err := validator.ValidateRequest(request, "token", testutil.DPoPThumbprint(t, key))
+	assert.Equal(t, oauth2.ErrorInvalidDPoPProof, err.Error())
+}
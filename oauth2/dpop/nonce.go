@@ -0,0 +1,97 @@
+package dpop
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/keyring"
+)
+
+// ReplayCache remembers identifiers of used proofs until they expire.
+type ReplayCache interface {
+	// Seen records the identifier and reports whether it was already recorded.
+	Seen(id string, expiresAt time.Time) bool
+}
+
+// MemoryReplayCache is a ReplayCache that keeps identifiers in memory.
+type MemoryReplayCache struct {
+	seen  map[string]time.Time
+	mutex sync.Mutex
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{seen: make(map[string]time.Time)}
+}
+
+func (c *MemoryReplayCache) Seen(id string, expiresAt time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	for seenId, expiration := range c.seen {
+		if now.After(expiration) {
+			delete(c.seen, seenId)
+		}
+	}
+	if _, ok := c.seen[id]; ok {
+		return true
+	}
+	c.seen[id] = expiresAt
+	return false
+}
+
+// NonceProvider issues server provided nonces that clients must include in
+// their proofs.
+type NonceProvider interface {
+	NewNonce() (string, error)
+	Valid(nonce string) bool
+}
+
+// MACNonceProvider issues stateless nonces that contain their creation time
+// authenticated with the server keys. Nonces are issued with the active key and
+// accepted with any key of the keyring, so they follow its rotation.
+type MACNonceProvider struct {
+	serverKeys *keyring.Keyring
+	lifetime   time.Duration
+}
+
+func NewMACNonceProvider(serverKeys *keyring.Keyring, lifetime time.Duration) *MACNonceProvider {
+	return &MACNonceProvider{serverKeys: serverKeys, lifetime: lifetime}
+}
+
+func (p *MACNonceProvider) NewNonce() (string, error) {
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(time.Now().Unix()))
+	mac := nonceMAC(timestamp, p.serverKeys.Secret())
+	return base64.RawURLEncoding.EncodeToString(append(timestamp, mac...)), nil
+}
+
+func (p *MACNonceProvider) Valid(nonce string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(decoded) != 8+sha256.Size {
+		return false
+	}
+	timestamp := decoded[:8]
+	valid := false
+	for _, key := range p.serverKeys.Secrets() {
+		if hmac.Equal(decoded[8:], nonceMAC(timestamp, key)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return false
+	}
+	created := time.Unix(int64(binary.BigEndian.Uint64(timestamp)), 0)
+	return time.Now().Before(created.Add(p.lifetime))
+}
+
+func nonceMAC(timestamp, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("dpop-nonce"))
+	mac.Write(timestamp)
+	return mac.Sum(nil)
+}
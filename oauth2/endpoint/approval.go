@@ -9,8 +9,11 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/arjantop/gopherauth/keyring"
 	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/response_mode"
 	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/util"
 )
 
 const (
@@ -19,20 +22,26 @@ const (
 )
 
 type approvalEndpointHandler struct {
-	serverKey       []byte
+	serverKeys      *keyring.Keyring
+	cookies         *util.CookiePolicy
 	userAuthService service.UserAuthenticationService
 	handlers        map[string]ResponseType
+	responseModes   *response_mode.ResponseModes
 }
 
 func NewApprovalEndpointHandler(
-	serverKey []byte,
+	serverKeys *keyring.Keyring,
+	cookies *util.CookiePolicy,
 	userAuthService service.UserAuthenticationService,
-	handlers map[string]ResponseType) http.Handler {
+	handlers map[string]ResponseType,
+	responseModes *response_mode.ResponseModes) http.Handler {
 
 	return &approvalEndpointHandler{
-		serverKey:       serverKey,
+		serverKeys:      serverKeys,
+		cookies:         cookies,
 		userAuthService: userAuthService,
 		handlers:        handlers,
+		responseModes:   responseModes,
 	}
 }
 
@@ -43,25 +52,17 @@ func (h *approvalEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 	}
 	params := r.URL.Query()
 	responseType := params.Get(oauth2.ParameterResponseType)
-	if handler, ok := h.handlers[responseType]; ok {
-		notAuthenticated := false
-
-		sessionId, err := r.Cookie("sessionid")
-		if err != nil {
-			notAuthenticated = true
-			sessionId = &http.Cookie{Name: "sessionid", Value: ""}
-		} else {
-			isAuthenticated, err := h.userAuthService.IsSessionValid(sessionId.Value)
+	if handler, ok := h.handlers[oauth2.NormalizeResponseType(responseType)]; ok {
+		var session *service.Session
+		if sessionId, err := h.cookies.Get(r, util.CookieSession); err == nil {
+			session, err = h.userAuthService.Session(sessionId)
 			if err != nil {
 				w.WriteHeader(http.StatusServiceUnavailable)
 				return
 			}
-			if !isAuthenticated {
-				notAuthenticated = true
-			}
 		}
 
-		if notAuthenticated {
+		if session == nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
@@ -73,15 +74,13 @@ func (h *approvalEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 			currentTimestamp := time.Now().UnixNano()
 			if currentTimestamp <= expirationTime {
 				if mac, err := base64.StdEncoding.DecodeString(signature); err == nil {
-					key := ComputeKey(expirationTime, sessionId.Value, h.serverKey)
-					if CheckMAC(params, expirationTime, sessionId.Value, mac, key) {
-						redirectUri, err := handler.Execute(params)
-						if err != nil {
-							w.WriteHeader(http.StatusServiceUnavailable)
+					// Approvals signed before a key rotation are still accepted
+					for _, serverKey := range h.serverKeys.Secrets() {
+						key := ComputeKey(expirationTime, session.Subject, serverKey)
+						if CheckMAC(params, expirationTime, session.Subject, mac, key) {
+							h.respond(w, r, handler, session, params)
 							return
 						}
-						http.Redirect(w, r, redirectUri.String(), http.StatusFound)
-						return
 					}
 				}
 			}
@@ -90,22 +89,58 @@ func (h *approvalEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusBadRequest)
 }
 
-func ComputeMAC(params url.Values, expirationTime int64, sessionId string, key []byte) []byte {
+// respond executes the approved request and returns the authorization response
+// to the client using the requested response mode.
+func (h *approvalEndpointHandler) respond(
+	w http.ResponseWriter, r *http.Request, handler ResponseType, session *service.Session, params url.Values) {
+
+	redirectURI, err := url.Parse(params.Get(oauth2.ParameterRedirectUri))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	responseParams, err := handler.Execute(session, params)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	// Client must be notified when the user logs out
+	err = h.userAuthService.AddSessionClient(session.Id, params.Get(oauth2.ParameterClientId))
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	err = h.responseModes.Write(w, r,
+		params.Get(oauth2.ParameterResponseMode),
+		params.Get(oauth2.ParameterResponseType),
+		&response_mode.Response{
+			ClientId:    params.Get(oauth2.ParameterClientId),
+			RedirectURI: redirectURI,
+			Parameters:  responseParams,
+		})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// ComputeMAC authenticates the approved parameters for the subject of the
+// session that approves them.
+func ComputeMAC(params url.Values, expirationTime int64, subject string, key []byte) []byte {
 	paramsEncoded := params.Encode()
 	computedMac := hmac.New(sha256.New, key)
 	computedMac.Write([]byte(paramsEncoded))
 	computedMac.Write([]byte(strconv.FormatInt(expirationTime, 10)))
-	computedMac.Write([]byte(sessionId))
+	computedMac.Write([]byte(subject))
 	return computedMac.Sum(nil)
 }
 
-func CheckMAC(params url.Values, expirationTime int64, sessionId string, mac, key []byte) bool {
-	return hmac.Equal(mac, ComputeMAC(params, expirationTime, sessionId, key))
+func CheckMAC(params url.Values, expirationTime int64, subject string, mac, key []byte) bool {
+	return hmac.Equal(mac, ComputeMAC(params, expirationTime, subject, key))
 }
 
-func ComputeKey(expirationTime int64, sessionId string, key []byte) []byte {
+func ComputeKey(expirationTime int64, subject string, key []byte) []byte {
 	keyMac := hmac.New(sha256.New, key)
 	keyMac.Write([]byte(strconv.FormatInt(expirationTime, 10)))
-	keyMac.Write([]byte(sessionId))
+	keyMac.Write([]byte(subject))
 	return keyMac.Sum(nil)
 }
@@ -13,10 +13,13 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/arjantop/gopherauth/jose"
 	"github.com/arjantop/gopherauth/oauth2"
 	"github.com/arjantop/gopherauth/oauth2/endpoint"
+	"github.com/arjantop/gopherauth/oauth2/response_mode"
 	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/util"
 )
 
 type approvalDeps struct {
@@ -30,24 +33,30 @@ type approvalDeps struct {
 
 func makeApprovalEndpointHandler() approvalDeps {
 	serverKey := []byte("ServerKey")
-	type1 := NewResponseTypeMock()
+	codeType := NewResponseTypeMock()
 	type2 := NewResponseTypeMock()
 	responseTypes := map[string]*ResponseTypeMock{
-		"type1": type1,
+		"code":  codeType,
 		"type2": type2,
 	}
 	userAuthService := service.NewUserAuthenticationServiceMock()
 
 	handler := endpoint.NewApprovalEndpointHandler(
-		serverKey,
+		testutil.NewSecretKeyring(string(serverKey)),
+		&util.CookiePolicy{},
 		userAuthService,
 		map[string]endpoint.ResponseType{
-			"type1": type1,
+			"code":  codeType,
 			"type2": type2,
-		})
+		},
+		response_mode.NewResponseModes(
+			issuer, util.NewTemplateFactory(templateRoot), makeResponseSigner()))
 
 	params := url.Values{}
-	params.Add(oauth2.ParameterResponseType, "type1")
+	params.Add(oauth2.ParameterResponseType, "code")
+	params.Add(oauth2.ParameterClientId, "client_id")
+	params.Add(oauth2.ParameterRedirectUri, clientURI)
+	params.Add(oauth2.ParameterResponseMode, "query")
 	params.Add("param1", "value1")
 	params.Add("param2", "value2")
 
@@ -55,8 +64,8 @@ func makeApprovalEndpointHandler() approvalDeps {
 	expirationTime := time.Now().Add(time.Hour).UnixNano()
 	approvalParams.Add(endpoint.ApprovalParameterExpirationTime, strconv.FormatInt(expirationTime, 10))
 
-	key := endpoint.ComputeKey(expirationTime, "SessionId", serverKey)
-	mac := endpoint.ComputeMAC(params, expirationTime, "SessionId", key)
+	key := endpoint.ComputeKey(expirationTime, userSession.Subject, serverKey)
+	mac := endpoint.ComputeMAC(params, expirationTime, userSession.Subject, key)
 
 	approvalParams.Add(endpoint.ApprovalParameterSignature, base64.StdEncoding.EncodeToString(mac))
 
@@ -104,7 +113,7 @@ func TestApprovalEndpointUnauthorizedErrorIfSessionCookieIsMissing(t *testing.T)
 	deps := makeApprovalEndpointHandler()
 
 	params := url.Values{}
-	params.Add(oauth2.ParameterResponseType, "type1")
+	params.Add(oauth2.ParameterResponseType, "code")
 	request := testutil.NewEndpointPostRequest(t, "approval", params, nil)
 
 	recorder := httptest.NewRecorder()
@@ -118,12 +127,12 @@ func TestApprovalEndpointStatusServiceUnavaliableOnSessionValidationError(t *tes
 	deps := makeApprovalEndpointHandler()
 
 	params := url.Values{}
-	params.Add(oauth2.ParameterResponseType, "type1")
+	params.Add(oauth2.ParameterResponseType, "code")
 	request := testutil.NewEndpointPostRequest(t, "approval", params, nil)
 	sessionIdCookie := &http.Cookie{Name: "sessionid", Value: "InvalidId"}
 	request.AddCookie(sessionIdCookie)
 
-	deps.userAuthService.On("IsSessionValid", "InvalidId").Return(false, errors.New("error"))
+	deps.userAuthService.On("Session", "InvalidId").Return(nil, errors.New("error"))
 
 	recorder := httptest.NewRecorder()
 	deps.handler.ServeHTTP(recorder, request)
@@ -136,13 +145,13 @@ func TestApprovalEndpointUnauthorizedErrorIfSessionIdIsInvalid(t *testing.T) {
 	deps := makeApprovalEndpointHandler()
 
 	params := url.Values{}
-	params.Add(oauth2.ParameterResponseType, "type1")
+	params.Add(oauth2.ParameterResponseType, "code")
 	params.Add("param1", "value1")
 	request := testutil.NewEndpointPostRequest(t, "approval", params, nil)
 	sessionIdCookie := &http.Cookie{Name: "sessionid", Value: "InvalidId"}
 	request.AddCookie(sessionIdCookie)
 
-	deps.userAuthService.On("IsSessionValid", "InvalidId").Return(false, nil)
+	deps.userAuthService.On("Session", "InvalidId").Return(nil, nil)
 
 	recorder := httptest.NewRecorder()
 	deps.handler.ServeHTTP(recorder, request)
@@ -187,8 +196,8 @@ func TestApprovalAfterSuccessfulValidationUserIsRedirectedToRedirectUri(t *testi
 	expirationTime, err := strconv.ParseInt(expirationTimeValue, 10, 64)
 	assert.Nil(t, err)
 
-	key := endpoint.ComputeKey(expirationTime, "SessionId", deps.serverKey)
-	mac := endpoint.ComputeMAC(deps.params, expirationTime, "SessionId", key)
+	key := endpoint.ComputeKey(expirationTime, userSession.Subject, deps.serverKey)
+	mac := endpoint.ComputeMAC(deps.params, expirationTime, userSession.Subject, key)
 
 	deps.approvalParams.Set(endpoint.ApprovalParameterSignature, base64.StdEncoding.EncodeToString(mac))
 
@@ -196,33 +205,114 @@ func TestApprovalAfterSuccessfulValidationUserIsRedirectedToRedirectUri(t *testi
 	sessionIdCookie := &http.Cookie{Name: "sessionid", Value: "SessionId"}
 	request.AddCookie(sessionIdCookie)
 
-	const RedirectUri = "https://example.com/callback"
-
-	uri, err := url.Parse(RedirectUri)
-	assert.Nil(t, err)
-	deps.responseTypes["type1"].On("Execute", deps.params).Return(uri, nil)
-	deps.userAuthService.On("IsSessionValid", "SessionId").Return(true, nil)
+	response := url.Values{}
+	response.Add("code", "auth_code")
+	deps.responseTypes["code"].On("Execute", userSession, deps.params).Return(response, nil)
+	deps.userAuthService.On("AddSessionClient", userSession.Id, "client_id").Return(nil)
+	deps.userAuthService.On("Session", "SessionId").Return(userSession, nil)
 
 	recorder := httptest.NewRecorder()
 	deps.handler.ServeHTTP(recorder, request)
 
 	assert.Equal(t, http.StatusFound, recorder.Code,
 		"After successful validation user must be redirected to configured redirect uri")
-	assert.Equal(t, RedirectUri, recorder.Header().Get("Location"))
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, clientURI, (&url.URL{Scheme: location.Scheme, Host: location.Host, Path: location.Path}).String())
+	assert.Equal(t, "auth_code", location.Query().Get("code"))
+	assert.Equal(t, issuer, location.Query().Get("iss"), "Issuer must be present in the response")
+	assertApprovalEndpointExpectations(t, deps)
+}
+
+func TestApprovalResponseIsReturnedUsingFormPost(t *testing.T) {
+	deps := makeApprovalEndpointHandler()
+	deps.params.Set(oauth2.ParameterResponseMode, "form_post")
+
+	request := makeSignedApprovalRequest(t, deps)
+
+	response := url.Values{}
+	response.Add("code", "auth_code")
+	deps.responseTypes["code"].On("Execute", userSession, deps.params).Return(response, nil)
+	deps.userAuthService.On("AddSessionClient", userSession.Id, "client_id").Return(nil)
+	deps.userAuthService.On("Session", "SessionId").Return(userSession, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	testutil.AssertContentTypeHtml(t, recorder)
+	body := recorder.Body.String()
+	assert.Contains(t, body, `action="https://client.example.com/cb"`)
+	assert.Contains(t, body, `name="code" value="auth_code"`)
+	assert.Contains(t, body, `name="iss" value="https://example.com"`)
+	assertApprovalEndpointExpectations(t, deps)
+}
+
+func TestApprovalResponseIsReturnedAsSignedJWT(t *testing.T) {
+	deps := makeApprovalEndpointHandler()
+	deps.params.Set(oauth2.ParameterResponseMode, "fragment.jwt")
+
+	request := makeSignedApprovalRequest(t, deps)
+
+	response := url.Values{}
+	response.Add("code", "auth_code")
+	deps.responseTypes["code"].On("Execute", userSession, deps.params).Return(response, nil)
+	deps.userAuthService.On("AddSessionClient", userSession.Id, "client_id").Return(nil)
+	deps.userAuthService.On("Session", "SessionId").Return(userSession, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.Nil(t, err)
+	fragment, err := url.ParseQuery(location.Fragment)
+	assert.Nil(t, err)
+	assert.Empty(t, fragment.Get("code"), "Parameters must only be present in the JWT")
+
+	jws, err := jose.ParseJWS(fragment.Get("response"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, jws.Verify([]byte("ResponseKey")))
+	var claims map[string]interface{}
+	assert.Nil(t, jws.Claims(&claims))
+	assert.Equal(t, "auth_code", claims["code"])
+	assert.Equal(t, issuer, claims["iss"])
+	assert.Equal(t, "client_id", claims["aud"])
 	assertApprovalEndpointExpectations(t, deps)
 }
 
+func makeSignedApprovalRequest(t *testing.T, deps approvalDeps) *http.Request {
+	expirationTimeValue := deps.approvalParams.Get(endpoint.ApprovalParameterExpirationTime)
+	expirationTime, err := strconv.ParseInt(expirationTimeValue, 10, 64)
+	assert.Nil(t, err)
+
+	key := endpoint.ComputeKey(expirationTime, userSession.Subject, deps.serverKey)
+	mac := endpoint.ComputeMAC(deps.params, expirationTime, userSession.Subject, key)
+	deps.approvalParams.Set(endpoint.ApprovalParameterSignature, base64.StdEncoding.EncodeToString(mac))
+
+	request := testutil.NewEndpointPostRequest(t, "approval", deps.params, deps.approvalParams)
+	request.AddCookie(&http.Cookie{Name: "sessionid", Value: "SessionId"})
+	return request
+}
+
+func makeResponseSigner() jose.Signer {
+	signer, _ := jose.NewSigner(jose.AlgorithmHS256, []byte("ResponseKey"), "")
+	return signer
+}
+
 func makeBadRequestTest(t *testing.T, modify func(url.Values) url.Values) {
 	deps := makeApprovalEndpointHandler()
 
 	params := url.Values{}
-	params.Add(oauth2.ParameterResponseType, "type1")
+	params.Add(oauth2.ParameterResponseType, "code")
 
 	request := testutil.NewEndpointPostRequest(t, "approval", params, modify(deps.approvalParams))
 	sessionIdCookie := &http.Cookie{Name: "sessionid", Value: "SessionId"}
 	request.AddCookie(sessionIdCookie)
 
-	deps.userAuthService.On("IsSessionValid", "SessionId").Return(true, nil)
+	deps.userAuthService.On("Session", "SessionId").Return(userSession, nil)
 
 	recorder := httptest.NewRecorder()
 	deps.handler.ServeHTTP(recorder, request)
@@ -8,8 +8,10 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/arjantop/gopherauth/keyring"
 	"github.com/arjantop/gopherauth/oauth2"
 	"github.com/arjantop/gopherauth/oauth2/helpers"
+	"github.com/arjantop/gopherauth/oauth2/response_mode"
 	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/util"
 )
@@ -21,41 +23,68 @@ type Scope struct {
 }
 
 type ApprovalPrompt struct {
-	Scopes         []*service.ScopeInfo
-	ExpirationTime int64
-	Signature      string
-	Parameters     template.URL
+	Scopes               []*service.ScopeInfo
+	AuthorizationDetails []template.HTML
+	ExpirationTime       int64
+	Signature            string
+	Parameters           template.URL
 }
 
+// ResponseType handles a single response type. Execute returns the parameters
+// of the authorization response that are sent to the client using the requested
+// response mode. Handlers are registered under the normalized response type.
 type ResponseType interface {
 	ExtractParameters(r *http.Request) url.Values
-	Execute(params url.Values) (*url.URL, error)
+	Execute(session *service.Session, params url.Values) (url.Values, error)
+}
+
+// requestValidator validates authorization requests. Pushed requests are
+// validated when they are pushed and again at the authorization endpoint.
+type requestValidator struct {
+	oauth2Service service.Oauth2Service
+	details       *AuthorizationDetails
+	resources     oauth2.ProtectedResources
+	responseModes *response_mode.ResponseModes
 }
 
 type authEndpointHandler struct {
-	serverKey       []byte
+	requestValidator
+	serverKeys      *keyring.Keyring
+	cookies         *util.CookiePolicy
 	loginUrl        *url.URL
-	oauth2Service   service.Oauth2Service
 	userAuthService service.UserAuthenticationService
 	templateFactory *util.TemplateFactory
 	handlers        map[string]ResponseType
+	requests        *PushedRequests
 }
 
 func NewAuthEndpointHandler(
-	serverKey []byte,
+	serverKeys *keyring.Keyring,
+	cookies *util.CookiePolicy,
 	loginUrl *url.URL,
 	oauth2Service service.Oauth2Service,
 	userAuthService service.UserAuthenticationService,
 	templateFactory *util.TemplateFactory,
-	handlers map[string]ResponseType) http.Handler {
+	handlers map[string]ResponseType,
+	details *AuthorizationDetails,
+	resources oauth2.ProtectedResources,
+	responseModes *response_mode.ResponseModes,
+	requests *PushedRequests) http.Handler {
 
 	handler := &authEndpointHandler{
-		serverKey:       serverKey,
+		requestValidator: requestValidator{
+			oauth2Service: oauth2Service,
+			details:       details,
+			resources:     resources,
+			responseModes: responseModes,
+		},
+		serverKeys:      serverKeys,
+		cookies:         cookies,
 		loginUrl:        loginUrl,
-		oauth2Service:   oauth2Service,
 		userAuthService: userAuthService,
 		templateFactory: templateFactory,
 		handlers:        handlers,
+		requests:        requests,
 	}
 	noCachingMiddleware := util.NoCachingMiddleware(handler)
 	return noCachingMiddleware
@@ -66,22 +95,25 @@ func (h *authEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if r.URL.Query().Get(oauth2.ParameterRequestURI) != "" {
+		pushed, ok := h.pushedRequest(w, r)
+		if !ok {
+			return
+		}
+		r = pushed
+	}
 	query := r.URL.Query()
 	responseType := query.Get(oauth2.ParameterResponseType)
 
-	if handler, ok := h.handlers[responseType]; ok {
+	if handler, ok := h.handlers[oauth2.NormalizeResponseType(responseType)]; ok {
 		params := handler.ExtractParameters(r)
 		valid := h.validateParameters(w, params)
 		if !valid {
 			return
 		}
-
 		scope := params.Get(oauth2.ParameterScope)
 
-		err := h.oauth2Service.ValidateRequest(
-			params.Get(oauth2.ParameterClientId),
-			scope,
-			params.Get(oauth2.ParameterRedirectUri))
+		details, authRequest, err := h.validateRequest(params, responseType)
 		if err != nil {
 			if response, ok := err.(*oauth2.ErrorResponse); ok {
 				helpers.RenderError(w, h.templateFactory, response)
@@ -91,23 +123,33 @@ func (h *authEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		sessionId := h.checkUserLogin(w, r)
-		if sessionId == "" {
+		session := h.checkUserLogin(w, r, params, authRequest)
+		if session == nil {
 			return
 		}
 
 		expirationTime := time.Now().Add(expiresIn).UnixNano()
-		userKey := ComputeKey(expirationTime, sessionId, h.serverKey)
-		sig := ComputeMAC(params, expirationTime, sessionId, userKey)
+		userKey := ComputeKey(expirationTime, session.Subject, h.serverKeys.Secret())
+		sig := ComputeMAC(params, expirationTime, session.Subject, userKey)
 
 		// TODO handle error
 		scopeInfo, _ := h.oauth2Service.ScopeInfo(scope, "en")
 
+		var renderedDetails []template.HTML
+		if len(details) > 0 {
+			renderedDetails, err = h.details.Render(details, "en")
+			if err != nil {
+				util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
+				return
+			}
+		}
+
 		data := ApprovalPrompt{
-			Scopes:         scopeInfo,
-			ExpirationTime: expirationTime,
-			Signature:      base64.StdEncoding.EncodeToString(sig),
-			Parameters:     template.URL(params.Encode()),
+			Scopes:               scopeInfo,
+			AuthorizationDetails: renderedDetails,
+			ExpirationTime:       expirationTime,
+			Signature:            base64.StdEncoding.EncodeToString(sig),
+			Parameters:           template.URL(params.Encode()),
 		}
 		w.Header().Add("Content-Type", "text/html; charset=utf-8")
 		h.templateFactory.ExecuteTemplate(w, "approval_prompt", &data)
@@ -127,22 +169,164 @@ func (h *authEndpointHandler) validateParameters(w http.ResponseWriter, params u
 	return true
 }
 
-func (h *authEndpointHandler) checkUserLogin(w http.ResponseWriter, r *http.Request) string {
-	sessionId, err := r.Cookie("sessionid")
+// validateRequest validates the parameters of an authorization request with
+// the response type. The error is an *oauth2.ErrorResponse if the request is
+// not valid.
+func (v *requestValidator) validateRequest(
+	params url.Values, responseType string) ([]oauth2.AuthorizationDetail, *authenticationRequest, error) {
+
+	// Nonce is required when an ID token is returned from this endpoint
+	if oauth2.ResponseTypeContains(responseType, oauth2.ResponseTypeIDToken) &&
+		params.Get(oauth2.ParameterNonce) == "" {
+		return nil, nil, helpers.NewMissingParameterError(oauth2.ParameterNonce, nil)
+	}
+	scope := params.Get(oauth2.ParameterScope)
+	err := v.oauth2Service.ValidateRequest(
+		params.Get(oauth2.ParameterClientId),
+		scope,
+		params.Get(oauth2.ParameterRedirectUri))
+	if err != nil {
+		return nil, nil, err
+	}
+	err = v.responseModes.Validate(params.Get(oauth2.ParameterResponseMode), responseType)
+	if err != nil {
+		return nil, nil, err
+	}
+	details, err := v.parseAuthorizationDetails(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = v.resources.Validate(params[oauth2.ParameterResource], scope)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = oauth2.ValidateCodeChallenge(
+		params.Get(oauth2.ParameterCodeChallenge), params.Get(oauth2.ParameterCodeChallengeMethod))
+	if err != nil {
+		return nil, nil, err
+	}
+	authRequest, err := parseAuthenticationRequest(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	return details, authRequest, nil
+}
+
+func (v *requestValidator) parseAuthorizationDetails(params url.Values) ([]oauth2.AuthorizationDetail, error) {
+	value := params.Get(oauth2.ParameterAuthorizationDetails)
+	if value == "" {
+		return nil, nil
+	}
+	if v.details == nil {
+		return nil, oauth2.NewInvalidAuthorizationDetailsError("Authorization details are not supported")
+	}
+	return v.details.Types.Parse(value)
+}
+
+// pushedRequest returns the request with the parameters of the pushed request
+// its request_uri refers to. The request uri can not be used again.
+func (h *authEndpointHandler) pushedRequest(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	query := r.URL.Query()
+	var params url.Values
+	if h.requests != nil {
+		params = h.requests.Take(query.Get(oauth2.ParameterRequestURI), query.Get(oauth2.ParameterClientId))
+	}
+	if params == nil {
+		helpers.RenderError(w, h.templateFactory, &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidRequestURI,
+			Description: "Parameter request_uri is invalid or expired",
+		})
+		return nil, false
+	}
+	pushed := r.Clone(r.Context())
+	pushed.URL.RawQuery = params.Encode()
+	return pushed, true
+}
+
+func (h *authEndpointHandler) checkUserLogin(
+	w http.ResponseWriter, r *http.Request, params url.Values, authRequest *authenticationRequest) *service.Session {
+
+	sessionId, err := h.cookies.Get(r, util.CookieSession)
 	if err != nil {
-		util.RedirectToLogin(w, r, *h.loginUrl, r.URL)
-		return ""
+		h.requireLogin(w, r, params, authRequest)
+		return nil
 	}
-	isAuthenticated, err := h.userAuthService.IsSessionValid(sessionId.Value)
+	session, err := h.userAuthService.Session(sessionId)
 	if err != nil {
 		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
-		return ""
+		return nil
 	}
-	if !isAuthenticated {
-		util.RedirectToLogin(w, r, *h.loginUrl, r.URL)
-		return ""
+	if session == nil {
+		h.requireLogin(w, r, params, authRequest)
+		return nil
+	}
+	requestedAt, returned := loginRequestedAt(r.URL, h.serverKeys)
+	if returned && !session.AuthTime.Before(requestedAt) {
+		// The session was started by the login for this request, the user would
+		// be asked to log in again in a loop if it still is not strong enough
+		if !authRequest.satisfiedByLogin(session) {
+			h.respondWithError(w, r, params, &oauth2.ErrorResponse{
+				ErrorCode:   oauth2.ErrorAccessDenied,
+				Description: "Requested authentication context could not be satisfied",
+			})
+			return nil
+		}
+	} else if authRequest.forcesLogin() || !authRequest.satisfiedBy(session, time.Now()) {
+		h.requireLogin(w, r, params, authRequest)
+		return nil
+	}
+	if authRequest.hasPrompt(oauth2.PromptNone) {
+		// Consent is not remembered, the approval prompt is always displayed
+		h.respondWithError(w, r, params, &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorConsentRequired})
+		return nil
+	}
+	return session
+}
+
+// requireLogin redirects the user to the login page or, if no user interaction
+// is allowed, returns the login_required error to the client.
+func (h *authEndpointHandler) requireLogin(
+	w http.ResponseWriter, r *http.Request, params url.Values, authRequest *authenticationRequest) {
+
+	if authRequest.hasPrompt(oauth2.PromptNone) {
+		h.respondWithError(w, r, params, &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorLoginRequired})
+		return
+	}
+	// The login flow is selected by the client and acr values of the signed
+	// return URL, not by parameters of the login URL the user could change
+	loginParams := url.Values{}
+	if loginHint := params.Get(oauth2.ParameterLoginHint); loginHint != "" {
+		loginParams.Set(oauth2.ParameterLoginHint, loginHint)
+	}
+	serverKey := h.serverKeys.Secret()
+	util.RedirectToLogin(w, r, serverKey, *h.loginUrl, returnTo(r.URL, time.Now(), serverKey), loginParams)
+}
+
+// respondWithError returns the error to the client using the requested response
+// mode. The request must be validated before the redirect uri can be used.
+func (h *authEndpointHandler) respondWithError(
+	w http.ResponseWriter, r *http.Request, params url.Values, response *oauth2.ErrorResponse) {
+
+	redirectURI, err := url.Parse(params.Get(oauth2.ParameterRedirectUri))
+	if err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
+		return
+	}
+	responseParams := response.Values()
+	if state := params.Get(oauth2.ParameterState); state != "" {
+		responseParams.Set(oauth2.ParameterState, state)
+	}
+	err = h.responseModes.Write(w, r,
+		params.Get(oauth2.ParameterResponseMode),
+		params.Get(oauth2.ParameterResponseType),
+		&response_mode.Response{
+			ClientId:    params.Get(oauth2.ParameterClientId),
+			RedirectURI: redirectURI,
+			Parameters:  responseParams,
+		})
+	if err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
 	}
-	return sessionId.Value
 }
 
 func (h *authEndpointHandler) missingResponseType(w http.ResponseWriter, responseType string) {
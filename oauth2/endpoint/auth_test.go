@@ -3,16 +3,19 @@ package endpoint_test
 import (
 	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/arjantop/gopherauth/oauth2"
 	"github.com/arjantop/gopherauth/oauth2/endpoint"
+	"github.com/arjantop/gopherauth/oauth2/response_mode"
 	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/testutil"
 	"github.com/arjantop/gopherauth/util"
@@ -23,6 +26,7 @@ const (
 	templateRoot    = "../../templates"
 	LoginUrl        = "https://example.com/login"
 	clientURI       = "https://client.example.com/cb"
+	issuer          = "https://example.com"
 )
 
 func makeLoginUrl() *url.URL {
@@ -40,13 +44,10 @@ func (m *ResponseTypeMock) ExtractParameters(r *http.Request) url.Values {
 	return params
 }
 
-func (m *ResponseTypeMock) Execute(params url.Values) (*url.URL, error) {
-	args := m.Mock.Called(params)
-	url, ok := args.Get(0).(*url.URL)
-	if !ok {
-		panic("Return value is not of correct type")
-	}
-	return url, args.Error(1)
+func (m *ResponseTypeMock) Execute(session *service.Session, params url.Values) (url.Values, error) {
+	args := m.Mock.Called(session, params)
+	response, _ := args.Get(0).(url.Values)
+	return response, args.Error(1)
 }
 
 func NewResponseTypeMock() *ResponseTypeMock {
@@ -60,39 +61,73 @@ type authDeps struct {
 	oauth2Service   *service.Oauth2ServiceMock
 	userAuthService *service.UserAuthenticationServiceMock
 	templateFactory *util.TemplateFactory
+	requests        *endpoint.PushedRequests
 }
 
 func makeAuthEndpointHandler() authDeps {
+	return makeAuthEndpointHandlerWith(map[string]*ResponseTypeMock{
+		"type1": NewResponseTypeMock(),
+		"type2": NewResponseTypeMock(),
+	})
+}
+
+// makeHybridAuthEndpointHandler only handles the hybrid response type, whose
+// requests are rejected without a nonce before they are validated.
+func makeHybridAuthEndpointHandler() authDeps {
+	return makeAuthEndpointHandlerWith(map[string]*ResponseTypeMock{
+		"code id_token": NewResponseTypeMock(),
+	})
+}
+
+func makeAuthEndpointHandlerWith(responseTypes map[string]*ResponseTypeMock) authDeps {
 	params := url.Values{}
 	params.Add("response_type", "type1")
 	params.Add("client_id", "client_id")
 	params.Add("scope", "scope1 scope2")
 	params.Add("redirect_uri", clientURI)
 
-	type1 := NewResponseTypeMock()
-	type2 := NewResponseTypeMock()
-	responseTypes := map[string]*ResponseTypeMock{
-		"type1": type1,
-		"type2": type2,
+	handlers := make(map[string]endpoint.ResponseType)
+	for responseType, handler := range responseTypes {
+		handlers[responseType] = handler
 	}
 	oauth2Service := service.NewOauth2ServiceMock()
 	userAuthService := service.NewUserAuthenticationServiceMock()
+	requests := endpoint.NewPushedRequests(service.NewCryptoTokenGenerator(), time.Minute)
 	handler := endpoint.NewAuthEndpointHandler(
-		[]byte("ServerKey"),
+		testutil.NewSecretKeyring("ServerKey"),
+		&util.CookiePolicy{},
 		makeLoginUrl(),
 		oauth2Service,
 		userAuthService,
 		util.NewTemplateFactory(templateRoot),
-		map[string]endpoint.ResponseType{
-			"type1": type1,
-			"type2": type2,
-		})
+		handlers,
+		&endpoint.AuthorizationDetails{
+			Types: oauth2.AuthorizationDetailTypes{
+				"payment_initiation": &oauth2.AuthorizationDetailSchema{
+					Fields: map[string]oauth2.DetailField{
+						"creditorName": oauth2.DetailField{Kind: oauth2.DetailFieldString, Required: true},
+					},
+				},
+			},
+			Renderers: map[string]endpoint.AuthorizationDetailRenderer{
+				"payment_initiation": endpoint.AuthorizationDetailRendererFunc(
+					func(detail oauth2.AuthorizationDetail, locale string) (template.HTML, error) {
+						return template.HTML("Pay to " + template.HTMLEscapeString(detail["creditorName"].(string))), nil
+					}),
+			},
+		},
+		oauth2.ProtectedResources{
+			"https://api.example.com/": &oauth2.ProtectedResource{Scopes: []string{"scope1", "scope2"}},
+		},
+		response_mode.NewResponseModes(issuer, util.NewTemplateFactory(templateRoot), nil),
+		requests)
 	return authDeps{
 		params:          params,
 		responseTypes:   responseTypes,
 		handler:         handler,
 		oauth2Service:   oauth2Service,
 		userAuthService: userAuthService,
+		requests:        requests,
 	}
 }
 
@@ -241,7 +276,7 @@ func TestUserIsRedirectedToLoginIfSessionValueIsInvalid(t *testing.T) {
 	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
 	deps.oauth2Service.On(
 		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
-	deps.userAuthService.On("IsSessionValid", "invalid_id").Return(false, nil)
+	deps.userAuthService.On("Session", "invalid_id").Return(nil, nil)
 
 	recorder := httptest.NewRecorder()
 	deps.handler.ServeHTTP(recorder, request)
@@ -260,7 +295,7 @@ func TestErrorIsDisaplyedIfUserAuthServiceErrorOccurs(t *testing.T) {
 	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
 	deps.oauth2Service.On(
 		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
-	deps.userAuthService.On("IsSessionValid", "valid_id").Return(false, errors.New("error"))
+	deps.userAuthService.On("Session", "valid_id").Return(nil, errors.New("error"))
 
 	recorder := httptest.NewRecorder()
 	deps.handler.ServeHTTP(recorder, request)
@@ -279,7 +314,7 @@ func TestAuthenticatedUserIsPresentedWithApprovalPrompt(t *testing.T) {
 	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
 	deps.oauth2Service.On(
 		"ValidateRequest", "client_id", deps.params.Get("scope"), clientURI).Return(nil)
-	deps.userAuthService.On("IsSessionValid", "valid_id").Return(true, nil)
+	deps.userAuthService.On("Session", "valid_id").Return(userSession, nil)
 	scopeInfo := []*service.ScopeInfo{
 		&service.ScopeInfo{
 			Description: "Description of scope1",
@@ -306,6 +341,401 @@ func TestAuthenticatedUserIsPresentedWithApprovalPrompt(t *testing.T) {
 	assertAuthEndpointExpectations(t, deps)
 }
 
+func makeAuthenticatedAuthRequest(t *testing.T, deps authDeps) *http.Request {
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+	request.AddCookie(&http.Cookie{Name: "sessionid", Value: "valid_id"})
+
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	deps.oauth2Service.On(
+		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
+	return request
+}
+
+func assertIsRedirectedToClientWithError(t *testing.T, recorder *httptest.ResponseRecorder, errorCode string) {
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, clientURI, (&url.URL{Scheme: location.Scheme, Host: location.Host, Path: location.Path}).String())
+	fragment, err := url.ParseQuery(location.Fragment)
+	assert.Nil(t, err)
+	assert.Equal(t, errorCode, fragment.Get("error"))
+	assert.Equal(t, "state", fragment.Get("state"))
+}
+
+func TestLoginRequiredIsReturnedWithoutSessionIfPromptIsNone(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("prompt", "none")
+	deps.params.Add("state", "state")
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	deps.oauth2Service.On(
+		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertIsRedirectedToClientWithError(t, recorder, "login_required")
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestConsentRequiredIsReturnedWithSessionIfPromptIsNone(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("prompt", "none")
+	deps.params.Add("state", "state")
+
+	request := makeAuthenticatedAuthRequest(t, deps)
+	deps.userAuthService.On("Session", "valid_id").Return(userSession, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertIsRedirectedToClientWithError(t, recorder, "consent_required")
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestPromptLoginForcesLoginAndIsKeptInReturnUrl(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("prompt", "login consent")
+	deps.params.Add("login_hint", "user@example.com")
+
+	request := makeAuthenticatedAuthRequest(t, deps)
+	deps.userAuthService.On("Session", "valid_id").Return(userSession, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	AssertIsRedirectedToLogin(t, recorder, request.URL)
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, "user@example.com", location.Query().Get("login_hint"))
+	returnTo, err := url.Parse(location.Query().Get("continue"))
+	assert.Nil(t, err)
+	assert.Equal(t, "login consent", returnTo.Query().Get("prompt"))
+	assert.NotEmpty(t, returnTo.Query().Get("login_requested"))
+	assertAuthEndpointExpectations(t, deps)
+}
+
+// returnFromLogin returns the request the user is returned with from the login
+// the recorded response redirected to, authenticated with the session.
+func returnFromLogin(
+	t *testing.T, deps authDeps, recorder *httptest.ResponseRecorder, session *service.Session) *http.Request {
+
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.Nil(t, err)
+	returnTo, err := url.Parse(location.Query().Get("continue"))
+	assert.Nil(t, err)
+	request := testutil.NewEndpointRequest(t, "GET", "auth", returnTo.Query())
+	request.AddCookie(&http.Cookie{Name: "sessionid", Value: "returned_id"})
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	deps.userAuthService.On("Session", "returned_id").Return(session, nil)
+	return request
+}
+
+func TestLoginAfterPromptLoginSatisfiesRequest(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("prompt", "login")
+	deps.params.Add("max_age", "0")
+
+	request := makeAuthenticatedAuthRequest(t, deps)
+	deps.userAuthService.On("Session", "valid_id").Return(userSession, nil)
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+	AssertIsRedirectedToLogin(t, recorder, request.URL)
+
+	request = returnFromLogin(t, deps, recorder, &service.Session{
+		Subject:  "user",
+		AuthTime: time.Now(),
+		Methods:  []string{"pwd"},
+	})
+	deps.oauth2Service.On("ScopeInfo", "scope1 scope2", "en").Return([]*service.ScopeInfo{}, nil)
+	recorder = httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestSessionOlderThanLoginRequestDoesNotSatisfyPromptLogin(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("prompt", "login")
+
+	request := makeAuthenticatedAuthRequest(t, deps)
+	deps.userAuthService.On("Session", "valid_id").Return(userSession, nil)
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	request = returnFromLogin(t, deps, recorder, &service.Session{
+		Subject:  "user",
+		AuthTime: time.Now().Add(-time.Minute),
+		Methods:  []string{"pwd"},
+	})
+	recorder = httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	AssertIsRedirectedToLogin(t, recorder, request.URL)
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestLoginRequestTimeCanNotBeMovedToOtherRequest(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("prompt", "login")
+
+	request := makeAuthenticatedAuthRequest(t, deps)
+	deps.userAuthService.On("Session", "valid_id").Return(userSession, nil)
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.Nil(t, err)
+	returnTo, err := url.Parse(location.Query().Get("continue"))
+	assert.Nil(t, err)
+	params := returnTo.Query()
+	params.Set("scope", "scope1")
+	request = testutil.NewEndpointRequest(t, "GET", "auth", params)
+	request.AddCookie(&http.Cookie{Name: "sessionid", Value: "valid_id"})
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	recorder = httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	AssertIsRedirectedToLogin(t, recorder, request.URL)
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestLoginIsRequiredIfAuthenticationIsOlderThanMaxAge(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("max_age", "60")
+
+	request := makeAuthenticatedAuthRequest(t, deps)
+	deps.userAuthService.On("Session", "valid_id").Return(&service.Session{
+		Subject:  "user",
+		AuthTime: time.Now().Add(-2 * time.Minute),
+		Methods:  []string{"pwd"},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	AssertIsRedirectedToLogin(t, recorder, request.URL)
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestLoginIsRequestedWithACRValuesIfSessionACRIsNotSatisfied(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("acr_values", "urn:example:mfa")
+
+	request := makeAuthenticatedAuthRequest(t, deps)
+	deps.userAuthService.On("Session", "valid_id").Return(&service.Session{
+		Subject:  "user",
+		AuthTime: time.Now(),
+		Methods:  []string{"pwd"},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	AssertIsRedirectedToLogin(t, recorder, request.URL)
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.Nil(t, err)
+	assert.Empty(t, location.Query().Get("acr_values"), "Login flow is selected by the signed return url")
+	returnTo, err := url.Parse(location.Query().Get("continue"))
+	assert.Nil(t, err)
+	assert.Equal(t, "urn:example:mfa", returnTo.Query().Get("acr_values"))
+	assert.Equal(t, "client_id", returnTo.Query().Get("client_id"))
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestAccessIsDeniedIfLoginDidNotSatisfyACRValues(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("acr_values", "urn:example:mfa")
+	deps.params.Add("state", "state")
+
+	request := makeAuthenticatedAuthRequest(t, deps)
+	deps.userAuthService.On("Session", "valid_id").Return(userSession, nil)
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+	AssertIsRedirectedToLogin(t, recorder, request.URL)
+
+	request = returnFromLogin(t, deps, recorder, &service.Session{
+		Subject:  "user",
+		AuthTime: time.Now(),
+		Methods:  []string{"pwd"},
+		ACR:      "urn:example:pwd",
+	})
+	recorder = httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertIsRedirectedToClientWithError(t, recorder, oauth2.ErrorAccessDenied)
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestRecentAuthenticationSatisfiesMaxAge(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("max_age", "60")
+
+	request := makeAuthenticatedAuthRequest(t, deps)
+	deps.userAuthService.On("Session", "valid_id").Return(&service.Session{
+		Subject:  "user",
+		AuthTime: time.Now().Add(-10 * time.Second),
+		Methods:  []string{"pwd"},
+	}, nil)
+	deps.oauth2Service.On("ScopeInfo", "scope1 scope2", "en").Return([]*service.ScopeInfo{}, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestErrorIsDisplayedIfPromptIsInvalid(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("prompt", "none login")
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	deps.oauth2Service.On(
+		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertIsBadRequest(t, recorder)
+	assert.Contains(t, recorder.Body.String(), "prompt")
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestErrorIsDisplayedIfAuthorizationDetailsAreInvalid(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("authorization_details", `[{"type":"payment_initiation"}]`)
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	deps.oauth2Service.On(
+		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertIsBadRequest(t, recorder)
+	assert.Contains(t, recorder.Body.String(), "invalid_authorization_details")
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestResponseTypeValuesCanBeInAnyOrder(t *testing.T) {
+	deps := makeHybridAuthEndpointHandler()
+	deps.params.Set("response_type", "id_token code")
+	deps.params.Add("nonce", "nonce")
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+
+	deps.responseTypes["code id_token"].On("ExtractParameters", request).Return(deps.params)
+	deps.oauth2Service.On(
+		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(errors.New("error"))
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestErrorIsDisplayedIfNonceIsMissingForIDTokenResponse(t *testing.T) {
+	deps := makeHybridAuthEndpointHandler()
+	deps.params.Set("response_type", "code id_token")
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+
+	deps.responseTypes["code id_token"].On("ExtractParameters", request).Return(deps.params)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertIsBadRequest(t, recorder)
+	assert.Contains(t, recorder.Body.String(), "nonce")
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestErrorIsDisplayedIfResponseModeIsNotSupported(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("response_mode", "query.jwt")
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	deps.oauth2Service.On(
+		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertIsBadRequest(t, recorder)
+	assert.Contains(t, recorder.Body.String(), "response_mode")
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestErrorIsDisplayedIfResourceIsNotRegistered(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("resource", "https://unknown.example.com/")
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	deps.oauth2Service.On(
+		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertIsBadRequest(t, recorder)
+	assert.Contains(t, recorder.Body.String(), "invalid_target")
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestErrorIsDisplayedIfCodeChallengeMethodIsNotS256(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	deps.params.Add("code_challenge_method", "plain")
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	deps.oauth2Service.On(
+		"ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assertIsBadRequest(t, recorder)
+	assert.Contains(t, recorder.Body.String(), "code_challenge_method")
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestAuthorizationDetailsAreRenderedOnApprovalPrompt(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	deps.params.Add("authorization_details", `[{"type":"payment_initiation","creditorName":"Merchant A"}]`)
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+	sessionIdCookie := &http.Cookie{Name: "sessionid", Value: "valid_id"}
+	request.AddCookie(sessionIdCookie)
+
+	deps.responseTypes["type1"].On("ExtractParameters", request).Return(deps.params)
+	deps.oauth2Service.On(
+		"ValidateRequest", "client_id", deps.params.Get("scope"), clientURI).Return(nil)
+	deps.userAuthService.On("Session", "valid_id").Return(userSession, nil)
+	deps.oauth2Service.On(
+		"ScopeInfo", deps.params.Get("scope"), "en").Return([]*service.ScopeInfo{}, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Pay to Merchant A")
+	assertAuthEndpointExpectations(t, deps)
+}
+
 func TestNoCacheHeadersAreSet(t *testing.T) {
 	deps := makeAuthEndpointHandler()
 
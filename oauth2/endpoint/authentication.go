@@ -0,0 +1,132 @@
+package endpoint
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+)
+
+// parameterLoginRequested is added to the URL the user is returned to after
+// login. It holds the signed time the login was requested at.
+const parameterLoginRequested = "login_requested"
+
+// authenticationRequest holds the OpenID Connect parameters of an authorization
+// request that control how the user must be authenticated.
+type authenticationRequest struct {
+	prompt    []string
+	maxAge    int64
+	hasMaxAge bool
+	acrValues []string
+}
+
+func parseAuthenticationRequest(params url.Values) (*authenticationRequest, error) {
+	prompt, err := oauth2.ParsePrompt(params.Get(oauth2.ParameterPrompt))
+	if err != nil {
+		return nil, err
+	}
+	maxAge, hasMaxAge, err := oauth2.ParseMaxAge(params.Get(oauth2.ParameterMaxAge))
+	if err != nil {
+		return nil, err
+	}
+	return &authenticationRequest{
+		prompt:    prompt,
+		maxAge:    maxAge,
+		hasMaxAge: hasMaxAge,
+		acrValues: oauth2.ParseScope(params.Get(oauth2.ParameterACRValues)),
+	}, nil
+}
+
+func (a *authenticationRequest) hasPrompt(value string) bool {
+	return containsString(a.prompt, value)
+}
+
+// forcesLogin reports whether the user must log in again regardless of the
+// existing session.
+func (a *authenticationRequest) forcesLogin() bool {
+	return a.hasPrompt(oauth2.PromptLogin) || a.hasPrompt(oauth2.PromptSelectAccount)
+}
+
+// satisfiedBy reports whether the existing authentication of a session is recent
+// and strong enough for the request.
+func (a *authenticationRequest) satisfiedBy(session *service.Session, now time.Time) bool {
+	if a.hasMaxAge && int64(now.Sub(session.AuthTime)/time.Second) > a.maxAge {
+		return false
+	}
+	if len(a.acrValues) > 0 && !containsString(a.acrValues, session.ACR) {
+		return false
+	}
+	return true
+}
+
+// satisfiedByLogin reports whether a session started after the user was sent
+// to log in for the request is strong enough. The login satisfies prompt and
+// max_age, but the flow the user completed might not provide the requested acr.
+func (a *authenticationRequest) satisfiedByLogin(session *service.Session) bool {
+	return len(a.acrValues) == 0 || containsString(a.acrValues, session.ACR)
+}
+
+// returnTo returns the request URL the user is returned to after login. All
+// parameters of the request are kept, so its requirements are checked again on
+// return, and the signed time of the login request is added, so the session of
+// the login can be told apart from an older one.
+func returnTo(requestURL *url.URL, now time.Time, serverKey []byte) *url.URL {
+	returnTo := *requestURL
+	query := returnTo.Query()
+	query.Del(parameterLoginRequested)
+	requested := strconv.FormatInt(now.Unix(), 10)
+	mac := loginRequestedMAC(query, requested, serverKey)
+	query.Set(parameterLoginRequested, requested+"."+base64.RawURLEncoding.EncodeToString(mac))
+	returnTo.RawQuery = query.Encode()
+	return &returnTo
+}
+
+// loginRequestedAt returns the time the user was sent to log in if the request
+// is a return from the login. The time is bound to the other parameters of the
+// request, it can not be moved to another request.
+func loginRequestedAt(requestURL *url.URL, serverKeys *keyring.Keyring) (time.Time, bool) {
+	query := requestURL.Query()
+	value := query.Get(parameterLoginRequested)
+	query.Del(parameterLoginRequested)
+	requested, encodedMac, found := strings.Cut(value, ".")
+	if !found {
+		return time.Time{}, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(requested, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, serverKey := range serverKeys.Secrets() {
+		if hmac.Equal(mac, loginRequestedMAC(query, requested, serverKey)) {
+			return time.Unix(seconds, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func loginRequestedMAC(query url.Values, requested string, serverKey []byte) []byte {
+	mac := hmac.New(sha256.New, serverKey)
+	mac.Write([]byte(parameterLoginRequested + requested))
+	mac.Write([]byte(query.Encode()))
+	return mac.Sum(nil)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package endpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"sort"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// AuthorizationDetailRenderer renders a human readable description of a single
+// authorization detail that is displayed on the approval prompt.
+type AuthorizationDetailRenderer interface {
+	Render(detail oauth2.AuthorizationDetail, locale string) (template.HTML, error)
+}
+
+// AuthorizationDetailRendererFunc is an adapter that allows ordinary functions
+// to be used as authorization detail renderers.
+type AuthorizationDetailRendererFunc func(detail oauth2.AuthorizationDetail, locale string) (template.HTML, error)
+
+func (f AuthorizationDetailRendererFunc) Render(
+	detail oauth2.AuthorizationDetail, locale string) (template.HTML, error) {
+
+	return f(detail, locale)
+}
+
+// AuthorizationDetails configures support for the authorization_details request
+// parameter. Types are used for validation and renderers, keyed by the detail
+// type, for display on the approval prompt.
+type AuthorizationDetails struct {
+	Types     oauth2.AuthorizationDetailTypes
+	Renderers map[string]AuthorizationDetailRenderer
+}
+
+// Render renders all details using the registered renderer for their type or the
+// default renderer if none is registered.
+func (a *AuthorizationDetails) Render(details []oauth2.AuthorizationDetail, locale string) ([]template.HTML, error) {
+	rendered := make([]template.HTML, 0, len(details))
+	for _, detail := range details {
+		renderer, ok := a.Renderers[detail.Type()]
+		if !ok {
+			renderer = DefaultAuthorizationDetailRenderer
+		}
+		html, err := renderer.Render(detail, locale)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, html)
+	}
+	return rendered, nil
+}
+
+var defaultDetailTemplate = template.Must(template.New("authorization_detail").Parse(
+	`<span class="detail-type">{{.Type}}</span><dl>{{range .Fields}}<dt>{{.Name}}</dt><dd>{{.Value}}</dd>{{end}}</dl>`))
+
+type renderedDetailField struct {
+	Name, Value string
+}
+
+// DefaultAuthorizationDetailRenderer renders the type of the detail followed by
+// a list of all its other members.
+var DefaultAuthorizationDetailRenderer = AuthorizationDetailRendererFunc(
+	func(detail oauth2.AuthorizationDetail, locale string) (template.HTML, error) {
+		names := make([]string, 0, len(detail))
+		for name := range detail {
+			if name != "type" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		fields := make([]renderedDetailField, 0, len(names))
+		for _, name := range names {
+			value, ok := detail[name].(string)
+			if !ok {
+				encoded, err := json.Marshal(detail[name])
+				if err != nil {
+					return "", err
+				}
+				value = string(encoded)
+			}
+			fields = append(fields, renderedDetailField{name, value})
+		}
+		var buf bytes.Buffer
+		err := defaultDetailTemplate.Execute(&buf, struct {
+			Type   string
+			Fields []renderedDetailField
+		}{detail.Type(), fields})
+		return template.HTML(buf.String()), err
+	})
@@ -5,10 +5,24 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/util"
 )
 
+var userSession = &service.Session{
+	Id:        "SessionId",
+	Sid:       "sid",
+	Subject:   "user",
+	AuthTime:  time.Now(),
+	Methods:   []string{"pwd"},
+	ExpiresAt: time.Now().Add(time.Hour),
+}
+
 func AssertIsRedirectedToLogin(t *testing.T, recorder *httptest.ResponseRecorder, returnTo *url.URL) {
 	assert.Equal(t, http.StatusFound, recorder.Code, "Response code should be 302 Found")
 	redirectUrl, err := url.Parse(recorder.Header().Get("Location"))
@@ -16,4 +30,8 @@ func AssertIsRedirectedToLogin(t *testing.T, recorder *httptest.ResponseRecorder
 	assert.True(t, redirectUrl.IsAbs())
 	assert.NotEmpty(t, redirectUrl.Query().Get("continue"),
 		"continue parameter must be present: %s", redirectUrl.String())
+	assert.True(t, util.VerifyContinue(
+		redirectUrl.Query().Get("continue"), redirectUrl.Query().Get("continue_sig"),
+		testutil.NewSecretKeyring("ServerKey")),
+		"continue parameter must be signed")
 }
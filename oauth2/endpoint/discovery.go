@@ -0,0 +1,68 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/arjantop/gopherauth/util"
+)
+
+// Paths the metadata is published at, for an issuer without a path
+const (
+	PathOpenIDConfiguration         = "/.well-known/openid-configuration"
+	PathAuthorizationServerMetadata = "/.well-known/oauth-authorization-server"
+)
+
+// Time clients may cache the metadata
+const metadataMaxAge = "3600"
+
+// Metadata describes the endpoints and capabilities of the authorization server
+// as defined in RFC 8414 and OpenID Connect Discovery.
+type Metadata struct {
+	Issuer                             string   `json:"issuer"`
+	AuthorizationEndpoint              string   `json:"authorization_endpoint"`
+	TokenEndpoint                      string   `json:"token_endpoint"`
+	JWKSURI                            string   `json:"jwks_uri"`
+	EndSessionEndpoint                 string   `json:"end_session_endpoint,omitempty"`
+	IntrospectionEndpoint              string   `json:"introspection_endpoint,omitempty"`
+	PushedAuthorizationRequestEndpoint string   `json:"pushed_authorization_request_endpoint,omitempty"`
+	ScopesSupported                    []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported             []string `json:"response_types_supported"`
+	ResponseModesSupported             []string `json:"response_modes_supported,omitempty"`
+	GrantTypesSupported                []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported              []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported   []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported  []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported      []string `json:"code_challenge_methods_supported,omitempty"`
+	DPoPSigningAlgValuesSupported      []string `json:"dpop_signing_alg_values_supported,omitempty"`
+	// The iss parameter of RFC 9207 is sent in authorization responses
+	AuthorizationResponseIssParameterSupported bool `json:"authorization_response_iss_parameter_supported,omitempty"`
+	BackchannelLogoutSupported                 bool `json:"backchannel_logout_supported,omitempty"`
+	BackchannelLogoutSessionSupported          bool `json:"backchannel_logout_session_supported,omitempty"`
+}
+
+type discoveryEndpointHandler struct {
+	metadata *Metadata
+}
+
+// NewDiscoveryEndpointHandler returns the handler publishing the metadata. It
+// serves both the OpenID Connect configuration and the RFC 8414 metadata, which
+// have the same content.
+func NewDiscoveryEndpointHandler(metadata *Metadata) http.Handler {
+	return &discoveryEndpointHandler{metadata: metadata}
+}
+
+func (h *discoveryEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	encoded, err := json.Marshal(h.metadata)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", util.ContentTypeJson)
+	w.Header().Set("Cache-Control", "public, max-age="+metadataMaxAge)
+	w.Write(encoded)
+}
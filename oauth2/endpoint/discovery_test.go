@@ -0,0 +1,45 @@
+package endpoint_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2/endpoint"
+	"github.com/arjantop/gopherauth/util"
+)
+
+func TestDiscoveryEndpointPublishesMetadata(t *testing.T) {
+	handler := endpoint.NewDiscoveryEndpointHandler(&endpoint.Metadata{
+		Issuer:                        issuer,
+		AuthorizationEndpoint:         issuer + "/auth",
+		TokenEndpoint:                 issuer + "/token",
+		JWKSURI:                       issuer + "/jwks",
+		ResponseTypesSupported:        []string{"code"},
+		SubjectTypesSupported:         []string{"public"},
+		CodeChallengeMethodsSupported: []string{"S256"},
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", endpoint.PathOpenIDConfiguration, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, util.ContentTypeJson, recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Header().Get("Cache-Control"), "max-age=")
+
+	var metadata map[string]interface{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &metadata))
+	assert.Equal(t, issuer, metadata["issuer"])
+	assert.Equal(t, issuer+"/token", metadata["token_endpoint"])
+	assert.Equal(t, []interface{}{"S256"}, metadata["code_challenge_methods_supported"])
+	assert.NotContains(t, metadata, "end_session_endpoint")
+}
+
+func TestDiscoveryEndpointAllowsOnlyGet(t *testing.T) {
+	handler := endpoint.NewDiscoveryEndpointHandler(&endpoint.Metadata{Issuer: issuer})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", endpoint.PathOpenIDConfiguration, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,53 @@
+package endpoint
+
+import (
+	"net/http"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/helpers"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/util"
+)
+
+type introspectionEndpointHandler struct {
+	oauth2Service service.Oauth2Service
+}
+
+// NewIntrospectionEndpointHandler returns the token introspection endpoint (RFC
+// 7662). Only authenticated clients, e.g. resource servers, can introspect
+// tokens.
+func NewIntrospectionEndpointHandler(oauth2Service service.Oauth2Service) http.Handler {
+	handler := &introspectionEndpointHandler{
+		oauth2Service: oauth2Service,
+	}
+	authMiddleware := util.ClientCredentialsFromFormDataToHeaderMiddleware(handler)
+	noCachingMiddleware := util.NoCachingMiddleware(authMiddleware)
+	return noCachingMiddleware
+}
+
+func (h *introspectionEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	clientCredentials, ok := authenticateClient(w, r, h.oauth2Service)
+	if !ok {
+		return
+	}
+	token := r.PostFormValue(oauth2.ParameterToken)
+	if token == "" {
+		helpers.NewMissingParameterError(oauth2.ParameterToken, nil).WriteResponse(w, http.StatusBadRequest)
+		return
+	}
+	response, err := h.oauth2Service.Introspect(
+		clientCredentials, token, r.PostFormValue(oauth2.ParameterTokenTypeHint))
+	if err != nil {
+		if response, ok := err.(*oauth2.ErrorResponse); ok {
+			response.WriteResponse(w, http.StatusBadRequest)
+		} else {
+			http.Error(w, "", http.StatusServiceUnavailable)
+		}
+		return
+	}
+	response.WriteResponse(w, http.StatusOK)
+}
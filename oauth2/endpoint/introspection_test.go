@@ -0,0 +1,93 @@
+package endpoint_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/endpoint"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+)
+
+type introspectionDeps struct {
+	oauth2Service *service.Oauth2ServiceMock
+	handler       http.Handler
+}
+
+func makeIntrospectionEndpointHandler() introspectionDeps {
+	oauth2Service := service.NewOauth2ServiceMock()
+	return introspectionDeps{
+		oauth2Service: oauth2Service,
+		handler:       endpoint.NewIntrospectionEndpointHandler(oauth2Service),
+	}
+}
+
+func newIntrospectionRequest(t *testing.T, token string) *http.Request {
+	request := testutil.NewEndpointRequest(t, "POST", "introspect", url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	})
+	request.SetBasicAuth("client_id", "secret")
+	return request
+}
+
+func TestIntrospectionReturnsTokenState(t *testing.T) {
+	deps := makeIntrospectionEndpointHandler()
+	deps.oauth2Service.On("AuthenticateClient", clientCredentials).Return(nil)
+	deps.oauth2Service.On("Introspect", clientCredentials, "active", "access_token").Return(
+		&oauth2.IntrospectionResponse{Active: true, Claims: map[string]interface{}{"sub": "user", "scope": "read"}}, nil)
+	deps.oauth2Service.On("Introspect", clientCredentials, "inactive", "access_token").Return(
+		&oauth2.IntrospectionResponse{Claims: map[string]interface{}{"sub": "user"}}, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newIntrospectionRequest(t, "active"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"active": true, "sub": "user", "scope": "read"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newIntrospectionRequest(t, "inactive"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"active": false}`, recorder.Body.String(), "Claims of inactive tokens must not be returned")
+	deps.oauth2Service.AssertExpectations(t)
+}
+
+func TestIntrospectionRequiresClientAuthentication(t *testing.T) {
+	deps := makeIntrospectionEndpointHandler()
+	request := testutil.NewEndpointRequest(t, "POST", "introspect", url.Values{"token": {"active"}})
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	deps.oauth2Service.On("AuthenticateClient", clientCredentials).Return(&oauth2.ErrorResponse{
+		ErrorCode: oauth2.ErrorInvalidClient,
+	})
+	recorder = httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newIntrospectionRequest(t, "active"))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	deps.oauth2Service.AssertNotCalled(t, "Introspect", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestIntrospectionRequiresToken(t *testing.T) {
+	deps := makeIntrospectionEndpointHandler()
+	deps.oauth2Service.On("AuthenticateClient", clientCredentials).Return(nil)
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newIntrospectionRequest(t, ""))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), oauth2.ErrorInvalidRequest)
+}
+
+func TestIntrospectionServiceErrorIsUnavailable(t *testing.T) {
+	deps := makeIntrospectionEndpointHandler()
+	deps.oauth2Service.On("AuthenticateClient", clientCredentials).Return(nil)
+	deps.oauth2Service.On("Introspect", clientCredentials, "active", "access_token").Return(nil, errors.New("error"))
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newIntrospectionRequest(t, "active"))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
@@ -0,0 +1,49 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/util"
+)
+
+// Time clients may cache the key set, pending keys must be published for
+// longer before they become active
+const jwksMaxAge = "300"
+
+type jwksEndpointHandler struct {
+	keyrings []*keyring.Keyring
+}
+
+// NewJWKSEndpointHandler returns the handler publishing the public keys of the
+// keyrings, including pending and retired keys, so clients can verify
+// signatures during key rotation.
+func NewJWKSEndpointHandler(keyrings ...*keyring.Keyring) http.Handler {
+	return &jwksEndpointHandler{keyrings: keyrings}
+}
+
+func (h *jwksEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	set := &jose.JSONWebKeySet{Keys: []*jose.JSONWebKey{}}
+	for _, keys := range h.keyrings {
+		published, err := keys.JSONWebKeySet()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		set.Keys = append(set.Keys, published.Keys...)
+	}
+	encoded, err := json.Marshal(set)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", util.ContentTypeJson)
+	w.Header().Set("Cache-Control", "public, max-age="+jwksMaxAge)
+	w.Write(encoded)
+}
@@ -0,0 +1,55 @@
+package endpoint_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/oauth2/endpoint"
+	"github.com/arjantop/gopherauth/util"
+)
+
+func newSigningKeyring(t *testing.T) *keyring.Keyring {
+	keys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSigning, keyring.Schedule{
+		Algorithm: jose.AlgorithmES256,
+		RetainFor: time.Hour,
+	})
+	assert.NoError(t, err)
+	return keys
+}
+
+func TestJWKSEndpointPublishesKeysOfAllKeyrings(t *testing.T) {
+	first := newSigningKeyring(t)
+	second := newSigningKeyring(t)
+	assert.NoError(t, second.Rotate(time.Now()))
+	handler := endpoint.NewJWKSEndpointHandler(first, second)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/jwks", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, util.ContentTypeJson, recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Header().Get("Cache-Control"), "max-age=")
+
+	var set jose.JSONWebKeySet
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &set))
+	var ids []string
+	for _, jwk := range set.Keys {
+		ids = append(ids, jwk.Kid)
+	}
+	assert.Len(t, ids, 5)
+	assert.Contains(t, ids, first.KeyID())
+	assert.Contains(t, ids, second.KeyID())
+}
+
+func TestJWKSEndpointOnlyAllowsGet(t *testing.T) {
+	handler := endpoint.NewJWKSEndpointHandler(newSigningKeyring(t))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/jwks", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
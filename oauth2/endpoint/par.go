@@ -0,0 +1,203 @@
+package endpoint
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/helpers"
+	"github.com/arjantop/gopherauth/oauth2/response_mode"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/util"
+)
+
+// Prefix of the request uris of pushed authorization requests
+const RequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+// Size of the random part of a request uri in bytes
+const requestURISize = 32
+
+// PushedRequests keeps pushed authorization requests until they are used at the
+// authorization endpoint. A request uri can be used once.
+type PushedRequests struct {
+	tokenGenerator service.TokenGenerator
+	lifetime       time.Duration
+	mutex          sync.Mutex
+	requests       map[string]*pushedRequest
+}
+
+type pushedRequest struct {
+	params    url.Values
+	expiresAt time.Time
+}
+
+// NewPushedRequests returns a store of pushed requests that expire after
+// lifetime, RFC 9126 suggests between 5 and 600 seconds.
+func NewPushedRequests(tokenGenerator service.TokenGenerator, lifetime time.Duration) *PushedRequests {
+	return &PushedRequests{
+		tokenGenerator: tokenGenerator,
+		lifetime:       lifetime,
+		requests:       make(map[string]*pushedRequest),
+	}
+}
+
+// Push stores the validated parameters of a request and returns its request
+// uri.
+func (p *PushedRequests) Push(params url.Values) (string, error) {
+	random, err := p.tokenGenerator.Generate(requestURISize)
+	if err != nil {
+		return "", err
+	}
+	requestURI := RequestURIPrefix + base64.RawURLEncoding.EncodeToString(random)
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for uri, request := range p.requests {
+		if !now.Before(request.expiresAt) {
+			delete(p.requests, uri)
+		}
+	}
+	p.requests[requestURI] = &pushedRequest{params: params, expiresAt: now.Add(p.lifetime)}
+	return requestURI, nil
+}
+
+// Take returns the parameters of the pushed request of the client and removes
+// it, or nil if the request does not exist, has expired or was pushed by
+// another client.
+func (p *PushedRequests) Take(requestURI, clientId string) url.Values {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	request, ok := p.requests[requestURI]
+	if !ok || request.params.Get(oauth2.ParameterClientId) != clientId {
+		return nil
+	}
+	delete(p.requests, requestURI)
+	if !time.Now().Before(request.expiresAt) {
+		return nil
+	}
+	return request.params
+}
+
+type parEndpointHandler struct {
+	requestValidator
+	handlers map[string]ResponseType
+	requests *PushedRequests
+}
+
+// NewPAREndpointHandler returns the pushed authorization request endpoint (RFC
+// 9126). Clients must authenticate and the request is validated like at the
+// authorization endpoint before it is stored.
+func NewPAREndpointHandler(
+	oauth2Service service.Oauth2Service,
+	handlers map[string]ResponseType,
+	details *AuthorizationDetails,
+	resources oauth2.ProtectedResources,
+	responseModes *response_mode.ResponseModes,
+	requests *PushedRequests) http.Handler {
+
+	handler := &parEndpointHandler{
+		requestValidator: requestValidator{
+			oauth2Service: oauth2Service,
+			details:       details,
+			resources:     resources,
+			responseModes: responseModes,
+		},
+		handlers: handlers,
+		requests: requests,
+	}
+	authMiddleware := util.ClientCredentialsFromFormDataToHeaderMiddleware(handler)
+	noCachingMiddleware := util.NoCachingMiddleware(authMiddleware)
+	return noCachingMiddleware
+}
+
+func (h *parEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	clientCredentials, ok := authenticateClient(w, r, h.oauth2Service)
+	if !ok {
+		return
+	}
+	form := url.Values{}
+	for name, values := range r.PostForm {
+		form[name] = values
+	}
+	if form.Get(oauth2.ParameterRequestURI) != "" {
+		writeInvalidRequest(w, "Parameter request_uri can not be pushed")
+		return
+	}
+	if clientId := form.Get(oauth2.ParameterClientId); clientId != "" && clientId != clientCredentials.Id {
+		writeInvalidRequest(w, "Parameter client_id does not match the authenticated client")
+		return
+	}
+	form.Set(oauth2.ParameterClientId, clientCredentials.Id)
+
+	responseType := form.Get(oauth2.ParameterResponseType)
+	handler, ok := h.handlers[oauth2.NormalizeResponseType(responseType)]
+	if !ok {
+		response := &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorUnsupportedResponseType}
+		if responseType == "" {
+			response = helpers.NewMissingParameterError(oauth2.ParameterResponseType, nil)
+		}
+		response.WriteResponse(w, http.StatusBadRequest)
+		return
+	}
+	// Response types extract the parameters from the query of the authorization
+	// endpoint
+	params := handler.ExtractParameters(&http.Request{Method: "GET", URL: &url.URL{RawQuery: form.Encode()}})
+	if !helpers.ValidateParameters(params, w) {
+		return
+	}
+	if _, _, err := h.validateRequest(params, responseType); err != nil {
+		if response, ok := err.(*oauth2.ErrorResponse); ok {
+			response.WriteResponse(w, http.StatusBadRequest)
+		} else {
+			http.Error(w, "", http.StatusServiceUnavailable)
+		}
+		return
+	}
+	requestURI, err := h.requests.Push(params)
+	if err != nil {
+		http.Error(w, "", http.StatusServiceUnavailable)
+		return
+	}
+	response := &oauth2.PushedAuthorizationResponse{
+		RequestURI: requestURI,
+		ExpiresIn:  uint(h.requests.lifetime / time.Second),
+	}
+	response.WriteResponse(w, http.StatusCreated)
+}
+
+func writeInvalidRequest(w http.ResponseWriter, description string) {
+	response := &oauth2.ErrorResponse{
+		ErrorCode:   oauth2.ErrorInvalidRequest,
+		Description: description,
+	}
+	response.WriteResponse(w, http.StatusBadRequest)
+}
+
+// authenticateClient returns the authenticated client of a request to an
+// endpoint that requires client authentication, the error response is written
+// if the client is not authenticated.
+func authenticateClient(
+	w http.ResponseWriter, r *http.Request, oauth2Service service.Oauth2Service) (*service.ClientCredentials, bool) {
+
+	clientCredentials, err := util.GetBasicAuth(r)
+	if err != nil {
+		helpers.NewMissingClientCredentialsError().WriteResponse(w, http.StatusUnauthorized)
+		return nil, false
+	}
+	if err := oauth2Service.AuthenticateClient(clientCredentials); err != nil {
+		if response, ok := err.(*oauth2.ErrorResponse); ok {
+			response.WriteResponse(w, http.StatusUnauthorized)
+		} else {
+			http.Error(w, "", http.StatusServiceUnavailable)
+		}
+		return nil, false
+	}
+	return clientCredentials, true
+}
@@ -0,0 +1,184 @@
+package endpoint_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/endpoint"
+	"github.com/arjantop/gopherauth/oauth2/response_mode"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/util"
+)
+
+type parDeps struct {
+	responseType  *ResponseTypeMock
+	oauth2Service *service.Oauth2ServiceMock
+	requests      *endpoint.PushedRequests
+	handler       http.Handler
+}
+
+func makePAREndpointHandler() parDeps {
+	responseType := NewResponseTypeMock()
+	oauth2Service := service.NewOauth2ServiceMock()
+	requests := endpoint.NewPushedRequests(service.NewCryptoTokenGenerator(), time.Minute)
+	return parDeps{
+		responseType:  responseType,
+		oauth2Service: oauth2Service,
+		requests:      requests,
+		handler: endpoint.NewPAREndpointHandler(
+			oauth2Service,
+			map[string]endpoint.ResponseType{"code": responseType},
+			nil,
+			oauth2.ProtectedResources{},
+			response_mode.NewResponseModes(issuer, util.NewTemplateFactory(templateRoot), nil),
+			requests),
+	}
+}
+
+func pushedParams() url.Values {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "client_id")
+	params.Set("redirect_uri", clientURI)
+	params.Set("scope", "scope1")
+	params.Set("state", "state")
+	return params
+}
+
+func newPARRequest(t *testing.T, params url.Values) *http.Request {
+	request := testutil.NewEndpointRequest(t, "POST", "par", params)
+	request.SetBasicAuth("client_id", "secret")
+	return request
+}
+
+var clientCredentials = &service.ClientCredentials{Id: "client_id", Secret: "secret"}
+
+func TestPushedRequestIsStored(t *testing.T) {
+	deps := makePAREndpointHandler()
+	params := pushedParams()
+	deps.oauth2Service.On("AuthenticateClient", clientCredentials).Return(nil)
+	deps.responseType.On("ExtractParameters", mock.Anything).Return(params)
+	deps.oauth2Service.On("ValidateRequest", "client_id", "scope1", clientURI).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newPARRequest(t, params))
+
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	requestURI, _ := response["request_uri"].(string)
+	assert.True(t, strings.HasPrefix(requestURI, endpoint.RequestURIPrefix))
+	assert.Equal(t, float64(60), response["expires_in"])
+
+	assert.Nil(t, deps.requests.Take(requestURI, "other_client"))
+	assert.Equal(t, params, deps.requests.Take(requestURI, "client_id"))
+	assert.Nil(t, deps.requests.Take(requestURI, "client_id"), "Request uri must be single-use")
+	deps.oauth2Service.AssertExpectations(t)
+}
+
+func TestPushedRequestRequiresClientAuthentication(t *testing.T) {
+	deps := makePAREndpointHandler()
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, testutil.NewEndpointRequest(t, "POST", "par", pushedParams()))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	deps.oauth2Service.On("AuthenticateClient", clientCredentials).Return(&oauth2.ErrorResponse{
+		ErrorCode: oauth2.ErrorInvalidClient,
+	})
+	recorder = httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newPARRequest(t, pushedParams()))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), oauth2.ErrorInvalidClient)
+}
+
+func TestPushedRequestOfOtherClientIsRejected(t *testing.T) {
+	deps := makePAREndpointHandler()
+	deps.oauth2Service.On("AuthenticateClient", clientCredentials).Return(nil)
+	params := pushedParams()
+	params.Set("client_id", "other_client")
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newPARRequest(t, params))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), oauth2.ErrorInvalidRequest)
+}
+
+func TestInvalidPushedRequestIsRejected(t *testing.T) {
+	deps := makePAREndpointHandler()
+	params := pushedParams()
+	deps.oauth2Service.On("AuthenticateClient", clientCredentials).Return(nil)
+	deps.responseType.On("ExtractParameters", mock.Anything).Return(params)
+	deps.oauth2Service.On("ValidateRequest", "client_id", "scope1", clientURI).Return(&oauth2.ErrorResponse{
+		ErrorCode: oauth2.ErrorInvalidScope,
+	})
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newPARRequest(t, params))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), oauth2.ErrorInvalidScope)
+}
+
+func TestPushedRequestWithUnsupportedResponseTypeIsRejected(t *testing.T) {
+	deps := makePAREndpointHandler()
+	deps.oauth2Service.On("AuthenticateClient", clientCredentials).Return(nil)
+	params := pushedParams()
+	params.Set("response_type", "token")
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, newPARRequest(t, params))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), oauth2.ErrorUnsupportedResponseType)
+}
+
+func TestAuthEndpointUsesPushedRequest(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	requestURI, err := deps.requests.Push(deps.params)
+	assert.NoError(t, err)
+	deps.responseTypes["type1"].On("ExtractParameters", mock.Anything).Return(deps.params)
+	deps.oauth2Service.On("ValidateRequest", "client_id", "scope1 scope2", clientURI).Return(nil)
+
+	params := url.Values{}
+	params.Set("client_id", "client_id")
+	params.Set("request_uri", requestURI)
+	// Parameters outside of the pushed request are ignored
+	params.Set("scope", "other")
+	request := testutil.NewEndpointRequest(t, "GET", "auth", params)
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	AssertIsRedirectedToLogin(t, recorder, request.URL)
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	assert.NoError(t, err)
+	returnTo, err := url.Parse(location.Query().Get("continue"))
+	assert.NoError(t, err)
+	assert.Equal(t, "scope1 scope2", returnTo.Query().Get("scope"))
+	assert.Empty(t, returnTo.Query().Get("request_uri"))
+	assertAuthEndpointExpectations(t, deps)
+}
+
+func TestAuthEndpointRejectsUnknownRequestURI(t *testing.T) {
+	deps := makeAuthEndpointHandler()
+	requestURI, err := deps.requests.Push(deps.params)
+	assert.NoError(t, err)
+
+	for _, params := range []url.Values{
+		{"client_id": {"client_id"}, "request_uri": {endpoint.RequestURIPrefix + "unknown"}},
+		{"client_id": {"other_client"}, "request_uri": {requestURI}},
+	} {
+		recorder := httptest.NewRecorder()
+		deps.handler.ServeHTTP(recorder, testutil.NewEndpointRequest(t, "GET", "auth", params))
+		assertIsBadRequest(t, recorder)
+		assert.Contains(t, recorder.Body.String(), oauth2.ErrorInvalidRequestURI)
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/dpop"
 	"github.com/arjantop/gopherauth/oauth2/helpers"
 	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/throttle"
 	"github.com/arjantop/gopherauth/util"
 )
 
@@ -17,12 +21,23 @@ type GrantType interface {
 }
 
 type tokenEndpointHandler struct {
-	handlers map[string]GrantType
+	handlers    map[string]GrantType
+	detailTypes oauth2.AuthorizationDetailTypes
+	resources   oauth2.ProtectedResources
+	dpop        *dpop.Validator
 }
 
-func NewTokenEndpointHandler(handlers map[string]GrantType) http.Handler {
+func NewTokenEndpointHandler(
+	handlers map[string]GrantType,
+	detailTypes oauth2.AuthorizationDetailTypes,
+	resources oauth2.ProtectedResources,
+	dpopValidator *dpop.Validator) http.Handler {
+
 	handler := &tokenEndpointHandler{
-		handlers: handlers,
+		handlers:    handlers,
+		detailTypes: detailTypes,
+		resources:   resources,
+		dpop:        dpopValidator,
 	}
 	authMiddleware := util.ClientCredentialsFromFormDataToHeaderMiddleware(handler)
 	noCachingMiddleware := util.NoCachingMiddleware(authMiddleware)
@@ -57,11 +72,31 @@ func (h *tokenEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		if !valid {
 			return
 		}
+		if details := params.Get(oauth2.ParameterAuthorizationDetails); details != "" {
+			if _, err := h.detailTypes.Parse(details); err != nil {
+				err.(*oauth2.ErrorResponse).WriteResponse(w, http.StatusBadRequest)
+				return
+			}
+		}
+		err = h.resources.Validate(params[oauth2.ParameterResource], params.Get(oauth2.ParameterScope))
+		if err != nil {
+			err.(*oauth2.ErrorResponse).WriteResponse(w, http.StatusBadRequest)
+			return
+		}
+		proof, valid := h.validateDPoPProof(w, r)
+		if !valid {
+			return
+		}
+		if proof != nil {
+			params.Set(oauth2.ParameterDPoPJKT, proof.Thumbprint)
+		}
 
 		response, err := handler.Execute(clientCredentials, params)
 		if err != nil {
 			if response, ok := err.(*oauth2.ErrorResponse); ok {
 				response.WriteResponse(w, http.StatusBadRequest)
+			} else if throttled, ok := err.(throttle.Throttled); ok {
+				writeThrottledResponse(w, throttled)
 			} else {
 				http.Error(w, "", http.StatusServiceUnavailable)
 			}
@@ -72,3 +107,43 @@ func (h *tokenEndpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		NewUnsupportedGrantTypeError(grantType).WriteResponse(w, http.StatusBadRequest)
 	}
 }
+
+// writeThrottledResponse rejects a request that was throttled because of
+// previous failed attempts, the client is told when to retry.
+func writeThrottledResponse(w http.ResponseWriter, throttled throttle.Throttled) {
+	seconds := int64((throttled.RetryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	response := &oauth2.ErrorResponse{
+		ErrorCode:   oauth2.ErrorInvalidGrant,
+		Description: "Too many failed attempts, retry later",
+	}
+	response.WriteResponse(w, http.StatusTooManyRequests)
+}
+
+// validateDPoPProof validates the DPoP proof if one was sent and proofs are
+// supported. Requests without a proof are valid and nil proof is returned.
+func (h *tokenEndpointHandler) validateDPoPProof(w http.ResponseWriter, r *http.Request) (*dpop.Proof, bool) {
+	proofs := r.Header.Values(dpop.HeaderDPoP)
+	if h.dpop == nil || len(proofs) == 0 {
+		return nil, true
+	}
+	if len(proofs) > 1 {
+		dpop.NewInvalidProofError("Only one DPoP proof is allowed").WriteResponse(w, http.StatusBadRequest)
+		return nil, false
+	}
+	proof, err := h.dpop.Validate(proofs[0], r.Method, dpop.RequestURI(r), "")
+	if err != nil {
+		response := err.(*oauth2.ErrorResponse)
+		if response.ErrorCode == oauth2.ErrorUseDPoPNonce {
+			nonce, err := h.dpop.NewNonce()
+			if err != nil {
+				http.Error(w, "", http.StatusServiceUnavailable)
+				return nil, false
+			}
+			w.Header().Set(dpop.HeaderDPoPNonce, nonce)
+		}
+		response.WriteResponse(w, http.StatusBadRequest)
+		return nil, false
+	}
+	return proof, true
+}
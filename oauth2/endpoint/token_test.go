@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"time"
 
 	"testing"
 
@@ -15,9 +16,11 @@ import (
 	"github.com/stretchr/testify/mock"
 
 	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/dpop"
 	"github.com/arjantop/gopherauth/oauth2/endpoint"
 	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/throttle"
 )
 
 type GrantTypeMock struct {
@@ -55,7 +58,11 @@ func makeTokenDeps() tokenDeps {
 		handler: endpoint.NewTokenEndpointHandler(map[string]endpoint.GrantType{
 			"type1": type1,
 			"type2": type2,
-		}),
+		}, oauth2.AuthorizationDetailTypes{
+			"detail_type": &oauth2.AuthorizationDetailSchema{},
+		}, oauth2.ProtectedResources{
+			"https://api.example.com/": &oauth2.ProtectedResource{Scopes: []string{"scope1"}},
+		}, dpop.NewValidator(dpop.NewMemoryReplayCache(), nil)),
 	}
 }
 
@@ -71,7 +78,7 @@ func TestTokenEndpointOnlyAcceptsPostHttpMethod(t *testing.T) {
 	httpMethods := []string{"GET", "HEAD", "PUT", "DELETE",
 		"TRACE", "OPTIONS", "CONNECT", "PATCH"}
 	for _, method := range httpMethods {
-		handler := endpoint.NewTokenEndpointHandler(nil)
+		handler := endpoint.NewTokenEndpointHandler(nil, nil, nil, nil)
 		recorder := httptest.NewRecorder()
 
 		request, err := http.NewRequest(method, "", strings.NewReader("body"))
@@ -184,6 +191,31 @@ func TestTokenEndpointServiceError(t *testing.T) {
 	deps.grantTypes["type1"].Mock.AssertExpectations(t)
 }
 
+func TestTokenEndpointThrottledError(t *testing.T) {
+	deps := makeTokenDeps()
+
+	params := makeTokenParameters()
+	request := testutil.NewEndpointRequest(t, "POST", "token", params)
+	request.SetBasicAuth("client_id", "client_secret")
+
+	deps.grantTypes["type1"].On("ExtractParameters", request).Return(params)
+	deps.grantTypes["type1"].On(
+		"Execute",
+		&service.ClientCredentials{"client_id", "client_secret"},
+		params).Return(nil, throttle.Throttled{Scope: throttle.ScopeAccount, RetryAfter: 1500 * time.Millisecond})
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+	testutil.AssertContentTypeJson(t, recorder)
+	var jsonMap map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &jsonMap)
+	assert.Equal(t, oauth2.ErrorInvalidGrant, jsonMap["error"])
+	deps.grantTypes["type1"].Mock.AssertExpectations(t)
+}
+
 func TestTokenEndpointClientCredentialsInFormDataAreInsertedIntoHeader(t *testing.T) {
 	deps := makeTokenDeps()
 
@@ -248,6 +280,130 @@ func TestClientCredentialsErrorOnMissingCredentials(t *testing.T) {
 	assertMissingCredentialsError(t, recorder)
 }
 
+func TestTokenEndpointInvalidAuthorizationDetailsError(t *testing.T) {
+	deps := makeTokenDeps()
+
+	params := makeTokenParameters()
+	params.Add("authorization_details", `[{"type":"unknown_type"}]`)
+	request := testutil.NewEndpointRequest(t, "POST", "token", params)
+	request.SetBasicAuth("client_id", "client_secret")
+
+	deps.grantTypes["type1"].On("ExtractParameters", request).Return(params)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	var jsonMap map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &jsonMap)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, jsonMap["error"])
+	deps.grantTypes["type1"].Mock.AssertExpectations(t)
+}
+
+func TestTokenEndpointUnknownResourceError(t *testing.T) {
+	deps := makeTokenDeps()
+
+	params := makeTokenParameters()
+	params.Add("resource", "https://api.example.com/")
+	params.Add("resource", "https://unknown.example.com/")
+	request := testutil.NewEndpointRequest(t, "POST", "token", params)
+	request.SetBasicAuth("client_id", "client_secret")
+
+	deps.grantTypes["type1"].On("ExtractParameters", request).Return(params)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	var jsonMap map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &jsonMap)
+	assert.Equal(t, oauth2.ErrorInvalidTarget, jsonMap["error"])
+	deps.grantTypes["type1"].Mock.AssertExpectations(t)
+}
+
+func TestTokenEndpointDPoPKeyThumbprintIsPassedToGrantType(t *testing.T) {
+	deps := makeTokenDeps()
+
+	params := makeTokenParameters()
+	request := testutil.NewEndpointRequest(t, "POST", "token", params)
+	request.SetBasicAuth("client_id", "client_secret")
+	key := testutil.NewDPoPKey(t)
+	request.Header.Set("DPoP", testutil.NewDPoPProof(t, key, map[string]interface{}{
+		"htm": "POST",
+		"htu": "https://example.com/token",
+	}))
+
+	response := &oauth2.AccessTokenResponse{
+		AccessToken: "access_token",
+		TokenType:   "DPoP",
+		ExpiresIn:   1200,
+	}
+	boundParams := makeTokenParameters()
+	boundParams.Set("dpop_jkt", testutil.DPoPThumbprint(t, key))
+	deps.grantTypes["type1"].On("ExtractParameters", request).Return(params)
+	deps.grantTypes["type1"].On(
+		"Execute",
+		&service.ClientCredentials{"client_id", "client_secret"},
+		boundParams).Return(response, nil)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	deps.grantTypes["type1"].Mock.AssertExpectations(t)
+}
+
+func TestTokenEndpointInvalidDPoPProofError(t *testing.T) {
+	deps := makeTokenDeps()
+
+	params := makeTokenParameters()
+	request := testutil.NewEndpointRequest(t, "POST", "token", params)
+	request.SetBasicAuth("client_id", "client_secret")
+	request.Header.Set("DPoP", testutil.NewDPoPProof(t, testutil.NewDPoPKey(t), map[string]interface{}{
+		"htm": "GET",
+		"htu": "https://example.com/token",
+	}))
+
+	deps.grantTypes["type1"].On("ExtractParameters", request).Return(params)
+
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	var jsonMap map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &jsonMap)
+	assert.Equal(t, oauth2.ErrorInvalidDPoPProof, jsonMap["error"])
+	deps.grantTypes["type1"].Mock.AssertExpectations(t)
+}
+
+func TestTokenEndpointDPoPNonceIsProvidedWhenRequired(t *testing.T) {
+	grantType := &GrantTypeMock{}
+	handler := endpoint.NewTokenEndpointHandler(
+		map[string]endpoint.GrantType{"type1": grantType}, nil, nil,
+		dpop.NewValidator(
+			dpop.NewMemoryReplayCache(),
+			dpop.NewMACNonceProvider(testutil.NewSecretKeyring("ServerKey"), time.Minute)))
+
+	params := makeTokenParameters()
+	request := testutil.NewEndpointRequest(t, "POST", "token", params)
+	request.SetBasicAuth("client_id", "client_secret")
+	request.Header.Set("DPoP", testutil.NewDPoPProof(t, testutil.NewDPoPKey(t), map[string]interface{}{
+		"htm": "POST",
+		"htu": "https://example.com/token",
+	}))
+
+	grantType.On("ExtractParameters", request).Return(params)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("DPoP-Nonce"))
+	var jsonMap map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &jsonMap)
+	assert.Equal(t, oauth2.ErrorUseDPoPNonce, jsonMap["error"])
+}
+
 func assertResponseValid(
 	t *testing.T,
 	tokenResponse *oauth2.AccessTokenResponse,
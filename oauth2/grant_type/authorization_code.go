@@ -3,20 +3,38 @@ package grant_type
 import (
 	"net/http"
 	"net/url"
+	"slices"
 
 	"github.com/arjantop/gopherauth/oauth2"
 	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/token"
 )
 
+// IDTokenIssuer signs ID tokens, e.g. response_type.IDTokenIssuer.
+type IDTokenIssuer interface {
+	Issue(r *service.AuthorizationRequest, code, accessToken string) (string, error)
+}
+
 type AuthorizationCodeController struct {
 	oauth2Service service.Oauth2Service
+	codes         *token.Codes
+	idTokens      IDTokenIssuer
 }
 
+// NewAuthorizationCodeController returns the authorization code grant. If
+// codes is not nil the code is redeemed from the built-in code store before the
+// service is called with the redeemed code, otherwise the service must verify
+// the code. An ID token is returned with the access token if the openid scope
+// was granted for a code of the store and idTokens is not nil.
 func NewAuthorizationCodeController(
-	oauth2Service service.Oauth2Service) *AuthorizationCodeController {
+	oauth2Service service.Oauth2Service,
+	codes *token.Codes,
+	idTokens IDTokenIssuer) *AuthorizationCodeController {
 
 	return &AuthorizationCodeController{
 		oauth2Service: oauth2Service,
+		codes:         codes,
+		idTokens:      idTokens,
 	}
 }
 
@@ -29,6 +47,10 @@ func (c *AuthorizationCodeController) ExtractParameters(r *http.Request) url.Val
 	params.Add(oauth2.ParameterGrantType, grantType)
 	params.Add(oauth2.ParameterCode, code)
 	params.Add(oauth2.ParameterRedirectUri, redirectURI)
+	if verifier := r.PostFormValue(oauth2.ParameterCodeVerifier); verifier != "" {
+		params.Add(oauth2.ParameterCodeVerifier, verifier)
+	}
+	extractOptionalParameters(r, params)
 
 	return params
 }
@@ -43,5 +65,31 @@ func (c *AuthorizationCodeController) Execute(
 	if err != nil {
 		return nil, err
 	}
-	return c.oauth2Service.AuthorizationCode(clientCredentials, code, redirectURI)
+	tokenRequest, err := makeTokenRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	if c.codes != nil {
+		tokenRequest.Code, err = c.codes.Redeem(clientCredentials.Id, code, redirectURIString,
+			params.Get(oauth2.ParameterCodeVerifier), tokenRequest.DPoPJKT)
+		if err != nil {
+			return nil, err
+		}
+	}
+	response, err := c.oauth2Service.AuthorizationCode(clientCredentials, code, redirectURI, tokenRequest)
+	if err != nil || tokenRequest.Code == nil || c.idTokens == nil ||
+		!slices.Contains(oauth2.ParseScope(tokenRequest.Code.Scope), oauth2.ScopeOpenID) {
+		return response, err
+	}
+	response.IDToken, err = c.idTokens.Issue(&service.AuthorizationRequest{
+		ClientId:  clientCredentials.Id,
+		Scope:     tokenRequest.Code.Scope,
+		Resources: tokenRequest.Code.Resources,
+		Session:   tokenRequest.Code.Session,
+		Nonce:     tokenRequest.Code.Nonce,
+	}, "", response.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
 }
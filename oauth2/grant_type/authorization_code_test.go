@@ -4,13 +4,16 @@ import (
 	"errors"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
 	"github.com/arjantop/gopherauth/oauth2"
 	"github.com/arjantop/gopherauth/oauth2/grant_type"
 	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/token"
 )
 
 func makeAuthCodeParameters() url.Values {
@@ -31,7 +34,7 @@ func makeAuthCodeController() authCodeDeps {
 	oauth2Service := service.NewOauth2ServiceMock()
 	return authCodeDeps{
 		oauth2Service: oauth2Service,
-		controller:    grant_type.NewAuthorizationCodeController(oauth2Service),
+		controller:    grant_type.NewAuthorizationCodeController(oauth2Service, nil, nil),
 		params:        makeAuthCodeParameters(),
 	}
 }
@@ -47,6 +50,30 @@ func TestAuthCodeParametersAreExtracted(t *testing.T) {
 	}
 }
 
+func TestAuthCodeResourcesArePassedToService(t *testing.T) {
+	deps := makeAuthCodeController()
+	deps.params["resource"] = []string{"https://api1.example.com/", "https://api2.example.com/"}
+
+	request := testutil.NewEndpointRequest(t, "POST", "token", deps.params)
+	params := deps.controller.ExtractParameters(request)
+
+	clientCredentials := &service.ClientCredentials{"client_id", "client_secret"}
+	expectedResponse := &oauth2.AccessTokenResponse{}
+	uri, _ := url.Parse(deps.params.Get("redirect_uri"))
+
+	deps.oauth2Service.On(
+		"AuthorizationCode",
+		clientCredentials,
+		deps.params.Get("code"),
+		uri,
+		&service.TokenRequest{Resources: deps.params["resource"]}).Return(expectedResponse, nil)
+
+	response, err := deps.controller.Execute(clientCredentials, params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedResponse, response)
+}
+
 func TestAuthCodeResponseIsReturned(t *testing.T) {
 	deps := makeAuthCodeController()
 
@@ -58,7 +85,8 @@ func TestAuthCodeResponseIsReturned(t *testing.T) {
 		"AuthorizationCode",
 		clientCredentials,
 		deps.params.Get("code"),
-		uri).Return(expectedResponse, nil)
+		uri,
+		&service.TokenRequest{}).Return(expectedResponse, nil)
 
 	response, err := deps.controller.Execute(clientCredentials, deps.params)
 
@@ -76,10 +104,122 @@ func TestAuthCodeServiceErrorIsReturned(t *testing.T) {
 		"AuthorizationCode",
 		clientCredentials,
 		deps.params.Get("code"),
-		uri).Return(nil, errors.New("error"))
+		uri,
+		&service.TokenRequest{}).Return(nil, errors.New("error"))
 
 	response, err := deps.controller.Execute(clientCredentials, deps.params)
 
 	assert.Nil(t, response)
 	assert.Equal(t, errors.New("error"), err)
 }
+
+func makeCodes(t *testing.T, redirectURI, scope string) (*token.Codes, string) {
+	codes := token.NewCodes(token.NewManager(token.NewMemoryStore(), service.NewCryptoTokenGenerator()), time.Minute)
+	uri, _ := url.Parse(redirectURI)
+	code, err := codes.Issue(&service.AuthorizationRequest{
+		ClientId:            "client_id",
+		RedirectURI:         uri,
+		Scope:               scope,
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+		Session:             &service.Session{Subject: "user", Sid: "sid"},
+		Nonce:               "nonce",
+	})
+	assert.Nil(t, err)
+	return codes, code
+}
+
+func TestAuthCodeIsRedeemedFromCodeStore(t *testing.T) {
+	deps := makeAuthCodeController()
+	codes, code := makeCodes(t, deps.params.Get("redirect_uri"), "scope1")
+	controller := grant_type.NewAuthorizationCodeController(deps.oauth2Service, codes, nil)
+	deps.params.Set("code", code)
+	deps.params.Set("code_verifier", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+
+	request := testutil.NewEndpointRequest(t, "POST", "token", deps.params)
+	params := controller.ExtractParameters(request)
+	assert.Equal(t, deps.params.Get("code_verifier"), params.Get("code_verifier"))
+
+	clientCredentials := &service.ClientCredentials{"client_id", "client_secret"}
+	expectedResponse := &oauth2.AccessTokenResponse{}
+	uri, _ := url.Parse(deps.params.Get("redirect_uri"))
+	deps.oauth2Service.On(
+		"AuthorizationCode",
+		clientCredentials,
+		code,
+		uri,
+		mock.MatchedBy(func(tr *service.TokenRequest) bool {
+			return tr.Code != nil && tr.Code.Subject == "user" && tr.Code.Scope == "scope1" && tr.Code.FamilyId != ""
+		})).Return(expectedResponse, nil)
+
+	response, err := controller.Execute(clientCredentials, params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedResponse, response)
+}
+
+func TestAuthCodeRejectedByCodeStoreIsNotPassedToService(t *testing.T) {
+	deps := makeAuthCodeController()
+	codes, code := makeCodes(t, deps.params.Get("redirect_uri"), "scope1")
+	controller := grant_type.NewAuthorizationCodeController(deps.oauth2Service, codes, nil)
+	deps.params.Set("code", code)
+
+	clientCredentials := &service.ClientCredentials{"client_id", "client_secret"}
+	response, err := controller.Execute(clientCredentials, deps.params)
+
+	assert.Nil(t, response)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, oauth2.ErrorInvalidGrant, err.(*oauth2.ErrorResponse).ErrorCode)
+	}
+	deps.oauth2Service.AssertNotCalled(t, "AuthorizationCode")
+}
+
+// idTokenIssuer records the requests ID tokens are issued for.
+type idTokenIssuer struct {
+	requests     []*service.AuthorizationRequest
+	accessTokens []string
+}
+
+func (i *idTokenIssuer) Issue(r *service.AuthorizationRequest, code, accessToken string) (string, error) {
+	i.requests = append(i.requests, r)
+	i.accessTokens = append(i.accessTokens, accessToken)
+	return "id-token", nil
+}
+
+func executeWithIDTokens(t *testing.T, scope string) (*oauth2.AccessTokenResponse, *idTokenIssuer) {
+	deps := makeAuthCodeController()
+	codes, code := makeCodes(t, deps.params.Get("redirect_uri"), scope)
+	idTokens := &idTokenIssuer{}
+	controller := grant_type.NewAuthorizationCodeController(deps.oauth2Service, codes, idTokens)
+	deps.params.Set("code", code)
+	deps.params.Set("code_verifier", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+
+	clientCredentials := &service.ClientCredentials{Id: "client_id", Secret: "client_secret"}
+	deps.oauth2Service.On("AuthorizationCode", clientCredentials, code, mock.Anything, mock.Anything).
+		Return(&oauth2.AccessTokenResponse{AccessToken: "access-token"}, nil)
+
+	response, err := controller.Execute(clientCredentials, deps.params)
+	assert.Nil(t, err)
+	return response, idTokens
+}
+
+func TestAuthCodeReturnsIDTokenIfOpenIDScopeIsGranted(t *testing.T) {
+	response, idTokens := executeWithIDTokens(t, "openid scope1")
+
+	assert.Equal(t, "id-token", response.IDToken)
+	if assert.Len(t, idTokens.requests, 1) {
+		request := idTokens.requests[0]
+		assert.Equal(t, "client_id", request.ClientId)
+		assert.Equal(t, "nonce", request.Nonce)
+		assert.Equal(t, "user", request.Session.Subject)
+		assert.Equal(t, "sid", request.Session.Sid)
+		assert.Equal(t, []string{"access-token"}, idTokens.accessTokens)
+	}
+}
+
+func TestAuthCodeReturnsNoIDTokenWithoutOpenIDScope(t *testing.T) {
+	response, idTokens := executeWithIDTokens(t, "scope1")
+
+	assert.Empty(t, response.IDToken)
+	assert.Empty(t, idTokens.requests)
+}
@@ -0,0 +1,47 @@
+package grant_type
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+)
+
+type ClientCredentialsController struct {
+	oauth2Service service.Oauth2Service
+}
+
+// NewClientCredentialsController returns the client credentials grant, clients
+// obtain tokens for themselves with their own credentials.
+func NewClientCredentialsController(oauth2Service service.Oauth2Service) *ClientCredentialsController {
+	return &ClientCredentialsController{
+		oauth2Service: oauth2Service,
+	}
+}
+
+func (c *ClientCredentialsController) ExtractParameters(r *http.Request) url.Values {
+	grantType := r.PostFormValue(oauth2.ParameterGrantType)
+
+	params := url.Values{}
+	params.Add(oauth2.ParameterGrantType, grantType)
+	if scope := r.PostFormValue(oauth2.ParameterScope); scope != "" {
+		params.Add(oauth2.ParameterScope, scope)
+	}
+	extractOptionalParameters(r, params)
+
+	return params
+}
+
+func (c *ClientCredentialsController) Execute(
+	clientCredentials *service.ClientCredentials,
+	params url.Values) (*oauth2.AccessTokenResponse, error) {
+
+	scope := params.Get(oauth2.ParameterScope)
+	tokenRequest, err := makeTokenRequest(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.oauth2Service.ClientCredentials(clientCredentials, scope, tokenRequest)
+}
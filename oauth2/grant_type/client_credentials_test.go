@@ -0,0 +1,81 @@
+package grant_type_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/grant_type"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+)
+
+func makeClientCredentialsParameters() url.Values {
+	return map[string][]string{
+		"grant_type": []string{"client_credentials"},
+		"scope":      []string{"scope1"},
+	}
+}
+
+type clientCredentialsDeps struct {
+	oauth2Service *service.Oauth2ServiceMock
+	controller    *grant_type.ClientCredentialsController
+	params        url.Values
+}
+
+func makeClientCredentialsController() clientCredentialsDeps {
+	oauth2Service := service.NewOauth2ServiceMock()
+	return clientCredentialsDeps{
+		oauth2Service: oauth2Service,
+		controller:    grant_type.NewClientCredentialsController(oauth2Service),
+		params:        makeClientCredentialsParameters(),
+	}
+}
+
+func TestClientCredentialsParametersAreExtracted(t *testing.T) {
+	deps := makeClientCredentialsController()
+	deps.params.Add("resource", "https://api.example.com/")
+
+	request := testutil.NewEndpointRequest(t, "POST", "token", deps.params)
+	params := deps.controller.ExtractParameters(request)
+	assert.Equal(t, deps.params, params)
+}
+
+func TestClientCredentialsTokenIsIssuedToClient(t *testing.T) {
+	deps := makeClientCredentialsController()
+	deps.params.Add("resource", "https://api.example.com/")
+
+	clientCredentials := &service.ClientCredentials{Id: "client_id", Secret: "client_secret"}
+	expectedResponse := &oauth2.AccessTokenResponse{}
+
+	deps.oauth2Service.On(
+		"ClientCredentials",
+		clientCredentials,
+		"scope1",
+		&service.TokenRequest{Resources: []string{"https://api.example.com/"}}).Return(expectedResponse, nil)
+
+	response, err := deps.controller.Execute(clientCredentials, deps.params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedResponse, response)
+}
+
+func TestClientCredentialsServiceErrorIsReturned(t *testing.T) {
+	deps := makeClientCredentialsController()
+
+	clientCredentials := &service.ClientCredentials{Id: "client_id", Secret: "wrong"}
+
+	deps.oauth2Service.On(
+		"ClientCredentials",
+		clientCredentials,
+		"scope1",
+		&service.TokenRequest{}).Return(nil, errors.New("error"))
+
+	response, err := deps.controller.Execute(clientCredentials, deps.params)
+
+	assert.Nil(t, response)
+	assert.Equal(t, errors.New("error"), err)
+}
@@ -0,0 +1,33 @@
+package grant_type
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+)
+
+// extractOptionalParameters adds optional token request parameters to params
+// only if they are present in the request.
+func extractOptionalParameters(r *http.Request, params url.Values) {
+	if details := r.PostFormValue(oauth2.ParameterAuthorizationDetails); details != "" {
+		params.Add(oauth2.ParameterAuthorizationDetails, details)
+	}
+	// Resource parameter can be present multiple times
+	for _, resource := range r.PostForm[oauth2.ParameterResource] {
+		params.Add(oauth2.ParameterResource, resource)
+	}
+}
+
+func makeTokenRequest(params url.Values) (*service.TokenRequest, error) {
+	details, err := oauth2.ParseAuthorizationDetails(params.Get(oauth2.ParameterAuthorizationDetails))
+	if err != nil {
+		return nil, err
+	}
+	return &service.TokenRequest{
+		AuthorizationDetails: details,
+		Resources:            params[oauth2.ParameterResource],
+		DPoPJKT:              params.Get(oauth2.ParameterDPoPJKT),
+	}, nil
+}
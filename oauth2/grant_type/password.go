@@ -3,18 +3,29 @@ package grant_type
 import (
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/arjantop/gopherauth/oauth2"
 	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/throttle"
+	"github.com/arjantop/gopherauth/util"
 )
 
+// IP address of the client, it is not a request parameter
+const parameterRemoteIP = "remote_ip"
+
 type PasswordController struct {
 	oauth2Service service.Oauth2Service
+	throttler     *throttle.Throttler
 }
 
-func NewPasswordController(oauth2Service service.Oauth2Service) *PasswordController {
+// NewPasswordController returns the password grant. Failed attempts are
+// throttled per username, IP address and client, nil throttler disables it.
+// The service must return invalid_grant if the credentials are not valid.
+func NewPasswordController(oauth2Service service.Oauth2Service, throttler *throttle.Throttler) *PasswordController {
 	return &PasswordController{
 		oauth2Service: oauth2Service,
+		throttler:     throttler,
 	}
 }
 
@@ -27,16 +38,49 @@ func (c *PasswordController) ExtractParameters(r *http.Request) url.Values {
 	params.Add(oauth2.ParameterGrantType, grantType)
 	params.Add(oauth2.ParameterUsername, username)
 	params.Add(oauth2.ParameterPassword, password)
+	if ip := util.RemoteIP(r); ip != "" {
+		params.Add(parameterRemoteIP, ip)
+	}
+	extractOptionalParameters(r, params)
 
 	return params
 }
 
+// Execute returns throttle.Throttled if the attempt is rejected because of
+// previous failures.
 func (c *PasswordController) Execute(
 	clientCredentials *service.ClientCredentials,
 	params url.Values) (*oauth2.AccessTokenResponse, error) {
 
 	username := params.Get(oauth2.ParameterUsername)
 	password := params.Get(oauth2.ParameterPassword)
+	tokenRequest, err := makeTokenRequest(params)
+	if err != nil {
+		return nil, err
+	}
 
-	return c.oauth2Service.Password(clientCredentials, username, password)
+	if c.throttler == nil {
+		return c.oauth2Service.Password(clientCredentials, username, password, tokenRequest)
+	}
+	attempt := throttle.Attempt{
+		Account: username,
+		IP:      params.Get(parameterRemoteIP),
+		Client:  clientCredentials.Id,
+	}
+	if err := c.throttler.Attempt(attempt, time.Now()); err != nil {
+		return nil, err
+	}
+	response, err := c.oauth2Service.Password(clientCredentials, username, password, tokenRequest)
+	if e, ok := err.(*oauth2.ErrorResponse); ok && e.ErrorCode == oauth2.ErrorInvalidGrant {
+		if err := c.throttler.Failure(attempt, time.Now()); err != nil {
+			return nil, err
+		}
+	} else if err == nil {
+		if err := c.throttler.Success(attempt); err != nil {
+			return nil, err
+		}
+	} else if err := c.throttler.Cancel(attempt); err != nil {
+		return nil, err
+	}
+	return response, err
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/arjantop/gopherauth/oauth2/grant_type"
 	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/throttle"
 )
 
 const (
@@ -35,7 +37,7 @@ func makePasswordController() passwordDeps {
 	oauth2Service := service.NewOauth2ServiceMock()
 	return passwordDeps{
 		oauth2Service: oauth2Service,
-		controller:    grant_type.NewPasswordController(oauth2Service),
+		controller:    grant_type.NewPasswordController(oauth2Service, nil),
 		params:        makePasswordParameters(),
 	}
 }
@@ -61,7 +63,8 @@ func TestPasswordResponseIsReturned(t *testing.T) {
 		"Password",
 		clientCredentials,
 		"user",
-		"pass").Return(expectedResponse, nil)
+		"pass",
+		&service.TokenRequest{}).Return(expectedResponse, nil)
 
 	response, err := deps.controller.Execute(clientCredentials, deps.params)
 
@@ -78,10 +81,110 @@ func TestPasswordServiceErrorIsReturned(t *testing.T) {
 		"Password",
 		clientCredentials,
 		"user",
-		"pass").Return(nil, errors.New("error"))
+		"pass",
+		&service.TokenRequest{}).Return(nil, errors.New("error"))
 
 	response, err := deps.controller.Execute(clientCredentials, deps.params)
 
 	assert.Nil(t, response)
 	assert.Equal(t, errors.New("error"), err)
 }
+
+func TestPasswordAuthorizationDetailsArePassedToService(t *testing.T) {
+	deps := makePasswordController()
+	deps.params.Set("authorization_details", `[{"type":"account_information"}]`)
+
+	clientCredentials := &service.ClientCredentials{"client_id", "client_secret"}
+	expectedResponse := &oauth2.AccessTokenResponse{}
+
+	deps.oauth2Service.On(
+		"Password",
+		clientCredentials,
+		"user",
+		"pass",
+		&service.TokenRequest{
+			AuthorizationDetails: []oauth2.AuthorizationDetail{
+				oauth2.AuthorizationDetail{"type": "account_information"},
+			},
+		}).Return(expectedResponse, nil)
+
+	response, err := deps.controller.Execute(clientCredentials, deps.params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedResponse, response)
+}
+
+func TestPasswordMalformedAuthorizationDetailsError(t *testing.T) {
+	deps := makePasswordController()
+	deps.params.Set("authorization_details", `{"type":"account_information"}`)
+
+	clientCredentials := &service.ClientCredentials{"client_id", "client_secret"}
+
+	response, err := deps.controller.Execute(clientCredentials, deps.params)
+
+	assert.Nil(t, response)
+	assert.Equal(t, oauth2.ErrorInvalidAuthorizationDetails, err.Error())
+}
+
+func makeThrottledPasswordController() passwordDeps {
+	deps := makePasswordController()
+	throttler := throttle.NewThrottler(throttle.NewMemoryStore(), map[string]throttle.Policy{
+		throttle.ScopeAccount: throttle.Policy{FreeFailures: 1, BaseDelay: time.Minute},
+	}, nil)
+	deps.controller = grant_type.NewPasswordController(deps.oauth2Service, throttler)
+	return deps
+}
+
+func TestPasswordFailedAttemptsAreThrottled(t *testing.T) {
+	deps := makeThrottledPasswordController()
+
+	clientCredentials := &service.ClientCredentials{"client_id", "client_secret"}
+	invalidGrant := &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidGrant}
+	deps.oauth2Service.On(
+		"Password",
+		clientCredentials,
+		"user",
+		"pass",
+		&service.TokenRequest{}).Return(nil, invalidGrant).Twice()
+
+	for i := 0; i < 2; i++ {
+		_, err := deps.controller.Execute(clientCredentials, deps.params)
+		assert.Equal(t, invalidGrant, err)
+	}
+	_, err := deps.controller.Execute(clientCredentials, deps.params)
+
+	throttled, ok := err.(throttle.Throttled)
+	assert.True(t, ok, "Attempt must be throttled: %v", err)
+	assert.Equal(t, throttle.ScopeAccount, throttled.Scope)
+	assert.True(t, throttled.RetryAfter > 0 && throttled.RetryAfter <= time.Minute)
+	deps.oauth2Service.Mock.AssertExpectations(t)
+}
+
+func TestPasswordSuccessfulAttemptResetsThrottling(t *testing.T) {
+	deps := makeThrottledPasswordController()
+
+	clientCredentials := &service.ClientCredentials{"client_id", "client_secret"}
+	invalidGrant := &oauth2.ErrorResponse{ErrorCode: oauth2.ErrorInvalidGrant}
+	expectedResponse := &oauth2.AccessTokenResponse{}
+	for _, err := range []error{invalidGrant, nil, invalidGrant, nil} {
+		response := expectedResponse
+		if err != nil {
+			response = nil
+		}
+		deps.oauth2Service.On(
+			"Password",
+			clientCredentials,
+			"user",
+			"pass",
+			&service.TokenRequest{}).Return(response, err).Once()
+	}
+
+	for i := 0; i < 3; i++ {
+		deps.controller.Execute(clientCredentials, deps.params)
+	}
+	response, err := deps.controller.Execute(clientCredentials, deps.params)
+
+	assert.Nil(t, err, "Failures before the successful attempt are forgotten")
+	assert.Equal(t, expectedResponse, response)
+	deps.oauth2Service.Mock.AssertExpectations(t)
+}
@@ -0,0 +1,48 @@
+package grant_type
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+)
+
+type RefreshTokenController struct {
+	oauth2Service service.Oauth2Service
+}
+
+func NewRefreshTokenController(oauth2Service service.Oauth2Service) *RefreshTokenController {
+	return &RefreshTokenController{
+		oauth2Service: oauth2Service,
+	}
+}
+
+func (c *RefreshTokenController) ExtractParameters(r *http.Request) url.Values {
+	grantType := r.PostFormValue(oauth2.ParameterGrantType)
+	refreshToken := r.PostFormValue(oauth2.ParameterRefreshToken)
+
+	params := url.Values{}
+	params.Add(oauth2.ParameterGrantType, grantType)
+	params.Add(oauth2.ParameterRefreshToken, refreshToken)
+	if scope := r.PostFormValue(oauth2.ParameterScope); scope != "" {
+		params.Add(oauth2.ParameterScope, scope)
+	}
+	extractOptionalParameters(r, params)
+
+	return params
+}
+
+func (c *RefreshTokenController) Execute(
+	clientCredentials *service.ClientCredentials,
+	params url.Values) (*oauth2.AccessTokenResponse, error) {
+
+	refreshToken := params.Get(oauth2.ParameterRefreshToken)
+	scope := params.Get(oauth2.ParameterScope)
+	tokenRequest, err := makeTokenRequest(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.oauth2Service.RefreshToken(clientCredentials, refreshToken, scope, tokenRequest)
+}
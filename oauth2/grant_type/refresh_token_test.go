@@ -0,0 +1,86 @@
+package grant_type_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/grant_type"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+)
+
+func makeRefreshTokenParameters() url.Values {
+	return map[string][]string{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{"refresh"},
+		"scope":         []string{"scope1"},
+	}
+}
+
+type refreshTokenDeps struct {
+	oauth2Service *service.Oauth2ServiceMock
+	controller    *grant_type.RefreshTokenController
+	params        url.Values
+}
+
+func makeRefreshTokenController() refreshTokenDeps {
+	oauth2Service := service.NewOauth2ServiceMock()
+	return refreshTokenDeps{
+		oauth2Service: oauth2Service,
+		controller:    grant_type.NewRefreshTokenController(oauth2Service),
+		params:        makeRefreshTokenParameters(),
+	}
+}
+
+func TestRefreshTokenParametersAreExtracted(t *testing.T) {
+	deps := makeRefreshTokenController()
+
+	request := testutil.NewEndpointRequest(t, "POST", "token", deps.params)
+	params := deps.controller.ExtractParameters(request)
+	for paramName, _ := range deps.params {
+		assert.Equal(t, deps.params.Get(paramName), params.Get(paramName),
+			"Parameter: %s", paramName)
+	}
+}
+
+func TestRefreshTokenDPoPKeyIsPassedToService(t *testing.T) {
+	deps := makeRefreshTokenController()
+	deps.params.Set("dpop_jkt", "thumbprint")
+
+	clientCredentials := &service.ClientCredentials{"client_id", "client_secret"}
+	expectedResponse := &oauth2.AccessTokenResponse{}
+
+	deps.oauth2Service.On(
+		"RefreshToken",
+		clientCredentials,
+		"refresh",
+		"scope1",
+		&service.TokenRequest{DPoPJKT: "thumbprint"}).Return(expectedResponse, nil)
+
+	response, err := deps.controller.Execute(clientCredentials, deps.params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedResponse, response)
+}
+
+func TestRefreshTokenServiceErrorIsReturned(t *testing.T) {
+	deps := makeRefreshTokenController()
+
+	clientCredentials := &service.ClientCredentials{"client_id", "client_secret"}
+
+	deps.oauth2Service.On(
+		"RefreshToken",
+		clientCredentials,
+		"refresh",
+		"scope1",
+		&service.TokenRequest{}).Return(nil, errors.New("error"))
+
+	response, err := deps.controller.Execute(clientCredentials, deps.params)
+
+	assert.Nil(t, response)
+	assert.Equal(t, errors.New("error"), err)
+}
@@ -1,19 +1,44 @@
 package oauth2
 
 const (
-	ParameterResponseType = "response_type"
-	ParameterGrantType    = "grant_type"
-	ParameterClientId     = "client_id"
-	ParameterRedirectUri  = "redirect_uri"
-	ParameterScope        = "scope"
-	ParameterState        = "state"
-	ParameterCode         = "code"
-	ParameterUsername     = "username"
-	ParameterPassword     = "password"
+	ParameterResponseType          = "response_type"
+	ParameterGrantType             = "grant_type"
+	ParameterClientId              = "client_id"
+	ParameterRedirectUri           = "redirect_uri"
+	ParameterScope                 = "scope"
+	ParameterState                 = "state"
+	ParameterCode                  = "code"
+	ParameterUsername              = "username"
+	ParameterPassword              = "password"
+	ParameterAuthorizationDetails  = "authorization_details"
+	ParameterResource              = "resource"
+	ParameterRefreshToken          = "refresh_token"
+	ParameterDPoPJKT               = "dpop_jkt"
+	ParameterResponseMode          = "response_mode"
+	ParameterResponse              = "response"
+	ParameterIssuer                = "iss"
+	ParameterNonce                 = "nonce"
+	ParameterIDToken               = "id_token"
+	ParameterAccessToken           = "access_token"
+	ParameterPrompt                = "prompt"
+	ParameterMaxAge                = "max_age"
+	ParameterLoginHint             = "login_hint"
+	ParameterACRValues             = "acr_values"
+	ParameterIDTokenHint           = "id_token_hint"
+	ParameterPostLogoutRedirectUri = "post_logout_redirect_uri"
+	ParameterCodeChallenge         = "code_challenge"
+	ParameterCodeChallengeMethod   = "code_challenge_method"
+	ParameterCodeVerifier          = "code_verifier"
+	ParameterRequestURI            = "request_uri"
+	ParameterToken                 = "token"
+	ParameterTokenTypeHint         = "token_type_hint"
 
-	ResponseTypeCode  = "code"
-	ResponseTypeToken = "token"
+	ResponseTypeCode    = "code"
+	ResponseTypeToken   = "token"
+	ResponseTypeIDToken = "id_token"
 
 	GrantTypePassword          = "password"
 	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
 )
@@ -0,0 +1,51 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// Only the S256 method is supported, plain does not protect the code if the
+// authorization request is observed
+const CodeChallengeMethodS256 = "S256"
+
+// ValidateCodeChallenge validates the PKCE parameters of an authorization
+// request as defined in RFC 7636. Both parameters are optional, the method
+// requires a challenge.
+func ValidateCodeChallenge(challenge, method string) error {
+	if challenge == "" {
+		if method != "" {
+			return NewInvalidRequestError("Parameter code_challenge_method requires code_challenge")
+		}
+		return nil
+	}
+	if method != CodeChallengeMethodS256 {
+		return NewInvalidRequestError("Parameter code_challenge_method must be S256")
+	}
+	if decoded, err := base64.RawURLEncoding.DecodeString(challenge); err != nil || len(decoded) != sha256.Size {
+		return NewInvalidRequestError("Parameter code_challenge must be a base64url encoded SHA-256 hash")
+	}
+	return nil
+}
+
+// VerifyCodeVerifier reports whether the code verifier matches the S256 code
+// challenge. Verifiers must be 43 to 128 unreserved characters.
+func VerifyCodeVerifier(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		if !isUnreserved(c) {
+			return false
+		}
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func isUnreserved(c rune) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,51 @@
+package oauth2_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// Example from RFC 7636 appendix B
+const (
+	codeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	codeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestCodeChallengeIsOptional(t *testing.T) {
+	assert.Nil(t, oauth2.ValidateCodeChallenge("", ""))
+}
+
+func TestS256CodeChallengeIsValid(t *testing.T) {
+	assert.Nil(t, oauth2.ValidateCodeChallenge(codeChallenge, "S256"))
+}
+
+func TestInvalidCodeChallengesAreRejected(t *testing.T) {
+	for _, params := range [][2]string{
+		{"", "S256"},
+		{codeChallenge, ""},
+		{codeChallenge, "plain"},
+		{"short", "S256"},
+		{strings.Repeat("+", 43), "S256"},
+	} {
+		err := oauth2.ValidateCodeChallenge(params[0], params[1])
+		if assert.NotNil(t, err, "%v", params) {
+			assert.Equal(t, oauth2.ErrorInvalidRequest, err.(*oauth2.ErrorResponse).ErrorCode)
+		}
+	}
+}
+
+func TestCodeVerifierMatchesChallenge(t *testing.T) {
+	assert.True(t, oauth2.VerifyCodeVerifier(codeVerifier, codeChallenge))
+	assert.False(t, oauth2.VerifyCodeVerifier(codeVerifier[1:]+"a", codeChallenge))
+}
+
+func TestMalformedCodeVerifiersAreRejected(t *testing.T) {
+	assert.False(t, oauth2.VerifyCodeVerifier("", codeChallenge))
+	assert.False(t, oauth2.VerifyCodeVerifier(strings.Repeat("a", 42), codeChallenge))
+	assert.False(t, oauth2.VerifyCodeVerifier(strings.Repeat("a", 129), codeChallenge))
+	assert.False(t, oauth2.VerifyCodeVerifier(strings.Repeat("a", 42)+"/", codeChallenge))
+}
@@ -0,0 +1,55 @@
+package oauth2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	PromptNone          = "none"
+	PromptLogin         = "login"
+	PromptConsent       = "consent"
+	PromptSelectAccount = "select_account"
+)
+
+// ParsePrompt parses the space separated prompt parameter of an OpenID Connect
+// authentication request. Value none must not be combined with other values.
+func ParsePrompt(prompt string) ([]string, error) {
+	values := make([]string, 0)
+	for _, value := range strings.Split(prompt, " ") {
+		switch value {
+		case "":
+			continue
+		case PromptNone, PromptLogin, PromptConsent, PromptSelectAccount:
+			values = append(values, value)
+		default:
+			return nil, NewInvalidRequestError(fmt.Sprintf("Unsupported prompt value: %s", value))
+		}
+	}
+	if len(values) > 1 && containsValue(values, PromptNone) {
+		return nil, NewInvalidRequestError("Value none of parameter prompt must not be combined with other values")
+	}
+	return values, nil
+}
+
+// ParseMaxAge parses the max_age parameter, the allowable elapsed time in seconds
+// since the user last authenticated. The second return value is false if the
+// parameter is not present.
+func ParseMaxAge(maxAge string) (int64, bool, error) {
+	if maxAge == "" {
+		return 0, false, nil
+	}
+	seconds, err := strconv.ParseInt(maxAge, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false, NewInvalidRequestError("Parameter max_age must be a non-negative integer")
+	}
+	return seconds, true, nil
+}
+
+func NewInvalidRequestError(description string) *ErrorResponse {
+	return &ErrorResponse{
+		ErrorCode:   ErrorInvalidRequest,
+		Description: description,
+	}
+}
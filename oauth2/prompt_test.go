@@ -0,0 +1,39 @@
+package oauth2_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+func TestPromptIsParsed(t *testing.T) {
+	prompt, err := oauth2.ParsePrompt("login  consent")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"login", "consent"}, prompt)
+}
+
+func TestPromptNoneMustNotBeCombined(t *testing.T) {
+	_, err := oauth2.ParsePrompt("none login")
+	assert.Equal(t, oauth2.ErrorInvalidRequest, err.(*oauth2.ErrorResponse).ErrorCode)
+}
+
+func TestUnsupportedPromptIsRejected(t *testing.T) {
+	_, err := oauth2.ParsePrompt("unknown")
+	assert.NotNil(t, err)
+}
+
+func TestMaxAgeIsParsed(t *testing.T) {
+	maxAge, ok, err := oauth2.ParseMaxAge("300")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(300), maxAge)
+
+	_, ok, err = oauth2.ParseMaxAge("")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, _, err = oauth2.ParseMaxAge("-1")
+	assert.NotNil(t, err)
+}
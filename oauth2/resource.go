@@ -0,0 +1,83 @@
+package oauth2
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// ProtectedResource describes an API that access tokens can be issued for.
+type ProtectedResource struct {
+	// Scopes that may be granted for this resource
+	Scopes []string
+}
+
+func (r *ProtectedResource) allowsScope(scope string) bool {
+	for _, s := range r.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ProtectedResources is a registry of protected APIs keyed by their resource
+// indicator as defined in RFC 8707.
+type ProtectedResources map[string]*ProtectedResource
+
+// Validate checks that every requested resource is a registered absolute URI
+// without a fragment and that every requested scope is allowed by at least one
+// of the requested resources. If no resources are requested no checks are made.
+func (p ProtectedResources) Validate(resources []string, scope string) error {
+	if len(resources) == 0 {
+		return nil
+	}
+	for _, resource := range resources {
+		uri, err := url.Parse(resource)
+		if err != nil || !uri.IsAbs() || uri.Fragment != "" {
+			return NewInvalidTargetError(fmt.Sprintf("Invalid resource: %s", resource))
+		}
+		if _, ok := p[resource]; !ok {
+			return NewInvalidTargetError(fmt.Sprintf("Unknown resource: %s", resource))
+		}
+	}
+	for _, s := range ParseScope(scope) {
+		allowed := false
+		for _, resource := range resources {
+			if p[resource].allowsScope(s) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &ErrorResponse{
+				ErrorCode:   ErrorInvalidScope,
+				Description: fmt.Sprintf("Scope %s is not allowed for the requested resources", s),
+			}
+		}
+	}
+	return nil
+}
+
+// RestrictResources returns the resources of a token issued from a grant, e.g.
+// a refresh token or an authorization code, for the requested resources. The
+// requested resources must be a subset of the granted ones, the granted
+// resources are returned if none are requested.
+func RestrictResources(requested, granted []string) ([]string, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+	for _, resource := range requested {
+		if !slices.Contains(granted, resource) {
+			return nil, NewInvalidTargetError(fmt.Sprintf("Resource was not granted: %s", resource))
+		}
+	}
+	return requested, nil
+}
+
+func NewInvalidTargetError(description string) *ErrorResponse {
+	return &ErrorResponse{
+		ErrorCode:   ErrorInvalidTarget,
+		Description: description,
+	}
+}
@@ -0,0 +1,65 @@
+package oauth2_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+func makeProtectedResources() oauth2.ProtectedResources {
+	return oauth2.ProtectedResources{
+		"https://billing.example.com/": &oauth2.ProtectedResource{Scopes: []string{"billing"}},
+		"https://profile.example.com/": &oauth2.ProtectedResource{Scopes: []string{"profile"}},
+	}
+}
+
+func TestNoResourcesAreValid(t *testing.T) {
+	assert.Nil(t, makeProtectedResources().Validate(nil, "anything"))
+}
+
+func TestMultipleResourcesAreValid(t *testing.T) {
+	err := makeProtectedResources().Validate(
+		[]string{"https://billing.example.com/", "https://profile.example.com/"}, "billing profile")
+	assert.Nil(t, err)
+}
+
+func TestRelativeResourceIsInvalid(t *testing.T) {
+	err := makeProtectedResources().Validate([]string{"/billing"}, "")
+	assert.Equal(t, oauth2.ErrorInvalidTarget, err.Error())
+}
+
+func TestResourceWithFragmentIsInvalid(t *testing.T) {
+	err := makeProtectedResources().Validate([]string{"https://billing.example.com/#x"}, "")
+	assert.Equal(t, oauth2.ErrorInvalidTarget, err.Error())
+}
+
+func TestUnknownResourceIsInvalid(t *testing.T) {
+	err := makeProtectedResources().Validate([]string{"https://other.example.com/"}, "")
+	assert.Equal(t, oauth2.ErrorInvalidTarget, err.Error())
+}
+
+func TestScopeNotAllowedForResourceIsInvalid(t *testing.T) {
+	err := makeProtectedResources().Validate([]string{"https://billing.example.com/"}, "billing profile")
+	assert.Equal(t, oauth2.ErrorInvalidScope, err.Error())
+}
+
+func TestGrantedResourcesAreUsedIfNoneAreRequested(t *testing.T) {
+	resources, err := oauth2.RestrictResources(nil, []string{"https://billing.example.com/"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://billing.example.com/"}, resources)
+}
+
+func TestRequestedResourcesMustBeGranted(t *testing.T) {
+	granted := []string{"https://billing.example.com/", "https://profile.example.com/"}
+	resources, err := oauth2.RestrictResources([]string{"https://profile.example.com/"}, granted)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://profile.example.com/"}, resources)
+
+	_, err = oauth2.RestrictResources([]string{"https://other.example.com/"}, granted)
+	assert.Equal(t, oauth2.ErrorInvalidTarget, err.Error())
+	// Grants without resources are for the default audience only
+	_, err = oauth2.RestrictResources([]string{"https://billing.example.com/"}, nil)
+	assert.Equal(t, oauth2.ErrorInvalidTarget, err.Error())
+}
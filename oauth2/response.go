@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"strconv"
 )
 
 const (
@@ -16,6 +17,22 @@ const (
 	ErrorTemporarilyUnavaliable  = "temporarily_unavailable"
 	ErrorUnsupportedGrantType    = "unsupported_grant_type"
 	ErrorInvalidClient           = "invalid_client"
+
+	ErrorInvalidAuthorizationDetails = "invalid_authorization_details"
+	ErrorInvalidTarget               = "invalid_target"
+	ErrorInvalidGrant                = "invalid_grant"
+	ErrorInvalidToken                = "invalid_token"
+	ErrorInvalidDPoPProof            = "invalid_dpop_proof"
+	ErrorUseDPoPNonce                = "use_dpop_nonce"
+	ErrorLoginRequired               = "login_required"
+	ErrorConsentRequired             = "consent_required"
+	ErrorInsufficientScope           = "insufficient_scope"
+	ErrorInvalidRequestURI           = "invalid_request_uri"
+	// Step-up authentication challenge of RFC 9470
+	ErrorInsufficientUserAuthentication = "insufficient_user_authentication"
+
+	TokenTypeBearer = "Bearer"
+	TokenTypeDPoP   = "DPoP"
 )
 
 type AuthorizationResponse struct {
@@ -24,16 +41,35 @@ type AuthorizationResponse struct {
 }
 
 func (r *AuthorizationResponse) Encode() string {
+	return r.Values().Encode()
+}
+
+func (r *AuthorizationResponse) Values() url.Values {
 	vals := url.Values{}
 	vals.Add("code", r.Code)
 	vals.Add("state", r.State)
-	return vals.Encode()
+	return vals
 }
 
 type AccessTokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   uint   `json:"expires_in"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    uint   `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// ID token of OpenID Connect requests
+	IDToken string `json:"id_token,omitempty"`
+
+	AuthorizationDetails []AuthorizationDetail `json:"authorization_details,omitempty"`
+}
+
+// Values returns the parameters of the response that are returned from the
+// authorization endpoint.
+func (r *AccessTokenResponse) Values() url.Values {
+	vals := url.Values{}
+	vals.Add("access_token", r.AccessToken)
+	vals.Add("token_type", r.TokenType)
+	vals.Add("expires_in", strconv.FormatUint(uint64(r.ExpiresIn), 10))
+	return vals
 }
 
 func (r *AccessTokenResponse) WriteResponse(w http.ResponseWriter, code int) bool {
@@ -48,6 +84,59 @@ func (r *AccessTokenResponse) WriteResponse(w http.ResponseWriter, code int) boo
 	return true
 }
 
+// PushedAuthorizationResponse is the response to a pushed authorization request
+// (RFC 9126). The request uri is used in place of the request parameters at the
+// authorization endpoint.
+type PushedAuthorizationResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  uint   `json:"expires_in"`
+}
+
+func (r *PushedAuthorizationResponse) WriteResponse(w http.ResponseWriter, code int) bool {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	jsonValue, err := json.Marshal(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	w.WriteHeader(code)
+	w.Write(jsonValue)
+	return true
+}
+
+// IntrospectionResponse is the state of a token returned by the introspection
+// endpoint (RFC 7662). Claims are only returned for active tokens.
+type IntrospectionResponse struct {
+	Active bool
+	// Claims of the token, e.g. scope, client_id, sub, exp and
+	// authorization_details
+	Claims map[string]interface{}
+}
+
+func (r *IntrospectionResponse) MarshalJSON() ([]byte, error) {
+	if !r.Active {
+		return json.Marshal(map[string]interface{}{"active": false})
+	}
+	response := make(map[string]interface{}, len(r.Claims)+1)
+	for name, value := range r.Claims {
+		response[name] = value
+	}
+	response["active"] = true
+	return json.Marshal(response)
+}
+
+func (r *IntrospectionResponse) WriteResponse(w http.ResponseWriter, code int) bool {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	jsonValue, err := json.Marshal(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	w.WriteHeader(code)
+	w.Write(jsonValue)
+	return true
+}
+
 type ErrorResponse struct {
 	ErrorCode   string   `json:"error"`
 	Description string   `json:"error_description,omitempty"`
@@ -58,6 +147,20 @@ func (e *ErrorResponse) Error() string {
 	return e.ErrorCode
 }
 
+// Values returns the parameters of the error that are returned to the client
+// from the authorization endpoint.
+func (e *ErrorResponse) Values() url.Values {
+	vals := url.Values{}
+	vals.Add("error", e.ErrorCode)
+	if e.Description != "" {
+		vals.Add("error_description", e.Description)
+	}
+	if e.Uri != nil {
+		vals.Add("error_uri", e.Uri.String())
+	}
+	return vals
+}
+
 func (e *ErrorResponse) WriteResponse(w http.ResponseWriter, code int) {
 	w.Header().Set("Content-Type", "application/json;charset=utf-8")
 	jsonValue, err := json.Marshal(e)
@@ -0,0 +1,50 @@
+package response_mode
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+const jwtResponseLifetime = 10 * time.Minute
+
+// jwtWriter wraps the response parameters in a signed JWT that is then
+// returned in a single response parameter by the underlying writer.
+type jwtWriter struct {
+	writer Writer
+	signer jose.Signer
+	issuer string
+}
+
+func newJWTWriter(writer Writer, signer jose.Signer, issuer string) *jwtWriter {
+	return &jwtWriter{
+		writer: writer,
+		signer: signer,
+		issuer: issuer,
+	}
+}
+
+func (j *jwtWriter) Write(w http.ResponseWriter, r *http.Request, response *Response) error {
+	claims := map[string]interface{}{
+		"iss": j.issuer,
+		"aud": response.ClientId,
+		"exp": time.Now().Add(jwtResponseLifetime).Unix(),
+	}
+	for name := range response.Parameters {
+		claims[name] = response.Parameters.Get(name)
+	}
+	signed, err := jose.SignClaims(j.signer, "JWT", claims)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set(oauth2.ParameterResponse, signed)
+	return j.writer.Write(w, r, &Response{
+		ClientId:    response.ClientId,
+		RedirectURI: response.RedirectURI,
+		Parameters:  params,
+	})
+}
@@ -0,0 +1,166 @@
+// Package response_mode implements the ways an authorization response is
+// returned to the client: OAuth 2.0 Multiple Response Type Encoding Practices,
+// Form Post Response Mode and JWT Secured Authorization Response Mode (JARM).
+package response_mode
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/util"
+)
+
+const (
+	Query       = "query"
+	Fragment    = "fragment"
+	FormPost    = "form_post"
+	JWT         = "jwt"
+	QueryJWT    = "query.jwt"
+	FragmentJWT = "fragment.jwt"
+	FormPostJWT = "form_post.jwt"
+)
+
+// Response is an authorization response that is returned to the client.
+type Response struct {
+	ClientId    string
+	RedirectURI *url.URL
+	Parameters  url.Values
+}
+
+// Writer returns an authorization response to the client using a single
+// response mode.
+type Writer interface {
+	Write(w http.ResponseWriter, r *http.Request, response *Response) error
+}
+
+// ResponseModes contains all supported response modes. The issuer is added to
+// every authorization response as defined in RFC 9207.
+type ResponseModes struct {
+	issuer  string
+	writers map[string]Writer
+}
+
+// NewResponseModes returns query, fragment and form_post response modes. If
+// signer is not nil JWT secured response modes are supported as well.
+func NewResponseModes(issuer string, templateFactory *util.TemplateFactory, signer jose.Signer) *ResponseModes {
+	writers := map[string]Writer{
+		Query:    queryWriter{},
+		Fragment: fragmentWriter{},
+		FormPost: formPostWriter{templateFactory},
+	}
+	if signer != nil {
+		writers[QueryJWT] = newJWTWriter(writers[Query], signer, issuer)
+		writers[FragmentJWT] = newJWTWriter(writers[Fragment], signer, issuer)
+		writers[FormPostJWT] = newJWTWriter(writers[FormPost], signer, issuer)
+	}
+	return &ResponseModes{
+		issuer:  issuer,
+		writers: writers,
+	}
+}
+
+// DefaultMode returns the response mode used for a response type if the client
+// did not request one. Responses containing tokens are never sent in the query.
+func DefaultMode(responseType string) string {
+	for _, rt := range strings.Split(responseType, " ") {
+		if rt != oauth2.ResponseTypeCode && rt != "" && rt != "none" {
+			return Fragment
+		}
+	}
+	return Query
+}
+
+// Resolve returns the response mode that is used for the requested mode and
+// response type. Empty mode and the generic jwt mode resolve to the default
+// mode of the response type.
+func (m *ResponseModes) Resolve(mode, responseType string) string {
+	switch mode {
+	case "":
+		return DefaultMode(responseType)
+	case JWT:
+		return DefaultMode(responseType) + "." + JWT
+	}
+	return mode
+}
+
+// Validate checks that the requested response mode is supported and allowed
+// for the response type.
+func (m *ResponseModes) Validate(mode, responseType string) error {
+	resolved := m.Resolve(mode, responseType)
+	if _, ok := m.writers[resolved]; !ok {
+		return &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidRequest,
+			Description: "Unsupported response_mode: " + mode,
+		}
+	}
+	if DefaultMode(responseType) == Fragment && (resolved == Query || resolved == QueryJWT) {
+		return &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidRequest,
+			Description: "Response type must not use the query response mode",
+		}
+	}
+	return nil
+}
+
+// Write returns the response to the client using the requested response mode.
+func (m *ResponseModes) Write(
+	w http.ResponseWriter, r *http.Request, mode, responseType string, response *Response) error {
+
+	if err := m.Validate(mode, responseType); err != nil {
+		return err
+	}
+	resolved := m.Resolve(mode, responseType)
+	if !strings.HasSuffix(resolved, "."+JWT) {
+		response.Parameters.Set(oauth2.ParameterIssuer, m.issuer)
+	}
+	return m.writers[resolved].Write(w, r, response)
+}
+
+type queryWriter struct{}
+
+func (queryWriter) Write(w http.ResponseWriter, r *http.Request, response *Response) error {
+	redirectURI := *response.RedirectURI
+	if redirectURI.RawQuery == "" {
+		redirectURI.RawQuery = response.Parameters.Encode()
+	} else {
+		parts := []string{redirectURI.RawQuery, response.Parameters.Encode()}
+		redirectURI.RawQuery = strings.Join(parts, "&")
+	}
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	return nil
+}
+
+type fragmentWriter struct{}
+
+func (fragmentWriter) Write(w http.ResponseWriter, r *http.Request, response *Response) error {
+	redirectURI := *response.RedirectURI
+	redirectURI.Fragment = ""
+	redirectURI.RawFragment = ""
+	http.Redirect(w, r, redirectURI.String()+"#"+response.Parameters.Encode(), http.StatusFound)
+	return nil
+}
+
+// FormPostData is the data of the auto submitting form_post template.
+type FormPostData struct {
+	Action     string
+	Parameters map[string]string
+}
+
+type formPostWriter struct {
+	templateFactory *util.TemplateFactory
+}
+
+func (f formPostWriter) Write(w http.ResponseWriter, r *http.Request, response *Response) error {
+	data := FormPostData{
+		Action:     response.RedirectURI.String(),
+		Parameters: make(map[string]string),
+	}
+	for name := range response.Parameters {
+		data.Parameters[name] = response.Parameters.Get(name)
+	}
+	w.Header().Set("Content-Type", util.ContentTypeHtml)
+	return f.templateFactory.ExecuteTemplate(w, "form_post", &data)
+}
@@ -0,0 +1,104 @@
+package response_mode_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/response_mode"
+	"github.com/arjantop/gopherauth/util"
+)
+
+const issuer = "https://example.com"
+
+func makeResponseModes(signer jose.Signer) *response_mode.ResponseModes {
+	return response_mode.NewResponseModes(issuer, util.NewTemplateFactory("../../templates"), signer)
+}
+
+func makeResponse() *response_mode.Response {
+	redirectURI, _ := url.Parse("https://client.example.com/cb?existing=1")
+	params := url.Values{}
+	params.Add("code", "auth_code")
+	params.Add("state", "state")
+	return &response_mode.Response{
+		ClientId:    "client_id",
+		RedirectURI: redirectURI,
+		Parameters:  params,
+	}
+}
+
+func TestDefaultResponseModeDependsOnResponseType(t *testing.T) {
+	assert.Equal(t, response_mode.Query, response_mode.DefaultMode("code"))
+	assert.Equal(t, response_mode.Fragment, response_mode.DefaultMode("token"))
+	assert.Equal(t, response_mode.Fragment, response_mode.DefaultMode("code id_token"))
+}
+
+func TestQueryResponseModeIsNotAllowedForTokens(t *testing.T) {
+	err := makeResponseModes(nil).Validate("query", "token")
+	assert.Equal(t, oauth2.ErrorInvalidRequest, err.Error())
+}
+
+func TestJWTResponseModesRequireSigner(t *testing.T) {
+	err := makeResponseModes(nil).Validate("jwt", "code")
+	assert.Equal(t, oauth2.ErrorInvalidRequest, err.Error())
+}
+
+func TestQueryResponseKeepsExistingParameters(t *testing.T) {
+	request, _ := http.NewRequest("POST", "https://example.com/approval", nil)
+	recorder := httptest.NewRecorder()
+
+	err := makeResponseModes(nil).Write(recorder, request, "", "code", makeResponse())
+
+	assert.Nil(t, err)
+	location, _ := url.Parse(recorder.Header().Get("Location"))
+	assert.Equal(t, "1", location.Query().Get("existing"))
+	assert.Equal(t, "auth_code", location.Query().Get("code"))
+	assert.Equal(t, issuer, location.Query().Get("iss"))
+}
+
+func TestFragmentResponseContainsParameters(t *testing.T) {
+	request, _ := http.NewRequest("POST", "https://example.com/approval", nil)
+	recorder := httptest.NewRecorder()
+
+	err := makeResponseModes(nil).Write(recorder, request, "fragment", "code", makeResponse())
+
+	assert.Nil(t, err)
+	location, _ := url.Parse(recorder.Header().Get("Location"))
+	fragment, err := url.ParseQuery(location.Fragment)
+	assert.Nil(t, err)
+	assert.Equal(t, "auth_code", fragment.Get("code"))
+	assert.Equal(t, issuer, fragment.Get("iss"))
+	assert.Empty(t, location.Query().Get("code"))
+}
+
+func TestFormPostResponseIsAutoSubmittingForm(t *testing.T) {
+	request, _ := http.NewRequest("POST", "https://example.com/approval", nil)
+	recorder := httptest.NewRecorder()
+
+	err := makeResponseModes(nil).Write(recorder, request, "form_post", "code", makeResponse())
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	assert.Contains(t, body, `method="post"`)
+	assert.Contains(t, body, `name="state" value="state"`)
+	assert.Contains(t, body, `name="iss" value="https://example.com"`)
+}
+
+func TestFormPostJWTResponseContainsSignedResponse(t *testing.T) {
+	signer, _ := jose.NewSigner(jose.AlgorithmHS256, []byte("key"), "kid")
+	request, _ := http.NewRequest("POST", "https://example.com/approval", nil)
+	recorder := httptest.NewRecorder()
+
+	err := makeResponseModes(signer).Write(recorder, request, "form_post.jwt", "code", makeResponse())
+
+	assert.Nil(t, err)
+	body := recorder.Body.String()
+	assert.Contains(t, body, `name="response"`)
+	assert.NotContains(t, body, `name="code"`)
+}
@@ -0,0 +1,40 @@
+package oauth2
+
+import (
+	"sort"
+	"strings"
+)
+
+// ParseResponseType parses a space separated response type into a sorted set of
+// its values. The order of values in a response type is not significant.
+func ParseResponseType(responseType string) []string {
+	values := make([]string, 0)
+	for _, value := range strings.Split(responseType, " ") {
+		if value != "" && !containsValue(values, value) {
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// NormalizeResponseType returns the canonical form of a response type that is
+// used for looking up its handler, so "id_token code" and "code id_token" are
+// equal.
+func NormalizeResponseType(responseType string) string {
+	return strings.Join(ParseResponseType(responseType), " ")
+}
+
+// ResponseTypeContains reports whether the response type contains the value.
+func ResponseTypeContains(responseType, value string) bool {
+	return containsValue(ParseResponseType(responseType), value)
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -3,19 +3,22 @@ package response_type
 import (
 	"net/http"
 	"net/url"
-	"strings"
 
-	"github.com/arjantop/gopherauth/oauth2"
 	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/token"
 )
 
 type CodeController struct {
 	oauth2Service service.Oauth2Service
+	codes         *token.Codes
 }
 
-func NewCodeController(oauth2Service service.Oauth2Service) *CodeController {
+// NewCodeController returns the code response type. Codes are issued by the
+// built-in code store if codes is not nil, otherwise by the service.
+func NewCodeController(oauth2Service service.Oauth2Service, codes *token.Codes) *CodeController {
 	return &CodeController{
 		oauth2Service: oauth2Service,
+		codes:         codes,
 	}
 }
 
@@ -23,24 +26,14 @@ func (c *CodeController) ExtractParameters(r *http.Request) url.Values {
 	return extractParameters(r)
 }
 
-func (c *CodeController) Execute(params url.Values) (*url.URL, error) {
-	clientId := params.Get(oauth2.ParameterClientId)
-	redirectURIString := params.Get(oauth2.ParameterRedirectUri)
-	redirectURI, err := url.Parse(redirectURIString)
+func (c *CodeController) Execute(session *service.Session, params url.Values) (url.Values, error) {
+	request, err := makeAuthorizationRequest(session, params)
 	if err != nil {
 		return nil, err
 	}
-	scope := params.Get(oauth2.ParameterScope)
-	state := params.Get(oauth2.ParameterState)
-	response, err := c.oauth2Service.Code(clientId, redirectURI, scope, state)
+	response, err := issueCode(c.oauth2Service, c.codes, request)
 	if err != nil {
 		return nil, err
 	}
-	if redirectURI.RawQuery == "" {
-		redirectURI.RawQuery = response.Encode()
-	} else {
-		parts := []string{redirectURI.RawQuery, response.Encode()}
-		redirectURI.RawQuery = strings.Join(parts, "&")
-	}
-	return redirectURI, nil
+	return response.Values(), nil
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -11,8 +12,17 @@ import (
 	"github.com/arjantop/gopherauth/oauth2/response_type"
 	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/token"
 )
 
+var userSession = &service.Session{
+	Id:       "SessionId",
+	Sid:      "sid",
+	Subject:  "user",
+	AuthTime: time.Unix(1700000000, 0),
+	Methods:  []string{"pwd"},
+}
+
 type deps struct {
 	params        url.Values
 	oauth2Service *service.Oauth2ServiceMock
@@ -25,7 +35,7 @@ func makeCodeController() deps {
 	return deps{
 		params:        params,
 		oauth2Service: oauth2Service,
-		controller:    response_type.NewCodeController(oauth2Service),
+		controller:    response_type.NewCodeController(oauth2Service, nil),
 	}
 }
 
@@ -53,7 +63,7 @@ func TestCodeParametersAreExtracted(t *testing.T) {
 	}
 }
 
-func TestCodeReturnsCorrectRedirectURL(t *testing.T) {
+func TestCodeReturnsResponseParameters(t *testing.T) {
 	deps := makeCodeController()
 
 	response := oauth2.AuthorizationResponse{
@@ -64,18 +74,19 @@ func TestCodeReturnsCorrectRedirectURL(t *testing.T) {
 	url, err := url.Parse(deps.params.Get("redirect_uri"))
 	assert.Nil(t, err)
 
-	deps.oauth2Service.On(
-		"Code",
-		deps.params.Get("client_id"),
-		url,
-		deps.params.Get("scope"),
-		deps.params.Get("state")).Return(&response, nil)
+	deps.oauth2Service.On("Code", &service.AuthorizationRequest{
+		ClientId:    deps.params.Get("client_id"),
+		RedirectURI: url,
+		Scope:       deps.params.Get("scope"),
+		State:       deps.params.Get("state"),
+		Session:     userSession,
+	}).Return(&response, nil)
 
-	redirectURL, err := deps.controller.Execute(deps.params)
+	responseParams, err := deps.controller.Execute(userSession, deps.params)
 
 	assert.Nil(t, err)
-	assert.Equal(t, "code", redirectURL.Query().Get("code"))
-	assert.Equal(t, "state", redirectURL.Query().Get("state"))
+	assert.Equal(t, "code", responseParams.Get("code"))
+	assert.Equal(t, "state", responseParams.Get("state"))
 }
 
 func TestCodeServiceErrorIsReturned(t *testing.T) {
@@ -84,15 +95,74 @@ func TestCodeServiceErrorIsReturned(t *testing.T) {
 	url, err := url.Parse(deps.params.Get("redirect_uri"))
 	assert.Nil(t, err)
 
-	deps.oauth2Service.On(
-		"Code",
-		deps.params.Get("client_id"),
-		url,
-		deps.params.Get("scope"),
-		deps.params.Get("state")).Return(nil, errors.New("error"))
+	deps.oauth2Service.On("Code", &service.AuthorizationRequest{
+		ClientId:    deps.params.Get("client_id"),
+		RedirectURI: url,
+		Scope:       deps.params.Get("scope"),
+		State:       deps.params.Get("state"),
+		Session:     userSession,
+	}).Return(nil, errors.New("error"))
 
-	redirectURL, err := deps.controller.Execute(deps.params)
+	responseParams, err := deps.controller.Execute(userSession, deps.params)
 
 	assert.Equal(t, errors.New("error"), err)
-	assert.Nil(t, redirectURL)
+	assert.Nil(t, responseParams)
+}
+
+func TestCodeMultipleResourcesAreExtracted(t *testing.T) {
+	deps := makeCodeController()
+	deps.params["resource"] = []string{"https://api1.example.com/", "https://api2.example.com/"}
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+	params := deps.controller.ExtractParameters(request)
+
+	assert.Equal(t, deps.params["resource"], params["resource"])
+}
+
+func TestCodeAuthorizationDetailsArePassedToService(t *testing.T) {
+	deps := makeCodeController()
+	deps.params.Set("authorization_details", `[{"type":"payment_initiation","creditorName":"Merchant A"}]`)
+
+	url, err := url.Parse(deps.params.Get("redirect_uri"))
+	assert.Nil(t, err)
+
+	deps.oauth2Service.On("Code", &service.AuthorizationRequest{
+		ClientId:    deps.params.Get("client_id"),
+		RedirectURI: url,
+		Scope:       deps.params.Get("scope"),
+		State:       deps.params.Get("state"),
+		Session:     userSession,
+		AuthorizationDetails: []oauth2.AuthorizationDetail{
+			oauth2.AuthorizationDetail{"type": "payment_initiation", "creditorName": "Merchant A"},
+		},
+	}).Return(&oauth2.AuthorizationResponse{Code: "code"}, nil)
+
+	_, err = deps.controller.Execute(userSession, deps.params)
+
+	assert.Nil(t, err)
+	deps.oauth2Service.Mock.AssertExpectations(t)
+}
+
+func TestCodeIsIssuedByCodeStore(t *testing.T) {
+	deps := makeCodeController()
+	manager := token.NewManager(token.NewMemoryStore(), service.NewCryptoTokenGenerator())
+	controller := response_type.NewCodeController(deps.oauth2Service, token.NewCodes(manager, time.Minute))
+	deps.params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	deps.params.Set("code_challenge_method", "S256")
+
+	request := testutil.NewEndpointRequest(t, "GET", "auth", deps.params)
+	params := controller.ExtractParameters(request)
+	responseParams, err := controller.Execute(userSession, params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "state", responseParams.Get("state"))
+	code, err := manager.Lookup(responseParams.Get("code"), token.TypeAuthorizationCode)
+	assert.Nil(t, err)
+	if assert.NotNil(t, code) {
+		assert.Equal(t, "client_id", code.ClientId)
+		assert.Equal(t, "user", code.Subject)
+		assert.Equal(t, deps.params.Get("redirect_uri"), code.RedirectURI)
+		assert.Equal(t, deps.params.Get("code_challenge"), code.CodeChallenge)
+	}
+	deps.oauth2Service.AssertNotCalled(t, "Code")
 }
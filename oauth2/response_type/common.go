@@ -5,6 +5,8 @@ import (
 	"net/url"
 
 	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/token"
 )
 
 func extractParameters(r *http.Request) url.Values {
@@ -21,6 +23,74 @@ func extractParameters(r *http.Request) url.Values {
 	params.Add(oauth2.ParameterRedirectUri, redirectUri)
 	params.Add(oauth2.ParameterState, state)
 	params.Add(oauth2.ParameterScope, scope)
+	if details := query.Get(oauth2.ParameterAuthorizationDetails); details != "" {
+		params.Add(oauth2.ParameterAuthorizationDetails, details)
+	}
+	if mode := query.Get(oauth2.ParameterResponseMode); mode != "" {
+		params.Add(oauth2.ParameterResponseMode, mode)
+	}
+	for _, name := range []string{oauth2.ParameterNonce, oauth2.ParameterPrompt,
+		oauth2.ParameterMaxAge, oauth2.ParameterLoginHint, oauth2.ParameterACRValues} {
+
+		if value := query.Get(name); value != "" {
+			params.Add(name, value)
+		}
+	}
+	for _, name := range []string{oauth2.ParameterDPoPJKT,
+		oauth2.ParameterCodeChallenge, oauth2.ParameterCodeChallengeMethod} {
+
+		if value := query.Get(name); value != "" {
+			params.Add(name, value)
+		}
+	}
+	// Resource parameter can be present multiple times
+	for _, resource := range query[oauth2.ParameterResource] {
+		params.Add(oauth2.ParameterResource, resource)
+	}
 
 	return params
 }
+
+func makeAuthorizationRequest(session *service.Session, params url.Values) (*service.AuthorizationRequest, error) {
+	redirectURI, err := url.Parse(params.Get(oauth2.ParameterRedirectUri))
+	if err != nil {
+		return nil, err
+	}
+	details, err := oauth2.ParseAuthorizationDetails(params.Get(oauth2.ParameterAuthorizationDetails))
+	if err != nil {
+		return nil, err
+	}
+	return &service.AuthorizationRequest{
+		ClientId:             params.Get(oauth2.ParameterClientId),
+		RedirectURI:          redirectURI,
+		Scope:                params.Get(oauth2.ParameterScope),
+		State:                params.Get(oauth2.ParameterState),
+		AuthorizationDetails: details,
+		Resources:            params[oauth2.ParameterResource],
+		DPoPJKT:              params.Get(oauth2.ParameterDPoPJKT),
+		CodeChallenge:        params.Get(oauth2.ParameterCodeChallenge),
+		CodeChallengeMethod:  params.Get(oauth2.ParameterCodeChallengeMethod),
+		Session:              session,
+		Nonce:                params.Get(oauth2.ParameterNonce),
+	}, nil
+}
+
+// issueCode issues the authorization code with the built-in code store if one
+// is configured, otherwise by the service.
+func issueCode(
+	oauth2Service service.Oauth2Service,
+	codes *token.Codes,
+	request *service.AuthorizationRequest) (*oauth2.AuthorizationResponse, error) {
+
+	if codes == nil {
+		return oauth2Service.Code(request)
+	}
+	code, err := codes.Issue(request)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.AuthorizationResponse{
+		Code:  code,
+		State: request.State,
+	}, nil
+}
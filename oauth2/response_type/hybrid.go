@@ -0,0 +1,82 @@
+package response_type
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/token"
+)
+
+// HybridController handles response types combining code, token and id_token
+// values as defined by OpenID Connect. Each value of the response type adds its
+// parameters to a single authorization response.
+type HybridController struct {
+	responseType  string
+	oauth2Service service.Oauth2Service
+	idTokens      *IDTokenIssuer
+	codes         *token.Codes
+}
+
+// NewHybridController returns the hybrid response type. Codes are issued by
+// the built-in code store if codes is not nil, otherwise by the service.
+func NewHybridController(
+	responseType string,
+	oauth2Service service.Oauth2Service,
+	idTokens *IDTokenIssuer,
+	codes *token.Codes) *HybridController {
+
+	return &HybridController{
+		responseType:  responseType,
+		oauth2Service: oauth2Service,
+		idTokens:      idTokens,
+		codes:         codes,
+	}
+}
+
+func (c *HybridController) ExtractParameters(r *http.Request) url.Values {
+	return extractParameters(r)
+}
+
+func (c *HybridController) Execute(session *service.Session, params url.Values) (url.Values, error) {
+	request, err := makeAuthorizationRequest(session, params)
+	if err != nil {
+		return nil, err
+	}
+	responseParams := url.Values{}
+	var code, accessToken string
+	if c.contains(oauth2.ResponseTypeCode) {
+		response, err := issueCode(c.oauth2Service, c.codes, request)
+		if err != nil {
+			return nil, err
+		}
+		code = response.Code
+		responseParams.Set(oauth2.ParameterCode, code)
+	}
+	if c.contains(oauth2.ResponseTypeToken) {
+		response, err := c.oauth2Service.Token(request)
+		if err != nil {
+			return nil, err
+		}
+		accessToken = response.AccessToken
+		for name, values := range response.Values() {
+			responseParams[name] = values
+		}
+	}
+	if c.contains(oauth2.ResponseTypeIDToken) {
+		idToken, err := c.idTokens.Issue(request, code, accessToken)
+		if err != nil {
+			return nil, err
+		}
+		responseParams.Set(oauth2.ParameterIDToken, idToken)
+	}
+	if request.State != "" {
+		responseParams.Set(oauth2.ParameterState, request.State)
+	}
+	return responseParams, nil
+}
+
+func (c *HybridController) contains(value string) bool {
+	return oauth2.ResponseTypeContains(c.responseType, value)
+}
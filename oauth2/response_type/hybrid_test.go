@@ -0,0 +1,114 @@
+package response_type_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/oauth2/response_type"
+	"github.com/arjantop/gopherauth/service"
+)
+
+var idTokenKey = []byte("IDTokenKey")
+
+type hybridDeps struct {
+	params        url.Values
+	oauth2Service *service.Oauth2ServiceMock
+}
+
+func makeHybridDeps(responseType string) hybridDeps {
+	params := makeCodeRequestParameters()
+	params.Set("response_type", responseType)
+	params.Set("nonce", "nonce")
+	return hybridDeps{
+		params:        params,
+		oauth2Service: service.NewOauth2ServiceMock(),
+	}
+}
+
+func makeHybridController(t *testing.T, deps hybridDeps) *response_type.HybridController {
+	signer, err := jose.NewSigner(jose.AlgorithmHS256, idTokenKey, "")
+	assert.Nil(t, err)
+	issuer := response_type.NewIDTokenIssuer("https://example.com", signer, time.Minute, deps.oauth2Service)
+	return response_type.NewHybridController(deps.params.Get("response_type"), deps.oauth2Service, issuer, nil)
+}
+
+func parseIDToken(t *testing.T, idToken string) map[string]interface{} {
+	jws, err := jose.ParseJWS(idToken)
+	assert.Nil(t, err)
+	assert.Nil(t, jws.Verify(idTokenKey))
+	var claims map[string]interface{}
+	assert.Nil(t, jws.Claims(&claims))
+	return claims
+}
+
+func TestHybridCodeIDTokenBindsCodeHash(t *testing.T) {
+	deps := makeHybridDeps("code id_token")
+	controller := makeHybridController(t, deps)
+
+	request := makeExpectedAuthorizationRequest(t, deps.params)
+	deps.oauth2Service.On("Code", request).Return(
+		&oauth2.AuthorizationResponse{Code: "code", State: "state"}, nil)
+	deps.oauth2Service.On("IDTokenClaims", request).Return(
+		map[string]interface{}{}, nil)
+
+	responseParams, err := controller.Execute(userSession, deps.params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "code", responseParams.Get("code"))
+	assert.Equal(t, "state", responseParams.Get("state"))
+	assert.Empty(t, responseParams.Get("access_token"))
+	claims := parseIDToken(t, responseParams.Get("id_token"))
+	assert.Equal(t, "user", claims["sub"])
+	assert.Equal(t, "https://example.com", claims["iss"])
+	assert.Equal(t, "client_id", claims["aud"])
+	assert.Equal(t, "nonce", claims["nonce"])
+	assert.Equal(t, jose.HalfHash(jose.AlgorithmHS256, "code"), claims["c_hash"])
+	assert.NotContains(t, claims, "at_hash")
+	deps.oauth2Service.Mock.AssertExpectations(t)
+}
+
+func TestHybridCodeIDTokenTokenBindsBothHashes(t *testing.T) {
+	deps := makeHybridDeps("code id_token token")
+	controller := makeHybridController(t, deps)
+
+	request := makeExpectedAuthorizationRequest(t, deps.params)
+	deps.oauth2Service.On("Code", request).Return(
+		&oauth2.AuthorizationResponse{Code: "code", State: "state"}, nil)
+	deps.oauth2Service.On("Token", request).Return(
+		&oauth2.AccessTokenResponse{AccessToken: "token", TokenType: "Bearer", ExpiresIn: 60}, nil)
+	deps.oauth2Service.On("IDTokenClaims", request).Return(
+		map[string]interface{}{}, nil)
+
+	responseParams, err := controller.Execute(userSession, deps.params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "code", responseParams.Get("code"))
+	assert.Equal(t, "token", responseParams.Get("access_token"))
+	claims := parseIDToken(t, responseParams.Get("id_token"))
+	assert.Equal(t, jose.HalfHash(jose.AlgorithmHS256, "code"), claims["c_hash"])
+	assert.Equal(t, jose.HalfHash(jose.AlgorithmHS256, "token"), claims["at_hash"])
+}
+
+func TestHybridIDTokenClaimsDescribeSessionAuthentication(t *testing.T) {
+	deps := makeHybridDeps("id_token")
+	controller := makeHybridController(t, deps)
+
+	request := makeExpectedAuthorizationRequest(t, deps.params)
+	deps.oauth2Service.On("IDTokenClaims", request).Return(
+		map[string]interface{}{"email": "user@example.com", "sub": "other"}, nil)
+
+	responseParams, err := controller.Execute(userSession, deps.params)
+
+	assert.Nil(t, err)
+	claims := parseIDToken(t, responseParams.Get("id_token"))
+	assert.Equal(t, "user", claims["sub"], "Subject must be taken from the session")
+	assert.Equal(t, "sid", claims["sid"])
+	assert.Equal(t, float64(1700000000), claims["auth_time"])
+	assert.Equal(t, []interface{}{"pwd"}, claims["amr"])
+	assert.Equal(t, "user@example.com", claims["email"])
+}
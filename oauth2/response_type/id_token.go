@@ -0,0 +1,70 @@
+package response_type
+
+import (
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/service"
+)
+
+// IDTokenIssuer signs OpenID Connect ID tokens returned from the authorization
+// endpoint and the authorization code grant.
+type IDTokenIssuer struct {
+	issuer        string
+	signer        jose.Signer
+	lifetime      time.Duration
+	oauth2Service service.Oauth2Service
+}
+
+func NewIDTokenIssuer(
+	issuer string,
+	signer jose.Signer,
+	lifetime time.Duration,
+	oauth2Service service.Oauth2Service) *IDTokenIssuer {
+
+	return &IDTokenIssuer{
+		issuer:        issuer,
+		signer:        signer,
+		lifetime:      lifetime,
+		oauth2Service: oauth2Service,
+	}
+}
+
+// Issue returns a signed ID token for the request. The code and access token,
+// when not empty, are bound to the token with the c_hash and at_hash claims.
+func (i *IDTokenIssuer) Issue(r *service.AuthorizationRequest, code, accessToken string) (string, error) {
+	claims, err := i.oauth2Service.IDTokenClaims(r)
+	if err != nil {
+		return "", err
+	}
+	if claims == nil {
+		claims = make(map[string]interface{})
+	}
+	// Claims describing the authentication are taken from the session
+	claims["sub"] = r.Session.Subject
+	claims["sid"] = r.Session.Sid
+	claims["auth_time"] = r.Session.AuthTime.Unix()
+	if len(r.Session.Methods) > 0 {
+		claims["amr"] = r.Session.Methods
+	}
+	if r.Session.ACR != "" {
+		claims["acr"] = r.Session.ACR
+	}
+	now := time.Now()
+	claims["iss"] = i.issuer
+	claims["aud"] = r.ClientId
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(i.lifetime).Unix()
+	if r.Nonce != "" {
+		claims["nonce"] = r.Nonce
+	}
+	// Hashes must use the algorithm of the key that signs the token
+	signer := jose.CurrentSigner(i.signer)
+	if code != "" {
+		claims["c_hash"] = jose.HalfHash(signer.Algorithm(), code)
+	}
+	if accessToken != "" {
+		claims["at_hash"] = jose.HalfHash(signer.Algorithm(), accessToken)
+	}
+	return jose.SignClaims(signer, "JWT", claims)
+}
@@ -3,19 +3,39 @@ package response_type
 import (
 	"net/http"
 	"net/url"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
 )
 
+// TokenController implements the implicit grant, the access token is returned
+// directly from the authorization endpoint.
 type TokenController struct {
+	oauth2Service service.Oauth2Service
 }
 
-func NewTokenController() *TokenController {
-	return &TokenController{}
+func NewTokenController(oauth2Service service.Oauth2Service) *TokenController {
+	return &TokenController{
+		oauth2Service: oauth2Service,
+	}
 }
 
 func (c *TokenController) ExtractParameters(r *http.Request) url.Values {
 	return extractParameters(r)
 }
 
-func (c *TokenController) Execute(params url.Values) (*url.URL, error) {
-	return &url.URL{}, nil
+func (c *TokenController) Execute(session *service.Session, params url.Values) (url.Values, error) {
+	request, err := makeAuthorizationRequest(session, params)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.oauth2Service.Token(request)
+	if err != nil {
+		return nil, err
+	}
+	responseParams := response.Values()
+	if request.State != "" {
+		responseParams.Add(oauth2.ParameterState, request.State)
+	}
+	return responseParams, nil
 }
@@ -1,25 +1,30 @@
 package response_type_test
 
 import (
+	"errors"
 	"net/url"
 	"testing"
 
 	"github.com/arjantop/gopherauth/oauth2"
 	"github.com/arjantop/gopherauth/oauth2/response_type"
+	"github.com/arjantop/gopherauth/service"
 	"github.com/arjantop/gopherauth/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
 type tokenDeps struct {
-	params     url.Values
-	controller *response_type.TokenController
+	params        url.Values
+	oauth2Service *service.Oauth2ServiceMock
+	controller    *response_type.TokenController
 }
 
 func makeTokenController() tokenDeps {
 	params := makeTokenRequestParameters()
+	oauth2Service := service.NewOauth2ServiceMock()
 	return tokenDeps{
-		params:     params,
-		controller: response_type.NewTokenController(),
+		params:        params,
+		oauth2Service: oauth2Service,
+		controller:    response_type.NewTokenController(oauth2Service),
 	}
 }
 
@@ -33,6 +38,19 @@ func makeTokenRequestParameters() url.Values {
 	}
 }
 
+func makeExpectedAuthorizationRequest(t *testing.T, params url.Values) *service.AuthorizationRequest {
+	redirectURI, err := url.Parse(params.Get("redirect_uri"))
+	assert.Nil(t, err)
+	return &service.AuthorizationRequest{
+		ClientId:    params.Get("client_id"),
+		RedirectURI: redirectURI,
+		Scope:       params.Get("scope"),
+		State:       params.Get("state"),
+		Session:     userSession,
+		Nonce:       params.Get("nonce"),
+	}
+}
+
 func TestTokenParametersAreExtracted(t *testing.T) {
 	deps := makeTokenController()
 
@@ -46,3 +64,34 @@ func TestTokenParametersAreExtracted(t *testing.T) {
 			"Parameter: %s", paramName)
 	}
 }
+
+func TestTokenReturnsAccessTokenParameters(t *testing.T) {
+	deps := makeTokenController()
+
+	deps.oauth2Service.On("Token", makeExpectedAuthorizationRequest(t, deps.params)).Return(
+		&oauth2.AccessTokenResponse{
+			AccessToken: "token",
+			TokenType:   oauth2.TokenTypeBearer,
+			ExpiresIn:   3600,
+		}, nil)
+
+	responseParams, err := deps.controller.Execute(userSession, deps.params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "token", responseParams.Get("access_token"))
+	assert.Equal(t, "Bearer", responseParams.Get("token_type"))
+	assert.Equal(t, "3600", responseParams.Get("expires_in"))
+	assert.Equal(t, "state", responseParams.Get("state"))
+}
+
+func TestTokenServiceErrorIsReturned(t *testing.T) {
+	deps := makeTokenController()
+
+	deps.oauth2Service.On("Token", makeExpectedAuthorizationRequest(t, deps.params)).Return(
+		nil, errors.New("error"))
+
+	responseParams, err := deps.controller.Execute(userSession, deps.params)
+
+	assert.Equal(t, errors.New("error"), err)
+	assert.Nil(t, responseParams)
+}
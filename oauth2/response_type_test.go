@@ -0,0 +1,22 @@
+package oauth2_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+func TestResponseTypeIsParsedAsSortedSet(t *testing.T) {
+	assert.Equal(t, []string{"code", "id_token", "token"}, oauth2.ParseResponseType("token  id_token code code"))
+}
+
+func TestResponseTypeOrderIsNotSignificant(t *testing.T) {
+	assert.Equal(t, oauth2.NormalizeResponseType("code id_token"), oauth2.NormalizeResponseType("id_token code"))
+}
+
+func TestResponseTypeContainsValue(t *testing.T) {
+	assert.True(t, oauth2.ResponseTypeContains("code id_token", "id_token"))
+	assert.False(t, oauth2.ResponseTypeContains("code id_token", "token"))
+}
@@ -2,6 +2,9 @@ package oauth2
 
 import "strings"
 
+// Scope of OpenID Connect requests, ID tokens are issued only if it is granted
+const ScopeOpenID = "openid"
+
 // ParseScopes parses scopes separated by the space character and returns
 // a slice of parsed non-empty scopes.
 func ParseScope(scopeString string) []string {
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/service"
+)
+
+// Type of the identity tokens, it keeps them from being accepted as ID or
+// access tokens
+const IdentityTokenType = "identity+jwt"
+
+// IdentityTokens sign short-lived JWTs of the user that upstreams can verify
+// with the published keys, instead of trusting the identity headers.
+type IdentityTokens struct {
+	issuer   string
+	signer   jose.Signer
+	lifetime time.Duration
+}
+
+// NewIdentityTokens returns identity tokens signed with signer, that must use
+// RS256, ES256 or EdDSA.
+func NewIdentityTokens(issuer string, signer jose.Signer, lifetime time.Duration) (*IdentityTokens, error) {
+	switch signer.Algorithm() {
+	case jose.AlgorithmRS256, jose.AlgorithmES256, jose.AlgorithmEdDSA:
+	default:
+		return nil, errors.New("Identity tokens can not be signed with " + signer.Algorithm())
+	}
+	return &IdentityTokens{
+		issuer:   issuer,
+		signer:   signer,
+		lifetime: lifetime,
+	}, nil
+}
+
+// Issue returns the token of the user for the upstream. The audience is the
+// origin of the upstream so a token can not be replayed to another upstream.
+func (t *IdentityTokens) Issue(user *User, session *service.Session, upstream *url.URL) (string, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":       t.issuer,
+		"sub":       user.Subject,
+		"aud":       upstream.Scheme + "://" + upstream.Host,
+		"iat":       now.Unix(),
+		"exp":       now.Add(t.lifetime).Unix(),
+		"sid":       session.Sid,
+		"auth_time": session.AuthTime.Unix(),
+	}
+	if user.Email != "" {
+		claims["email"] = user.Email
+	}
+	if len(user.Groups) > 0 {
+		claims["groups"] = user.Groups
+	}
+	if session.ACR != "" {
+		claims["acr"] = session.ACR
+	}
+	return jose.SignClaims(t.signer, IdentityTokenType, claims)
+}
@@ -0,0 +1,297 @@
+// Package proxy is an authenticating reverse proxy. It protects upstream apps
+// that have no authentication of their own with the login sessions of the
+// server and forwards the identity of the user to them.
+//
+// Upstream apps share the origin of the host they are served on, their scripts
+// can use every page of the server on that host as the signed in user. Apps
+// should be served on a separate host, cookies of the server are bound to the
+// host so users sign in on it separately.
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/login"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/util"
+)
+
+// Identity headers set on forwarded requests. Headers with the same name sent by
+// the user are removed.
+const (
+	HeaderUser     = "X-Forwarded-User"
+	HeaderEmail    = "X-Forwarded-Email"
+	HeaderGroups   = "X-Forwarded-Groups"
+	HeaderIdentity = "X-Forwarded-Identity"
+)
+
+// User is the identity of a signed in user that is forwarded to upstreams.
+type User struct {
+	Subject string
+	Email   string
+	Groups  []string
+	// Scopes the user is entitled to, e.g. admin
+	Scopes []string
+}
+
+// Users looks up the users of sessions.
+type Users interface {
+	// User returns the user or nil if the user does not exist.
+	User(subject string) (*User, error)
+}
+
+// Route forwards requests whose path is the prefix or is below it to the
+// upstream. The session cookie is only sent to the host that set it, so routes
+// are served on a host that also serves the login page.
+type Route struct {
+	Prefix   string
+	Upstream *url.URL
+	// Removes the prefix from the path forwarded to the upstream
+	StripPrefix bool
+	// User must be a member of one of the groups, if set
+	Groups []string
+	// User must have all of the scopes, if set
+	Scopes []string
+}
+
+// allows reports whether the user satisfies the rules of the route.
+func (r *Route) allows(user *User) bool {
+	if len(r.Groups) > 0 && !slices.ContainsFunc(r.Groups, func(group string) bool {
+		return slices.Contains(user.Groups, group)
+	}) {
+		return false
+	}
+	for _, scope := range r.Scopes {
+		if !slices.Contains(user.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+type route struct {
+	*Route
+	proxy *httputil.ReverseProxy
+}
+
+type proxyHandler struct {
+	serverKeys      *keyring.Keyring
+	cookies         *util.CookiePolicy
+	loginUrl        url.URL
+	userAuthService service.UserAuthenticationService
+	users           Users
+	routes          []*route
+	identity        *IdentityTokens
+	templateFactory *util.TemplateFactory
+	// Names of the cookies set by the server
+	serverCookies map[string]bool
+}
+
+// Purposes of the cookies set by the server, they are never exchanged with
+// upstreams.
+var serverCookies = []string{
+	util.CookieSession, login.CookieTransaction, login.CookieNonce, login.CookiePasskeyRegistration,
+}
+
+// NewProxyHandler returns the handler of the routes. Users without a session are
+// redirected to the login page and returned to the requested URL after login.
+// If identity is not nil a signed JWT of the user is also forwarded.
+func NewProxyHandler(
+	serverKeys *keyring.Keyring,
+	cookies *util.CookiePolicy,
+	loginUrl url.URL,
+	userAuthService service.UserAuthenticationService,
+	users Users,
+	routes []*Route,
+	identity *IdentityTokens,
+	templateFactory *util.TemplateFactory) http.Handler {
+
+	handler := &proxyHandler{
+		serverKeys:      serverKeys,
+		cookies:         cookies,
+		loginUrl:        loginUrl,
+		userAuthService: userAuthService,
+		users:           users,
+		identity:        identity,
+		templateFactory: templateFactory,
+		serverCookies:   make(map[string]bool),
+	}
+	for _, purpose := range serverCookies {
+		handler.serverCookies[cookies.Name(purpose)] = true
+	}
+	for purpose := range cookies.Names {
+		handler.serverCookies[cookies.Name(purpose)] = true
+	}
+	for _, r := range routes {
+		handler.routes = append(handler.routes, &route{Route: r, proxy: handler.newReverseProxy(r)})
+	}
+	// The longest matching prefix wins
+	slices.SortStableFunc(handler.routes, func(a, b *route) int {
+		return len(b.Prefix) - len(a.Prefix)
+	})
+	return handler
+}
+
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := h.match(r.URL.Path)
+	if route == nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode:  http.StatusNotFound,
+			Description: "The page you're looking for does not exist.",
+		})
+		return
+	}
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+	if session == nil {
+		h.requireLogin(w, r)
+		return
+	}
+	user, err := h.users.User(session.Subject)
+	if err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		return
+	}
+	if user == nil || !route.allows(user) {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode:  http.StatusForbidden,
+			Description: "You don't have access to this page.",
+		})
+		return
+	}
+	outgoing := r.Clone(r.Context())
+	removeIdentity(outgoing)
+	h.removeServerCookies(outgoing)
+	outgoing.Header.Set(HeaderUser, user.Subject)
+	if user.Email != "" {
+		outgoing.Header.Set(HeaderEmail, user.Email)
+	}
+	if len(user.Groups) > 0 {
+		outgoing.Header.Set(HeaderGroups, strings.Join(user.Groups, ","))
+	}
+	if h.identity != nil {
+		token, err := h.identity.Issue(user, session, route.Upstream)
+		if err != nil {
+			log.Printf("Identity token can not be issued: %s", err)
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorInternalServerError())
+			return
+		}
+		outgoing.Header.Set(HeaderIdentity, token)
+	}
+	route.proxy.ServeHTTP(w, outgoing)
+}
+
+func (h *proxyHandler) match(path string) *route {
+	for _, route := range h.routes {
+		if _, ok := trimPrefix(path, route.Prefix); ok {
+			return route
+		}
+	}
+	return nil
+}
+
+// trimPrefix returns the path below the prefix, starting with a slash. Prefixes
+// end at a path segment boundary, /apps/a does not match /apps/admin.
+func trimPrefix(path, prefix string) (string, bool) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return "", false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	return rest, true
+}
+
+// session returns the valid session of the user or nil if there is none.
+func (h *proxyHandler) session(w http.ResponseWriter, r *http.Request) (*service.Session, bool) {
+	sessionId, err := h.cookies.Get(r, util.CookieSession)
+	if err != nil {
+		return nil, true
+	}
+	session, err := h.userAuthService.Session(sessionId)
+	if err != nil {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPErrorServiceUnavaliable())
+		return nil, false
+	}
+	return session, true
+}
+
+// requireLogin redirects navigations to the login page. Other requests, e.g.
+// form submissions or API calls, can not be continued after login.
+func (h *proxyHandler) requireLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+			StatusCode:  http.StatusUnauthorized,
+			Description: "You must sign in to access this page.",
+		})
+		return
+	}
+	returnTo := &url.URL{Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	util.RedirectToLogin(w, r, h.serverKeys.Secret(), h.loginUrl, returnTo, nil)
+}
+
+func (h *proxyHandler) newReverseProxy(r *Route) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if r.StripPrefix {
+				pr.Out.URL.Path, _ = trimPrefix(pr.Out.URL.Path, r.Prefix)
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(r.Upstream)
+			pr.SetXForwarded()
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			h.removeServerSetCookies(resp)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("Proxy request to %s failed: %s", r.Upstream, err)
+			util.RenderHTTPError(w, h.templateFactory, util.HTTPError{
+				StatusCode:  http.StatusBadGateway,
+				Description: "The service you're looking for is not responding.",
+			})
+		},
+	}
+}
+
+// removeIdentity removes identity headers the user sent.
+func removeIdentity(r *http.Request) {
+	for _, header := range []string{HeaderUser, HeaderEmail, HeaderGroups, HeaderIdentity} {
+		r.Header.Del(header)
+	}
+}
+
+// removeServerCookies keeps the cookies of the server from upstreams, the
+// session cookie would let them act as the user on the server.
+func (h *proxyHandler) removeServerCookies(r *http.Request) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if !h.serverCookies[cookie.Name] {
+			r.AddCookie(cookie)
+		}
+	}
+}
+
+// removeServerSetCookies keeps upstreams from replacing or deleting the cookies
+// of the server, e.g. fixing the session of the user.
+func (h *proxyHandler) removeServerSetCookies(resp *http.Response) {
+	setCookies := resp.Header.Values("Set-Cookie")
+	resp.Header.Del("Set-Cookie")
+	for _, setCookie := range setCookies {
+		cookie, err := http.ParseSetCookie(setCookie)
+		if err == nil && !h.serverCookies[cookie.Name] {
+			resp.Header.Add("Set-Cookie", setCookie)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/login"
+	"github.com/arjantop/gopherauth/proxy"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/util"
+)
+
+const (
+	issuer            = "https://example.com"
+	sessionCookieName = "sessionid"
+)
+
+var (
+	session = &service.Session{Id: "SessionId", Sid: "sid", Subject: "user", AuthTime: time.Now()}
+	users   = staticUsers{
+		"user": {Subject: "user", Email: "user@example.com", Groups: []string{"staff", "ops"}, Scopes: []string{"read"}},
+	}
+)
+
+type staticUsers map[string]*proxy.User
+
+func (u staticUsers) User(subject string) (*proxy.User, error) {
+	return u[subject], nil
+}
+
+// upstream records the last request it received.
+type upstream struct {
+	server  *httptest.Server
+	request *http.Request
+}
+
+func newUpstream(t *testing.T) *upstream {
+	u := &upstream{}
+	u.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.request = r
+		w.Write([]byte("upstream"))
+	}))
+	t.Cleanup(u.server.Close)
+	return u
+}
+
+func (u *upstream) url() *url.URL {
+	parsed, _ := url.Parse(u.server.URL)
+	return parsed
+}
+
+type proxyDeps struct {
+	userAuthService *service.UserAuthenticationServiceMock
+	handler         http.Handler
+}
+
+func makeProxy(routes []*proxy.Route, identity *proxy.IdentityTokens) proxyDeps {
+	userAuthService := service.NewUserAuthenticationServiceMock()
+	userAuthService.On("Session", session.Id).Return(session, nil)
+	userAuthService.On("Session", "expired").Return(nil, nil)
+	loginUrl, _ := url.Parse("/login")
+	return proxyDeps{
+		userAuthService: userAuthService,
+		handler: proxy.NewProxyHandler(
+			testutil.NewSecretKeyring("ServerKey"), &util.CookiePolicy{}, *loginUrl, userAuthService, users,
+			routes, identity, util.NewTemplateFactory("../templates")),
+	}
+}
+
+func newRequest(method, target, sessionId string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	if sessionId != "" {
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionId})
+	}
+	return r
+}
+
+func serve(deps proxyDeps, r *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	deps.handler.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestUnknownRouteIsNotFound(t *testing.T) {
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/wiki/", Upstream: newUpstream(t).url()}}, nil)
+	recorder := serve(deps, newRequest("GET", "/apps/other/", session.Id))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestUserWithoutSessionIsRedirectedToLogin(t *testing.T) {
+	upstream := newUpstream(t)
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/wiki/", Upstream: upstream.url()}}, nil)
+
+	for _, sessionId := range []string{"", "expired"} {
+		recorder := serve(deps, newRequest("GET", "/apps/wiki/page?id=1", sessionId))
+		assert.Equal(t, http.StatusFound, recorder.Code)
+		location, err := url.Parse(recorder.Header().Get("Location"))
+		assert.NoError(t, err)
+		assert.Equal(t, "/login", location.Path)
+		assert.Equal(t, "/apps/wiki/page?id=1", location.Query().Get(util.ParameterContinue))
+		assert.Equal(t, util.SignContinue("/apps/wiki/page?id=1", []byte("ServerKey")),
+			location.Query().Get(util.ParameterContinueSignature))
+	}
+	assert.Nil(t, upstream.request)
+}
+
+func TestNonNavigationRequestWithoutSessionIsUnauthorized(t *testing.T) {
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/wiki/", Upstream: newUpstream(t).url()}}, nil)
+	recorder := serve(deps, newRequest("POST", "/apps/wiki/page", ""))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestRequestIsForwardedWithIdentity(t *testing.T) {
+	upstream := newUpstream(t)
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/wiki/", Upstream: upstream.url(), StripPrefix: true}}, nil)
+
+	r := newRequest("GET", "/apps/wiki/page?id=1", session.Id)
+	r.AddCookie(&http.Cookie{Name: "app", Value: "value"})
+	r.AddCookie(&http.Cookie{Name: login.CookieTransaction, Value: "transaction"})
+	r.AddCookie(&http.Cookie{Name: login.CookieNonce, Value: "nonce"})
+	r.Header.Set(proxy.HeaderUser, "admin")
+	r.Header.Set(proxy.HeaderIdentity, "forged")
+	recorder := serve(deps, r)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "upstream", recorder.Body.String())
+	if assert.NotNil(t, upstream.request) {
+		assert.Equal(t, "/page", upstream.request.URL.Path)
+		assert.Equal(t, "id=1", upstream.request.URL.RawQuery)
+		assert.Equal(t, "user", upstream.request.Header.Get(proxy.HeaderUser))
+		assert.Equal(t, "user@example.com", upstream.request.Header.Get(proxy.HeaderEmail))
+		assert.Equal(t, "staff,ops", upstream.request.Header.Get(proxy.HeaderGroups))
+		assert.Empty(t, upstream.request.Header.Get(proxy.HeaderIdentity))
+		_, err := upstream.request.Cookie(sessionCookieName)
+		assert.Equal(t, http.ErrNoCookie, err, "Session cookie must not be forwarded")
+		for _, name := range []string{login.CookieTransaction, login.CookieNonce} {
+			_, err := upstream.request.Cookie(name)
+			assert.Equal(t, http.ErrNoCookie, err, "Cookie %s must not be forwarded", name)
+		}
+		cookie, err := upstream.request.Cookie("app")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "value", cookie.Value)
+		}
+	}
+}
+
+func TestUpstreamCanNotSetServerCookies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "fixed"})
+		http.SetCookie(w, &http.Cookie{Name: login.CookieNonce, Value: "nonce"})
+		http.SetCookie(w, &http.Cookie{Name: "app", Value: "value"})
+	}))
+	defer server.Close()
+	upstreamUrl, _ := url.Parse(server.URL)
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/wiki/", Upstream: upstreamUrl}}, nil)
+
+	recorder := serve(deps, newRequest("GET", "/apps/wiki/", session.Id))
+	cookies := recorder.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, "app", cookies[0].Name)
+	}
+}
+
+func TestPrefixIsKeptUnlessStripped(t *testing.T) {
+	upstream := newUpstream(t)
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/wiki/", Upstream: upstream.url()}}, nil)
+	serve(deps, newRequest("GET", "/apps/wiki/page", session.Id))
+	assert.Equal(t, "/apps/wiki/page", upstream.request.URL.Path)
+}
+
+func TestLongestPrefixIsMatched(t *testing.T) {
+	wiki := newUpstream(t)
+	admin := newUpstream(t)
+	deps := makeProxy([]*proxy.Route{
+		{Prefix: "/apps/wiki/", Upstream: wiki.url()},
+		{Prefix: "/apps/wiki/admin/", Upstream: admin.url()},
+	}, nil)
+	serve(deps, newRequest("GET", "/apps/wiki/admin/users", session.Id))
+	assert.NotNil(t, admin.request)
+	assert.Nil(t, wiki.request)
+}
+
+func TestPrefixEndsAtPathSegment(t *testing.T) {
+	upstream := newUpstream(t)
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/a", Upstream: upstream.url(), StripPrefix: true}}, nil)
+
+	recorder := serve(deps, newRequest("GET", "/apps/admin", session.Id))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Nil(t, upstream.request)
+
+	for path, forwarded := range map[string]string{"/apps/a": "/", "/apps/a/": "/", "/apps/a/page": "/page"} {
+		recorder := serve(deps, newRequest("GET", path, session.Id))
+		assert.Equal(t, http.StatusOK, recorder.Code, path)
+		if assert.NotNil(t, upstream.request, path) {
+			assert.Equal(t, forwarded, upstream.request.URL.Path, path)
+		}
+	}
+}
+
+func TestRouteRulesRestrictAccess(t *testing.T) {
+	routes := []struct {
+		route   *proxy.Route
+		allowed bool
+	}{
+		{&proxy.Route{Groups: []string{"ops", "admins"}}, true},
+		{&proxy.Route{Groups: []string{"admins"}}, false},
+		{&proxy.Route{Scopes: []string{"read"}}, true},
+		{&proxy.Route{Scopes: []string{"read", "write"}}, false},
+		{&proxy.Route{Groups: []string{"staff"}, Scopes: []string{"admin"}}, false},
+	}
+	for i, test := range routes {
+		upstream := newUpstream(t)
+		test.route.Prefix = "/apps/wiki/"
+		test.route.Upstream = upstream.url()
+		deps := makeProxy([]*proxy.Route{test.route}, nil)
+
+		recorder := serve(deps, newRequest("GET", "/apps/wiki/", session.Id))
+		if test.allowed {
+			assert.Equal(t, http.StatusOK, recorder.Code, "Route %d", i)
+		} else {
+			assert.Equal(t, http.StatusForbidden, recorder.Code, "Route %d", i)
+			assert.Nil(t, upstream.request, "Route %d", i)
+		}
+	}
+}
+
+func TestUnknownUserIsForbidden(t *testing.T) {
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/wiki/", Upstream: newUpstream(t).url()}}, nil)
+	other := &service.Session{Id: "OtherSessionId", Subject: "other"}
+	deps.userAuthService.On("Session", other.Id).Return(other, nil)
+	recorder := serve(deps, newRequest("GET", "/apps/wiki/", other.Id))
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestIdentityTokenIsForwarded(t *testing.T) {
+	keys, err := keyring.NewKeyring(keyring.NewMemoryStore(), keyring.UsageSigning, keyring.Schedule{
+		Algorithm: jose.AlgorithmES256,
+		RetainFor: time.Hour,
+	})
+	assert.NoError(t, err)
+	identity, err := proxy.NewIdentityTokens(issuer, keys, 5*time.Minute)
+	assert.NoError(t, err)
+	upstream := newUpstream(t)
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/wiki/", Upstream: upstream.url()}}, identity)
+
+	serve(deps, newRequest("GET", "/apps/wiki/", session.Id))
+	if !assert.NotNil(t, upstream.request) {
+		return
+	}
+	jws, err := jose.ParseJWS(upstream.request.Header.Get(proxy.HeaderIdentity))
+	assert.NoError(t, err)
+	assert.NoError(t, jws.Verify(keys))
+	assert.Equal(t, proxy.IdentityTokenType, jws.Header.Type)
+	var claims map[string]interface{}
+	assert.NoError(t, jws.Claims(&claims))
+	assert.Equal(t, issuer, claims["iss"])
+	assert.Equal(t, "user", claims["sub"])
+	assert.Equal(t, upstream.server.URL, claims["aud"])
+	assert.Equal(t, "user@example.com", claims["email"])
+	assert.Equal(t, "sid", claims["sid"])
+}
+
+func TestIdentityTokensRequireAsymmetricKeys(t *testing.T) {
+	_, err := proxy.NewIdentityTokens(issuer, testutil.NewSecretKeyring("secret"), time.Minute)
+	assert.Error(t, err)
+}
+
+func TestUnavailableUpstreamIsBadGateway(t *testing.T) {
+	upstream := newUpstream(t)
+	upstream.server.Close()
+	deps := makeProxy([]*proxy.Route{{Prefix: "/apps/wiki/", Upstream: upstream.url()}}, nil)
+	recorder := serve(deps, newRequest("GET", "/apps/wiki/", session.Id))
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+}
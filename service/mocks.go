@@ -12,14 +12,31 @@ type UserAuthenticationServiceMock struct {
 	mock.Mock
 }
 
-func (m *UserAuthenticationServiceMock) IsSessionValid(sessionId string) (bool, error) {
+func (m *UserAuthenticationServiceMock) Session(sessionId string) (*Session, error) {
 	args := m.Mock.Called(sessionId)
-	return args.Bool(0), args.Error(1)
+	session, _ := args.Get(0).(*Session)
+	return session, args.Error(1)
 }
 
-func (m *UserAuthenticationServiceMock) AuthenticateUser(user, password string) (string, error) {
+func (m *UserAuthenticationServiceMock) AuthenticateUser(user, password string) error {
 	args := m.Mock.Called(user, password)
-	return args.String(0), args.Error(1)
+	return args.Error(0)
+}
+
+func (m *UserAuthenticationServiceMock) StartSession(user string, methods []string, acr string) (*Session, error) {
+	args := m.Mock.Called(user, methods, acr)
+	session, _ := args.Get(0).(*Session)
+	return session, args.Error(1)
+}
+
+func (m *UserAuthenticationServiceMock) EndSession(sessionId string) error {
+	args := m.Mock.Called(sessionId)
+	return args.Error(0)
+}
+
+func (m *UserAuthenticationServiceMock) AddSessionClient(sessionId, clientId string) error {
+	args := m.Mock.Called(sessionId, clientId)
+	return args.Error(0)
 }
 
 func NewUserAuthenticationServiceMock() *UserAuthenticationServiceMock {
@@ -30,29 +47,67 @@ type Oauth2ServiceMock struct {
 	mock.Mock
 }
 
+func (s *Oauth2ServiceMock) AuthenticateClient(c *ClientCredentials) error {
+	args := s.Mock.Called(c)
+	return args.Error(0)
+}
+
 func (s *Oauth2ServiceMock) ValidateRequest(clientID, scope, redirectURI string) error {
 	args := s.Mock.Called(clientID, scope, redirectURI)
 	return args.Error(0)
 }
 
-func (s *Oauth2ServiceMock) Password(c *ClientCredentials, username, password string) (*oauth2.AccessTokenResponse, error) {
-	args := s.Mock.Called(c, username, password)
+func (s *Oauth2ServiceMock) ValidatePostLogoutRedirectURI(clientID, redirectURI string) error {
+	args := s.Mock.Called(clientID, redirectURI)
+	return args.Error(0)
+}
+
+func (s *Oauth2ServiceMock) Password(
+	c *ClientCredentials, username, password string, tr *TokenRequest) (*oauth2.AccessTokenResponse, error) {
+
+	args := s.Mock.Called(c, username, password, tr)
 	tokenResponse, _ := args.Get(0).(*oauth2.AccessTokenResponse)
 	return tokenResponse, args.Error(1)
 }
 
-func (s *Oauth2ServiceMock) Code(
-	clientId string, redirectURI *url.URL, scope, state string) (*oauth2.AuthorizationResponse, error) {
-
-	args := s.Mock.Called(clientId, redirectURI, scope, state)
+func (s *Oauth2ServiceMock) Code(r *AuthorizationRequest) (*oauth2.AuthorizationResponse, error) {
+	args := s.Mock.Called(r)
 	response, _ := args.Get(0).(*oauth2.AuthorizationResponse)
 	return response, args.Error(1)
 }
 
+func (s *Oauth2ServiceMock) Token(r *AuthorizationRequest) (*oauth2.AccessTokenResponse, error) {
+	args := s.Mock.Called(r)
+	response, _ := args.Get(0).(*oauth2.AccessTokenResponse)
+	return response, args.Error(1)
+}
+
+func (s *Oauth2ServiceMock) IDTokenClaims(r *AuthorizationRequest) (map[string]interface{}, error) {
+	args := s.Mock.Called(r)
+	claims, _ := args.Get(0).(map[string]interface{})
+	return claims, args.Error(1)
+}
+
 func (s *Oauth2ServiceMock) AuthorizationCode(
-	c *ClientCredentials, code string, redirectURI *url.URL) (*oauth2.AccessTokenResponse, error) {
+	c *ClientCredentials, code string, redirectURI *url.URL, tr *TokenRequest) (*oauth2.AccessTokenResponse, error) {
+
+	args := s.Mock.Called(c, code, redirectURI, tr)
+	tokenResponse, _ := args.Get(0).(*oauth2.AccessTokenResponse)
+	return tokenResponse, args.Error(1)
+}
+
+func (s *Oauth2ServiceMock) RefreshToken(
+	c *ClientCredentials, refreshToken, scope string, tr *TokenRequest) (*oauth2.AccessTokenResponse, error) {
 
-	args := s.Mock.Called(c, code, redirectURI)
+	args := s.Mock.Called(c, refreshToken, scope, tr)
+	tokenResponse, _ := args.Get(0).(*oauth2.AccessTokenResponse)
+	return tokenResponse, args.Error(1)
+}
+
+func (s *Oauth2ServiceMock) ClientCredentials(
+	c *ClientCredentials, scope string, tr *TokenRequest) (*oauth2.AccessTokenResponse, error) {
+
+	args := s.Mock.Called(c, scope, tr)
 	tokenResponse, _ := args.Get(0).(*oauth2.AccessTokenResponse)
 	return tokenResponse, args.Error(1)
 }
@@ -63,6 +118,14 @@ func (s *Oauth2ServiceMock) ScopeInfo(scope, locale string) ([]*ScopeInfo, error
 	return scopeInfo, args.Error(1)
 }
 
+func (s *Oauth2ServiceMock) Introspect(
+	c *ClientCredentials, token, tokenTypeHint string) (*oauth2.IntrospectionResponse, error) {
+
+	args := s.Mock.Called(c, token, tokenTypeHint)
+	response, _ := args.Get(0).(*oauth2.IntrospectionResponse)
+	return response, args.Error(1)
+}
+
 func NewOauth2ServiceMock() *Oauth2ServiceMock {
 	return &Oauth2ServiceMock{}
 }
@@ -71,13 +134,14 @@ type TokenGeneratorMock struct {
 	mock.Mock
 }
 
-func (t *TokenGeneratorMock) Generate(n uint) []byte {
+// Generate returns the error only if one is set as the second return value.
+func (t *TokenGeneratorMock) Generate(n uint) ([]byte, error) {
 	args := t.Mock.Called(n)
-	token, ok := args.Get(0).([]byte)
-	if !ok {
-		panic("Argument 0 not of correct type")
+	token, _ := args.Get(0).([]byte)
+	if len(args) > 1 {
+		return token, args.Error(1)
 	}
-	return token
+	return token, nil
 }
 
 func NewTokenGeneratorMock() *TokenGeneratorMock {
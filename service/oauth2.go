@@ -17,14 +17,106 @@ type ScopeInfo struct {
 	MoreURI     *url.URL
 }
 
+// AuthorizationRequest contains the parameters of an authorization request
+// that was approved by the user.
+type AuthorizationRequest struct {
+	ClientId             string
+	RedirectURI          *url.URL
+	Scope                string
+	State                string
+	AuthorizationDetails []oauth2.AuthorizationDetail
+	// Resource indicators of the protected APIs the client requested access to
+	Resources []string
+	// Thumbprint of the DPoP key the authorization code must be bound to
+	DPoPJKT string
+	// PKCE code challenge the authorization code is bound to, empty if the
+	// client did not send one
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Session of the user that approved the request
+	Session *Session
+	Nonce   string
+}
+
+// TokenRequest contains the optional parameters of a token request that are
+// common to all grant types.
+type TokenRequest struct {
+	// Authorization details the issued access token must be restricted to. For
+	// refresh tokens and codes they must be included in the approved details,
+	// see oauth2.RestrictAuthorizationDetails.
+	AuthorizationDetails []oauth2.AuthorizationDetail
+	// Resource indicators the issued access token must be restricted to. The
+	// audience of the token should be limited to exactly these resources. For
+	// refresh tokens and codes they must be a subset of the granted resources,
+	// see oauth2.RestrictResources.
+	Resources []string
+	// Thumbprint of the key of a validated DPoP proof. If set the issued access
+	// token must be bound to the key with a cnf.jkt confirmation and have token
+	// type DPoP. Refresh tokens issued to public clients must be bound to the
+	// same key and only be accepted with a proof signed by it, token.Manager
+	// Refresh checks the binding.
+	DPoPJKT string
+	// Redeemed code of the authorization code grant, only set if codes are
+	// issued by the built-in code store
+	Code *CodeGrant
+}
+
+// CodeGrant is the authorization a code of the built-in code store was issued
+// for. The code was verified to be unused and redeemed by the client it was
+// issued to, with the same redirect uri and matching PKCE verifier.
+type CodeGrant struct {
+	Subject   string
+	Scope     string
+	Resources []string
+	// Authorization details the user approved
+	AuthorizationDetails []oauth2.AuthorizationDetail
+	// Tokens issued for the code must be in the family of the code so they are
+	// revoked if the code is replayed, JWT access tokens are registered in it
+	// with token.Manager.RegisterJWT
+	FamilyId string
+	// Nonce of the authorization request and the authentication of the user,
+	// the session has only the fields an ID token describes
+	Nonce   string
+	Session *Session
+}
+
 type Oauth2Service interface {
+	// AuthenticateClient authenticates a confidential client at endpoints that
+	// are not token grants, e.g. pushed authorization requests and introspection.
+	// Wrong credentials are an invalid_client *oauth2.ErrorResponse.
+	AuthenticateClient(c *ClientCredentials) error
+
 	ValidateRequest(clientID, scope, redirectURI string) error
 
-	Password(c *ClientCredentials, username, password string) (*oauth2.AccessTokenResponse, error)
+	// ValidatePostLogoutRedirectURI checks that the uri is registered for the
+	// client to return the user to after logout.
+	ValidatePostLogoutRedirectURI(clientID, redirectURI string) error
 
-	Code(clientId string, redirectURI *url.URL, scope, state string) (*oauth2.AuthorizationResponse, error)
+	Password(c *ClientCredentials, username, password string, tr *TokenRequest) (*oauth2.AccessTokenResponse, error)
 
-	AuthorizationCode(c *ClientCredentials, code string, redirectURI *url.URL) (*oauth2.AccessTokenResponse, error)
+	Code(r *AuthorizationRequest) (*oauth2.AuthorizationResponse, error)
+
+	// Token issues an access token directly from the authorization endpoint.
+	Token(r *AuthorizationRequest) (*oauth2.AccessTokenResponse, error)
+
+	// IDTokenClaims returns additional claims of an ID token for the user that
+	// approved the request, such as email. Protocol claims and claims describing
+	// the authentication are set from the request and its session by the caller.
+	IDTokenClaims(r *AuthorizationRequest) (map[string]interface{}, error)
+
+	AuthorizationCode(
+		c *ClientCredentials, code string, redirectURI *url.URL, tr *TokenRequest) (*oauth2.AccessTokenResponse, error)
+
+	RefreshToken(c *ClientCredentials, refreshToken, scope string, tr *TokenRequest) (*oauth2.AccessTokenResponse, error)
+
+	// ClientCredentials issues a token to the client for itself, e.g. a service
+	// calling another service. Wrong credentials are an invalid_client
+	// *oauth2.ErrorResponse.
+	ClientCredentials(c *ClientCredentials, scope string, tr *TokenRequest) (*oauth2.AccessTokenResponse, error)
 
 	ScopeInfo(scope, locale string) ([]*ScopeInfo, error)
+
+	// Introspect returns the state of the token for the authenticated client,
+	// e.g. a resource server. Tokens the client may not introspect are inactive.
+	Introspect(c *ClientCredentials, token, tokenTypeHint string) (*oauth2.IntrospectionResponse, error)
 }
@@ -3,7 +3,8 @@ package service
 import "crypto/rand"
 
 type TokenGenerator interface {
-	Generate(n uint) []byte
+	// Generate returns n random bytes.
+	Generate(n uint) ([]byte, error)
 }
 
 type CryptoTokenGenerator struct{}
@@ -12,11 +13,10 @@ func NewCryptoTokenGenerator() *CryptoTokenGenerator {
 	return &CryptoTokenGenerator{}
 }
 
-func (c *CryptoTokenGenerator) Generate(n uint) []byte {
+func (c *CryptoTokenGenerator) Generate(n uint) ([]byte, error) {
 	token := make([]byte, n)
-	_, err := rand.Read(token)
-	if err != nil {
-		panic("Error generating random token")
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
 	}
-	return token
+	return token, nil
 }
@@ -1,12 +1,55 @@
 package service
 
+import "time"
+
 type CredentialsMismatch struct{}
 
 func (c CredentialsMismatch) Error() string {
 	return "Credentials mismatch"
 }
 
+// Session is a login session of a user. Id is the secret value of the session
+// cookie and must never be exposed to clients, Sid identifies the session to
+// clients, e.g. in ID tokens.
+type Session struct {
+	Id      string
+	Sid     string
+	Subject string
+	// Time when the user authenticated
+	AuthTime time.Time
+	// Authentication method references, e.g. pwd
+	Methods []string
+	// Authentication context class reference
+	ACR string
+	// Absolute expiration time of the session
+	ExpiresAt time.Time
+	// Session expires if it is not used for longer than the idle timeout
+	LastActivity time.Time
+	IdleTimeout  time.Duration
+	// Clients that were issued tokens during the session, they are notified on logout
+	Clients []string
+}
+
+// Valid reports whether the session has neither expired nor timed out.
+func (s *Session) Valid(now time.Time) bool {
+	if !now.Before(s.ExpiresAt) {
+		return false
+	}
+	return s.IdleTimeout == 0 || now.Sub(s.LastActivity) < s.IdleTimeout
+}
+
 type UserAuthenticationService interface {
-	IsSessionValid(sessionId string) (bool, error)
-	AuthenticateUser(user, password string) (string, error)
+	// Session returns the session with the given id or nil if the session does not
+	// exist or is no longer valid.
+	Session(sessionId string) (*Session, error)
+	// AuthenticateUser checks the credentials of the user, CredentialsMismatch is
+	// returned if they are not valid.
+	AuthenticateUser(user, password string) error
+	// StartSession starts a new session of the user authenticated with the given
+	// authentication methods, acr is the satisfied authentication context class.
+	StartSession(user string, methods []string, acr string) (*Session, error)
+	// EndSession ends the session, it is no longer valid after logout.
+	EndSession(sessionId string) error
+	// AddSessionClient records that the client was issued tokens during the session.
+	AddSessionClient(sessionId, clientId string) error
 }
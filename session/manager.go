@@ -0,0 +1,101 @@
+package session
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/arjantop/gopherauth/service"
+)
+
+const (
+	// Size of the random session id in bytes
+	IdSize = 32
+	// Size of the random client visible session id in bytes
+	SidSize = 16
+)
+
+// Manager creates sessions and enforces their expiration. The idle timeout is
+// sliding, every successful lookup extends it.
+type Manager struct {
+	store          Store
+	tokenGenerator service.TokenGenerator
+	lifetime       time.Duration
+	idleTimeout    time.Duration
+}
+
+// NewManager returns a manager creating sessions valid for lifetime. An idle
+// timeout of zero disables the idle timeout.
+func NewManager(
+	store Store,
+	tokenGenerator service.TokenGenerator,
+	lifetime, idleTimeout time.Duration) *Manager {
+
+	return &Manager{
+		store:          store,
+		tokenGenerator: tokenGenerator,
+		lifetime:       lifetime,
+		idleTimeout:    idleTimeout,
+	}
+}
+
+// Create starts a new session of the subject authenticated with the given
+// methods.
+func (m *Manager) Create(subject string, methods []string, acr string) (*service.Session, error) {
+	id, err := m.generate(IdSize)
+	if err != nil {
+		return nil, err
+	}
+	sid, err := m.generate(SidSize)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s := &service.Session{
+		Id:           id,
+		Sid:          sid,
+		Subject:      subject,
+		AuthTime:     now,
+		Methods:      methods,
+		ACR:          acr,
+		ExpiresAt:    now.Add(m.lifetime),
+		LastActivity: now,
+		IdleTimeout:  m.idleTimeout,
+	}
+	if err := m.store.Save(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Session returns a valid session and records the activity, or nil if the
+// session does not exist or has expired. Expired sessions are removed.
+func (m *Manager) Session(id string) (*service.Session, error) {
+	s, err := m.store.Get(id)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !s.Valid(now) {
+		return nil, m.store.Delete(id)
+	}
+	// The session may have ended since it was read, it must not be recreated
+	return m.store.Touch(id, now)
+}
+
+// AddClient records that the client was issued tokens during the session.
+func (m *Manager) AddClient(id, clientId string) error {
+	return m.store.AddClient(id, clientId)
+}
+
+// End ends the session.
+func (m *Manager) End(id string) error {
+	return m.store.Delete(id)
+}
+
+func (m *Manager) generate(size uint) (string, error) {
+	id, err := m.tokenGenerator.Generate(size)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
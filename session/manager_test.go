@@ -0,0 +1,105 @@
+package session_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/session"
+)
+
+func makeManager(t *testing.T) (*session.Manager, *session.MemoryStore) {
+	store, err := session.NewMemoryStore(nil)
+	assert.Nil(t, err)
+	return session.NewManager(store, service.NewCryptoTokenGenerator(), time.Hour, 10*time.Minute), store
+}
+
+func TestCreatedSessionDescribesAuthentication(t *testing.T) {
+	manager, _ := makeManager(t)
+
+	s, err := manager.Create("user", []string{"pwd"}, "acr1")
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, s.Id)
+	assert.NotEmpty(t, s.Sid)
+	assert.NotEqual(t, s.Id, s.Sid, "Client visible sid must differ from the session id")
+	assert.Equal(t, "user", s.Subject)
+	assert.Equal(t, []string{"pwd"}, s.Methods)
+	assert.Equal(t, "acr1", s.ACR)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), s.ExpiresAt, time.Second)
+
+	found, err := manager.Session(s.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "user", found.Subject)
+}
+
+func TestSessionLookupExtendsIdleTimeout(t *testing.T) {
+	manager, store := makeManager(t)
+	s, err := manager.Create("user", []string{"pwd"}, "")
+	assert.Nil(t, err)
+	s.LastActivity = time.Now().Add(-5 * time.Minute)
+	assert.Nil(t, store.Save(s))
+
+	_, err = manager.Session(s.Id)
+	assert.Nil(t, err)
+
+	stored, err := store.Get(s.Id)
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), stored.LastActivity, time.Second)
+}
+
+func TestIdleSessionIsExpiredAndRemoved(t *testing.T) {
+	manager, store := makeManager(t)
+	s, err := manager.Create("user", []string{"pwd"}, "")
+	assert.Nil(t, err)
+	s.LastActivity = time.Now().Add(-11 * time.Minute)
+	assert.Nil(t, store.Save(s))
+
+	found, err := manager.Session(s.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	stored, err := store.Get(s.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+}
+
+func TestSessionExpiresAfterLifetimeDespiteActivity(t *testing.T) {
+	manager, store := makeManager(t)
+	s, err := manager.Create("user", []string{"pwd"}, "")
+	assert.Nil(t, err)
+	s.ExpiresAt = time.Now().Add(-time.Second)
+	assert.Nil(t, store.Save(s))
+
+	found, err := manager.Session(s.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+}
+
+func TestEndedSessionIsNotFound(t *testing.T) {
+	manager, _ := makeManager(t)
+	s, err := manager.Create("user", []string{"pwd"}, "")
+	assert.Nil(t, err)
+
+	assert.Nil(t, manager.End(s.Id))
+
+	found, err := manager.Session(s.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+}
+
+func TestSessionClientsAreRecordedOnce(t *testing.T) {
+	manager, _ := makeManager(t)
+	s, err := manager.Create("user", []string{"pwd"}, "")
+	assert.Nil(t, err)
+
+	assert.Nil(t, manager.AddClient(s.Id, "client1"))
+	assert.Nil(t, manager.AddClient(s.Id, "client2"))
+	assert.Nil(t, manager.AddClient(s.Id, "client1"))
+
+	found, err := manager.Session(s.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"client1", "client2"}, found.Clients)
+}
@@ -0,0 +1,134 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/service"
+)
+
+// Store stores sessions by their id.
+type Store interface {
+	// Get returns the session or nil if it does not exist.
+	Get(id string) (*service.Session, error)
+	Save(s *service.Session) error
+	// Touch records activity of an existing session and returns it, or nil if
+	// the session does not exist. A missing session is not created.
+	Touch(id string, now time.Time) (*service.Session, error)
+	// AddClient adds the client to an existing session if it is not in it yet.
+	// A missing session is not created.
+	AddClient(id, clientId string) error
+	Delete(id string) error
+}
+
+// Persistence stores sessions outside of memory so they survive a restart.
+type Persistence interface {
+	LoadAll() ([]*service.Session, error)
+	Save(s *service.Session) error
+	Delete(id string) error
+}
+
+// MemoryStore keeps sessions in memory. Changes are written through to the
+// persistence if one is configured.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	sessions    map[string]*service.Session
+	persistence Persistence
+}
+
+// NewMemoryStore returns a store loaded with the persisted sessions. Persistence
+// can be nil.
+func NewMemoryStore(persistence Persistence) (*MemoryStore, error) {
+	store := &MemoryStore{
+		sessions:    make(map[string]*service.Session),
+		persistence: persistence,
+	}
+	if persistence != nil {
+		sessions, err := persistence.LoadAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sessions {
+			store.sessions[s.Id] = s
+		}
+	}
+	return store, nil
+}
+
+func (m *MemoryStore) Get(id string) (*service.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	// Callers must not modify the stored session without saving it
+	copied := *s
+	return &copied, nil
+}
+
+func (m *MemoryStore) Save(s *service.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.persistence != nil {
+		if err := m.persistence.Save(s); err != nil {
+			return err
+		}
+	}
+	copied := *s
+	m.sessions[s.Id] = &copied
+	return nil
+}
+
+func (m *MemoryStore) Touch(id string, now time.Time) (*service.Session, error) {
+	return m.update(id, func(s *service.Session) {
+		s.LastActivity = now
+	})
+}
+
+func (m *MemoryStore) AddClient(id, clientId string) error {
+	_, err := m.update(id, func(s *service.Session) {
+		for _, c := range s.Clients {
+			if c == clientId {
+				return
+			}
+		}
+		clients := make([]string, len(s.Clients), len(s.Clients)+1)
+		copy(clients, s.Clients)
+		s.Clients = append(clients, clientId)
+	})
+	return err
+}
+
+// update modifies a copy of an existing session under the lock and stores it,
+// so concurrent changes and deletes are not lost.
+func (m *MemoryStore) update(id string, modify func(s *service.Session)) (*service.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *s
+	modify(&copied)
+	if m.persistence != nil {
+		if err := m.persistence.Save(&copied); err != nil {
+			return nil, err
+		}
+	}
+	m.sessions[id] = &copied
+	result := copied
+	return &result, nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.persistence != nil {
+		if err := m.persistence.Delete(id); err != nil {
+			return err
+		}
+	}
+	delete(m.sessions, id)
+	return nil
+}
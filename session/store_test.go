@@ -0,0 +1,150 @@
+package session_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/session"
+)
+
+type persistenceStub struct {
+	sessions map[string]*service.Session
+	err      error
+}
+
+func newPersistenceStub() *persistenceStub {
+	return &persistenceStub{sessions: make(map[string]*service.Session)}
+}
+
+func (p *persistenceStub) LoadAll() ([]*service.Session, error) {
+	sessions := make([]*service.Session, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions, p.err
+}
+
+func (p *persistenceStub) Save(s *service.Session) error {
+	if p.err != nil {
+		return p.err
+	}
+	copied := *s
+	p.sessions[s.Id] = &copied
+	return nil
+}
+
+func (p *persistenceStub) Delete(id string) error {
+	delete(p.sessions, id)
+	return p.err
+}
+
+func TestMemoryStoreReturnsNilForUnknownSession(t *testing.T) {
+	store, err := session.NewMemoryStore(nil)
+	assert.Nil(t, err)
+
+	s, err := store.Get("unknown")
+
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+}
+
+func TestMemoryStoreWritesThroughToPersistence(t *testing.T) {
+	persistence := newPersistenceStub()
+	store, err := session.NewMemoryStore(persistence)
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.Save(&service.Session{Id: "id", Subject: "user"}))
+	assert.Equal(t, "user", persistence.sessions["id"].Subject)
+
+	assert.Nil(t, store.Delete("id"))
+	assert.Empty(t, persistence.sessions)
+}
+
+func TestMemoryStoreIsLoadedFromPersistence(t *testing.T) {
+	persistence := newPersistenceStub()
+	persistence.sessions["id"] = &service.Session{Id: "id", Subject: "user"}
+
+	store, err := session.NewMemoryStore(persistence)
+	assert.Nil(t, err)
+
+	s, err := store.Get("id")
+	assert.Nil(t, err)
+	assert.Equal(t, "user", s.Subject)
+}
+
+func TestMemoryStoreDoesNotKeepSessionIfPersistenceFails(t *testing.T) {
+	persistence := newPersistenceStub()
+	store, err := session.NewMemoryStore(persistence)
+	assert.Nil(t, err)
+	persistence.err = errors.New("error")
+
+	assert.NotNil(t, store.Save(&service.Session{Id: "id"}))
+
+	s, err := store.Get("id")
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+}
+
+func TestMemoryStoreDoesNotRecreateTouchedSession(t *testing.T) {
+	persistence := newPersistenceStub()
+	store, err := session.NewMemoryStore(persistence)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Save(&service.Session{Id: "id", Subject: "user"}))
+	assert.Nil(t, store.Delete("id"))
+
+	s, err := store.Touch("id", time.Now())
+
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+	s, err = store.Get("id")
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+	assert.Empty(t, persistence.sessions)
+}
+
+func TestMemoryStoreTouchKeepsOtherChanges(t *testing.T) {
+	store, err := session.NewMemoryStore(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Save(&service.Session{Id: "id", Subject: "user"}))
+	assert.Nil(t, store.Save(&service.Session{Id: "id", Subject: "user", Clients: []string{"client1"}}))
+	now := time.Now()
+
+	s, err := store.Touch("id", now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, now, s.LastActivity)
+	assert.Equal(t, []string{"client1"}, s.Clients)
+}
+
+func TestMemoryStoreAddsClientToCurrentSession(t *testing.T) {
+	store, err := session.NewMemoryStore(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Save(&service.Session{Id: "id", Subject: "user"}))
+	stale, err := store.Get("id")
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.AddClient("id", "client1"))
+	_, err = store.Touch(stale.Id, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, store.AddClient("id", "client2"))
+
+	s, err := store.Get("id")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"client1", "client2"}, s.Clients)
+	assert.Empty(t, stale.Clients)
+}
+
+func TestMemoryStoreDoesNotAddClientToDeletedSession(t *testing.T) {
+	store, err := session.NewMemoryStore(nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.AddClient("id", "client1"))
+
+	s, err := store.Get("id")
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+}
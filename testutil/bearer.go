@@ -0,0 +1,20 @@
+package testutil
+
+import (
+	"github.com/arjantop/gopherauth/bearer"
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// StaticValidator accepts the tokens it has claims for.
+type StaticValidator map[string]*bearer.Claims
+
+func (v StaticValidator) Validate(token string) (*bearer.Claims, error) {
+	claims, ok := v[token]
+	if !ok {
+		return nil, &oauth2.ErrorResponse{
+			ErrorCode:   oauth2.ErrorInvalidToken,
+			Description: "Access token is not valid",
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,53 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/jose"
+)
+
+// NewDPoPKey generates a P-256 key that can be used to sign DPoP proofs.
+func NewDPoPKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return key
+}
+
+// DPoPThumbprint returns the JWK thumbprint of the public part of the key.
+func DPoPThumbprint(t *testing.T, key *ecdsa.PrivateKey) string {
+	jwk, err := jose.NewJSONWebKey(&key.PublicKey)
+	assert.Nil(t, err)
+	thumbprint, err := jwk.Thumbprint()
+	assert.Nil(t, err)
+	return thumbprint
+}
+
+// NewDPoPProof signs a DPoP proof with the given claims. The jti and iat
+// claims are set to unique and current values if they are empty.
+func NewDPoPProof(t *testing.T, key *ecdsa.PrivateKey, claims map[string]interface{}) string {
+	jwk, err := jose.NewJSONWebKey(&key.PublicKey)
+	assert.Nil(t, err)
+	signer, err := jose.NewSigner(jose.AlgorithmES256, key, "")
+	assert.Nil(t, err)
+	if _, ok := claims["jti"]; !ok {
+		jti := make([]byte, 16)
+		rand.Read(jti)
+		claims["jti"] = hex.EncodeToString(jti)
+	}
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = time.Now().Unix()
+	}
+	payload, err := json.Marshal(claims)
+	assert.Nil(t, err)
+	proof, err := jose.Sign(signer, jose.Header{Type: "dpop+jwt", JWK: jwk}, payload)
+	assert.Nil(t, err)
+	return proof
+}
@@ -0,0 +1,54 @@
+package testutil
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/arjantop/gopherauth/jose"
+	"github.com/arjantop/gopherauth/keyring"
+)
+
+// NewKEK returns a key-encryption key with a random key.
+func NewKEK() *keyring.KEK {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	kek, err := keyring.NewKEK(key)
+	if err != nil {
+		panic(err)
+	}
+	return kek
+}
+
+// NewSecretKeyring returns a keyring whose active key is the first secret, the
+// other secrets are retired keys that are still accepted.
+func NewSecretKeyring(secrets ...string) *keyring.Keyring {
+	now := time.Now()
+	keys := make([]*keyring.Key, len(secrets))
+	for i, secret := range secrets {
+		keys[i] = &keyring.Key{
+			ID:          fmt.Sprintf("key%d", i),
+			Algorithm:   jose.AlgorithmHS256,
+			State:       keyring.StateActive,
+			Private:     []byte(secret),
+			CreatedAt:   now.Add(-time.Duration(i) * time.Hour),
+			ActivatedAt: now.Add(-time.Duration(i) * time.Hour),
+		}
+		if i > 0 {
+			keys[i].State = keyring.StateRetired
+			keys[i].RetiredAt = now
+			keys[i].ExpiresAt = now.Add(time.Hour)
+		}
+	}
+	store := keyring.NewMemoryStore()
+	if err := store.Save(keys); err != nil {
+		panic(err)
+	}
+	serverKeys, err := keyring.NewKeyring(store, keyring.UsageSecret, keyring.Schedule{Algorithm: jose.AlgorithmHS256})
+	if err != nil {
+		panic(err)
+	}
+	return serverKeys
+}
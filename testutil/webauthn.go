@@ -0,0 +1,238 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/webauthn"
+)
+
+// SoftwareAuthenticator is an ES256 authenticator holding a single
+// credential, it answers ceremonies the way a browser and a security key would.
+type SoftwareAuthenticator struct {
+	RPID   string
+	Origin string
+	AAGUID []byte
+	Key    *ecdsa.PrivateKey
+	// Credential created by the last registration
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+	// Flags reported in authenticator data, user presence is always reported
+	UserVerified bool
+	// Attestation format of registrations, none or packed
+	Format string
+	// Packed attestation is self attestation unless a certificate is set
+	AttestationKey         *ecdsa.PrivateKey
+	AttestationCertificate []byte
+}
+
+// NewSoftwareAuthenticator returns an authenticator that verifies the user and
+// registers credentials without attestation.
+func NewSoftwareAuthenticator(t *testing.T, rpID, origin string) *SoftwareAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return &SoftwareAuthenticator{
+		RPID:         rpID,
+		Origin:       origin,
+		AAGUID:       []byte("gopherauth-tests"),
+		Key:          key,
+		UserVerified: true,
+		Format:       webauthn.AttestationNone,
+	}
+}
+
+// UsePackedCertificateAttestation generates an attestation certificate that
+// packed attestation statements are signed with.
+func (a *SoftwareAuthenticator) UsePackedCertificateAttestation(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	aaguid, err := asn1.Marshal(a.AAGUID)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"SI"},
+			Organization:       []string{"gopherauth"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "gopherauth software authenticator",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}, Value: aaguid},
+		},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	a.Format = webauthn.AttestationPacked
+	a.AttestationKey = key
+	a.AttestationCertificate = certificate
+}
+
+// Create registers a new credential for the options.
+func (a *SoftwareAuthenticator) Create(t *testing.T, options *webauthn.CreationOptions) *webauthn.RegistrationResponse {
+	a.CredentialID = make([]byte, 16)
+	_, err := rand.Read(a.CredentialID)
+	assert.Nil(t, err)
+	a.UserHandle = options.User.ID
+	a.SignCount = 1
+
+	clientDataJSON := a.clientData(t, "webauthn.create", options.Challenge)
+	publicKey := EncodeCBOR(map[interface{}]interface{}{
+		1:  2,
+		3:  webauthn.AlgorithmES256,
+		-1: 1,
+		-2: padLeft(a.Key.PublicKey.X.Bytes(), 32),
+		-3: padLeft(a.Key.PublicKey.Y.Bytes(), 32),
+	})
+	attestedCredential := append([]byte{}, a.AAGUID...)
+	attestedCredential = binary.BigEndian.AppendUint16(attestedCredential, uint16(len(a.CredentialID)))
+	attestedCredential = append(attestedCredential, a.CredentialID...)
+	attestedCredential = append(attestedCredential, publicKey...)
+	authData := append(a.authenticatorData(webauthn.FlagAttestedCredential), attestedCredential...)
+
+	statement := map[interface{}]interface{}{}
+	if a.Format == webauthn.AttestationPacked {
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		signingKey := a.Key
+		if a.AttestationKey != nil {
+			signingKey = a.AttestationKey
+			statement["x5c"] = []interface{}{a.AttestationCertificate}
+		}
+		statement["alg"] = webauthn.AlgorithmES256
+		statement["sig"] = sign(t, signingKey, append(append([]byte{}, authData...), clientDataHash[:]...))
+	}
+	attestationObject := EncodeCBOR(map[interface{}]interface{}{
+		"fmt":      a.Format,
+		"attStmt":  statement,
+		"authData": authData,
+	})
+
+	response := &webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.CredentialID),
+		RawID: a.CredentialID,
+		Type:  webauthn.CredentialTypePublicKey,
+	}
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AttestationObject = attestationObject
+	return response
+}
+
+// Get signs an assertion with the registered credential for the options.
+func (a *SoftwareAuthenticator) Get(t *testing.T, options *webauthn.RequestOptions) *webauthn.AssertionResponse {
+	a.SignCount++
+	clientDataJSON := a.clientData(t, "webauthn.get", options.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	authData := a.authenticatorData(0)
+
+	response := &webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.CredentialID),
+		RawID: a.CredentialID,
+		Type:  webauthn.CredentialTypePublicKey,
+	}
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AuthenticatorData = authData
+	response.Response.Signature = sign(t, a.Key, append(append([]byte{}, authData...), clientDataHash[:]...))
+	response.Response.UserHandle = a.UserHandle
+	return response
+}
+
+func (a *SoftwareAuthenticator) clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	clientDataJSON, err := json.Marshal(map[string]interface{}{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.Origin,
+	})
+	assert.Nil(t, err)
+	return clientDataJSON
+}
+
+func (a *SoftwareAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	flags |= webauthn.FlagUserPresent
+	if a.UserVerified {
+		flags |= webauthn.FlagUserVerified
+	}
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.SignCount)
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, message []byte) []byte {
+	digest := sha256.Sum256(message)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	assert.Nil(t, err)
+	return signature
+}
+
+func padLeft(b []byte, size int) []byte {
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+// EncodeCBOR encodes integers, strings, byte strings, arrays and maps as CBOR.
+// Map keys are sorted so the encoding is deterministic.
+func EncodeCBOR(value interface{}) []byte {
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		encoded := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			encoded = append(encoded, EncodeCBOR(item)...)
+		}
+		return encoded
+	case map[interface{}]interface{}:
+		keys := make([][]byte, 0, len(v))
+		values := make(map[string][]byte, len(v))
+		for key, item := range v {
+			encodedKey := EncodeCBOR(key)
+			keys = append(keys, encodedKey)
+			values[string(encodedKey)] = EncodeCBOR(item)
+		}
+		sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+		encoded := cborHead(5, uint64(len(v)))
+		for _, key := range keys {
+			encoded = append(append(encoded, key...), values[string(key)]...)
+		}
+		return encoded
+	}
+	panic("Unsupported CBOR value")
+}
+
+func cborHead(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, argument)
+}
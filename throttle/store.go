@@ -0,0 +1,75 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Counter counts the failed attempts of a key, e.g. of an account.
+type Counter struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	// Attempts are rejected until the time
+	LockedUntil time.Time
+}
+
+// Store persists counters.
+type Store interface {
+	// Get returns the counter of the key or nil if there is none.
+	Get(key string) (*Counter, error)
+	// CompareAndSwap replaces the stored counter with the new one if it is
+	// equal to old, nil old means there is no stored counter. It reports
+	// whether the counter was replaced, concurrent updates are not lost.
+	CompareAndSwap(old, new *Counter) (bool, error)
+	Delete(key string) error
+}
+
+// MemoryStore is a store that keeps counters in memory.
+type MemoryStore struct {
+	counters map[string]*Counter
+	mutex    sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]*Counter)}
+}
+
+func (s *MemoryStore) Get(key string) (*Counter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.counters[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (s *MemoryStore) CompareAndSwap(old, new *Counter) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !old.equal(s.counters[new.Key]) {
+		return false, nil
+	}
+	copied := *new
+	s.counters[new.Key] = &copied
+	return true, nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+func (c *Counter) equal(other *Counter) bool {
+	if c == nil || other == nil {
+		return c == other
+	}
+	return c.Key == other.Key &&
+		c.Failures == other.Failures &&
+		c.LastFailure.Equal(other.LastFailure) &&
+		c.LockedUntil.Equal(other.LockedUntil)
+}
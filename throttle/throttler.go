@@ -0,0 +1,316 @@
+// Package throttle limits failed authentication attempts per account, IP
+// address, client and login transaction.
+package throttle
+
+import (
+	"fmt"
+	"time"
+)
+
+// Scopes attempts are counted in
+const (
+	ScopeAccount     = "account"
+	ScopeIP          = "ip"
+	ScopeClient      = "client"
+	ScopeTransaction = "transaction"
+)
+
+// Policy configures how failed attempts in a scope are throttled.
+type Policy struct {
+	// Failures allowed before following attempts are delayed
+	FreeFailures int
+	// Delay after the first delayed failure, it doubles with every following
+	// failure up to the maximum, zero maximum does not limit it
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Failures after which attempts are rejected for the lockout duration,
+	// zero disables the lockout
+	LockoutFailures int
+	LockoutDuration time.Duration
+	// Failures are forgotten if there were none for the duration
+	ResetAfter time.Duration
+}
+
+// Attempt identifies an authentication attempt, empty values are not counted.
+type Attempt struct {
+	Account string
+	IP      string
+	Client  string
+	// Login transaction of the attempt, it limits the failures of all steps
+	Transaction string
+}
+
+// Throttled is returned when an attempt is rejected.
+type Throttled struct {
+	Scope string
+	// Time after which the attempt can be retried
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e Throttled) Error() string {
+	return fmt.Sprintf("Too many failed attempts in scope %s, retry after %s", e.Scope, e.RetryAfter)
+}
+
+// Lockout is the notification of a locked key.
+type Lockout struct {
+	Scope, Value string
+	Failures     int
+	LockedUntil  time.Time
+}
+
+// Notifier is notified when a key is locked out, e.g. to alert the owner of
+// the account.
+type Notifier interface {
+	Locked(l Lockout)
+}
+
+// Throttler counts failed attempts and rejects attempts that come too soon.
+type Throttler struct {
+	store    Store
+	policies map[string]Policy
+	notifier Notifier
+	// Factor the failures of accounts are counted for
+	factor string
+}
+
+// NewThrottler returns a throttler with policies by scope, scopes without a
+// policy are not throttled. Nil notifier disables notifications.
+func NewThrottler(store Store, policies map[string]Policy, notifier Notifier) *Throttler {
+	return &Throttler{
+		store:    store,
+		policies: policies,
+		notifier: notifier,
+	}
+}
+
+// ForFactor returns a throttler that counts failures of accounts separately for
+// the authentication factor, e.g. one-time codes, so a successful password does
+// not forget failed codes. Failures in other scopes are shared.
+func (t *Throttler) ForFactor(factor string) *Throttler {
+	if t == nil {
+		return nil
+	}
+	forFactor := *t
+	forFactor.factor = factor
+	return &forFactor
+}
+
+// Check returns Throttled if the attempt must not be made yet. The longest
+// wait of all scopes is returned. The attempt is not counted, credentials must
+// be verified after Attempt.
+func (t *Throttler) Check(a Attempt, now time.Time) error {
+	var throttled *Throttled
+	for scope, value := range a.values() {
+		policy, ok := t.policies[scope]
+		if !ok || value == "" {
+			continue
+		}
+		c, err := t.store.Get(t.key(scope, value))
+		if err != nil {
+			return err
+		}
+		wait, locked := policy.wait(c, now)
+		if wait > 0 && (throttled == nil || wait > throttled.RetryAfter) {
+			throttled = &Throttled{Scope: scope, RetryAfter: wait, Locked: locked}
+		}
+	}
+	if throttled != nil {
+		return *throttled
+	}
+	return nil
+}
+
+// Attempt returns Throttled like Check or counts the attempt as failed before
+// the credentials are verified, so concurrent attempts can not all pass the
+// check before their failures are recorded. The outcome of a counted attempt
+// must be reported with Failure, Success or Cancel.
+func (t *Throttler) Attempt(a Attempt, now time.Time) error {
+	counted := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		policy, ok := t.policies[scope]
+		value := a.values()[scope]
+		if !ok || value == "" {
+			continue
+		}
+		k := t.key(scope, value)
+		err := t.update(k, func(c *Counter) (*Counter, error) {
+			if wait, locked := policy.wait(c, now); wait > 0 {
+				return nil, Throttled{Scope: scope, RetryAfter: wait, Locked: locked}
+			}
+			if c == nil || policy.expired(c, now) {
+				c = &Counter{Key: k}
+			}
+			c.Failures++
+			c.LastFailure = now
+			return c, nil
+		})
+		if _, ok := err.(Throttled); ok {
+			if err := t.uncount(a, counted); err != nil {
+				return err
+			}
+			// Other scopes might have a longer wait
+			if checked := t.Check(a, now); checked != nil {
+				return checked
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+		counted = append(counted, scope)
+	}
+	return nil
+}
+
+// Failure reports that the counted attempt failed, keys that reached the
+// lockout limit are locked out.
+func (t *Throttler) Failure(a Attempt, now time.Time) error {
+	for _, scope := range scopes {
+		policy, ok := t.policies[scope]
+		value := a.values()[scope]
+		if !ok || value == "" || policy.LockoutFailures <= 0 {
+			continue
+		}
+		var locked *Counter
+		err := t.update(t.key(scope, value), func(c *Counter) (*Counter, error) {
+			locked = nil
+			if c == nil || c.Failures < policy.LockoutFailures || now.Before(c.LockedUntil) {
+				return nil, nil
+			}
+			c.LockedUntil = now.Add(policy.LockoutDuration)
+			locked = c
+			return c, nil
+		})
+		if err != nil {
+			return err
+		}
+		if locked != nil && t.notifier != nil {
+			t.notifier.Locked(Lockout{
+				Scope:       scope,
+				Value:       value,
+				Failures:    locked.Failures,
+				LockedUntil: locked.LockedUntil,
+			})
+		}
+	}
+	return nil
+}
+
+// Success reports that the counted attempt succeeded and forgets the failures
+// of the account. Failures of the IP address, client and transaction are kept,
+// an attacker could otherwise reset them with an own account.
+func (t *Throttler) Success(a Attempt) error {
+	if err := t.uncount(a, []string{ScopeIP, ScopeClient, ScopeTransaction}); err != nil {
+		return err
+	}
+	if _, ok := t.policies[ScopeAccount]; !ok || a.Account == "" {
+		return nil
+	}
+	return t.store.Delete(t.key(ScopeAccount, a.Account))
+}
+
+// Cancel removes the counted attempt from all scopes, e.g. when the credentials
+// could not be verified because of an error.
+func (t *Throttler) Cancel(a Attempt) error {
+	return t.uncount(a, scopes)
+}
+
+// uncount removes one failure from the counters of the scopes.
+func (t *Throttler) uncount(a Attempt, counted []string) error {
+	for _, scope := range counted {
+		value := a.values()[scope]
+		if _, ok := t.policies[scope]; !ok || value == "" {
+			continue
+		}
+		err := t.update(t.key(scope, value), func(c *Counter) (*Counter, error) {
+			if c == nil || c.Failures == 0 {
+				return nil, nil
+			}
+			c.Failures--
+			return c, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update applies the change to the counter of the key until it is not changed
+// concurrently. The change returns nil if the counter must not be changed.
+func (t *Throttler) update(k string, change func(c *Counter) (*Counter, error)) error {
+	for {
+		old, err := t.store.Get(k)
+		if err != nil {
+			return err
+		}
+		var current *Counter
+		if old != nil {
+			copied := *old
+			current = &copied
+		}
+		updated, err := change(current)
+		if err != nil || updated == nil {
+			return err
+		}
+		if swapped, err := t.store.CompareAndSwap(old, updated); err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// scopes in the order attempts are counted in
+var scopes = []string{ScopeAccount, ScopeIP, ScopeClient, ScopeTransaction}
+
+func (a Attempt) values() map[string]string {
+	return map[string]string{
+		ScopeAccount:     a.Account,
+		ScopeIP:          a.IP,
+		ScopeClient:      a.Client,
+		ScopeTransaction: a.Transaction,
+	}
+}
+
+func (t *Throttler) key(scope, value string) string {
+	if scope == ScopeAccount && t.factor != "" {
+		return scope + ":" + t.factor + ":" + value
+	}
+	return scope + ":" + value
+}
+
+// wait returns the time until the next attempt is allowed and whether the key
+// is locked out.
+func (p Policy) wait(c *Counter, now time.Time) (time.Duration, bool) {
+	if c == nil || p.expired(c, now) {
+		return 0, false
+	}
+	if now.Before(c.LockedUntil) {
+		return c.LockedUntil.Sub(now), true
+	}
+	delayed := c.Failures - p.FreeFailures
+	if delayed <= 0 || p.BaseDelay <= 0 {
+		return 0, false
+	}
+	// Shift is limited so the delay does not overflow
+	shift := delayed - 1
+	if shift > 20 {
+		shift = 20
+	}
+	delay := p.BaseDelay << uint(shift)
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if wait := c.LastFailure.Add(delay).Sub(now); wait > 0 {
+		return wait, false
+	}
+	return 0, false
+}
+
+// expired reports whether the failures of the counter are forgotten, counting
+// starts again after a lockout has passed.
+func (p Policy) expired(c *Counter, now time.Time) bool {
+	if !c.LockedUntil.IsZero() && !now.Before(c.LockedUntil) {
+		return true
+	}
+	return p.ResetAfter > 0 && now.Sub(c.LastFailure) >= p.ResetAfter
+}
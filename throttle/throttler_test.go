@@ -0,0 +1,175 @@
+package throttle_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/throttle"
+)
+
+type notifierMock struct {
+	lockouts []throttle.Lockout
+}
+
+func (n *notifierMock) Locked(l throttle.Lockout) {
+	n.lockouts = append(n.lockouts, l)
+}
+
+var attempt = throttle.Attempt{Account: "user@example.com", IP: "192.0.2.1", Client: "client_id"}
+
+func makeThrottler(policies map[string]throttle.Policy) (*throttle.Throttler, *notifierMock) {
+	notifier := &notifierMock{}
+	return throttle.NewThrottler(throttle.NewMemoryStore(), policies, notifier), notifier
+}
+
+func fail(t *testing.T, throttler *throttle.Throttler, a throttle.Attempt, times int, now time.Time) {
+	for i := 0; i < times; i++ {
+		assert.Nil(t, throttler.Attempt(a, now))
+		assert.Nil(t, throttler.Failure(a, now))
+	}
+}
+
+func TestFreeFailuresAreNotThrottled(t *testing.T) {
+	throttler, _ := makeThrottler(map[string]throttle.Policy{
+		throttle.ScopeAccount: {FreeFailures: 3, BaseDelay: time.Second},
+	})
+	now := time.Now()
+
+	fail(t, throttler, attempt, 3, now)
+
+	assert.Nil(t, throttler.Check(attempt, now))
+}
+
+func TestDelayDoublesWithEveryFailure(t *testing.T) {
+	throttler, _ := makeThrottler(map[string]throttle.Policy{
+		throttle.ScopeAccount: {FreeFailures: 1, BaseDelay: time.Second, MaxDelay: 3 * time.Second},
+	})
+	now := time.Now()
+	fail(t, throttler, attempt, 1, now)
+
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		fail(t, throttler, attempt, 1, now)
+		err := throttler.Check(attempt, now)
+		assert.Equal(t, throttle.Throttled{Scope: throttle.ScopeAccount, RetryAfter: expected}, err)
+		now = now.Add(expected)
+		assert.Nil(t, throttler.Check(attempt, now), "Attempt is allowed after the delay")
+	}
+}
+
+func TestLockoutRejectsAttemptsAndNotifies(t *testing.T) {
+	throttler, notifier := makeThrottler(map[string]throttle.Policy{
+		throttle.ScopeAccount: {LockoutFailures: 5, LockoutDuration: time.Hour},
+	})
+	now := time.Now()
+
+	fail(t, throttler, attempt, 4, now)
+	assert.Nil(t, throttler.Check(attempt, now))
+	assert.Empty(t, notifier.lockouts)
+	fail(t, throttler, attempt, 1, now)
+
+	err := throttler.Check(attempt, now.Add(time.Minute))
+	assert.Equal(t, throttle.Throttled{Scope: throttle.ScopeAccount, RetryAfter: 59 * time.Minute, Locked: true}, err)
+	assert.Equal(t, []throttle.Lockout{{
+		Scope:       throttle.ScopeAccount,
+		Value:       attempt.Account,
+		Failures:    5,
+		LockedUntil: now.Add(time.Hour),
+	}}, notifier.lockouts)
+
+	assert.Nil(t, throttler.Check(attempt, now.Add(time.Hour)), "Lockout expires")
+	fail(t, throttler, attempt, 1, now.Add(time.Hour))
+	assert.Nil(t, throttler.Check(attempt, now.Add(time.Hour)), "Counting starts again after the lockout")
+}
+
+func TestScopesAreCountedSeparately(t *testing.T) {
+	throttler, _ := makeThrottler(map[string]throttle.Policy{
+		throttle.ScopeIP: {FreeFailures: 2, BaseDelay: time.Minute},
+	})
+	now := time.Now()
+
+	fail(t, throttler, throttle.Attempt{Account: "a@example.com", IP: attempt.IP}, 2, now)
+	fail(t, throttler, throttle.Attempt{Account: "b@example.com", IP: attempt.IP}, 1, now)
+
+	err := throttler.Check(throttle.Attempt{Account: "c@example.com", IP: attempt.IP}, now)
+	assert.Equal(t, throttle.Throttled{Scope: throttle.ScopeIP, RetryAfter: time.Minute}, err)
+	assert.Nil(t, throttler.Check(throttle.Attempt{Account: "c@example.com", IP: "192.0.2.2"}, now))
+}
+
+func TestSuccessResetsOnlyAccount(t *testing.T) {
+	throttler, _ := makeThrottler(map[string]throttle.Policy{
+		throttle.ScopeAccount: {BaseDelay: time.Minute},
+		throttle.ScopeClient:  {BaseDelay: time.Second},
+	})
+	now := time.Now()
+
+	fail(t, throttler, attempt, 1, now)
+	later := now.Add(time.Minute)
+	assert.Nil(t, throttler.Attempt(attempt, later))
+	assert.Nil(t, throttler.Success(attempt))
+
+	err := throttler.Check(attempt, later)
+	assert.Equal(t, throttle.Throttled{Scope: throttle.ScopeClient, RetryAfter: time.Second}, err)
+}
+
+func TestFailuresAreForgottenAfterReset(t *testing.T) {
+	throttler, _ := makeThrottler(map[string]throttle.Policy{
+		throttle.ScopeAccount: {BaseDelay: time.Hour, ResetAfter: time.Minute},
+	})
+	now := time.Now()
+
+	fail(t, throttler, attempt, 1, now)
+
+	assert.Nil(t, throttler.Check(attempt, now.Add(time.Minute)))
+}
+
+func TestAttemptIsCountedBeforeItsOutcome(t *testing.T) {
+	throttler, _ := makeThrottler(map[string]throttle.Policy{
+		throttle.ScopeAccount: {BaseDelay: time.Minute},
+	})
+	now := time.Now()
+
+	assert.Nil(t, throttler.Attempt(attempt, now))
+	err := throttler.Attempt(attempt, now)
+	assert.Equal(t, throttle.Throttled{Scope: throttle.ScopeAccount, RetryAfter: time.Minute}, err,
+		"Concurrent attempt is throttled before the first one failed")
+	assert.Nil(t, throttler.Cancel(attempt))
+	assert.Nil(t, throttler.Check(attempt, now))
+}
+
+func TestThrottledAttemptIsNotCountedInOtherScopes(t *testing.T) {
+	throttler, _ := makeThrottler(map[string]throttle.Policy{
+		throttle.ScopeAccount: {BaseDelay: time.Minute},
+		throttle.ScopeIP:      {BaseDelay: time.Minute},
+	})
+	now := time.Now()
+	fail(t, throttler, throttle.Attempt{IP: attempt.IP}, 1, now)
+
+	err := throttler.Attempt(attempt, now)
+	assert.Equal(t, throttle.Throttled{Scope: throttle.ScopeIP, RetryAfter: time.Minute}, err)
+	assert.Nil(t, throttler.Check(throttle.Attempt{Account: attempt.Account}, now))
+}
+
+func TestConcurrentAttemptsAreAllCounted(t *testing.T) {
+	throttler, notifier := makeThrottler(map[string]throttle.Policy{
+		throttle.ScopeAccount: {LockoutFailures: 50, LockoutDuration: time.Hour},
+	})
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, throttler.Attempt(attempt, now))
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, throttler.Failure(attempt, now))
+
+	assert.Len(t, notifier.lockouts, 1)
+	err := throttler.Attempt(attempt, now)
+	assert.IsType(t, throttle.Throttled{}, err)
+}
@@ -0,0 +1,99 @@
+package token
+
+import (
+	"time"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+)
+
+// Codes is the built-in store of authorization codes. Codes are single-use and
+// bound to the client, redirect uri, PKCE code challenge and DPoP key of the
+// authorization request.
+type Codes struct {
+	tokens   *Manager
+	lifetime time.Duration
+}
+
+// NewCodes returns the code store issuing codes valid for lifetime, RFC 6749
+// recommends at most 10 minutes.
+func NewCodes(tokens *Manager, lifetime time.Duration) *Codes {
+	return &Codes{
+		tokens:   tokens,
+		lifetime: lifetime,
+	}
+}
+
+// Issue issues the code for the approved authorization request.
+func (c *Codes) Issue(r *service.AuthorizationRequest) (string, error) {
+	return c.tokens.Issue(&Token{
+		Type:                 TypeAuthorizationCode,
+		ClientId:             r.ClientId,
+		Subject:              r.Session.Subject,
+		Scope:                r.Scope,
+		Audience:             r.Resources,
+		Confirmation:         r.DPoPJKT,
+		AuthorizationDetails: r.AuthorizationDetails,
+		RedirectURI:          r.RedirectURI.String(),
+		CodeChallenge:        r.CodeChallenge,
+		Nonce:                r.Nonce,
+		SessionId:            r.Session.Sid,
+		AuthTime:             r.Session.AuthTime,
+		ACR:                  r.Session.ACR,
+		Methods:              r.Session.Methods,
+	}, c.lifetime)
+}
+
+// Redeem redeems the code for the client. The redirect uri must be identical
+// to the one of the authorization request, the verifier must match the code
+// challenge and the DPoP key the code is bound to must be proven. The code can
+// not be redeemed again even if it is rejected, a replayed code revokes all
+// tokens issued for it. Errors are invalid_grant error responses.
+func (c *Codes) Redeem(clientId, code, redirectURI, verifier, dpopJKT string) (*service.CodeGrant, error) {
+	t, err := c.tokens.Redeem(code, TypeAuthorizationCode)
+	if err == ErrReplayed {
+		return nil, newInvalidGrantError("Authorization code was already used")
+	} else if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, newInvalidGrantError("Authorization code is invalid or expired")
+	}
+	if t.ClientId != clientId {
+		return nil, newInvalidGrantError("Authorization code was issued to another client")
+	}
+	if t.RedirectURI != redirectURI {
+		return nil, newInvalidGrantError("Parameter redirect_uri does not match the authorization request")
+	}
+	if t.CodeChallenge == "" && verifier != "" {
+		return nil, newInvalidGrantError("Authorization request did not have a code_challenge")
+	}
+	if t.CodeChallenge != "" && !oauth2.VerifyCodeVerifier(verifier, t.CodeChallenge) {
+		return nil, newInvalidGrantError("Parameter code_verifier does not match the code_challenge")
+	}
+	if t.Confirmation != "" && t.Confirmation != dpopJKT {
+		return nil, newInvalidGrantError("Authorization code is bound to another DPoP key")
+	}
+	return &service.CodeGrant{
+		Subject:              t.Subject,
+		Scope:                t.Scope,
+		Resources:            t.Audience,
+		AuthorizationDetails: t.AuthorizationDetails,
+		FamilyId:             t.FamilyId,
+		Nonce:                t.Nonce,
+		Session: &service.Session{
+			Sid:      t.SessionId,
+			Subject:  t.Subject,
+			AuthTime: t.AuthTime,
+			ACR:      t.ACR,
+			Methods:  t.Methods,
+		},
+	}, nil
+}
+
+func newInvalidGrantError(description string) *oauth2.ErrorResponse {
+	return &oauth2.ErrorResponse{
+		ErrorCode:   oauth2.ErrorInvalidGrant,
+		Description: description,
+	}
+}
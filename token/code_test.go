@@ -0,0 +1,172 @@
+package token_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/token"
+)
+
+// Example from RFC 7636 appendix B
+const (
+	codeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	codeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	redirectURI   = "https://client.example.com/callback"
+)
+
+func newCodes(lifetime time.Duration) (*token.Codes, *token.Manager) {
+	manager, _ := newManager()
+	return token.NewCodes(manager, lifetime), manager
+}
+
+func newAuthorizationRequest() *service.AuthorizationRequest {
+	uri, _ := url.Parse(redirectURI)
+	return &service.AuthorizationRequest{
+		ClientId:    "client",
+		RedirectURI: uri,
+		Scope:       "openid profile",
+		Resources:   []string{"https://api.example.com"},
+		AuthorizationDetails: []oauth2.AuthorizationDetail{
+			{"type": "payment_initiation", "instructedAmount": map[string]interface{}{"currency": "EUR", "amount": "45.00"}},
+		},
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: oauth2.CodeChallengeMethodS256,
+		Session: &service.Session{
+			Sid:      "sid",
+			Subject:  "user",
+			AuthTime: time.Unix(time.Now().Add(-time.Minute).Unix(), 0),
+			ACR:      "urn:example:mfa",
+			Methods:  []string{"pwd", "otp"},
+		},
+		Nonce: "nonce",
+	}
+}
+
+func assertInvalidGrant(t *testing.T, err error) {
+	if assert.NotNil(t, err) {
+		response, ok := err.(*oauth2.ErrorResponse)
+		if assert.True(t, ok, "Error must be an error response: %s", err) {
+			assert.Equal(t, oauth2.ErrorInvalidGrant, response.ErrorCode)
+		}
+	}
+}
+
+func TestCodeIsRedeemedForGrant(t *testing.T) {
+	codes, _ := newCodes(time.Minute)
+	code, err := codes.Issue(newAuthorizationRequest())
+	assert.Nil(t, err)
+
+	grant, err := codes.Redeem("client", code, redirectURI, codeVerifier, "")
+	assert.Nil(t, err)
+	if assert.NotNil(t, grant) {
+		assert.Equal(t, "user", grant.Subject)
+		assert.Equal(t, "openid profile", grant.Scope)
+		assert.Equal(t, []string{"https://api.example.com"}, grant.Resources)
+		assert.Equal(t, newAuthorizationRequest().AuthorizationDetails, grant.AuthorizationDetails)
+		assert.NotEmpty(t, grant.FamilyId)
+		assert.Equal(t, "nonce", grant.Nonce)
+		assert.Equal(t, newAuthorizationRequest().Session, grant.Session)
+	}
+}
+
+func TestCodeCanBeRedeemedOnce(t *testing.T) {
+	codes, _ := newCodes(time.Minute)
+	code, _ := codes.Issue(newAuthorizationRequest())
+	_, err := codes.Redeem("client", code, redirectURI, codeVerifier, "")
+	assert.Nil(t, err)
+
+	_, err = codes.Redeem("client", code, redirectURI, codeVerifier, "")
+	assertInvalidGrant(t, err)
+}
+
+func TestReplayedCodeRevokesIssuedTokens(t *testing.T) {
+	codes, manager := newCodes(time.Minute)
+	code, _ := codes.Issue(newAuthorizationRequest())
+	grant, _ := codes.Redeem("client", code, redirectURI, codeVerifier, "")
+	refreshToken, _ := manager.Issue(&token.Token{Type: token.TypeRefreshToken, FamilyId: grant.FamilyId}, time.Hour)
+
+	_, err := codes.Redeem("client", code, redirectURI, codeVerifier, "")
+	assertInvalidGrant(t, err)
+	found, err := manager.Lookup(refreshToken, token.TypeRefreshToken)
+	assert.Nil(t, err)
+	assert.Nil(t, found, "Tokens issued for the code must be revoked")
+}
+
+func TestExpiredCodeIsRejected(t *testing.T) {
+	codes, _ := newCodes(-time.Second)
+	code, _ := codes.Issue(newAuthorizationRequest())
+
+	_, err := codes.Redeem("client", code, redirectURI, codeVerifier, "")
+	assertInvalidGrant(t, err)
+}
+
+func TestUnknownCodeIsRejected(t *testing.T) {
+	codes, manager := newCodes(time.Minute)
+	refreshToken, _ := manager.Issue(&token.Token{Type: token.TypeRefreshToken, ClientId: "client"}, time.Hour)
+
+	_, err := codes.Redeem("client", "unknown", redirectURI, codeVerifier, "")
+	assertInvalidGrant(t, err)
+	_, err = codes.Redeem("client", refreshToken, redirectURI, codeVerifier, "")
+	assertInvalidGrant(t, err)
+	found, _ := manager.Lookup(refreshToken, token.TypeRefreshToken)
+	assert.NotNil(t, found, "Tokens of other types must not be redeemed")
+}
+
+func TestCodeMustBeRedeemedByTheSameClient(t *testing.T) {
+	codes, _ := newCodes(time.Minute)
+	code, _ := codes.Issue(newAuthorizationRequest())
+
+	_, err := codes.Redeem("other", code, redirectURI, codeVerifier, "")
+	assertInvalidGrant(t, err)
+	_, err = codes.Redeem("client", code, redirectURI, codeVerifier, "")
+	assertInvalidGrant(t, err)
+}
+
+func TestCodeMustBeRedeemedWithIdenticalRedirectURI(t *testing.T) {
+	codes, _ := newCodes(time.Minute)
+	code, _ := codes.Issue(newAuthorizationRequest())
+
+	_, err := codes.Redeem("client", code, redirectURI+"/", codeVerifier, "")
+	assertInvalidGrant(t, err)
+}
+
+func TestCodeMustBeRedeemedWithMatchingVerifier(t *testing.T) {
+	codes, _ := newCodes(time.Minute)
+	for _, verifier := range []string{"", codeVerifier[1:] + "a"} {
+		code, _ := codes.Issue(newAuthorizationRequest())
+		_, err := codes.Redeem("client", code, redirectURI, verifier, "")
+		assertInvalidGrant(t, err)
+	}
+}
+
+func TestVerifierIsRejectedWithoutChallenge(t *testing.T) {
+	codes, _ := newCodes(time.Minute)
+	request := newAuthorizationRequest()
+	request.CodeChallenge = ""
+	request.CodeChallengeMethod = ""
+	code, _ := codes.Issue(request)
+	_, err := codes.Redeem("client", code, redirectURI, codeVerifier, "")
+	assertInvalidGrant(t, err)
+
+	code, _ = codes.Issue(request)
+	_, err = codes.Redeem("client", code, redirectURI, "", "")
+	assert.Nil(t, err)
+}
+
+func TestCodeBoundToDPoPKeyRequiresProofOfKey(t *testing.T) {
+	codes, _ := newCodes(time.Minute)
+	request := newAuthorizationRequest()
+	request.DPoPJKT = "jkt"
+	code, _ := codes.Issue(request)
+	_, err := codes.Redeem("client", code, redirectURI, codeVerifier, "other")
+	assertInvalidGrant(t, err)
+
+	code, _ = codes.Issue(request)
+	_, err = codes.Redeem("client", code, redirectURI, codeVerifier, "jkt")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,94 @@
+package token
+
+import (
+	"hash/crc32"
+	"math/big"
+	"strings"
+
+	"github.com/arjantop/gopherauth/service"
+)
+
+// Prefixes identify the kind of a secret token, so secret scanners can find
+// leaked tokens in repositories and logs.
+const (
+	PrefixAccessToken       = "gpa_at_"
+	PrefixRefreshToken      = "gpa_rt_"
+	PrefixAuthorizationCode = "gpa_ac_"
+	PrefixClientSecret      = "gpa_cs_"
+)
+
+const (
+	base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// Length of the base62 encoded CRC32 checksum
+	checksumLength = 6
+)
+
+// Prefix returns the prefix of secret tokens of the type.
+func (t Type) Prefix() string {
+	switch t {
+	case TypeAccessToken:
+		return PrefixAccessToken
+	case TypeRefreshToken:
+		return PrefixRefreshToken
+	case TypeAuthorizationCode:
+		return PrefixAuthorizationCode
+	}
+	return ""
+}
+
+// NewSecret generates a secret token of size random bytes. The token is the
+// prefix, the base62 encoded random bytes and the base62 encoded CRC32 of both.
+func NewSecret(tokenGenerator service.TokenGenerator, prefix string, size uint) (string, error) {
+	random, err := tokenGenerator.Generate(size)
+	if err != nil {
+		return "", err
+	}
+	body := prefix + encodeBase62(random, base62Length(len(random)))
+	return body + checksum(body), nil
+}
+
+// ValidSecret reports whether the secret has the prefix, a body of size random
+// bytes and a matching checksum. Malformed secrets can be rejected without a
+// store lookup.
+func ValidSecret(secret, prefix string, size uint) bool {
+	if !strings.HasPrefix(secret, prefix) {
+		return false
+	}
+	if len(secret) != len(prefix)+base62Length(int(size))+checksumLength {
+		return false
+	}
+	for _, c := range secret[len(prefix):] {
+		if strings.IndexRune(base62Alphabet, c) < 0 {
+			return false
+		}
+	}
+	body := secret[:len(secret)-checksumLength]
+	return checksum(body) == secret[len(body):]
+}
+
+// checksum is only a check for typos and truncation, it does not authenticate
+// the token.
+func checksum(body string) string {
+	sum := crc32.ChecksumIEEE([]byte(body))
+	return encodeBase62(big.NewInt(int64(sum)).Bytes(), checksumLength)
+}
+
+// base62Length returns the number of base62 digits needed for size bytes.
+func base62Length(size int) int {
+	max := new(big.Int).Lsh(big.NewInt(1), uint(size*8))
+	return len(max.Sub(max, big.NewInt(1)).Text(62))
+}
+
+// encodeBase62 encodes the bytes as a big-endian number left padded with zero
+// digits to length.
+func encodeBase62(b []byte, length int) string {
+	digits := make([]byte, length)
+	n := new(big.Int).SetBytes(b)
+	base := big.NewInt(62)
+	remainder := new(big.Int)
+	for i := length - 1; i >= 0; i-- {
+		n.DivMod(n, base, remainder)
+		digits[i] = base62Alphabet[remainder.Int64()]
+	}
+	return string(digits)
+}
@@ -0,0 +1,56 @@
+package token_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/token"
+)
+
+func TestSecretHasPrefixBase62BodyAndChecksum(t *testing.T) {
+	secret, err := token.NewSecret(service.NewCryptoTokenGenerator(), token.PrefixRefreshToken, 32)
+	assert.Nil(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^gpa_rt_[0-9A-Za-z]{43}[0-9A-Za-z]{6}$`), secret)
+	assert.True(t, token.ValidSecret(secret, token.PrefixRefreshToken, 32))
+}
+
+func TestSecretIsEncodedDeterministically(t *testing.T) {
+	tokenGenerator := service.NewTokenGeneratorMock()
+	tokenGenerator.On("Generate", uint(4)).Return([]byte{0, 0, 0, 61})
+	secret, err := token.NewSecret(tokenGenerator, token.PrefixClientSecret, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "gpa_cs_00000z", secret[:len(secret)-6])
+	assert.True(t, token.ValidSecret(secret, token.PrefixClientSecret, 4))
+}
+
+func TestMalformedSecretsAreRejected(t *testing.T) {
+	secret, _ := token.NewSecret(service.NewCryptoTokenGenerator(), token.PrefixAccessToken, 32)
+	body := secret[len(token.PrefixAccessToken):]
+	changed := []byte(secret)
+	if changed[10] == 'a' {
+		changed[10] = 'b'
+	} else {
+		changed[10] = 'a'
+	}
+
+	for _, malformed := range []string{
+		"",
+		token.PrefixRefreshToken + body,
+		secret[:len(secret)-1],
+		secret + "0",
+		string(changed),
+		secret[:len(secret)-1] + "-",
+	} {
+		assert.False(t, token.ValidSecret(malformed, token.PrefixAccessToken, 32), malformed)
+	}
+	assert.False(t, token.ValidSecret(secret, token.PrefixAccessToken, 16))
+}
+
+func TestTypesHaveDistinctPrefixes(t *testing.T) {
+	assert.Equal(t, token.PrefixAccessToken, token.TypeAccessToken.Prefix())
+	assert.Equal(t, token.PrefixRefreshToken, token.TypeRefreshToken.Prefix())
+	assert.Equal(t, token.PrefixAuthorizationCode, token.TypeAuthorizationCode.Prefix())
+}
@@ -0,0 +1,56 @@
+package token
+
+import (
+	"strings"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// Introspect returns the introspection response of an opaque access or refresh
+// token issued by the manager. Malformed, unknown, expired and revoked tokens
+// and authorization codes are not active.
+func (m *Manager) Introspect(value, issuer string) (*oauth2.IntrospectionResponse, error) {
+	for _, typ := range []Type{TypeAccessToken, TypeRefreshToken} {
+		if !strings.HasPrefix(value, typ.Prefix()) {
+			continue
+		}
+		t, err := m.Lookup(value, typ)
+		if err != nil || t == nil {
+			return &oauth2.IntrospectionResponse{}, err
+		}
+		return &oauth2.IntrospectionResponse{Active: true, Claims: t.claims(issuer)}, nil
+	}
+	return &oauth2.IntrospectionResponse{}, nil
+}
+
+// claims returns the introspection claims of the token.
+func (t *Token) claims(issuer string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":       issuer,
+		"sub":       t.Subject,
+		"client_id": t.ClientId,
+		"iat":       t.IssuedAt.Unix(),
+		"exp":       t.ExpiresAt.Unix(),
+	}
+	if t.Scope != "" {
+		claims["scope"] = t.Scope
+	}
+	if len(t.Audience) == 1 {
+		claims["aud"] = t.Audience[0]
+	} else if len(t.Audience) > 1 {
+		claims["aud"] = t.Audience
+	}
+	if len(t.AuthorizationDetails) > 0 {
+		claims["authorization_details"] = t.AuthorizationDetails
+	}
+	if t.Type == TypeAccessToken {
+		claims["token_type"] = oauth2.TokenTypeBearer
+		if t.Confirmation != "" {
+			claims["token_type"] = oauth2.TokenTypeDPoP
+		}
+	}
+	if t.Confirmation != "" {
+		claims["cnf"] = map[string]string{"jkt": t.Confirmation}
+	}
+	return claims
+}
@@ -0,0 +1,67 @@
+package token
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"time"
+)
+
+// Size of the random JWT id in bytes
+const JWTIdSize = 16
+
+// RegisterJWT records a JWT access token valid for lifetime in the family of t
+// and returns the id (jti) the token must be issued with. Signed tokens can
+// not be changed once issued, the record is revoked with its family instead,
+// e.g. when the authorization code the token was issued for is replayed. Only
+// introspection and validators that check ActiveJWT see the revocation,
+// resource servers that validate the token locally accept it until it expires.
+func (m *Manager) RegisterJWT(t *Token, lifetime time.Duration) (string, error) {
+	id, err := m.tokenGenerator.Generate(JWTIdSize)
+	if err != nil {
+		return "", err
+	}
+	jti := base64.RawURLEncoding.EncodeToString(id)
+	record := *t
+	record.Type = TypeAccessToken
+	record.Hash = jwtHash(jti)
+	if record.FamilyId == "" {
+		familyId, err := m.tokenGenerator.Generate(FamilyIdSize)
+		if err != nil {
+			return "", err
+		}
+		record.FamilyId = base64.RawURLEncoding.EncodeToString(familyId)
+	}
+	now := time.Now()
+	record.IssuedAt = now
+	record.ExpiresAt = now.Add(lifetime)
+	if err := m.store.Save(&record); err != nil {
+		return "", err
+	}
+	return jti, nil
+}
+
+// ActiveJWT reports whether the JWT access token with the id was registered and
+// was not revoked. Expired records are removed.
+func (m *Manager) ActiveJWT(jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	hash := jwtHash(jti)
+	t, err := m.store.Get(hash)
+	if err != nil || t == nil {
+		return false, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(t.Hash)) != 1 || t.Type != TypeAccessToken {
+		return false, nil
+	}
+	if !t.Valid(time.Now()) {
+		return false, m.store.Delete(hash)
+	}
+	return true, nil
+}
+
+// jwtHash is the hash a JWT is stored by. Opaque tokens never start with the
+// prefix, so a JWT id can not be used as an opaque token.
+func jwtHash(jti string) string {
+	return Hash("jti:" + jti)
+}
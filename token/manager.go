@@ -0,0 +1,127 @@
+package token
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/arjantop/gopherauth/service"
+)
+
+const (
+	// Size of the random token in bytes
+	Size = 32
+	// Size of the random family id in bytes
+	FamilyIdSize = 16
+)
+
+// ErrReplayed is returned when a single-use token is redeemed again. All tokens
+// of its family are revoked.
+var ErrReplayed = errors.New("token: single-use token was already redeemed")
+
+// Manager issues opaque tokens and looks them up. Only hashes of the tokens
+// are stored, a leaked store does not contain usable tokens.
+type Manager struct {
+	store          Store
+	tokenGenerator service.TokenGenerator
+}
+
+func NewManager(store Store, tokenGenerator service.TokenGenerator) *Manager {
+	return &Manager{
+		store:          store,
+		tokenGenerator: tokenGenerator,
+	}
+}
+
+// Issue generates a token with the prefix of its type valid for lifetime and
+// stores the record t with its hash. A new family is started if the family id
+// of the record is empty.
+func (m *Manager) Issue(t *Token, lifetime time.Duration) (string, error) {
+	value, err := NewSecret(m.tokenGenerator, t.Type.Prefix(), Size)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	t.Hash = Hash(value)
+	if t.FamilyId == "" {
+		familyId, err := m.tokenGenerator.Generate(FamilyIdSize)
+		if err != nil {
+			return "", err
+		}
+		t.FamilyId = base64.RawURLEncoding.EncodeToString(familyId)
+	}
+	t.IssuedAt = now
+	t.ExpiresAt = now.Add(lifetime)
+	if err := m.store.Save(t); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// Lookup returns the valid token of the type, or nil if the token is malformed,
+// does not exist, has expired or is of another type. Expired tokens are removed.
+func (m *Manager) Lookup(value string, typ Type) (*Token, error) {
+	if !ValidSecret(value, typ.Prefix(), Size) {
+		return nil, nil
+	}
+	hash := Hash(value)
+	t, err := m.store.Get(hash)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	// Stores may match case insensitively
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(t.Hash)) != 1 {
+		return nil, nil
+	}
+	if !t.Valid(time.Now()) {
+		return nil, m.store.Delete(hash)
+	}
+	if t.Type != typ {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// Redeem returns the valid token of the type and marks it redeemed, or nil if
+// the token does not exist, has expired or is of another type. Tokens can be
+// redeemed once, if a token is redeemed again its family is revoked and
+// ErrReplayed is returned.
+func (m *Manager) Redeem(value string, typ Type) (*Token, error) {
+	if !ValidSecret(value, typ.Prefix(), Size) {
+		return nil, nil
+	}
+	hash := Hash(value)
+	// Tokens of other types must not be marked redeemed
+	if t, err := m.store.Get(hash); err != nil || t == nil || t.Type != typ {
+		return nil, err
+	}
+	now := time.Now()
+	t, err := m.store.Redeem(hash, now)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(t.Hash)) != 1 {
+		return nil, nil
+	}
+	if !t.RedeemedAt.IsZero() {
+		if err := m.store.DeleteFamily(t.FamilyId); err != nil {
+			return nil, err
+		}
+		return nil, ErrReplayed
+	}
+	if !t.Valid(now) {
+		return nil, m.store.Delete(hash)
+	}
+	return t, nil
+}
+
+// Revoke revokes the token, revoking an unknown token is not an error.
+func (m *Manager) Revoke(value string) error {
+	return m.store.Delete(Hash(value))
+}
+
+// RevokeFamily revokes all tokens issued from the same grant.
+func (m *Manager) RevokeFamily(familyId string) error {
+	return m.store.DeleteFamily(familyId)
+}
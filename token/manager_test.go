@@ -0,0 +1,260 @@
+package token_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/token"
+)
+
+func newManager() (*token.Manager, *token.MemoryStore) {
+	store := token.NewMemoryStore()
+	return token.NewManager(store, service.NewCryptoTokenGenerator()), store
+}
+
+func TestIssuedTokenIsStoredByHash(t *testing.T) {
+	manager, store := newManager()
+	record := &token.Token{Type: token.TypeAccessToken, ClientId: "client", Subject: "user"}
+	value, err := manager.Issue(record, time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(value, token.PrefixAccessToken))
+	assert.True(t, token.ValidSecret(value, token.PrefixAccessToken, token.Size))
+
+	stored, _ := store.Get(token.Hash(value))
+	if assert.NotNil(t, stored) {
+		assert.Equal(t, token.Hash(value), stored.Hash)
+		assert.NotEmpty(t, stored.FamilyId)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), stored.ExpiresAt, time.Second)
+	}
+	stored, _ = store.Get(value)
+	assert.Nil(t, stored, "Token must not be stored as is")
+}
+
+func TestIssueKeepsFamily(t *testing.T) {
+	manager, _ := newManager()
+	record := &token.Token{Type: token.TypeRefreshToken, FamilyId: "family"}
+	_, err := manager.Issue(record, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "family", record.FamilyId)
+}
+
+func TestLookupReturnsValidToken(t *testing.T) {
+	manager, _ := newManager()
+	value, _ := manager.Issue(&token.Token{Type: token.TypeAccessToken, Subject: "user"}, time.Hour)
+
+	found, err := manager.Lookup(value, token.TypeAccessToken)
+	assert.Nil(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, "user", found.Subject)
+	}
+}
+
+func TestLookupRejectsTokenOfOtherType(t *testing.T) {
+	manager, _ := newManager()
+	value, _ := manager.Issue(&token.Token{Type: token.TypeRefreshToken}, time.Hour)
+
+	found, err := manager.Lookup(value, token.TypeAccessToken)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+}
+
+func TestLookupRemovesExpiredToken(t *testing.T) {
+	manager, store := newManager()
+	value, _ := manager.Issue(&token.Token{Type: token.TypeAccessToken}, -time.Second)
+
+	found, err := manager.Lookup(value, token.TypeAccessToken)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+	stored, _ := store.Get(token.Hash(value))
+	assert.Nil(t, stored)
+}
+
+func TestRevokedTokenIsNotFound(t *testing.T) {
+	manager, _ := newManager()
+	value, _ := manager.Issue(&token.Token{Type: token.TypeAccessToken}, time.Hour)
+	assert.Nil(t, manager.Revoke(value))
+
+	found, err := manager.Lookup(value, token.TypeAccessToken)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+}
+
+func TestRevokeFamilyRevokesAllTokensOfGrant(t *testing.T) {
+	manager, _ := newManager()
+	access := &token.Token{Type: token.TypeAccessToken}
+	accessToken, _ := manager.Issue(access, time.Hour)
+	refreshToken, _ := manager.Issue(&token.Token{Type: token.TypeRefreshToken, FamilyId: access.FamilyId}, time.Hour)
+	otherToken, _ := manager.Issue(&token.Token{Type: token.TypeAccessToken}, time.Hour)
+	assert.Nil(t, manager.RevokeFamily(access.FamilyId))
+
+	found, _ := manager.Lookup(accessToken, token.TypeAccessToken)
+	assert.Nil(t, found)
+	found, _ = manager.Lookup(refreshToken, token.TypeRefreshToken)
+	assert.Nil(t, found)
+	found, _ = manager.Lookup(otherToken, token.TypeAccessToken)
+	assert.NotNil(t, found)
+}
+
+func TestMalformedTokenIsRejectedWithoutLookup(t *testing.T) {
+	manager := token.NewManager(failingStore{}, service.NewCryptoTokenGenerator())
+	for _, value := range []string{"", "unknown", token.PrefixAccessToken + strings.Repeat("0", 49)} {
+		found, err := manager.Lookup(value, token.TypeAccessToken)
+		assert.Nil(t, err)
+		assert.Nil(t, found)
+		found, err = manager.Redeem(value, token.TypeAccessToken)
+		assert.Nil(t, err)
+		assert.Nil(t, found)
+	}
+}
+
+func TestGenerationErrorIsReturned(t *testing.T) {
+	tokenGenerator := service.NewTokenGeneratorMock()
+	tokenGenerator.On("Generate", uint(token.Size)).Return(nil, errors.New("error"))
+	manager := token.NewManager(token.NewMemoryStore(), tokenGenerator)
+
+	value, err := manager.Issue(&token.Token{Type: token.TypeAccessToken}, time.Hour)
+	assert.Equal(t, errors.New("error"), err)
+	assert.Empty(t, value)
+}
+
+// failingStore fails every call, a malformed token must not reach it
+type failingStore struct {
+	token.Store
+}
+
+func (failingStore) Get(hash string) (*token.Token, error) {
+	return nil, errors.New("store must not be called")
+}
+
+func (failingStore) Redeem(hash string, now time.Time) (*token.Token, error) {
+	return nil, errors.New("store must not be called")
+}
+
+func TestIntrospectReturnsClaimsOfActiveTokens(t *testing.T) {
+	manager, _ := newManager()
+	value, err := manager.Issue(&token.Token{
+		Type:         token.TypeAccessToken,
+		ClientId:     "client",
+		Subject:      "user",
+		Scope:        "read",
+		Audience:     []string{"https://api.example.com/"},
+		Confirmation: "jkt",
+		AuthorizationDetails: []oauth2.AuthorizationDetail{
+			{"type": "payment_initiation", "instructedAmount": map[string]interface{}{"currency": "EUR", "amount": "45.00"}},
+		},
+	}, time.Hour)
+	assert.Nil(t, err)
+
+	response, err := manager.Introspect(value, "https://example.com")
+	assert.Nil(t, err)
+	assert.True(t, response.Active)
+	assert.Equal(t, "user", response.Claims["sub"])
+	assert.Equal(t, "client", response.Claims["client_id"])
+	assert.Equal(t, "read", response.Claims["scope"])
+	assert.Equal(t, "https://api.example.com/", response.Claims["aud"])
+	assert.Equal(t, "DPoP", response.Claims["token_type"])
+	assert.Equal(t, map[string]string{"jkt": "jkt"}, response.Claims["cnf"])
+	assert.Equal(t, []oauth2.AuthorizationDetail{
+		{"type": "payment_initiation", "instructedAmount": map[string]interface{}{"currency": "EUR", "amount": "45.00"}},
+	}, response.Claims["authorization_details"])
+}
+
+func TestIntrospectOfRevokedOrUnknownTokensIsInactive(t *testing.T) {
+	manager, _ := newManager()
+	code, err := manager.Issue(&token.Token{Type: token.TypeAuthorizationCode}, time.Hour)
+	assert.Nil(t, err)
+	refresh, err := manager.Issue(&token.Token{Type: token.TypeRefreshToken}, time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, manager.Revoke(refresh))
+
+	for _, value := range []string{code, refresh, "unknown", token.PrefixAccessToken + "malformed"} {
+		response, err := manager.Introspect(value, "https://example.com")
+		assert.Nil(t, err)
+		assert.False(t, response.Active, value)
+	}
+}
+
+func TestRefreshReturnsGrantOfTheClient(t *testing.T) {
+	manager, _ := newManager()
+	value, err := manager.Issue(&token.Token{
+		Type:     token.TypeRefreshToken,
+		ClientId: "client",
+		Subject:  "user",
+	}, time.Hour)
+	assert.Nil(t, err)
+
+	grant, err := manager.Refresh(value, "client", "")
+	assert.Nil(t, err)
+	if assert.NotNil(t, grant) {
+		assert.Equal(t, "user", grant.Subject)
+	}
+	_, err = manager.Refresh(value, "other", "")
+	assert.Equal(t, oauth2.ErrorInvalidGrant, err.Error())
+	_, err = manager.Refresh("unknown", "client", "")
+	assert.Equal(t, oauth2.ErrorInvalidGrant, err.Error())
+}
+
+func TestBoundRefreshTokenRequiresProofOfItsKey(t *testing.T) {
+	manager, _ := newManager()
+	value, err := manager.Issue(&token.Token{
+		Type:         token.TypeRefreshToken,
+		ClientId:     "client",
+		Confirmation: "jkt",
+	}, time.Hour)
+	assert.Nil(t, err)
+
+	_, err = manager.Refresh(value, "client", "")
+	assert.Equal(t, oauth2.ErrorInvalidGrant, err.Error(), "Bound token without a proof")
+	_, err = manager.Refresh(value, "client", "other-jkt")
+	assert.Equal(t, oauth2.ErrorInvalidGrant, err.Error(), "Bound token with a proof of another key")
+	grant, err := manager.Refresh(value, "client", "jkt")
+	assert.Nil(t, err)
+	assert.NotNil(t, grant)
+}
+
+func TestRegisteredJWTIsActiveUntilItsFamilyIsRevoked(t *testing.T) {
+	manager, _ := newManager()
+	jti, err := manager.RegisterJWT(&token.Token{ClientId: "client", FamilyId: "family"}, time.Hour)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, jti)
+	active, err := manager.ActiveJWT(jti)
+	assert.Nil(t, err)
+	assert.True(t, active)
+
+	assert.Nil(t, manager.RevokeFamily("family"))
+	active, err = manager.ActiveJWT(jti)
+	assert.Nil(t, err)
+	assert.False(t, active)
+}
+
+func TestUnknownOrExpiredJWTIsNotActive(t *testing.T) {
+	manager, store := newManager()
+	active, err := manager.ActiveJWT("unknown")
+	assert.Nil(t, err)
+	assert.False(t, active)
+
+	jti, err := manager.RegisterJWT(&token.Token{ClientId: "client"}, -time.Second)
+	assert.Nil(t, err)
+	active, err = manager.ActiveJWT(jti)
+	assert.Nil(t, err)
+	assert.False(t, active)
+	assert.Nil(t, store.DeleteExpired(time.Now()))
+}
+
+func TestJWTIdIsNotAnOpaqueToken(t *testing.T) {
+	manager, _ := newManager()
+	jti, err := manager.RegisterJWT(&token.Token{ClientId: "client"}, time.Hour)
+	assert.Nil(t, err)
+	response, err := manager.Introspect(jti, "issuer")
+	assert.Nil(t, err)
+	assert.False(t, response.Active)
+	found, err := manager.Lookup(jti, token.TypeAccessToken)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+}
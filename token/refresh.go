@@ -0,0 +1,22 @@
+package token
+
+// Refresh returns the grant of a valid refresh token of the client. Tokens that
+// are bound to a DPoP key are only accepted with a proof of the key, dpopJKT is
+// the thumbprint of the key of the validated proof, empty if there is none.
+// Errors are invalid_grant error responses.
+func (m *Manager) Refresh(value, clientId, dpopJKT string) (*Token, error) {
+	t, err := m.Lookup(value, TypeRefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || t.ClientId != clientId {
+		return nil, newInvalidGrantError("Refresh token is invalid or expired")
+	}
+	if t.Confirmation != "" && dpopJKT == "" {
+		return nil, newInvalidGrantError("Refresh token is bound to a DPoP key, a DPoP proof is required")
+	}
+	if t.Confirmation != "" && t.Confirmation != dpopJKT {
+		return nil, newInvalidGrantError("Refresh token is bound to another DPoP key")
+	}
+	return t, nil
+}
@@ -0,0 +1,178 @@
+package token
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// Dialect selects the placeholder syntax of the database.
+type Dialect int
+
+const (
+	// Placeholders are ?, e.g. SQLite and MySQL
+	DialectQuestion Dialect = iota
+	// Placeholders are $1, $2, ..., e.g. PostgreSQL
+	DialectDollar
+)
+
+// SQLSchema creates the table used by SQLStore. Tokens are stored by the hash
+// as primary key so lookups are a single index search.
+const SQLSchema = `CREATE TABLE oauth2_tokens (
+	hash                  CHAR(64)     NOT NULL PRIMARY KEY,
+	type                  VARCHAR(32)  NOT NULL,
+	client_id             VARCHAR(255) NOT NULL,
+	subject               VARCHAR(255) NOT NULL,
+	scope                 TEXT         NOT NULL,
+	audience              TEXT         NOT NULL,
+	authorization_details TEXT         NOT NULL,
+	family_id             VARCHAR(64)  NOT NULL,
+	confirmation          VARCHAR(64)  NOT NULL,
+	redirect_uri          TEXT         NOT NULL,
+	code_challenge        VARCHAR(64)  NOT NULL,
+	nonce                 TEXT         NOT NULL,
+	session_id            VARCHAR(255) NOT NULL,
+	auth_time             BIGINT       NOT NULL,
+	acr                   VARCHAR(255) NOT NULL,
+	methods               TEXT         NOT NULL,
+	issued_at             BIGINT       NOT NULL,
+	expires_at            BIGINT       NOT NULL,
+	redeemed_at           BIGINT       NOT NULL
+);
+CREATE INDEX oauth2_tokens_family_id ON oauth2_tokens (family_id);
+CREATE INDEX oauth2_tokens_expires_at ON oauth2_tokens (expires_at);`
+
+// SQLStore stores tokens in the oauth2_tokens table created by SQLSchema.
+type SQLStore struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func NewSQLStore(db *sql.DB, dialect Dialect) *SQLStore {
+	return &SQLStore{db: db, dialect: dialect}
+}
+
+func (s *SQLStore) Save(t *Token) error {
+	details, err := encodeDetails(t.AuthorizationDetails)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.query(`INSERT INTO oauth2_tokens
+		(hash, type, client_id, subject, scope, audience, authorization_details,
+		family_id, confirmation, redirect_uri, code_challenge, nonce, session_id,
+		auth_time, acr, methods, issued_at, expires_at, redeemed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		t.Hash, string(t.Type), t.ClientId, t.Subject, t.Scope, strings.Join(t.Audience, " "), details,
+		t.FamilyId, t.Confirmation, t.RedirectURI, t.CodeChallenge,
+		t.Nonce, t.SessionId, unixOrZero(t.AuthTime), t.ACR, strings.Join(t.Methods, " "),
+		t.IssuedAt.Unix(), t.ExpiresAt.Unix(), unixOrZero(t.RedeemedAt))
+	return err
+}
+
+func (s *SQLStore) Get(hash string) (*Token, error) {
+	row := s.db.QueryRow(s.query(`SELECT
+		hash, type, client_id, subject, scope, audience, authorization_details,
+		family_id, confirmation, redirect_uri, code_challenge, nonce, session_id,
+		auth_time, acr, methods, issued_at, expires_at, redeemed_at
+		FROM oauth2_tokens WHERE hash = ?`), hash)
+	var t Token
+	var typ, audience, details, methods string
+	var authTime, issuedAt, expiresAt, redeemedAt int64
+	err := row.Scan(&t.Hash, &typ, &t.ClientId, &t.Subject, &t.Scope, &audience, &details,
+		&t.FamilyId, &t.Confirmation, &t.RedirectURI, &t.CodeChallenge,
+		&t.Nonce, &t.SessionId, &authTime, &t.ACR, &methods, &issuedAt, &expiresAt, &redeemedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	t.Type = Type(typ)
+	t.Audience = strings.Fields(audience)
+	if t.AuthorizationDetails, err = oauth2.ParseAuthorizationDetails(details); err != nil {
+		return nil, err
+	}
+	t.Methods = strings.Fields(methods)
+	if authTime != 0 {
+		t.AuthTime = time.Unix(authTime, 0)
+	}
+	t.IssuedAt = time.Unix(issuedAt, 0)
+	t.ExpiresAt = time.Unix(expiresAt, 0)
+	if redeemedAt != 0 {
+		t.RedeemedAt = time.Unix(redeemedAt, 0)
+	}
+	return &t, nil
+}
+
+// Redeem marks the token with a conditional update, the database guarantees
+// that only one of concurrent updates changes the row.
+func (s *SQLStore) Redeem(hash string, now time.Time) (*Token, error) {
+	result, err := s.db.Exec(s.query(`UPDATE oauth2_tokens SET redeemed_at = ?
+		WHERE hash = ? AND redeemed_at = 0`), now.Unix(), hash)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.Get(hash)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	if updated == 1 {
+		t.RedeemedAt = time.Time{}
+	}
+	return t, nil
+}
+
+// encodeDetails encodes the authorization details as JSON, an empty string if
+// there are none.
+func encodeDetails(details []oauth2.AuthorizationDetail) (string, error) {
+	if len(details) == 0 {
+		return "", nil
+	}
+	return oauth2.EncodeAuthorizationDetails(details)
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func (s *SQLStore) Delete(hash string) error {
+	_, err := s.db.Exec(s.query(`DELETE FROM oauth2_tokens WHERE hash = ?`), hash)
+	return err
+}
+
+func (s *SQLStore) DeleteFamily(familyId string) error {
+	_, err := s.db.Exec(s.query(`DELETE FROM oauth2_tokens WHERE family_id = ?`), familyId)
+	return err
+}
+
+func (s *SQLStore) DeleteExpired(now time.Time) error {
+	_, err := s.db.Exec(s.query(`DELETE FROM oauth2_tokens WHERE expires_at <= ?`), now.Unix())
+	return err
+}
+
+// query replaces the ? placeholders with the placeholders of the dialect.
+func (s *SQLStore) query(query string) string {
+	if s.dialect != DialectDollar {
+		return query
+	}
+	var numbered strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&numbered, "$%d", n)
+		} else {
+			numbered.WriteRune(c)
+		}
+	}
+	return numbered.String()
+}
@@ -0,0 +1,160 @@
+package token
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/oauth2"
+)
+
+// Type is the kind of an opaque token.
+type Type string
+
+const (
+	TypeAccessToken       Type = "access_token"
+	TypeRefreshToken      Type = "refresh_token"
+	TypeAuthorizationCode Type = "authorization_code"
+)
+
+// Token is the stored record of an opaque token. The token itself is never
+// stored, only its SHA-256 hash.
+type Token struct {
+	// Hex encoded SHA-256 hash of the token
+	Hash     string
+	Type     Type
+	ClientId string
+	// Subject the token was issued for, the client id for client credentials
+	Subject  string
+	Scope    string
+	Audience []string
+	// Authorization details approved by the user, tokens issued from the grant
+	// are restricted to them
+	AuthorizationDetails []oauth2.AuthorizationDetail
+	// Tokens issued from the same grant share the family id, e.g. the
+	// authorization code and all access and refresh tokens issued for it
+	FamilyId string
+	// Thumbprint of the key the token is bound to (cnf.jkt), empty if unbound
+	Confirmation string
+	// Redirect uri and PKCE code challenge an authorization code is bound to
+	RedirectURI   string
+	CodeChallenge string
+	// Nonce of the authorization request and the authentication of the user an
+	// authorization code was issued for, ID tokens issued for the code repeat them
+	Nonce     string
+	SessionId string
+	AuthTime  time.Time
+	ACR       string
+	Methods   []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Time a single-use token was redeemed, zero if it was not
+	RedeemedAt time.Time
+}
+
+// Valid reports whether the token has not expired.
+func (t *Token) Valid(now time.Time) bool {
+	return now.Before(t.ExpiresAt)
+}
+
+// Hash returns the hex encoded SHA-256 hash a token is stored by. Tokens are
+// looked up by their hash, so the time of a lookup does not depend on how much
+// of a guessed token is correct.
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store stores tokens by their hash.
+type Store interface {
+	Save(t *Token) error
+	// Get returns the token with the hash or nil if it does not exist.
+	Get(hash string) (*Token, error)
+	Delete(hash string) error
+	// Redeem marks the token redeemed and returns it as it was before, or nil
+	// if it does not exist. Of concurrent calls only one returns the token
+	// unredeemed.
+	Redeem(hash string, now time.Time) (*Token, error)
+	// DeleteFamily deletes all tokens of the family.
+	DeleteFamily(familyId string) error
+	// DeleteExpired deletes the tokens that expired before now.
+	DeleteExpired(now time.Time) error
+}
+
+// MemoryStore keeps tokens in memory, they are lost on restart.
+type MemoryStore struct {
+	mutex  sync.Mutex
+	tokens map[string]*Token
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]*Token)}
+}
+
+func (s *MemoryStore) Save(t *Token) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokens[t.Hash] = copyToken(t)
+	return nil
+}
+
+func (s *MemoryStore) Get(hash string) (*Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, ok := s.tokens[hash]
+	if !ok {
+		return nil, nil
+	}
+	return copyToken(t), nil
+}
+
+func (s *MemoryStore) Delete(hash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.tokens, hash)
+	return nil
+}
+
+func (s *MemoryStore) Redeem(hash string, now time.Time) (*Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, ok := s.tokens[hash]
+	if !ok {
+		return nil, nil
+	}
+	before := copyToken(t)
+	if t.RedeemedAt.IsZero() {
+		t.RedeemedAt = now
+	}
+	return before, nil
+}
+
+func (s *MemoryStore) DeleteFamily(familyId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for hash, t := range s.tokens {
+		if t.FamilyId == familyId {
+			delete(s.tokens, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for hash, t := range s.tokens {
+		if !t.Valid(now) {
+			delete(s.tokens, hash)
+		}
+	}
+	return nil
+}
+
+func copyToken(t *Token) *Token {
+	copied := *t
+	copied.Audience = append([]string(nil), t.Audience...)
+	copied.AuthorizationDetails = append([]oauth2.AuthorizationDetail(nil), t.AuthorizationDetails...)
+	return &copied
+}
@@ -0,0 +1,163 @@
+package token_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/oauth2"
+	"github.com/arjantop/gopherauth/token"
+)
+
+func newSQLStore(t *testing.T) *token.SQLStore {
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(t, err)
+	// Every connection would open a new in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(token.SQLSchema)
+	assert.Nil(t, err)
+	return token.NewSQLStore(db, token.DialectQuestion)
+}
+
+func forEachStore(t *testing.T, test func(t *testing.T, store token.Store)) {
+	t.Run("memory", func(t *testing.T) { test(t, token.NewMemoryStore()) })
+	t.Run("sql", func(t *testing.T) { test(t, newSQLStore(t)) })
+}
+
+func newRecord(value, familyId string, expiresAt time.Time) *token.Token {
+	return &token.Token{
+		Hash:     token.Hash(value),
+		Type:     token.TypeRefreshToken,
+		ClientId: "client",
+		Subject:  "user",
+		Scope:    "openid profile",
+		Audience: []string{"https://api.example.com", "https://other.example.com"},
+		AuthorizationDetails: []oauth2.AuthorizationDetail{
+			{"type": "payment_initiation", "instructedAmount": map[string]interface{}{"currency": "EUR", "amount": "45.00"}},
+		},
+		FamilyId:     familyId,
+		Confirmation: "jkt",
+		RedirectURI:  "https://client.example.com/callback",
+		Nonce:        "nonce",
+		SessionId:    "sid",
+		AuthTime:     time.Unix(time.Now().Add(-time.Minute).Unix(), 0),
+		ACR:          "urn:example:mfa",
+		Methods:      []string{"pwd", "otp"},
+		IssuedAt:     time.Unix(time.Now().Unix(), 0),
+		ExpiresAt:    time.Unix(expiresAt.Unix(), 0),
+	}
+}
+
+func TestStoreSavesAndReturnsToken(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store token.Store) {
+		record := newRecord("value", "family", time.Now().Add(time.Hour))
+		assert.Nil(t, store.Save(record))
+
+		stored, err := store.Get(token.Hash("value"))
+		assert.Nil(t, err)
+		assert.Equal(t, record.Hash, stored.Hash)
+		assert.Equal(t, record.Type, stored.Type)
+		assert.Equal(t, record.Audience, stored.Audience)
+		assert.True(t, record.ExpiresAt.Equal(stored.ExpiresAt))
+		assert.True(t, record.IssuedAt.Equal(stored.IssuedAt))
+		assert.True(t, record.AuthTime.Equal(stored.AuthTime))
+		stored.IssuedAt, stored.ExpiresAt, stored.AuthTime = record.IssuedAt, record.ExpiresAt, record.AuthTime
+		assert.Equal(t, record, stored)
+	})
+}
+
+func TestStoreReturnsNilForUnknownToken(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store token.Store) {
+		stored, err := store.Get(token.Hash("unknown"))
+		assert.Nil(t, err)
+		assert.Nil(t, stored)
+	})
+}
+
+func TestStoreDeletesToken(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store token.Store) {
+		assert.Nil(t, store.Save(newRecord("value", "family", time.Now().Add(time.Hour))))
+		assert.Nil(t, store.Delete(token.Hash("value")))
+		assert.Nil(t, store.Delete(token.Hash("unknown")))
+
+		stored, err := store.Get(token.Hash("value"))
+		assert.Nil(t, err)
+		assert.Nil(t, stored)
+	})
+}
+
+func TestStoreDeletesFamily(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store token.Store) {
+		expiresAt := time.Now().Add(time.Hour)
+		assert.Nil(t, store.Save(newRecord("first", "family", expiresAt)))
+		assert.Nil(t, store.Save(newRecord("second", "family", expiresAt)))
+		assert.Nil(t, store.Save(newRecord("other", "other", expiresAt)))
+		assert.Nil(t, store.DeleteFamily("family"))
+
+		for _, value := range []string{"first", "second"} {
+			stored, _ := store.Get(token.Hash(value))
+			assert.Nil(t, stored)
+		}
+		stored, _ := store.Get(token.Hash("other"))
+		assert.NotNil(t, stored)
+	})
+}
+
+func TestStoreDeletesExpiredTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store token.Store) {
+		now := time.Now()
+		assert.Nil(t, store.Save(newRecord("expired", "family", now.Add(-time.Minute))))
+		assert.Nil(t, store.Save(newRecord("valid", "family", now.Add(time.Hour))))
+		assert.Nil(t, store.DeleteExpired(now))
+
+		stored, _ := store.Get(token.Hash("expired"))
+		assert.Nil(t, stored)
+		stored, _ = store.Get(token.Hash("valid"))
+		assert.NotNil(t, stored)
+	})
+}
+
+func TestStoreRedeemsTokenOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store token.Store) {
+		assert.Nil(t, store.Save(newRecord("value", "family", time.Now().Add(time.Hour))))
+
+		first, err := store.Redeem(token.Hash("value"), time.Now())
+		assert.Nil(t, err)
+		if assert.NotNil(t, first) {
+			assert.True(t, first.RedeemedAt.IsZero())
+		}
+		second, err := store.Redeem(token.Hash("value"), time.Now())
+		assert.Nil(t, err)
+		if assert.NotNil(t, second) {
+			assert.False(t, second.RedeemedAt.IsZero())
+		}
+		unknown, err := store.Redeem(token.Hash("unknown"), time.Now())
+		assert.Nil(t, err)
+		assert.Nil(t, unknown)
+	})
+}
+
+func TestSQLStoreDoesNotStoreTokens(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(token.SQLSchema)
+	assert.Nil(t, err)
+	store := token.NewSQLStore(db, token.DialectQuestion)
+	assert.Nil(t, store.Save(newRecord("secret-token-value", "family", time.Now().Add(time.Hour))))
+
+	var dump string
+	assert.Nil(t, db.QueryRow(`SELECT hash || type || client_id || subject || scope || audience ||
+		family_id || confirmation FROM oauth2_tokens`).Scan(&dump))
+	assert.False(t, strings.Contains(dump, "secret-token-value"))
+}
+
+func TestHashIsHexEncodedSHA256(t *testing.T) {
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", token.Hash("hello"))
+}
@@ -0,0 +1,191 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arjantop/gopherauth/service"
+)
+
+const (
+	// Number of recovery codes issued on enrollment
+	RecoveryCodeCount = 10
+	// Size of a random recovery code in bytes
+	recoveryCodeSize = 5
+)
+
+// InvalidCode is returned when a code or recovery code is not accepted.
+type InvalidCode struct{}
+
+func (e InvalidCode) Error() string {
+	return "Invalid code"
+}
+
+// Manager enrolls users and verifies their codes. Codes of skew periods before
+// and after the current one are accepted to allow for clock drift, every code
+// can be used only once.
+type Manager struct {
+	store          Store
+	tokenGenerator service.TokenGenerator
+	skew           int64
+	// Serializes verification, a code must not be accepted by concurrent requests
+	mutex sync.Mutex
+}
+
+func NewManager(store Store, tokenGenerator service.TokenGenerator, skew int) *Manager {
+	return &Manager{
+		store:          store,
+		tokenGenerator: tokenGenerator,
+		skew:           int64(skew),
+	}
+}
+
+// Enrolled reports whether the subject has a confirmed second factor.
+func (m *Manager) Enrolled(subject string) (bool, error) {
+	e, err := m.store.Get(subject)
+	if err != nil || e == nil {
+		return false, err
+	}
+	return e.Secret != "", nil
+}
+
+// Enroll returns the secret waiting for confirmation, a new one is generated if
+// there is none. The secret becomes active once it is confirmed and an existing
+// confirmed secret stays active until then.
+func (m *Manager) Enroll(subject string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, err := m.store.Get(subject)
+	if err != nil {
+		return "", err
+	} else if e == nil {
+		e = &Enrollment{Subject: subject}
+	} else if e.PendingSecret != "" {
+		return e.PendingSecret, nil
+	}
+	e.PendingSecret, err = GenerateSecret(m.tokenGenerator)
+	if err != nil {
+		return "", err
+	}
+	if err := m.store.Save(e); err != nil {
+		return "", err
+	}
+	return e.PendingSecret, nil
+}
+
+// PendingSecret returns the secret waiting for confirmation or an empty string.
+func (m *Manager) PendingSecret(subject string) (string, error) {
+	e, err := m.store.Get(subject)
+	if err != nil || e == nil {
+		return "", err
+	}
+	return e.PendingSecret, nil
+}
+
+// Confirm activates the pending secret if the code is valid for it and returns
+// new recovery codes, previous recovery codes are no longer valid.
+func (m *Manager) Confirm(subject, code string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, err := m.store.Get(subject)
+	if err != nil {
+		return nil, err
+	} else if e == nil || e.PendingSecret == "" {
+		return nil, InvalidCode{}
+	}
+	step, ok := m.match(e.PendingSecret, code, 0)
+	if !ok {
+		return nil, InvalidCode{}
+	}
+	recoveryCodes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range recoveryCodes {
+		random, err := m.tokenGenerator.Generate(recoveryCodeSize)
+		if err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(encoding.EncodeToString(random))
+		recoveryCodes[i] = c[:len(c)/2] + "-" + c[len(c)/2:]
+		hashes[i] = hashRecoveryCode(recoveryCodes[i])
+	}
+	e.Secret = e.PendingSecret
+	e.PendingSecret = ""
+	e.LastStep = step
+	e.RecoveryCodes = hashes
+	if err := m.store.Save(e); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// Verify accepts a code of the subject's confirmed secret.
+func (m *Manager) Verify(subject, code string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, err := m.store.Get(subject)
+	if err != nil {
+		return err
+	} else if e == nil || e.Secret == "" {
+		return InvalidCode{}
+	}
+	if step, ok := m.match(e.Secret, code, e.LastStep); ok {
+		e.LastStep = step
+		return m.store.Save(e)
+	}
+	return InvalidCode{}
+}
+
+// Recover accepts one of the subject's unused recovery codes, the used code is
+// removed.
+func (m *Manager) Recover(subject, code string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, err := m.store.Get(subject)
+	if err != nil {
+		return err
+	} else if e == nil || e.Secret == "" {
+		return InvalidCode{}
+	}
+	hash := hashRecoveryCode(code)
+	for i, h := range e.RecoveryCodes {
+		if hmac.Equal([]byte(h), []byte(hash)) {
+			recoveryCodes := make([]string, 0, len(e.RecoveryCodes)-1)
+			recoveryCodes = append(recoveryCodes, e.RecoveryCodes[:i]...)
+			e.RecoveryCodes = append(recoveryCodes, e.RecoveryCodes[i+1:]...)
+			return m.store.Save(e)
+		}
+	}
+	return InvalidCode{}
+}
+
+// match returns the step within the skew window that the code is valid for.
+// Steps up to and including lastStep are not considered.
+func (m *Manager) match(secret, c string, lastStep int64) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(c) != Digits {
+		return 0, false
+	}
+	current := step(time.Now())
+	for s := current - m.skew; s <= current+m.skew; s++ {
+		if s > lastStep && hmac.Equal([]byte(code(key, s)), []byte(c)) {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// hashRecoveryCode hashes the code ignoring case, separators and whitespace.
+func hashRecoveryCode(c string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(c)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,109 @@
+package totp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/totp"
+)
+
+const subject = "user"
+
+func makeManager() *totp.Manager {
+	return totp.NewManager(totp.NewMemoryStore(), service.NewCryptoTokenGenerator(), 1)
+}
+
+func code(t *testing.T, secret string, at time.Time) string {
+	c, err := totp.Code(secret, at)
+	assert.Nil(t, err)
+	return c
+}
+
+// enroll confirms an enrollment with a code of the previous period, leaving
+// the current period unused.
+func enroll(t *testing.T, manager *totp.Manager) (string, []string) {
+	secret, err := manager.Enroll(subject)
+	assert.Nil(t, err)
+	recoveryCodes, err := manager.Confirm(subject, code(t, secret, time.Now().Add(-totp.Period)))
+	assert.Nil(t, err)
+	return secret, recoveryCodes
+}
+
+func TestEnrollmentIsActiveOnlyAfterConfirmation(t *testing.T) {
+	manager := makeManager()
+
+	secret, err := manager.Enroll(subject)
+	assert.Nil(t, err)
+	enrolled, err := manager.Enrolled(subject)
+	assert.Nil(t, err)
+	assert.False(t, enrolled)
+	assert.IsType(t, totp.InvalidCode{}, manager.Verify(subject, code(t, secret, time.Now())))
+
+	_, err = manager.Confirm(subject, code(t, secret, time.Now().Add(-time.Hour)))
+	assert.IsType(t, totp.InvalidCode{}, err)
+
+	recoveryCodes, err := manager.Confirm(subject, code(t, secret, time.Now()))
+	assert.Nil(t, err)
+	assert.Len(t, recoveryCodes, totp.RecoveryCodeCount)
+	enrolled, err = manager.Enrolled(subject)
+	assert.Nil(t, err)
+	assert.True(t, enrolled)
+}
+
+func TestCodesWithinSkewAreAccepted(t *testing.T) {
+	manager := makeManager()
+	secret, _ := enroll(t, manager)
+
+	assert.IsType(t, totp.InvalidCode{}, manager.Verify(subject, code(t, secret, time.Now().Add(-3*totp.Period))))
+	assert.Nil(t, manager.Verify(subject, code(t, secret, time.Now())))
+	assert.Nil(t, manager.Verify(subject, code(t, secret, time.Now().Add(totp.Period))))
+}
+
+func TestCodeCanBeUsedOnce(t *testing.T) {
+	manager := makeManager()
+	secret, _ := enroll(t, manager)
+
+	current := code(t, secret, time.Now())
+	assert.Nil(t, manager.Verify(subject, current))
+	assert.IsType(t, totp.InvalidCode{}, manager.Verify(subject, current))
+}
+
+func TestRecoveryCodeCanBeUsedOnce(t *testing.T) {
+	manager := makeManager()
+	_, recoveryCodes := enroll(t, manager)
+
+	assert.IsType(t, totp.InvalidCode{}, manager.Verify(subject, recoveryCodes[0]), "Recovery code is not a code")
+	assert.Nil(t, manager.Recover(subject, " "+recoveryCodes[0]+" "))
+	assert.IsType(t, totp.InvalidCode{}, manager.Recover(subject, recoveryCodes[0]))
+	assert.Nil(t, manager.Recover(subject, recoveryCodes[1]))
+}
+
+func TestPendingSecretIsReusedUntilConfirmed(t *testing.T) {
+	manager := makeManager()
+
+	secret, err := manager.Enroll(subject)
+	assert.Nil(t, err)
+	again, err := manager.Enroll(subject)
+	assert.Nil(t, err)
+	assert.Equal(t, secret, again)
+
+	_, err = manager.Confirm(subject, code(t, secret, time.Now()))
+	assert.Nil(t, err)
+	next, err := manager.Enroll(subject)
+	assert.Nil(t, err)
+	assert.NotEqual(t, secret, next)
+}
+
+func TestReenrollmentKeepsSecretUntilConfirmed(t *testing.T) {
+	manager := makeManager()
+	secret, recoveryCodes := enroll(t, manager)
+
+	_, err := manager.Enroll(subject)
+	assert.Nil(t, err)
+
+	assert.Nil(t, manager.Verify(subject, code(t, secret, time.Now())))
+	assert.Nil(t, manager.Recover(subject, recoveryCodes[0]))
+}
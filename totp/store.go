@@ -0,0 +1,145 @@
+package totp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+
+	"github.com/arjantop/gopherauth/keyring"
+)
+
+// Enrollment is the second factor registration of a user.
+type Enrollment struct {
+	Subject string
+	// Confirmed shared secret, empty until the user confirms the enrollment
+	Secret string
+	// Secret waiting for the user to confirm it with a valid code
+	PendingSecret string
+	// Last step a code was accepted for, codes of it and earlier steps are
+	// rejected to prevent replay
+	LastStep int64
+	// SHA-256 hashes of unused recovery codes
+	RecoveryCodes []string
+}
+
+// Store persists enrollments.
+type Store interface {
+	// Get returns the enrollment of the subject or nil if there is none.
+	Get(subject string) (*Enrollment, error)
+	Save(e *Enrollment) error
+}
+
+// MemoryStore is a store that keeps enrollments in memory.
+type MemoryStore struct {
+	enrollments map[string]*Enrollment
+	mutex       sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{enrollments: make(map[string]*Enrollment)}
+}
+
+func (s *MemoryStore) Get(subject string) (*Enrollment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	e, ok := s.enrollments[subject]
+	if !ok {
+		return nil, nil
+	}
+	copied := *e
+	return &copied, nil
+}
+
+func (s *MemoryStore) Save(e *Enrollment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	copied := *e
+	s.enrollments[e.Subject] = &copied
+	return nil
+}
+
+// EncryptedStore seals the shared secrets of enrollments with the KEK before
+// they are saved to the underlying store. Secrets sealed with a previous KEK
+// are sealed with the current KEK when the enrollment is saved again.
+type EncryptedStore struct {
+	store    Store
+	kek      *keyring.KEK
+	previous []*keyring.KEK
+}
+
+func NewEncryptedStore(store Store, kek *keyring.KEK, previous ...*keyring.KEK) *EncryptedStore {
+	return &EncryptedStore{
+		store:    store,
+		kek:      kek,
+		previous: previous,
+	}
+}
+
+func (s *EncryptedStore) Get(subject string) (*Enrollment, error) {
+	e, err := s.store.Get(subject)
+	if err != nil || e == nil {
+		return e, err
+	}
+	keks := append([]*keyring.KEK{s.kek}, s.previous...)
+	if e.Secret, err = openSecret(e.Secret, secretAAD(subject, "secret"), keks); err != nil {
+		return nil, err
+	}
+	if e.PendingSecret, err = openSecret(e.PendingSecret, secretAAD(subject, "pending"), keks); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *EncryptedStore) Save(e *Enrollment) error {
+	sealed := *e
+	var err error
+	if sealed.Secret, err = s.sealSecret(e.Secret, secretAAD(e.Subject, "secret")); err != nil {
+		return err
+	}
+	if sealed.PendingSecret, err = s.sealSecret(e.PendingSecret, secretAAD(e.Subject, "pending")); err != nil {
+		return err
+	}
+	return s.store.Save(&sealed)
+}
+
+// sealSecret returns the encrypted secret encoded as a string, so it fits the
+// field of the plaintext secret. Missing secrets stay empty.
+func (s *EncryptedStore) sealSecret(secret string, aad []byte) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+	encrypted, err := s.kek.SealData([]byte(secret), aad)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(encrypted)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func openSecret(sealed string, aad []byte, keks []*keyring.KEK) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	var encrypted keyring.EncryptedData
+	if err := json.Unmarshal(data, &encrypted); err != nil {
+		return "", err
+	}
+	secret, err := keyring.OpenData(&encrypted, aad, keks...)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// secretAAD binds a sealed secret to its subject and field, so it can not be
+// moved to another enrollment.
+func secretAAD(subject, field string) []byte {
+	return []byte("totp\x00" + field + "\x00" + subject)
+}
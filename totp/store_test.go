@@ -0,0 +1,67 @@
+package totp_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/keyring"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/totp"
+)
+
+func TestEncryptedStoreDoesNotStorePlaintextSecrets(t *testing.T) {
+	memory := totp.NewMemoryStore()
+	store := totp.NewEncryptedStore(memory, testutil.NewKEK())
+	enrollment := &totp.Enrollment{Subject: subject, Secret: "SECRET", PendingSecret: "PENDING", LastStep: 3}
+	assert.NoError(t, store.Save(enrollment))
+
+	stored, err := memory.Get(subject)
+	assert.NoError(t, err)
+	assert.NotContains(t, stored.Secret, "SECRET")
+	assert.NotEmpty(t, stored.Secret)
+	assert.NotContains(t, stored.PendingSecret, "PENDING")
+
+	loaded, err := store.Get(subject)
+	assert.NoError(t, err)
+	assert.Equal(t, enrollment, loaded)
+}
+
+func TestEncryptedStoreKeepsMissingSecretsEmpty(t *testing.T) {
+	store := totp.NewEncryptedStore(totp.NewMemoryStore(), testutil.NewKEK())
+	assert.NoError(t, store.Save(&totp.Enrollment{Subject: subject, PendingSecret: "PENDING"}))
+
+	loaded, err := store.Get(subject)
+	assert.NoError(t, err)
+	assert.Empty(t, loaded.Secret)
+	assert.Equal(t, "PENDING", loaded.PendingSecret)
+
+	missing, err := store.Get("other")
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestEncryptedSecretCanNotBeMovedToOtherSubject(t *testing.T) {
+	memory := totp.NewMemoryStore()
+	store := totp.NewEncryptedStore(memory, testutil.NewKEK())
+	assert.NoError(t, store.Save(&totp.Enrollment{Subject: subject, Secret: "SECRET"}))
+	stored, _ := memory.Get(subject)
+	stored.Subject = "other"
+	assert.NoError(t, memory.Save(stored))
+
+	_, err := store.Get("other")
+	assert.Error(t, err)
+}
+
+func TestSecretsOfPreviousKEKAreReadable(t *testing.T) {
+	memory := totp.NewMemoryStore()
+	previous := testutil.NewKEK()
+	assert.NoError(t, totp.NewEncryptedStore(memory, previous).Save(&totp.Enrollment{Subject: subject, Secret: "SECRET"}))
+
+	loaded, err := totp.NewEncryptedStore(memory, testutil.NewKEK(), previous).Get(subject)
+	assert.NoError(t, err)
+	assert.Equal(t, "SECRET", loaded.Secret)
+
+	_, err = totp.NewEncryptedStore(memory, testutil.NewKEK()).Get(subject)
+	assert.Equal(t, keyring.ErrUnknownKEK, err)
+}
@@ -0,0 +1,42 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/totp"
+)
+
+func TestCodeMatchesTestVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 values truncated to six digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code, err := totp.Code(secret, time.Unix(unix, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, code, "Code at %d", unix)
+	}
+}
+
+func TestProvisioningURIDescribesSecret(t *testing.T) {
+	uri, err := url.Parse(totp.ProvisioningURI("gopherauth", "user@example.com", "SECRET"))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/gopherauth:user@example.com", uri.Path)
+	assert.Equal(t, "SECRET", uri.Query().Get("secret"))
+	assert.Equal(t, "gopherauth", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+	assert.Equal(t, "30", uri.Query().Get("period"))
+}
@@ -0,0 +1,192 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/arjantop/gopherauth/keyring"
+)
+
+const (
+	// Cookie holding the id of the login session
+	CookieSession = "sessionid"
+	// Prefix of cookies that are bound to the host, sent only over HTTPS and
+	// for all paths
+	HostPrefix = "__Host-"
+)
+
+// CookiePolicy configures the cookies set by the server. Cookies are identified
+// by their purpose, e.g. CookieSession, the zero policy uses the purpose as the
+// cookie name.
+type CookiePolicy struct {
+	// Names of the cookies by purpose
+	Names map[string]string
+	// Domain the cookies are sent to, only the host that set them if empty
+	Domain string
+	// Path the cookies are sent for, all paths if empty
+	Path string
+	// Cookies are sent only over HTTPS
+	Secure   bool
+	SameSite http.SameSite
+	// Lifetime of the cookies by purpose, cookies without a lifetime are
+	// deleted when the browser is closed
+	Lifetime map[string]time.Duration
+	// Names are prefixed with __Host-, requires Secure, no Domain and all paths
+	HostPrefix bool
+	// Keyring used to encrypt and authenticate cookie values, the active key
+	// encrypts new values and all keys that did not expire are accepted.
+	// Values are stored as they are if it is nil.
+	Keys *keyring.Keyring
+}
+
+// Validate returns an error if the policy can not be satisfied by browsers.
+func (p *CookiePolicy) Validate() error {
+	if p.HostPrefix && (!p.Secure || p.Domain != "" || (p.Path != "" && p.Path != "/")) {
+		return errors.New("Cookies with the __Host- prefix must be secure, without a domain and for all paths")
+	}
+	return nil
+}
+
+// Name returns the name of the cookie with the purpose.
+func (p *CookiePolicy) Name(purpose string) string {
+	name := purpose
+	if configured, ok := p.Names[purpose]; ok && configured != "" {
+		name = configured
+	}
+	if p.HostPrefix {
+		name = HostPrefix + name
+	}
+	return name
+}
+
+// Set sets the cookie with the purpose that expires after the lifetime of the
+// purpose.
+func (p *CookiePolicy) Set(w http.ResponseWriter, purpose, value string) error {
+	var expiresAt time.Time
+	if lifetime := p.Lifetime[purpose]; lifetime > 0 {
+		expiresAt = time.Now().Add(lifetime)
+	}
+	return p.SetExpiring(w, purpose, value, expiresAt)
+}
+
+// SetExpiring sets the cookie with the purpose that expires at the given time,
+// zero time makes it a session cookie. Encrypted values are also rejected by
+// Get once they expire.
+func (p *CookiePolicy) SetExpiring(w http.ResponseWriter, purpose, value string, expiresAt time.Time) error {
+	if p.Keys != nil {
+		sealed, err := p.seal(purpose, value, expiresAt)
+		if err != nil {
+			return err
+		}
+		value = sealed
+	}
+	cookie := p.cookie(purpose, value)
+	if !expiresAt.IsZero() {
+		cookie.Expires = expiresAt
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// Get returns the value of the cookie with the purpose. http.ErrNoCookie is
+// returned if the cookie is not present or its value was changed or expired.
+func (p *CookiePolicy) Get(r *http.Request, purpose string) (string, error) {
+	cookie, err := r.Cookie(p.Name(purpose))
+	if err != nil {
+		return "", err
+	}
+	if p.Keys == nil {
+		return cookie.Value, nil
+	}
+	value, ok := p.open(purpose, cookie.Value, time.Now())
+	if !ok {
+		return "", http.ErrNoCookie
+	}
+	return value, nil
+}
+
+// Delete deletes the cookie with the purpose.
+func (p *CookiePolicy) Delete(w http.ResponseWriter, purpose string) {
+	cookie := p.cookie(purpose, "")
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+func (p *CookiePolicy) cookie(purpose, value string) *http.Cookie {
+	path := p.Path
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     p.Name(purpose),
+		Value:    value,
+		Path:     path,
+		Domain:   p.Domain,
+		Secure:   p.Secure,
+		SameSite: p.SameSite,
+		HttpOnly: true,
+	}
+}
+
+// seal encrypts the value and its expiration time with the active key. The
+// purpose is authenticated so values can not be moved between cookies.
+func (p *CookiePolicy) seal(purpose, value string, expiresAt time.Time) (string, error) {
+	aead, err := cookieCipher(p.Keys.Secret())
+	if err != nil {
+		return "", err
+	}
+	plaintext := make([]byte, 8, 8+len(value))
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(plaintext, uint64(expiresAt.Unix()))
+	}
+	plaintext = append(plaintext, value...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, []byte(purpose))), nil
+}
+
+// open decrypts the value with any of the keys.
+func (p *CookiePolicy) open(purpose, value string, now time.Time) (string, bool) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", false
+	}
+	for _, key := range p.Keys.Secrets() {
+		aead, err := cookieCipher(key)
+		if err != nil || len(sealed) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(purpose))
+		if err != nil || len(plaintext) < 8 {
+			continue
+		}
+		expiresAt := int64(binary.BigEndian.Uint64(plaintext))
+		if expiresAt != 0 && now.Unix() >= expiresAt {
+			return "", false
+		}
+		return string(plaintext[8:]), true
+	}
+	return "", false
+}
+
+// cookieCipher returns AES-256-GCM with a key derived from the keyring key.
+func cookieCipher(key []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("cookie"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"encoding/base64"
 	"errors"
+	"net"
 	"net/http"
 	"strings"
 
@@ -25,3 +26,13 @@ func GetBasicAuth(r *http.Request) (*service.ClientCredentials, error) {
 	}
 	return &service.ClientCredentials{clientCredentialsParts[0], clientCredentialsParts[1]}, nil
 }
+
+// RemoteIP returns the IP address of the client that sent the request or an
+// empty string if it is unknown. Forwarding headers are not trusted.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
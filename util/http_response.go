@@ -1,13 +1,21 @@
 package util
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"net/http"
 	"net/url"
+
+	"github.com/arjantop/gopherauth/keyring"
 )
 
 const (
 	ContentTypeHtml = "text/html;charset=utf-8"
 	ContentTypeJson = "application/json;charset=utf-8"
+	// Login parameters with the URL the user is returned to and its signature
+	ParameterContinue          = "continue"
+	ParameterContinueSignature = "continue_sig"
 )
 
 type HTTPError struct {
@@ -39,7 +47,45 @@ func RenderHTTPError(w http.ResponseWriter, tf *TemplateFactory, he HTTPError) {
 	tf.ExecuteTemplate(w, "http_error", he)
 }
 
-func RedirectToLogin(w http.ResponseWriter, r *http.Request, loginURL url.URL, returnTo *url.URL) {
-	loginURL.RawQuery = "continue=" + url.QueryEscape(returnTo.String())
+// RedirectToLogin redirects the user to the login page that returns the user to
+// returnTo after login. Params, such as a login hint, are passed to the login page.
+// The return URL is signed with the server key so it can not be swapped.
+func RedirectToLogin(
+	w http.ResponseWriter, r *http.Request, serverKey []byte, loginURL url.URL, returnTo *url.URL, params url.Values) {
+
+	query := url.Values{}
+	for name, values := range params {
+		query[name] = values
+	}
+	query.Set(ParameterContinue, returnTo.String())
+	query.Set(ParameterContinueSignature, SignContinue(returnTo.String(), serverKey))
+	loginURL.RawQuery = query.Encode()
 	http.Redirect(w, r, loginURL.String(), http.StatusFound)
 }
+
+// SignContinue returns the signature of the URL the user is returned to after
+// login.
+func SignContinue(continueUrl string, serverKey []byte) string {
+	return base64.RawURLEncoding.EncodeToString(continueMAC(continueUrl, serverKey))
+}
+
+// VerifyContinue reports whether the return URL was signed with any of the
+// server keys.
+func VerifyContinue(continueUrl, signature string, serverKeys *keyring.Keyring) bool {
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	for _, serverKey := range serverKeys.Secrets() {
+		if hmac.Equal(mac, continueMAC(continueUrl, serverKey)) {
+			return true
+		}
+	}
+	return false
+}
+
+func continueMAC(continueUrl string, serverKey []byte) []byte {
+	mac := hmac.New(sha256.New, serverKey)
+	mac.Write([]byte(ParameterContinue + continueUrl))
+	return mac.Sum(nil)
+}
@@ -28,6 +28,18 @@ func (tf *TemplateFactory) init() {
 	loginTemplate := template.Must(template.ParseFiles(path.Join(tf.templateRoot, "login.html")))
 	tf.templates["login"] = loginTemplate
 
+	totpEnrollmentTemplate := template.Must(template.ParseFiles(path.Join(tf.templateRoot, "totp_enrollment.html")))
+	tf.templates["totp_enrollment"] = totpEnrollmentTemplate
+
+	passkeyRegistrationTemplate := template.Must(template.ParseFiles(path.Join(tf.templateRoot, "passkey_registration.html")))
+	tf.templates["passkey_registration"] = passkeyRegistrationTemplate
+
+	logoutTemplate := template.Must(template.ParseFiles(path.Join(tf.templateRoot, "logout.html")))
+	tf.templates["logout"] = logoutTemplate
+
+	formPostTemplate := template.Must(template.ParseFiles(path.Join(tf.templateRoot, "form_post.html")))
+	tf.templates["form_post"] = formPostTemplate
+
 	httpErrorTemplate := template.Must(template.ParseFiles(path.Join(tf.templateRoot, "http_error.html")))
 	tf.templates["http_error"] = httpErrorTemplate
 
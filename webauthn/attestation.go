@@ -0,0 +1,113 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+)
+
+// Supported attestation statement formats
+const (
+	AttestationNone   = "none"
+	AttestationPacked = "packed"
+)
+
+// Extension of attestation certificates that contains the AAGUID of the
+// authenticator model
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// verifyAttestation verifies the attestation statement over the authenticator
+// data and the client data hash. Packed attestation certificates are checked
+// for the required fields, they are not validated against trusted roots.
+func verifyAttestation(
+	format string,
+	statement map[interface{}]interface{},
+	rawAuthData []byte,
+	authData *AuthenticatorData,
+	clientDataHash []byte) error {
+
+	switch format {
+	case AttestationNone:
+		if len(statement) != 0 {
+			return &VerificationError{"Attestation statement of format none must be empty"}
+		}
+		return nil
+	case AttestationPacked:
+		return verifyPackedAttestation(statement, rawAuthData, authData, clientDataHash)
+	}
+	return &VerificationError{"Unsupported attestation format " + format}
+}
+
+func verifyPackedAttestation(
+	statement map[interface{}]interface{},
+	rawAuthData []byte,
+	authData *AuthenticatorData,
+	clientDataHash []byte) error {
+
+	algorithm, _ := statement["alg"].(int64)
+	signature, _ := statement["sig"].([]byte)
+	if signature == nil {
+		return &VerificationError{"Packed attestation signature is missing"}
+	}
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash...)
+
+	certificates, hasCertificates := statement["x5c"].([]interface{})
+	if !hasCertificates {
+		// Self attestation is signed with the credential key
+		credentialKey, _, err := parsePublicKey(authData.PublicKey)
+		if err != nil {
+			return err
+		}
+		if credentialKey.algorithm != algorithm || !credentialKey.verify(signed, signature) {
+			return &VerificationError{"Invalid packed self attestation signature"}
+		}
+		return nil
+	}
+
+	if len(certificates) == 0 {
+		return &VerificationError{"Packed attestation certificate is missing"}
+	}
+	der, _ := certificates[0].([]byte)
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return &VerificationError{"Invalid packed attestation certificate"}
+	}
+	var signatureAlgorithm x509.SignatureAlgorithm
+	switch algorithm {
+	case AlgorithmES256:
+		signatureAlgorithm = x509.ECDSAWithSHA256
+	case AlgorithmRS256:
+		signatureAlgorithm = x509.SHA256WithRSA
+	case AlgorithmEdDSA:
+		signatureAlgorithm = x509.PureEd25519
+	default:
+		return &VerificationError{"Unsupported packed attestation algorithm"}
+	}
+	if certificate.CheckSignature(signatureAlgorithm, signed, signature) != nil {
+		return &VerificationError{"Invalid packed attestation signature"}
+	}
+	if certificate.Version != 3 || certificate.IsCA ||
+		!containsString(certificate.Subject.OrganizationalUnit, "Authenticator Attestation") {
+		return &VerificationError{"Packed attestation certificate does not meet the requirements"}
+	}
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidAAGUID) {
+			continue
+		}
+		var aaguid []byte
+		if _, err := asn1.Unmarshal(extension.Value, &aaguid); err != nil || extension.Critical ||
+			!bytes.Equal(aaguid, authData.AAGUID) {
+			return &VerificationError{"Packed attestation certificate AAGUID does not match"}
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
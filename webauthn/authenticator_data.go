@@ -0,0 +1,85 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent        = 0x01
+	FlagUserVerified       = 0x04
+	FlagBackupEligible     = 0x08
+	FlagBackedUp           = 0x10
+	FlagAttestedCredential = 0x40
+	FlagExtensions         = 0x80
+)
+
+var errInvalidAuthenticatorData = errors.New("Invalid authenticator data")
+
+// AuthenticatorData is the data an authenticator signs in both ceremonies.
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// Attested credential data, only present on registration
+	AAGUID       []byte
+	CredentialID []byte
+	// COSE_Key of the credential
+	PublicKey []byte
+}
+
+func (d *AuthenticatorData) UserPresent() bool {
+	return d.Flags&FlagUserPresent != 0
+}
+
+func (d *AuthenticatorData) UserVerified() bool {
+	return d.Flags&FlagUserVerified != 0
+}
+
+func (d *AuthenticatorData) BackupEligible() bool {
+	return d.Flags&FlagBackupEligible != 0
+}
+
+// parseAuthenticatorData parses authenticator data, extensions are not
+// processed but must be well formed.
+func parseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errInvalidAuthenticatorData
+	}
+	d := &AuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+	if d.Flags&FlagAttestedCredential != 0 {
+		if len(rest) < 18 {
+			return nil, errInvalidAuthenticatorData
+		}
+		d.AAGUID = rest[:16]
+		length := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if length == 0 || length > 1023 || len(rest) < length {
+			return nil, errInvalidAuthenticatorData
+		}
+		d.CredentialID = rest[:length]
+		rest = rest[length:]
+		_, afterKey, err := parsePublicKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		d.PublicKey = rest[:len(rest)-len(afterKey)]
+		rest = afterKey
+	}
+	if d.Flags&FlagExtensions != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, errInvalidAuthenticatorData
+		}
+	}
+	if len(rest) != 0 {
+		return nil, errInvalidAuthenticatorData
+	}
+	return d, nil
+}
@@ -0,0 +1,140 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// CBOR major types (RFC 8949)
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTag      = 6
+	cborSimple   = 7
+)
+
+// Nesting limit of decoded items, authenticator data is never nested deeply
+const cborMaxDepth = 16
+
+var errInvalidCBOR = errors.New("Invalid CBOR")
+
+// decodeCBOR decodes the first data item in data and returns the remaining
+// bytes. Only the subset used by authenticators is supported: integers are
+// returned as int64, byte strings as []byte, text as string, arrays as
+// []interface{} and maps as map[interface{}]interface{} with int64 or string
+// keys. Indefinite lengths and floating point numbers are rejected.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth || len(data) == 0 {
+		return nil, nil, errInvalidCBOR
+	}
+	major := data[0] >> 5
+	if major == cborSimple {
+		switch data[0] & 0x1f {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22:
+			return nil, data[1:], nil
+		}
+		return nil, nil, errInvalidCBOR
+	}
+	argument, rest, err := decodeCBORArgument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case cborUnsigned:
+		if argument > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return int64(argument), rest, nil
+	case cborNegative:
+		if argument > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return -1 - int64(argument), rest, nil
+	case cborBytes, cborText:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errInvalidCBOR
+		}
+		if major == cborText {
+			return string(rest[:argument]), rest[argument:], nil
+		}
+		value := make([]byte, argument)
+		copy(value, rest)
+		return value, rest[argument:], nil
+	case cborArray:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errInvalidCBOR
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case cborMap:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errInvalidCBOR
+		}
+		items := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errInvalidCBOR
+			}
+			if _, ok := items[key]; ok {
+				return nil, nil, errInvalidCBOR
+			}
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, rest, nil
+	case cborTag:
+		return decodeCBORItem(rest, depth+1)
+	}
+	return nil, nil, errInvalidCBOR
+}
+
+// decodeCBORArgument decodes the argument of the initial byte, a small value
+// or a length.
+func decodeCBORArgument(data []byte) (uint64, []byte, error) {
+	info := data[0] & 0x1f
+	data = data[1:]
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	return 0, nil, errInvalidCBOR
+}
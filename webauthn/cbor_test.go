@@ -0,0 +1,40 @@
+package webauthn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCBORIsDecoded(t *testing.T) {
+	// {1: 2, "a": [-1, h'0102'], "b": true}
+	data := []byte{0xa3, 0x01, 0x02, 0x61, 0x61, 0x82, 0x20, 0x42, 0x01, 0x02, 0x61, 0x62, 0xf5, 0xff}
+
+	item, rest, err := decodeCBOR(data)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xff}, rest)
+	assert.Equal(t, map[interface{}]interface{}{
+		int64(1): int64(2),
+		"a":      []interface{}{int64(-1), []byte{0x01, 0x02}},
+		"b":      true,
+	}, item)
+}
+
+func TestInvalidCBORIsRejected(t *testing.T) {
+	invalid := [][]byte{
+		{},
+		// Byte string longer than the data
+		{0x45, 0x01},
+		// Indefinite length array
+		{0x9f, 0x01, 0xff},
+		// Duplicate map key
+		{0xa2, 0x01, 0x01, 0x01, 0x02},
+		// Floating point number
+		{0xf9, 0x3c, 0x00},
+	}
+	for _, data := range invalid {
+		_, _, err := decodeCBOR(data)
+		assert.NotNil(t, err, "%x", data)
+	}
+}
@@ -0,0 +1,99 @@
+package webauthn
+
+import (
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credential is a public key credential registered by a user.
+type Credential struct {
+	ID      []byte
+	Subject string
+	// User handle the authenticator returns for discoverable credentials
+	UserHandle []byte
+	// COSE_Key of the credential
+	PublicKey []byte
+	SignCount uint32
+	// Attestation statement format the credential was registered with
+	AttestationFormat string
+	// The credential may be synced to other authenticators of the user
+	BackupEligible bool
+	CreatedAt      time.Time
+}
+
+// CredentialStore persists credentials.
+type CredentialStore interface {
+	// Get returns the credential with the id or nil if there is none.
+	Get(id []byte) (*Credential, error)
+	// List returns all credentials of the subject.
+	List(subject string) ([]*Credential, error)
+	// Save adds a credential or updates the credential with the same id.
+	Save(c *Credential) error
+}
+
+// MemoryCredentialStore is a store that keeps credentials in memory.
+type MemoryCredentialStore struct {
+	credentials map[string]*Credential
+	mutex       sync.Mutex
+}
+
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{credentials: make(map[string]*Credential)}
+}
+
+func (s *MemoryCredentialStore) Get(id []byte) (*Credential, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.credentials[string(id)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (s *MemoryCredentialStore) List(subject string) ([]*Credential, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	credentials := make([]*Credential, 0)
+	for _, c := range s.credentials {
+		if c.Subject == subject {
+			copied := *c
+			credentials = append(credentials, &copied)
+		}
+	}
+	return credentials, nil
+}
+
+func (s *MemoryCredentialStore) Save(c *Credential) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	copied := *c
+	s.credentials[string(c.ID)] = &copied
+	return nil
+}
+
+// URLEncodedBytes are bytes encoded as unpadded base64url in JSON, the encoding
+// used by the WebAuthn JSON serialization of credentials.
+type URLEncodedBytes []byte
+
+func (b URLEncodedBytes) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + base64.RawURLEncoding.EncodeToString(b) + `"`), nil
+}
+
+func (b *URLEncodedBytes) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return &VerificationError{"Invalid base64url value"}
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(string(data[1:len(data)-1]), "="))
+	if err != nil {
+		return &VerificationError{"Invalid base64url value"}
+	}
+	*b = decoded
+	return nil
+}
@@ -0,0 +1,276 @@
+// Package webauthn implements the relying party side of Web Authentication
+// registration and authentication ceremonies.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/arjantop/gopherauth/service"
+)
+
+const (
+	// Size of random challenges in bytes
+	ChallengeSize = 32
+	// Size of random user handles in bytes
+	UserHandleSize = 32
+	// Time the user has to complete a ceremony
+	CeremonyTimeout = 5 * time.Minute
+)
+
+// VerificationError is returned when a response of the authenticator is not
+// accepted.
+type VerificationError struct {
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return e.Reason
+}
+
+// RelyingParty runs the ceremonies for the relying party id, responses are
+// accepted only from the given origins.
+type RelyingParty struct {
+	id             string
+	name           string
+	origins        []string
+	store          CredentialStore
+	tokenGenerator service.TokenGenerator
+}
+
+func NewRelyingParty(
+	id, name string,
+	origins []string,
+	store CredentialStore,
+	tokenGenerator service.TokenGenerator) *RelyingParty {
+
+	return &RelyingParty{
+		id:             id,
+		name:           name,
+		origins:        origins,
+		store:          store,
+		tokenGenerator: tokenGenerator,
+	}
+}
+
+// HasCredentials reports whether the subject registered any credentials.
+func (rp *RelyingParty) HasCredentials(subject string) (bool, error) {
+	credentials, err := rp.store.List(subject)
+	return len(credentials) > 0, err
+}
+
+// BeginRegistration returns options for registering a discoverable credential
+// of the subject. Existing credentials are excluded so an authenticator is not
+// registered twice. The challenge and user handle of the options must be kept
+// until the registration is finished.
+func (rp *RelyingParty) BeginRegistration(subject, displayName string) (*CreationOptions, error) {
+	credentials, err := rp.store.List(subject)
+	if err != nil {
+		return nil, err
+	}
+	userHandle, err := rp.tokenGenerator.Generate(UserHandleSize)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := rp.tokenGenerator.Generate(ChallengeSize)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make([]CredentialDescriptor, 0, len(credentials))
+	for _, c := range credentials {
+		userHandle = c.UserHandle
+		excluded = append(excluded, CredentialDescriptor{Type: CredentialTypePublicKey, ID: c.ID})
+	}
+	return &CreationOptions{
+		Challenge:    challenge,
+		RelyingParty: RelyingPartyEntity{ID: rp.id, Name: rp.name},
+		User: UserEntity{
+			ID:          userHandle,
+			Name:        subject,
+			DisplayName: displayName,
+		},
+		CredentialParameters: []CredentialParameters{
+			{Type: CredentialTypePublicKey, Algorithm: AlgorithmES256},
+			{Type: CredentialTypePublicKey, Algorithm: AlgorithmEdDSA},
+			{Type: CredentialTypePublicKey, Algorithm: AlgorithmRS256},
+		},
+		Timeout:            int64(CeremonyTimeout / time.Millisecond),
+		ExcludeCredentials: excluded,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      RequirementRequired,
+			UserVerification: RequirementPreferred,
+		},
+		Attestation: "direct",
+	}, nil
+}
+
+// FinishRegistration verifies the response to the options with the challenge
+// and user handle and stores the new credential.
+func (rp *RelyingParty) FinishRegistration(
+	subject string, userHandle, challenge []byte, response *RegistrationResponse) (*Credential, error) {
+
+	clientDataHash, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+	item, rest, err := decodeCBOR(response.Response.AttestationObject)
+	attestation, ok := item.(map[interface{}]interface{})
+	if err != nil || !ok || len(rest) != 0 {
+		return nil, &VerificationError{"Invalid attestation object"}
+	}
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, &VerificationError{err.Error()}
+	}
+	if err := rp.verifyAuthenticatorData(authData, false); err != nil {
+		return nil, err
+	}
+	if authData.CredentialID == nil {
+		return nil, &VerificationError{"Attested credential data is missing"}
+	}
+	// The client reports the id of the credential it later asserts with
+	if !bytes.Equal(response.RawID, authData.CredentialID) {
+		return nil, &VerificationError{"Credential id does not match the attested credential"}
+	}
+	if err := verifyAttestation(format, statement, rawAuthData, authData, clientDataHash); err != nil {
+		return nil, err
+	}
+	existing, err := rp.store.Get(authData.CredentialID)
+	if err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, &VerificationError{"Credential is already registered"}
+	}
+	credential := &Credential{
+		ID:                authData.CredentialID,
+		Subject:           subject,
+		UserHandle:        userHandle,
+		PublicKey:         authData.PublicKey,
+		SignCount:         authData.SignCount,
+		AttestationFormat: format,
+		BackupEligible:    authData.BackupEligible(),
+		CreatedAt:         time.Now(),
+	}
+	if err := rp.store.Save(credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// BeginLogin returns options for authenticating the subject with one of the
+// registered credentials. Without a subject any discoverable credential is
+// allowed and user verification is required, the passkey is the only factor.
+func (rp *RelyingParty) BeginLogin(subject string) (*RequestOptions, error) {
+	challenge, err := rp.tokenGenerator.Generate(ChallengeSize)
+	if err != nil {
+		return nil, err
+	}
+	options := &RequestOptions{
+		Challenge:        challenge,
+		RelyingPartyID:   rp.id,
+		Timeout:          int64(CeremonyTimeout / time.Millisecond),
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: RequirementRequired,
+	}
+	if subject == "" {
+		return options, nil
+	}
+	credentials, err := rp.store.List(subject)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range credentials {
+		options.AllowCredentials = append(options.AllowCredentials,
+			CredentialDescriptor{Type: CredentialTypePublicKey, ID: c.ID})
+	}
+	options.UserVerification = RequirementPreferred
+	return options, nil
+}
+
+// FinishLogin verifies the assertion for the challenge and returns the used
+// credential. With an empty subject the user is identified by the credential
+// and user verification is required.
+func (rp *RelyingParty) FinishLogin(
+	subject string, challenge []byte, response *AssertionResponse) (*Credential, *AuthenticatorData, error) {
+
+	credential, err := rp.store.Get(response.RawID)
+	if err != nil {
+		return nil, nil, err
+	} else if credential == nil || (subject != "" && credential.Subject != subject) {
+		return nil, nil, &VerificationError{"Unknown credential"}
+	}
+	userHandle := response.Response.UserHandle
+	if (subject == "" || len(userHandle) > 0) && !bytes.Equal(userHandle, credential.UserHandle) {
+		return nil, nil, &VerificationError{"User handle does not match the credential"}
+	}
+	clientDataHash, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+	rawAuthData := response.Response.AuthenticatorData
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, nil, &VerificationError{err.Error()}
+	}
+	if err := rp.verifyAuthenticatorData(authData, subject == ""); err != nil {
+		return nil, nil, err
+	}
+	key, _, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash...)
+	if !key.verify(signed, response.Response.Signature) {
+		return nil, nil, &VerificationError{"Invalid assertion signature"}
+	}
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		return nil, nil, &VerificationError{"Signature counter did not increase, the authenticator may be cloned"}
+	}
+	credential.SignCount = authData.SignCount
+	if err := rp.store.Save(credential); err != nil {
+		return nil, nil, err
+	}
+	return credential, authData, nil
+}
+
+// verifyClientData checks the ceremony type, challenge and origin and returns
+// the hash of the client data that the authenticator signed.
+func (rp *RelyingParty) verifyClientData(data []byte, ceremony string, challenge []byte) ([]byte, error) {
+	var c clientData
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, &VerificationError{"Invalid client data"}
+	}
+	if c.Type != ceremony {
+		return nil, &VerificationError{"Client data is not of type " + ceremony}
+	}
+	expected := base64.RawURLEncoding.EncodeToString(challenge)
+	if subtle.ConstantTimeCompare([]byte(c.Challenge), []byte(expected)) != 1 {
+		return nil, &VerificationError{"Challenge does not match"}
+	}
+	if !containsString(rp.origins, c.Origin) {
+		return nil, &VerificationError{"Origin " + c.Origin + " is not allowed"}
+	}
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *AuthenticatorData, requireUserVerification bool) error {
+	rpIDHash := sha256.Sum256([]byte(rp.id))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return &VerificationError{"Relying party id does not match"}
+	}
+	if !authData.UserPresent() {
+		return &VerificationError{"User was not present"}
+	}
+	if requireUserVerification && !authData.UserVerified() {
+		return &VerificationError{"User was not verified"}
+	}
+	return nil
+}
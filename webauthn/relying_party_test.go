@@ -0,0 +1,217 @@
+package webauthn_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arjantop/gopherauth/service"
+	"github.com/arjantop/gopherauth/testutil"
+	"github.com/arjantop/gopherauth/webauthn"
+)
+
+const (
+	rpID    = "example.com"
+	origin  = "https://example.com"
+	subject = "user@example.com"
+)
+
+func makeRelyingParty() *webauthn.RelyingParty {
+	return webauthn.NewRelyingParty(
+		rpID, "Example", []string{origin}, webauthn.NewMemoryCredentialStore(), service.NewCryptoTokenGenerator())
+}
+
+func register(t *testing.T, rp *webauthn.RelyingParty, authenticator *testutil.SoftwareAuthenticator) (*webauthn.Credential, error) {
+	options, err := rp.BeginRegistration(subject, "User")
+	assert.Nil(t, err)
+	response := authenticator.Create(t, options)
+	return rp.FinishRegistration(subject, options.User.ID, options.Challenge, response)
+}
+
+func TestCredentialIsRegisteredWithoutAttestation(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+
+	credential, err := register(t, rp, authenticator)
+
+	assert.Nil(t, err)
+	assert.Equal(t, authenticator.CredentialID, credential.ID)
+	assert.Equal(t, subject, credential.Subject)
+	assert.Equal(t, webauthn.AttestationNone, credential.AttestationFormat)
+	hasCredentials, err := rp.HasCredentials(subject)
+	assert.Nil(t, err)
+	assert.True(t, hasCredentials)
+}
+
+func TestCredentialIsRegisteredWithPackedSelfAttestation(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	authenticator.Format = webauthn.AttestationPacked
+
+	credential, err := register(t, rp, authenticator)
+
+	assert.Nil(t, err)
+	assert.Equal(t, webauthn.AttestationPacked, credential.AttestationFormat)
+}
+
+func TestCredentialIsRegisteredWithPackedCertificateAttestation(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	authenticator.UsePackedCertificateAttestation(t)
+
+	_, err := register(t, rp, authenticator)
+
+	assert.Nil(t, err)
+}
+
+func TestPackedAttestationWithInvalidSignatureIsRejected(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	authenticator.UsePackedCertificateAttestation(t)
+	authenticator.AttestationKey = authenticator.Key
+
+	_, err := register(t, rp, authenticator)
+
+	assert.IsType(t, &webauthn.VerificationError{}, err)
+}
+
+func TestRegistrationFromOtherOriginIsRejected(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, "https://evil.example.com")
+
+	_, err := register(t, rp, authenticator)
+
+	assert.IsType(t, &webauthn.VerificationError{}, err)
+}
+
+func TestRegistrationForOtherRelyingPartyIsRejected(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, "evil.example.com", origin)
+
+	_, err := register(t, rp, authenticator)
+
+	assert.IsType(t, &webauthn.VerificationError{}, err)
+}
+
+func TestRegistrationWithOtherChallengeIsRejected(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	options, err := rp.BeginRegistration(subject, "User")
+	assert.Nil(t, err)
+	response := authenticator.Create(t, options)
+
+	_, err = rp.FinishRegistration(subject, options.User.ID, []byte("other challenge"), response)
+
+	assert.IsType(t, &webauthn.VerificationError{}, err)
+}
+
+func TestRegistrationWithOtherCredentialIdIsRejected(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	options, err := rp.BeginRegistration(subject, "User")
+	assert.Nil(t, err)
+	response := authenticator.Create(t, options)
+	response.RawID = []byte("other credential")
+
+	_, err = rp.FinishRegistration(subject, options.User.ID, options.Challenge, response)
+
+	assert.IsType(t, &webauthn.VerificationError{}, err)
+	hasCredentials, err := rp.HasCredentials(subject)
+	assert.Nil(t, err)
+	assert.False(t, hasCredentials)
+}
+
+func TestExistingCredentialsAreExcludedFromRegistration(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	credential, err := register(t, rp, authenticator)
+	assert.Nil(t, err)
+
+	options, err := rp.BeginRegistration(subject, "User")
+
+	assert.Nil(t, err)
+	assert.Len(t, options.ExcludeCredentials, 1)
+	assert.Equal(t, credential.ID, []byte(options.ExcludeCredentials[0].ID))
+	assert.Equal(t, credential.UserHandle, []byte(options.User.ID), "User handle is stable")
+}
+
+func TestDiscoverableCredentialIdentifiesUser(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	_, err := register(t, rp, authenticator)
+	assert.Nil(t, err)
+
+	options, err := rp.BeginLogin("")
+	assert.Nil(t, err)
+	assert.Empty(t, options.AllowCredentials)
+	assert.Equal(t, webauthn.RequirementRequired, options.UserVerification)
+	credential, authData, err := rp.FinishLogin("", options.Challenge, authenticator.Get(t, options))
+
+	assert.Nil(t, err)
+	assert.Equal(t, subject, credential.Subject)
+	assert.True(t, authData.UserVerified())
+}
+
+func TestDiscoverableLoginRequiresUserVerification(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	_, err := register(t, rp, authenticator)
+	assert.Nil(t, err)
+	authenticator.UserVerified = false
+
+	options, err := rp.BeginLogin("")
+	assert.Nil(t, err)
+	_, _, err = rp.FinishLogin("", options.Challenge, authenticator.Get(t, options))
+	assert.IsType(t, &webauthn.VerificationError{}, err)
+
+	options, err = rp.BeginLogin(subject)
+	assert.Nil(t, err)
+	_, _, err = rp.FinishLogin(subject, options.Challenge, authenticator.Get(t, options))
+	assert.Nil(t, err, "User verification is not required for a second factor")
+}
+
+func TestCredentialOfOtherUserIsRejected(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	_, err := register(t, rp, authenticator)
+	assert.Nil(t, err)
+
+	options, err := rp.BeginLogin("other@example.com")
+	assert.Nil(t, err)
+	_, _, err = rp.FinishLogin("other@example.com", options.Challenge, authenticator.Get(t, options))
+
+	assert.IsType(t, &webauthn.VerificationError{}, err)
+}
+
+func TestInvalidAssertionSignatureIsRejected(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	_, err := register(t, rp, authenticator)
+	assert.Nil(t, err)
+
+	options, err := rp.BeginLogin(subject)
+	assert.Nil(t, err)
+	response := authenticator.Get(t, options)
+	response.Response.AuthenticatorData[32] |= webauthn.FlagBackedUp
+
+	_, _, err = rp.FinishLogin(subject, options.Challenge, response)
+	assert.IsType(t, &webauthn.VerificationError{}, err)
+}
+
+func TestSignCounterMustIncrease(t *testing.T) {
+	rp := makeRelyingParty()
+	authenticator := testutil.NewSoftwareAuthenticator(t, rpID, origin)
+	_, err := register(t, rp, authenticator)
+	assert.Nil(t, err)
+
+	options, err := rp.BeginLogin(subject)
+	assert.Nil(t, err)
+	_, _, err = rp.FinishLogin(subject, options.Challenge, authenticator.Get(t, options))
+	assert.Nil(t, err)
+
+	authenticator.SignCount--
+	options, err = rp.BeginLogin(subject)
+	assert.Nil(t, err)
+	_, _, err = rp.FinishLogin(subject, options.Challenge, authenticator.Get(t, options))
+	assert.IsType(t, &webauthn.VerificationError{}, err, "Cloned authenticator is detected")
+}